@@ -0,0 +1,323 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
+
+// action runs a command with the arguments that are left after parsing the flags.
+type action func(ctx context.Context, client stablenet.Client, args []string) (*result, error)
+
+// command registers its flags at the flag set and returns the action that reads them.
+type command struct {
+	usage       string
+	description string
+	setup       func(flags *flag.FlagSet) action
+}
+
+// usageError is returned by actions that were called with missing or invalid arguments.
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+var commands = map[string]command{
+	"info": {
+		usage:       "info",
+		description: "shows the version and the license of the server",
+		setup:       setupInfo,
+	},
+	"devices": {
+		usage:       "devices [--filter name] [--ip address] [--vendor number]",
+		description: "lists the devices whose attributes contain the given texts",
+		setup:       setupDevices,
+	},
+	"measurements": {
+		usage:       "measurements --device obid [--filter name] [--limit n]",
+		description: "lists the measurements of a device",
+		setup:       setupMeasurements,
+	},
+	"metrics": {
+		usage:       "metrics --measurement obid",
+		description: "lists the metrics of a measurement",
+		setup:       setupMetrics,
+	},
+	"data": {
+		usage:       "data --measurement obid [--metrics key,...] [--from time] [--to time] [--average duration]",
+		description: "fetches the data of metrics, times are RFC 3339, unix milliseconds, \"now\" or \"now-1h\"",
+		setup:       setupData,
+	},
+	"expand-link": {
+		usage:       "expand-link <statistic link>",
+		description: "shows the measurements, devices and metrics a statistic link refers to",
+		setup:       setupExpandLink,
+	},
+}
+
+type serverInfo struct {
+	Version              string `json:"version"`
+	RestReporting        bool   `json:"restReporting"`
+	MultiMeasurementData bool   `json:"multiMeasurementData"`
+	Compatible           bool   `json:"compatible"`
+	Problem              string `json:"problem,omitempty"`
+}
+
+func setupInfo(*flag.FlagSet) action {
+	return func(ctx context.Context, client stablenet.Client, _ []string) (*result, error) {
+		info, err := client.QueryStableNetInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		value := serverInfo{
+			Version:              info.ServerVersion.Version,
+			RestReporting:        info.License.Modules.IsRestReportingLicensed(),
+			MultiMeasurementData: info.SupportsMultiMeasurementData(),
+			Compatible:           true,
+		}
+		if err := info.CheckCompatibility(); err != nil {
+			value.Compatible = false
+			value.Problem = stablenet.UserMessage(err)
+		}
+		return &result{
+			value:  value,
+			header: []string{"version", "rest-reporting", "multi-measurement-data", "compatible"},
+			rows:   [][]string{{value.Version, yesNo(value.RestReporting), yesNo(value.MultiMeasurementData), yesNo(value.Compatible)}},
+		}, nil
+	}
+}
+
+func setupDevices(flags *flag.FlagSet) action {
+	filter := flags.String("filter", "", "the text the device names must contain")
+	ip := flags.String("ip", "", "the text the IP addresses of the devices must contain")
+	vendor := flags.String("vendor", "", "the IANA enterprise number of the vendor of the devices, e.g. 9 for Cisco")
+	return func(ctx context.Context, client stablenet.Client, _ []string) (*result, error) {
+		search := stablenet.DeviceSearch{Name: *filter, IPAddress: *ip}
+		if *vendor != "" {
+			var err error
+			if search.Vendor, err = stablenet.ParseVendor(*vendor); err != nil {
+				return nil, usageErrorf("%v", err)
+			}
+		}
+		devices, err := client.SearchDevices(ctx, search)
+		if err != nil {
+			return nil, err
+		}
+		rows := make([][]string, 0, len(devices.Data))
+		for _, device := range devices.Data {
+			var vendor string
+			if device.Vendor != 0 {
+				vendor = strconv.Itoa(device.Vendor)
+			}
+			rows = append(rows, []string{strconv.Itoa(device.Obid), device.Name, device.IPAddress, vendor})
+		}
+		return &result{value: devices.Data, header: []string{"obid", "name", "ip", "vendor"}, rows: rows}, nil
+	}
+}
+
+func setupMeasurements(flags *flag.FlagSet) action {
+	device := flags.Int("device", 0, "the obid of the device")
+	filter := flags.String("filter", "", "the text the measurement names must contain")
+	limit := flags.Int("limit", 1000, "the maximum number of measurements")
+	return func(ctx context.Context, client stablenet.Client, _ []string) (*result, error) {
+		if *device <= 0 {
+			return nil, usageErrorf("the flag --device is required")
+		}
+		measurements, err := client.FetchAllMeasurementsForDevice(ctx, *device, *filter, *limit)
+		if err != nil {
+			return nil, err
+		}
+		rows := make([][]string, 0, len(measurements))
+		for _, measurement := range measurements {
+			rows = append(rows, []string{strconv.Itoa(measurement.Obid), measurement.Name, strconv.Itoa(measurement.DeviceObid), measurement.Type})
+		}
+		return &result{value: measurements, header: []string{"obid", "name", "device", "type"}, rows: rows}, nil
+	}
+}
+
+func setupMetrics(flags *flag.FlagSet) action {
+	measurement := flags.Int("measurement", 0, "the obid of the measurement")
+	return func(ctx context.Context, client stablenet.Client, _ []string) (*result, error) {
+		if *measurement <= 0 {
+			return nil, usageErrorf("the flag --measurement is required")
+		}
+		metrics, err := client.FetchMetricsForMeasurement(ctx, *measurement)
+		if err != nil {
+			return nil, err
+		}
+		rows := make([][]string, 0, len(metrics))
+		for _, metric := range metrics {
+			interval := ""
+			if metric.Interval > 0 {
+				interval = (time.Duration(metric.Interval) * time.Millisecond).String()
+			}
+			rows = append(rows, []string{metric.Key, metric.Name, metric.Unit, interval})
+		}
+		return &result{value: metrics, header: []string{"key", "name", "unit", "interval"}, rows: rows}, nil
+	}
+}
+
+type dataPoint struct {
+	Time     time.Time `json:"time"`
+	Interval int64     `json:"interval"`
+	Min      float64   `json:"min"`
+	Avg      float64   `json:"avg"`
+	Max      float64   `json:"max"`
+}
+
+func setupData(flags *flag.FlagSet) action {
+	measurement := flags.Int("measurement", 0, "the obid of the measurement")
+	metrics := flags.String("metrics", "", "comma separated keys of the metrics, all metrics if empty")
+	from := flags.String("from", "now-1h", "the start of the time range")
+	to := flags.String("to", "now", "the end of the time range")
+	average := flags.Duration("average", 0, "the average of the data, chosen by StableNet® if 0")
+	return func(ctx context.Context, client stablenet.Client, _ []string) (*result, error) {
+		if *measurement <= 0 {
+			return nil, usageErrorf("the flag --measurement is required")
+		}
+		now := time.Now()
+		start, err := parseTime(*from, now)
+		if err != nil {
+			return nil, usageErrorf("invalid --from: %v", err)
+		}
+		end, err := parseTime(*to, now)
+		if err != nil {
+			return nil, usageErrorf("invalid --to: %v", err)
+		}
+		if !end.After(start) {
+			return nil, usageErrorf("the time range must end after it starts")
+		}
+		keys := splitList(*metrics)
+		if len(keys) == 0 {
+			all, err := client.FetchMetricsForMeasurement(ctx, *measurement)
+			if err != nil {
+				return nil, err
+			}
+			for _, metric := range all {
+				keys = append(keys, metric.Key)
+			}
+		}
+		series, err := client.FetchDataForMetrics(ctx, stablenet.DataQueryOptions{
+			MeasurementObid: *measurement,
+			Metrics:         keys,
+			Start:           start,
+			End:             end,
+			Average:         average.Milliseconds(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return dataResult(series), nil
+	}
+}
+
+func dataResult(series map[string]stablenet.MetricDataSeries) *result {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	value := make(map[string][]dataPoint, len(series))
+	rows := make([][]string, 0)
+	for _, key := range keys {
+		points := make([]dataPoint, 0, len(series[key]))
+		for _, data := range series[key] {
+			points = append(points, dataPoint{Time: data.Time, Interval: data.Interval.Milliseconds(), Min: data.Min, Avg: data.Avg, Max: data.Max})
+			rows = append(rows, []string{key, data.Time.Format(time.RFC3339), formatFloat(data.Min), formatFloat(data.Avg), formatFloat(data.Max)})
+		}
+		value[key] = points
+	}
+	return &result{value: value, header: []string{"metric", "time", "min", "avg", "max"}, rows: rows}
+}
+
+func setupExpandLink(*flag.FlagSet) action {
+	return func(ctx context.Context, client stablenet.Client, args []string) (*result, error) {
+		if len(args) != 1 {
+			return nil, usageErrorf("expected exactly one statistic link, but got %d arguments", len(args))
+		}
+		resolution, err := stablenet.ResolveStatisticLink(ctx, client, args[0])
+		if err != nil {
+			return nil, err
+		}
+		rows := make([][]string, 0, len(resolution.Measurements)+len(resolution.Unresolved))
+		for _, measurement := range resolution.Measurements {
+			device := ""
+			if measurement.Device != nil {
+				device = measurement.Device.Name
+			}
+			metrics := make([]string, 0, len(measurement.Metrics))
+			for _, metric := range measurement.Metrics {
+				metrics = append(metrics, fmt.Sprintf("%s (%s)", metric.Key, metric.Name))
+			}
+			rows = append(rows, []string{strconv.Itoa(measurement.Obid), measurement.Name, device, strings.Join(metrics, ", "), ""})
+		}
+		for _, unresolved := range resolution.Unresolved {
+			rows = append(rows, []string{strconv.Itoa(unresolved.Obid), "", "", "", unresolved.Error})
+		}
+		return &result{value: resolution, header: []string{"obid", "measurement", "device", "metrics", "error"}, rows: rows}, nil
+	}
+}
+
+// Parses a point in time given as RFC 3339, as unix milliseconds, as "now" or relative to now, e.g. "now-1h30m".
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "now" {
+		return now, nil
+	}
+	if offset, ok := strings.CutPrefix(value, "now-"); ok {
+		duration, err := time.ParseDuration(offset)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-duration), nil
+	}
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(millis), nil
+	}
+	result, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("expected RFC 3339, unix milliseconds, \"now\" or \"now-<duration>\"")
+	}
+	return result, nil
+}
+
+func splitList(value string) []string {
+	result := make([]string, 0)
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			result = append(result, element)
+		}
+	}
+	return result
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The environment variables that override the configuration file.
+const (
+	envAddress  = "STABLENET_ADDRESS"
+	envUsername = "STABLENET_USERNAME"
+	envPassword = "STABLENET_PASSWORD"
+	envConfig   = "STABLENET_CONFIG"
+)
+
+// The configuration file in the home directory that is read if no other file is given.
+const defaultConfigFile = ".stablenet-cli.json"
+
+// config is the connection to StableNet®, e.g.
+//
+//	{"address": "https://127.0.0.1:5443", "username": "infosim", "password": "stablenet"}
+type config struct {
+	Address  string `json:"address"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Loads the configuration from the file and overrides it with the environment. The file is given by the flag or the
+// environment, otherwise the default file in the home directory is read if it exists.
+func loadConfig(file string, getenv func(string) string) (*config, error) {
+	result := &config{}
+	explicit := true
+	if file == "" {
+		file = getenv(envConfig)
+	}
+	if file == "" {
+		explicit = false
+		if home, err := os.UserHomeDir(); err == nil {
+			file = filepath.Join(home, defaultConfigFile)
+		}
+	}
+	if file != "" {
+		content, err := os.ReadFile(file)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(content, result); err != nil {
+				return nil, fmt.Errorf("could not parse config file %s: %w", file, err)
+			}
+		case explicit || !errors.Is(err, os.ErrNotExist):
+			return nil, fmt.Errorf("could not read config file: %w", err)
+		}
+	}
+	if address := getenv(envAddress); address != "" {
+		result.Address = address
+	}
+	if username := getenv(envUsername); username != "" {
+		result.Username = username
+	}
+	if password := getenv(envPassword); password != "" {
+		result.Password = password
+	}
+	if result.Address == "" {
+		return nil, fmt.Errorf("the address of StableNet® is missing, set %s or \"address\" in the config file", envAddress)
+	}
+	return result, nil
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+
+// Command stablenet-cli queries StableNet® with the client of the Grafana® datasource, e.g. to check what a panel
+// should show:
+//
+//	STABLENET_ADDRESS=https://127.0.0.1:5443 stablenet-cli metrics --measurement 1001 --output json
+//
+// The credentials are read from the environment (STABLENET_ADDRESS, STABLENET_USERNAME, STABLENET_PASSWORD) or from a
+// JSON config file, which is given by --config or STABLENET_CONFIG and defaults to ~/.stablenet-cli.json.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
+
+const (
+	exitOk    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr, os.Getenv)
+	stop()
+	os.Exit(code)
+}
+
+// Runs the command given by the arguments and returns the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stderr)
+		return exitUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		_, _ = fmt.Fprintf(stderr, "stablenet-cli: unknown command \"%s\"\n", args[0])
+		printUsage(stderr)
+		return exitUsage
+	}
+
+	flags := flag.NewFlagSet("stablenet-cli "+args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "the JSON config file with address, username and password")
+	output := flags.String("output", string(outputTable), "the output format: table, json or csv")
+	timeout := flags.Duration("timeout", 30*time.Second, "the timeout of a single request")
+	execute := cmd.setup(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	format, err := parseOutputFormat(*output)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "stablenet-cli: %v\n", err)
+		return exitUsage
+	}
+	cfg, err := loadConfig(*configFile, getenv)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "stablenet-cli: %v\n", err)
+		return exitError
+	}
+
+	client := stablenet.NewClient(cfg.Address, stablenet.WithCredentials(cfg.Username, cfg.Password), stablenet.WithTimeout(*timeout))
+	res, err := execute(ctx, client, flags.Args())
+	var usage *usageError
+	if errors.As(err, &usage) {
+		_, _ = fmt.Fprintf(stderr, "stablenet-cli %s: %v\nusage: stablenet-cli %s\n", args[0], err, cmd.usage)
+		return exitUsage
+	}
+	if err != nil {
+		// the user message explains the kind of error, the error itself tells which request failed
+		message, details := stablenet.UserMessage(err), stablenet.RedactCredentials(err.Error())
+		if message != details {
+			message += "\n" + details
+		}
+		_, _ = fmt.Fprintf(stderr, "stablenet-cli: %s\n", message)
+		return exitError
+	}
+	if err := res.write(stdout, format); err != nil {
+		_, _ = fmt.Fprintf(stderr, "stablenet-cli: could not write the output: %v\n", err)
+		return exitError
+	}
+	return exitOk
+}
+
+func printUsage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	_, _ = fmt.Fprintln(w, "usage: stablenet-cli <command> [--config file] [--output table|json|csv] [--timeout duration] [flags]")
+	_, _ = fmt.Fprintln(w, "\ncommands:")
+	for _, name := range names {
+		_, _ = fmt.Fprintf(w, "  %s\n      %s\n", commands[name].usage, commands[name].description)
+	}
+}
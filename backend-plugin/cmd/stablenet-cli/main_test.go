@@ -0,0 +1,135 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/mock"
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	snServer := mock.CreateMockServer("infosim", "stablenet")
+	snServer.Info = stablenet.ServerInfo{
+		ServerVersion: stablenet.ServerVersion{Version: "11.2.0"},
+		License:       stablenet.License{Modules: stablenet.Modules{Modules: []stablenet.Module{{Name: "rest-reporting"}}}},
+	}
+	server := httptest.NewServer(mock.CreateHandler(snServer))
+	defer server.Close()
+	// an empty config file keeps the tests independent of the config in the home directory
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(configFile, []byte("{}"), 0600), "writing config failed")
+	env := map[string]string{envAddress: server.URL, envUsername: "infosim", envPassword: "stablenet", envConfig: configFile}
+
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "info", args: []string{"info"}, wantStdout: "VERSION  REST-REPORTING  MULTI-MEASUREMENT-DATA  COMPATIBLE\n11.2.0   yes             yes                     yes\n"},
+		{name: "devices as csv", args: []string{"devices", "--filter", "bach", "--output", "csv"}, wantStdout: "obid,name,ip,vendor\n9000,Bach,10.1.2.3,9\n"},
+		{name: "devices by vendor", args: []string{"devices", "--vendor", "2636", "--output", "csv"}, wantStdout: "obid,name,ip,vendor\n9001,Fluss,10.1.2.4,2636\n"},
+		{name: "vendor name", args: []string{"devices", "--vendor", "juniper"}, wantCode: exitUsage, wantStderr: "\"juniper\" is not an IANA enterprise number"},
+		{name: "measurements", args: []string{"measurements", "--device", "9000", "--output", "csv"}, wantStdout: "obid,name,device,type\n1001,Host,9000,snmpTemplate\n1002,Processor,9000,snmpTemplate\n"},
+		{name: "metrics", args: []string{"metrics", "--measurement", "1001"}, wantStdout: "KEY       NAME    UNIT  INTERVAL\nSNMP_1    Uptime  s     1m0s\nEXTERN_2  CPU 1   %     1m0s\n"},
+		{name: "expand link", args: []string{"expand-link", "--output", "csv", "https://localhost:5443/PlotServlet?id=1001&value0=1"}, wantStdout: "obid,measurement,device,metrics,error\n1001,Host,Bach,SNMP_1 (Uptime),\n"},
+		{name: "no command", args: []string{}, wantCode: exitUsage, wantStderr: "usage: stablenet-cli <command>"},
+		{name: "unknown command", args: []string{"reboot"}, wantCode: exitUsage, wantStderr: "unknown command \"reboot\""},
+		{name: "unknown output", args: []string{"info", "--output", "xml"}, wantCode: exitUsage, wantStderr: "unknown output format \"xml\""},
+		{name: "missing flag", args: []string{"metrics"}, wantCode: exitUsage, wantStderr: "the flag --measurement is required"},
+		{name: "invalid time", args: []string{"data", "--measurement", "1001", "--from", "yesterday"}, wantCode: exitUsage, wantStderr: "invalid --from"},
+		{name: "wrong credentials", args: []string{"devices"}, env: map[string]string{envPassword: "wrong"}, wantCode: exitError, wantStderr: "the credentials were invalid"},
+		{name: "unknown measurement", args: []string{"metrics", "--measurement", "4711"}, wantCode: exitError, wantStderr: "could not find the requested entity"},
+		{name: "missing address", args: []string{"info"}, env: map[string]string{envAddress: ""}, wantCode: exitError, wantStderr: "the address of StableNet® is missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string {
+				if value, ok := tt.env[key]; ok {
+					return value
+				}
+				return env[key]
+			}
+			var stdout, stderr bytes.Buffer
+			code := run(context.Background(), tt.args, &stdout, &stderr, getenv)
+			assert.Equal(t, tt.wantCode, code, "exit code wrong, stderr: %s", stderr.String())
+			if tt.wantCode == exitOk {
+				assert.Equal(t, tt.wantStdout, stdout.String(), "output wrong")
+			} else {
+				assert.Contains(t, stderr.String(), tt.wantStderr, "error output wrong")
+			}
+		})
+	}
+
+	t.Run("data as json", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), []string{"data", "--measurement", "1001", "--metrics", "SNMP_1", "--average", "1m", "--output", "json"}, &stdout, &stderr, func(key string) string { return env[key] })
+		require.Equal(t, exitOk, code, "exit code wrong, stderr: %s", stderr.String())
+		var got map[string][]dataPoint
+		require.NoError(t, json.Unmarshal(stdout.Bytes(), &got), "the output should be JSON")
+		require.Equal(t, 60, len(got["SNMP_1"]), "one data point per minute of the last hour expected")
+		assert.Equal(t, 7.5, got["SNMP_1"][0].Avg, "average of data point")
+		assert.Equal(t, int64(60000), got["SNMP_1"][0].Interval, "interval of data point")
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"address": "https://sn:5443", "username": "infosim", "password": "secret"}`), 0600), "writing config failed")
+
+	got, err := loadConfig(file, func(key string) string { return "" })
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, &config{Address: "https://sn:5443", Username: "infosim", Password: "secret"}, got, "config from file wrong")
+
+	env := map[string]string{envConfig: file, envPassword: "other"}
+	got, err = loadConfig("", func(key string) string { return env[key] })
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, "other", got.Password, "the environment should override the file")
+	assert.Equal(t, "infosim", got.Username, "the file should be read from the environment variable")
+
+	_, err = loadConfig(filepath.Join(t.TempDir(), "missing.json"), func(string) string { return "" })
+	assert.ErrorContains(t, err, "could not read config file", "a missing explicit config file should be an error")
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "now", want: now},
+		{value: "now-90m", want: now.Add(-90 * time.Minute)},
+		{value: "1714564800000", want: time.UnixMilli(1714564800000)},
+		{value: "2024-05-01T10:00:00Z", want: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{value: "now-1d", wantErr: true},
+		{value: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.value, now)
+		if tt.wantErr {
+			assert.Error(t, err, "%s should be invalid", tt.value)
+			continue
+		}
+		require.NoError(t, err, "no error expected for %s", tt.value)
+		assert.True(t, tt.want.Equal(got), "time of %s wrong: %v", tt.value, got)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+type outputFormat string
+
+const (
+	outputTable outputFormat = "table"
+	outputJson  outputFormat = "json"
+	outputCsv   outputFormat = "csv"
+)
+
+func parseOutputFormat(value string) (outputFormat, error) {
+	switch format := outputFormat(value); format {
+	case outputTable, outputJson, outputCsv:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown output format \"%s\", use table, json or csv", value)
+}
+
+// result is the outcome of a command. The value is written as JSON, the header and rows as table or CSV.
+type result struct {
+	value  interface{}
+	header []string
+	rows   [][]string
+}
+
+func (r *result) write(w io.Writer, format outputFormat) error {
+	switch format {
+	case outputJson:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r.value)
+	case outputCsv:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(r.header); err != nil {
+			return err
+		}
+		if err := writer.WriteAll(r.rows); err != nil {
+			return err
+		}
+		return writer.Error()
+	}
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, strings.ToUpper(strings.Join(r.header, "\t")))
+	for _, row := range r.rows {
+		_, _ = fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+
+// Command stablenet-mock runs a fake StableNet® for developing the plugin without a real server:
+//
+//	stablenet-mock --fixtures mock/fixtures/example.yaml --listen :5443
+//
+// It serves the devices, measurements and metrics of the fixtures via HTTP (without TLS) and generates the data of
+// the metrics for the requested time range. Without fixtures, it serves the fixtures of the tests. The scripted faults
+// of the fixtures can be replaced at runtime, e.g.
+//
+//	curl -X PUT -d '[{"path": "/api/1/measurement-data", "status": 503, "times": 2}]' http://localhost:5443/mock/faults
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/mock"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stderr); err != nil {
+		log.Fatalf("stablenet-mock: %v", err)
+	}
+}
+
+func run(ctx context.Context, args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("stablenet-mock", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	fixturesFile := flags.String("fixtures", "", "the YAML or JSON file with the fixtures, the fixtures of the tests if empty")
+	listen := flags.String("listen", ":5443", "the address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fixtures, err := loadFixtures(*fixturesFile)
+	if err != nil {
+		return err
+	}
+	logger := log.New(stderr, "", log.LstdFlags)
+	server := &http.Server{Addr: *listen, Handler: logRequests(logger, mock.CreateHandler(mock.NewServer(fixtures)))}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Printf("serving %d devices and %d measurements as user \"%s\" on %s", len(fixtures.Devices), len(fixtures.Measurements), fixtures.Username, *listen)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Loads the fixtures from the file. The fixtures of the tests are completed by a version and license, so that the
+// health check of the plugin succeeds.
+func loadFixtures(file string) (*mock.Fixtures, error) {
+	if file != "" {
+		return mock.LoadFixtures(file)
+	}
+	fixtures := mock.DefaultFixtures("infosim", "stablenet")
+	fixtures.Version = "11.2.0"
+	fixtures.Modules = []string{"rest-reporting"}
+	return fixtures, nil
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func logRequests(logger *log.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(recorder, req)
+		logger.Printf("%s %s %d %v", req.Method, req.URL.RequestURI(), recorder.status, time.Since(start).Round(time.Millisecond))
+	})
+}
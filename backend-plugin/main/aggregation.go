@@ -0,0 +1,294 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+type AggregationFunction string
+
+const (
+	AggregateSum        AggregationFunction = "sum"
+	AggregateAvg        AggregationFunction = "avg"
+	AggregateMin        AggregationFunction = "min"
+	AggregateMax        AggregationFunction = "max"
+	AggregateCount      AggregationFunction = "count"
+	AggregatePercentile AggregationFunction = "percentile"
+)
+
+type GroupBy string
+
+const (
+	GroupByNone        GroupBy = ""
+	GroupByDevice      GroupBy = "device"
+	GroupByMeasurement GroupBy = "measurement"
+	GroupByMetric      GroupBy = "metric"
+)
+
+// Aggregation combines all series of a query, e.g. the measurements of a statistic link, into one series per group.
+// The series are aligned onto a grid of the query's average before they are combined per timestamp.
+type Aggregation struct {
+	Function   AggregationFunction `json:"function"`
+	Percentile float64             `json:"percentile,omitempty"`
+	GroupBy    GroupBy             `json:"groupBy,omitempty"`
+	// KeepInputs returns the aggregated series next to the original ones instead of replacing them.
+	KeepInputs bool `json:"keepInputs"`
+}
+
+func (a *Aggregation) validate() error {
+	switch a.Function {
+	case AggregateSum, AggregateAvg, AggregateMin, AggregateMax, AggregateCount:
+	case AggregatePercentile:
+		if a.Percentile < 0 || a.Percentile > 100 {
+			return fmt.Errorf("the percentile %v is not between 0 and 100", a.Percentile)
+		}
+	default:
+		return fmt.Errorf("unknown aggregation function \"%s\"", a.Function)
+	}
+	switch a.GroupBy {
+	case GroupByNone, GroupByDevice, GroupByMeasurement, GroupByMetric:
+		return nil
+	}
+	return fmt.Errorf("cannot group by \"%s\"", a.GroupBy)
+}
+
+func (a *Aggregation) name() string {
+	if a.Function == AggregatePercentile {
+		return fmt.Sprintf("p%v", a.Percentile)
+	}
+	return string(a.Function)
+}
+
+// aggregationInput is a single series that takes part in an aggregation.
+type aggregationInput struct {
+	MeasurementObid int
+	MetricKey       string
+	MetricName      string
+	Unit            string
+	Series          stablenet.MetricDataSeries
+}
+
+func aggregationInputs(query MetricQuery, series map[string]stablenet.MetricDataSeries) []aggregationInput {
+	names := query.keyNameMap()
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]aggregationInput, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, aggregationInput{MeasurementObid: query.MeasurementObid, MetricKey: key, MetricName: names[key], Unit: query.unitOf(key), Series: series[key]})
+	}
+	return result
+}
+
+// measurementLabeler returns the name of a measurement and the name of its device.
+type measurementLabeler func(measurementObid int) (measurement string, device string, err error)
+
+func newMeasurementLabeler(ctx context.Context, client stablenet.Client) measurementLabeler {
+	type labels struct{ measurement, device string }
+	cache := make(map[int]labels)
+	return func(measurementObid int) (string, string, error) {
+		if cached, ok := cache[measurementObid]; ok {
+			return cached.measurement, cached.device, nil
+		}
+		measurement, err := client.FetchMeasurement(ctx, measurementObid)
+		if err != nil {
+			return "", "", err
+		}
+		result := labels{measurement: measurement.Name}
+		if measurement.DeviceObid != 0 {
+			device, err := client.FetchDevice(ctx, measurement.DeviceObid)
+			if err != nil {
+				return "", "", err
+			}
+			result.device = device.Name
+		}
+		cache[measurementObid] = result
+		return result.measurement, result.device, nil
+	}
+}
+
+// Aggregates the inputs into one frame per group. The query defines the statistics and the time grid of the result. The
+// aggregation has been validated with the query, before any data was fetched.
+func aggregate(inputs []aggregationInput, aggregation Aggregation, query MetricQuery, labeler measurementLabeler) ([]*data.Frame, error) {
+	groups := make(map[string][]aggregationInput)
+	groupNames := make([]string, 0)
+	for _, input := range inputs {
+		group, err := groupOf(input, aggregation.GroupBy, labeler)
+		if err != nil {
+			return nil, fmt.Errorf("could not determine the %s of measurement %d: %w", aggregation.GroupBy, input.MeasurementObid, err)
+		}
+		if _, ok := groups[group]; !ok {
+			groupNames = append(groupNames, group)
+		}
+		groups[group] = append(groups[group], input)
+	}
+
+	frames := make([]*data.Frame, 0, len(groups))
+	for _, group := range groupNames {
+		series := make([]stablenet.MetricDataSeries, 0, len(groups[group]))
+		for _, input := range groups[group] {
+			series = append(series, input.Series)
+		}
+		combined := combineSeries(series, time.Duration(query.Interval)*time.Millisecond, aggregation)
+		// a count has no unit, the other functions keep the unit of their inputs
+		unit := commonUnit(groups[group])
+		if aggregation.Function == AggregateCount {
+			unit = ""
+		}
+		if aggregation.GroupBy == GroupByNone {
+			frames = append(frames, query.newFrame(aggregation.name(), combined, nil, unit))
+			continue
+		}
+		labels := data.Labels{string(aggregation.GroupBy): group}
+		frames = append(frames, query.newFrame(fmt.Sprintf("%s (%s)", aggregation.name(), group), combined, labels, unit))
+	}
+	return frames, nil
+}
+
+// The group of the measurements without a device when grouping by device.
+const unknownDevice = "unknown device"
+
+func groupOf(input aggregationInput, groupBy GroupBy, labeler measurementLabeler) (string, error) {
+	switch groupBy {
+	case GroupByMetric:
+		return input.MetricName, nil
+	case GroupByMeasurement, GroupByDevice:
+		measurement, device, err := labeler(input.MeasurementObid)
+		if groupBy == GroupByMeasurement {
+			return measurement, err
+		}
+		if err == nil && device == "" {
+			return unknownDevice, nil
+		}
+		return device, err
+	}
+	return "", nil
+}
+
+// Returns the longest interval of the values of the inputs, which is the average StableNet® used for the coarsest series.
+func longestInterval(inputs []aggregationInput) time.Duration {
+	var result time.Duration
+	for _, input := range inputs {
+		for _, entry := range input.Series {
+			result = max(result, entry.Interval)
+		}
+	}
+	return result
+}
+
+// Aligns the series onto a grid with the given step and combines the values of each grid point. Several values of
+// the same series within one step are averaged first. A step of zero only combines values with equal timestamps.
+func combineSeries(series []stablenet.MetricDataSeries, step time.Duration, aggregation Aggregation) stablenet.MetricDataSeries {
+	type values struct{ min, max, avg []float64 }
+	grid := make(map[int64]*values)
+	for _, single := range series {
+		for timestamp, entry := range alignSeries(single, step) {
+			point, ok := grid[timestamp]
+			if !ok {
+				point = &values{}
+				grid[timestamp] = point
+			}
+			point.min = append(point.min, entry.Min)
+			point.max = append(point.max, entry.Max)
+			point.avg = append(point.avg, entry.Avg)
+		}
+	}
+	timestamps := make([]int64, 0, len(grid))
+	for timestamp := range grid {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	result := make(stablenet.MetricDataSeries, 0, len(timestamps))
+	for _, timestamp := range timestamps {
+		point := grid[timestamp]
+		result = append(result, stablenet.MetricData{
+			Interval: step,
+			Time:     time.UnixMilli(timestamp),
+			Min:      aggregateValues(point.min, aggregation),
+			Max:      aggregateValues(point.max, aggregation),
+			Avg:      aggregateValues(point.avg, aggregation),
+		})
+	}
+	return result
+}
+
+func alignSeries(series stablenet.MetricDataSeries, step time.Duration) map[int64]stablenet.MetricData {
+	sums := make(map[int64]stablenet.MetricData)
+	counts := make(map[int64]float64)
+	for _, entry := range series {
+		timestamp := entry.Time.UnixMilli()
+		if step > 0 {
+			timestamp -= timestamp % step.Milliseconds()
+		}
+		sum := sums[timestamp]
+		sum.Min += entry.Min
+		sum.Max += entry.Max
+		sum.Avg += entry.Avg
+		sums[timestamp] = sum
+		counts[timestamp]++
+	}
+	for timestamp, sum := range sums {
+		count := counts[timestamp]
+		sums[timestamp] = stablenet.MetricData{Min: sum.Min / count, Max: sum.Max / count, Avg: sum.Avg / count}
+	}
+	return sums
+}
+
+func aggregateValues(values []float64, aggregation Aggregation) float64 {
+	switch aggregation.Function {
+	case AggregateSum:
+		return sum(values)
+	case AggregateAvg:
+		return sum(values) / float64(len(values))
+	case AggregateMin:
+		result := math.Inf(1)
+		for _, value := range values {
+			result = math.Min(result, value)
+		}
+		return result
+	case AggregateMax:
+		result := math.Inf(-1)
+		for _, value := range values {
+			result = math.Max(result, value)
+		}
+		return result
+	case AggregateCount:
+		return float64(len(values))
+	case AggregatePercentile:
+		return percentile(values, aggregation.Percentile)
+	}
+	return math.NaN()
+}
+
+func sum(values []float64) float64 {
+	result := 0.0
+	for _, value := range values {
+		result += value
+	}
+	return result
+}
+
+// Computes the percentile by linear interpolation between the closest ranks. The values must not be empty.
+func percentile(values []float64, p float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
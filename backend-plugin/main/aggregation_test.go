@@ -0,0 +1,140 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateValues(t *testing.T) {
+	values := []float64{4, 1, 3, 2}
+	tests := []struct {
+		aggregation Aggregation
+		want        float64
+	}{
+		{aggregation: Aggregation{Function: AggregateSum}, want: 10},
+		{aggregation: Aggregation{Function: AggregateAvg}, want: 2.5},
+		{aggregation: Aggregation{Function: AggregateMin}, want: 1},
+		{aggregation: Aggregation{Function: AggregateMax}, want: 4},
+		{aggregation: Aggregation{Function: AggregateCount}, want: 4},
+		{aggregation: Aggregation{Function: AggregatePercentile, Percentile: 50}, want: 2.5},
+		{aggregation: Aggregation{Function: AggregatePercentile, Percentile: 0}, want: 1},
+		{aggregation: Aggregation{Function: AggregatePercentile, Percentile: 100}, want: 4},
+		{aggregation: Aggregation{Function: AggregatePercentile, Percentile: 95}, want: 3.85},
+	}
+	for _, tt := range tests {
+		t.Run(tt.aggregation.name(), func(t *testing.T) {
+			assert.InDelta(t, tt.want, aggregateValues(values, tt.aggregation), 1e-9, "aggregated value wrong")
+		})
+	}
+}
+
+func TestCombineSeries(t *testing.T) {
+	start := time.UnixMilli(1599999960000)
+	first := stablenet.MetricDataSeries{
+		{Time: start, Min: 1, Max: 3, Avg: 2},
+		{Time: start.Add(20 * time.Second), Min: 3, Max: 5, Avg: 4},
+		{Time: start.Add(60 * time.Second), Min: 5, Max: 7, Avg: 6},
+	}
+	second := stablenet.MetricDataSeries{
+		{Time: start.Add(10 * time.Second), Min: 10, Max: 10, Avg: 10},
+		{Time: start.Add(70 * time.Second), Min: 20, Max: 20, Avg: 20},
+		{Time: start.Add(120 * time.Second), Min: 30, Max: 30, Avg: 30},
+	}
+	got := combineSeries([]stablenet.MetricDataSeries{first, second}, time.Minute, Aggregation{Function: AggregateSum})
+	want := stablenet.MetricDataSeries{
+		{Interval: time.Minute, Time: start, Min: 12, Max: 14, Avg: 13},
+		{Interval: time.Minute, Time: start.Add(time.Minute), Min: 25, Max: 27, Avg: 26},
+		{Interval: time.Minute, Time: start.Add(2 * time.Minute), Min: 30, Max: 30, Avg: 30},
+	}
+	assert.Equal(t, want, got, "combined series wrong")
+
+	got = combineSeries([]stablenet.MetricDataSeries{first, second}, 0, Aggregation{Function: AggregateCount})
+	assert.Equal(t, 6, len(got), "without step only equal timestamps should be combined")
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.UnixMilli(1599999960000)
+	inputs := []aggregationInput{
+		{MeasurementObid: 1, MetricKey: "SNMP_1", MetricName: "In", Series: seriesOf(start, time.Minute, 1, 2)},
+		{MeasurementObid: 1, MetricKey: "SNMP_2", MetricName: "Out", Series: seriesOf(start, time.Minute, 10, 20)},
+		{MeasurementObid: 2, MetricKey: "SNMP_1", MetricName: "In", Series: seriesOf(start, time.Minute, 100, 200)},
+	}
+	labeler := func(measurementObid int) (string, string, error) {
+		if measurementObid == 1 {
+			return "Uplink 1", "Router", nil
+		}
+		return "Uplink 2", "Switch", nil
+	}
+	query := MetricQuery{Interval: 60000, Statistics: []stablenet.Statistic{stablenet.StatisticAvg}}
+
+	t.Run("no groups", func(t *testing.T) {
+		got, err := aggregate(inputs, Aggregation{Function: AggregateSum}, query, labeler)
+		require.NoError(t, err, "no error expected")
+		require.Equal(t, 1, len(got), "number of frames")
+		assert.Equal(t, "sum", got[0].Name, "name of frame")
+		assert.Equal(t, []interface{}{start.Add(time.Minute), 222.0}, got[0].RowCopy(1), "second row wrong")
+	})
+	t.Run("group by metric", func(t *testing.T) {
+		got, err := aggregate(inputs, Aggregation{Function: AggregateMax, GroupBy: GroupByMetric}, query, labeler)
+		require.NoError(t, err, "no error expected")
+		require.Equal(t, 2, len(got), "number of frames")
+		assert.Equal(t, "max (In)", got[0].Name, "name of first frame")
+		assert.Equal(t, data.Labels{"metric": "In"}, got[0].Fields[1].Labels, "labels of first frame")
+		assert.Equal(t, []interface{}{start, 100.0}, got[0].RowCopy(0), "first row of first frame wrong")
+		assert.Equal(t, "max (Out)", got[1].Name, "name of second frame")
+	})
+	t.Run("group by device", func(t *testing.T) {
+		got, err := aggregate(inputs, Aggregation{Function: AggregatePercentile, Percentile: 50, GroupBy: GroupByDevice}, query, labeler)
+		require.NoError(t, err, "no error expected")
+		require.Equal(t, 2, len(got), "number of frames")
+		assert.Equal(t, "p50 (Router)", got[0].Name, "name of first frame")
+		assert.Equal(t, []interface{}{start, 5.5}, got[0].RowCopy(0), "first row of first frame wrong")
+		assert.Equal(t, "p50 (Switch)", got[1].Name, "name of second frame")
+	})
+	t.Run("group by device without device", func(t *testing.T) {
+		withoutDevice := func(measurementObid int) (string, string, error) {
+			if measurementObid == 1 {
+				return "Uplink 1", "Router", nil
+			}
+			return "Uplink 2", "", nil
+		}
+		got, err := aggregate(inputs, Aggregation{Function: AggregateCount, GroupBy: GroupByDevice}, query, withoutDevice)
+		require.NoError(t, err, "no error expected")
+		require.Equal(t, 2, len(got), "number of frames")
+		assert.Equal(t, "count (unknown device)", got[1].Name, "measurements without device should be labeled explicitly")
+		assert.Equal(t, data.Labels{"device": "unknown device"}, got[1].Fields[1].Labels, "labels of frame without device")
+	})
+	t.Run("labeler error", func(t *testing.T) {
+		failing := func(int) (string, string, error) { return "", "", errors.New("not found") }
+		_, err := aggregate(inputs, Aggregation{Function: AggregateSum, GroupBy: GroupByMeasurement}, query, failing)
+		assert.EqualError(t, err, "could not determine the measurement of measurement 1: not found", "error message wrong")
+	})
+}
+
+func TestAggregation_validate(t *testing.T) {
+	tests := []struct {
+		aggregation Aggregation
+		wantErr     string
+	}{
+		{aggregation: Aggregation{Function: "median"}, wantErr: "unknown aggregation function \"median\""},
+		{aggregation: Aggregation{Function: AggregatePercentile, Percentile: 101}, wantErr: "the percentile 101 is not between 0 and 100"},
+		{aggregation: Aggregation{Function: AggregateSum, GroupBy: "location"}, wantErr: "cannot group by \"location\""},
+	}
+	for _, tt := range tests {
+		assert.EqualError(t, tt.aggregation.validate(), tt.wantErr, "error message wrong")
+	}
+	assert.NoError(t, (&Aggregation{Function: AggregateAvg, GroupBy: GroupByDevice}).validate(), "valid aggregation")
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
+
+// The number of single-measurement data requests that are sent at the same time if the StableNet® server does not offer
+// the multi-measurement endpoint.
+const maxConcurrentMeasurementRequests = 4
+
+type dataProvider func(stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error)
+
+type multiDataProvider func(stablenet.MultiDataQueryOptions) (map[int]map[string]stablenet.MetricDataSeries, error)
+
+// Queries can only share a StableNet® request if they request the same time range with the same average.
+type batchKey struct {
+	Start    int64
+	End      int64
+	Interval int64
+}
+
+func batchKeyOf(options stablenet.DataQueryOptions) batchKey {
+	return batchKey{Start: options.Start.UnixNano(), End: options.End.UnixNano(), Interval: options.Average}
+}
+
+type batchResult struct {
+	data map[string]stablenet.MetricDataSeries
+	err  error
+}
+
+// Fetches the data of all queries in as few requests as possible and returns a provider for MetricQuery.FetchData that
+// answers from the fetched data. Queries with the same time range and average are grouped. Each group is fetched
+// with the multi-measurement endpoint if multi is not nil, otherwise every measurement of the group is fetched once
+// with parallel single calls. If the server does not know the multi-measurement endpoint, i.e. answers with 404 or 405,
+// the group is fetched with single calls as well. The returned provider only returns the metrics requested by the respective query.
+func prefetchBatchedData(queries []MetricQuery, single dataProvider, multi multiDataProvider) dataProvider {
+	groups := make(map[batchKey]map[int][]string)
+	templates := make(map[batchKey]stablenet.DataQueryOptions)
+	for _, query := range queries {
+		for _, options := range query.requiredDataQueryOptions() {
+			key := batchKeyOf(options)
+			if _, ok := groups[key]; !ok {
+				groups[key] = make(map[int][]string)
+				templates[key] = options
+			}
+			groups[key][query.MeasurementObid] = appendMissing(groups[key][query.MeasurementObid], options.Metrics)
+		}
+	}
+
+	results := make(map[batchKey]map[int]batchResult, len(groups))
+	for key, metrics := range groups {
+		if multi != nil && len(metrics) > 1 {
+			results[key] = fetchGroupWithMulti(templates[key], metrics, multi, single)
+		} else {
+			results[key] = fetchGroupWithSingle(templates[key], metrics, single)
+		}
+	}
+
+	return func(options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+		result, ok := results[batchKeyOf(options)][options.MeasurementObid]
+		if !ok {
+			return nil, fmt.Errorf("no data was prefetched for measurement %d", options.MeasurementObid)
+		}
+		if result.err != nil {
+			return nil, result.err
+		}
+		filtered := make(map[string]stablenet.MetricDataSeries, len(options.Metrics))
+		for _, key := range options.Metrics {
+			if series, ok := result.data[key]; ok {
+				filtered[key] = series
+			}
+		}
+		return filtered, nil
+	}
+}
+
+func fetchGroupWithMulti(template stablenet.DataQueryOptions, metrics map[int][]string, multi multiDataProvider, single dataProvider) map[int]batchResult {
+	data, err := multi(stablenet.MultiDataQueryOptions{
+		Metrics: metrics,
+		Start:   template.Start,
+		End:     template.End,
+		Average: template.Average,
+	})
+	if isMissingEndpoint(err) {
+		return fetchGroupWithSingle(template, metrics, single)
+	}
+	results := make(map[int]batchResult, len(metrics))
+	for obid := range metrics {
+		if err != nil {
+			results[obid] = batchResult{err: err}
+			continue
+		}
+		results[obid] = batchResult{data: data[obid]}
+	}
+	return results
+}
+
+func fetchGroupWithSingle(template stablenet.DataQueryOptions, metrics map[int][]string, single dataProvider) map[int]batchResult {
+	results := make(map[int]batchResult, len(metrics))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentMeasurementRequests)
+	for obid, keys := range metrics {
+		options := template
+		options.MeasurementObid = obid
+		options.Metrics = keys
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			data, err := single(options)
+			mutex.Lock()
+			defer mutex.Unlock()
+			results[options.MeasurementObid] = batchResult{data: data, err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// Returns whether the error shows that the server does not offer the requested endpoint at all.
+func isMissingEndpoint(err error) bool {
+	var statusErr *stablenet.StatusError
+	return errors.Is(err, stablenet.ErrNotFound) || (errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusMethodNotAllowed)
+}
+
+func appendMissing(list []string, values []string) []string {
+	for _, value := range values {
+		present := false
+		for _, existing := range list {
+			if existing == value {
+				present = true
+				break
+			}
+		}
+		if !present {
+			list = append(list, value)
+		}
+	}
+	return list
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchTestQueries() []MetricQuery {
+	start := time.Now()
+	end := start.Add(time.Hour)
+	return []MetricQuery{
+		{RefId: "A", Start: start, End: end, Interval: 60000, MeasurementObid: 1, Metrics: []StringPair{{Key: "SNMP_1"}}},
+		{RefId: "B", Start: start, End: end, Interval: 60000, MeasurementObid: 2, Metrics: []StringPair{{Key: "SNMP_1"}, {Key: "SNMP_2"}}},
+		{RefId: "C", Start: start, End: end, Interval: 60000, MeasurementObid: 1, Metrics: []StringPair{{Key: "SNMP_2"}}},
+		{RefId: "D", Start: start, End: end, Interval: 300000, MeasurementObid: 1, Metrics: []StringPair{{Key: "SNMP_1"}}},
+	}
+}
+
+func fakeSeries(obid int, interval int64) stablenet.MetricDataSeries {
+	return stablenet.MetricDataSeries{{Avg: float64(obid), Interval: time.Duration(interval)}}
+}
+
+func TestPrefetchBatchedData_Single(t *testing.T) {
+	queries := batchTestQueries()
+	var mutex sync.Mutex
+	calls := make([]stablenet.DataQueryOptions, 0)
+	single := func(options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+		mutex.Lock()
+		calls = append(calls, options)
+		mutex.Unlock()
+		result := make(map[string]stablenet.MetricDataSeries)
+		for _, key := range options.Metrics {
+			result[key] = fakeSeries(options.MeasurementObid, options.Average)
+		}
+		return result, nil
+	}
+
+	provider := prefetchBatchedData(queries, single, nil)
+	require.Equal(t, 3, len(calls), "one call per measurement and time range expected")
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i].Average != calls[j].Average {
+			return calls[i].Average < calls[j].Average
+		}
+		return calls[i].MeasurementObid < calls[j].MeasurementObid
+	})
+	assert.Equal(t, []string{"SNMP_1", "SNMP_2"}, calls[0].Metrics, "metrics of measurement 1 should be combined")
+	assert.Equal(t, []string{"SNMP_1", "SNMP_2"}, calls[1].Metrics, "metrics of measurement 2")
+	assert.Equal(t, []string{"SNMP_1"}, calls[2].Metrics, "metrics of measurement 1 with other average")
+
+	got, err := provider(queries[0].dataQueryOptions())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]stablenet.MetricDataSeries{"SNMP_1": fakeSeries(1, 60000)}, got, "only requested metrics should be returned")
+	got, err = provider(queries[3].dataQueryOptions())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]stablenet.MetricDataSeries{"SNMP_1": fakeSeries(1, 300000)}, got, "data of the other average expected")
+}
+
+func TestPrefetchBatchedData_Multi(t *testing.T) {
+	queries := batchTestQueries()
+	multiCalls := make([]stablenet.MultiDataQueryOptions, 0)
+	singleCalls := make([]stablenet.DataQueryOptions, 0)
+	single := func(options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+		singleCalls = append(singleCalls, options)
+		return map[string]stablenet.MetricDataSeries{"SNMP_1": fakeSeries(options.MeasurementObid, options.Average)}, nil
+	}
+	multi := func(options stablenet.MultiDataQueryOptions) (map[int]map[string]stablenet.MetricDataSeries, error) {
+		multiCalls = append(multiCalls, options)
+		result := make(map[int]map[string]stablenet.MetricDataSeries)
+		for obid, keys := range options.Metrics {
+			result[obid] = make(map[string]stablenet.MetricDataSeries)
+			for _, key := range keys {
+				result[obid][key] = fakeSeries(obid, options.Average)
+			}
+		}
+		return result, nil
+	}
+
+	provider := prefetchBatchedData(queries, single, multi)
+	require.Equal(t, 1, len(multiCalls), "both measurements with the same range should be fetched at once")
+	assert.Equal(t, map[int][]string{1: {"SNMP_1", "SNMP_2"}, 2: {"SNMP_1", "SNMP_2"}}, multiCalls[0].Metrics, "requested metrics")
+	require.Equal(t, 1, len(singleCalls), "a group with a single measurement should use the single endpoint")
+
+	got, err := provider(queries[1].dataQueryOptions())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]stablenet.MetricDataSeries{"SNMP_1": fakeSeries(2, 60000), "SNMP_2": fakeSeries(2, 60000)}, got, "data of query B")
+	got, err = provider(queries[2].dataQueryOptions())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]stablenet.MetricDataSeries{"SNMP_2": fakeSeries(1, 60000)}, got, "data of query C")
+}
+
+func TestPrefetchBatchedData_Error(t *testing.T) {
+	queries := batchTestQueries()
+	single := func(options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+		if options.MeasurementObid == 2 {
+			return nil, errors.New("measurement 2 failed")
+		}
+		return map[string]stablenet.MetricDataSeries{"SNMP_1": fakeSeries(options.MeasurementObid, options.Average)}, nil
+	}
+	provider := prefetchBatchedData(queries, single, nil)
+
+	_, err := provider(queries[1].dataQueryOptions())
+	assert.EqualError(t, err, "measurement 2 failed", "error of the measurement expected")
+	_, err = provider(queries[0].dataQueryOptions())
+	assert.NoError(t, err, "other measurements should not be affected")
+	_, err = provider(stablenet.DataQueryOptions{MeasurementObid: 42})
+	assert.EqualError(t, err, "no data was prefetched for measurement 42", "unknown measurement")
+}
+
+func TestPrefetchBatchedData_Comparison(t *testing.T) {
+	query := batchTestQueries()[0]
+	query.TimeShift = 24 * time.Hour
+	query.Comparison = CompareDifference
+	calls := make([]stablenet.DataQueryOptions, 0)
+	single := func(options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+		calls = append(calls, options)
+		return map[string]stablenet.MetricDataSeries{"SNMP_1": fakeSeries(options.MeasurementObid, options.Average)}, nil
+	}
+
+	provider := prefetchBatchedData([]MetricQuery{query}, single, nil)
+	require.Equal(t, 2, len(calls), "the shifted and the current range should be fetched")
+	_, err := query.FetchSeries(provider)
+	assert.NoError(t, err, "both ranges should be prefetched")
+}
+
+func TestPrefetchBatchedData_MultiFallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		multiErr   error
+		wantSingle int
+		wantErr    string
+	}{
+		{name: "not found", multiErr: &stablenet.StatusError{Message: "multi failed", StatusCode: 404}, wantSingle: 3},
+		{name: "method not allowed", multiErr: &stablenet.StatusError{Message: "multi failed", StatusCode: 405}, wantSingle: 3},
+		{name: "server error", multiErr: &stablenet.StatusError{Message: "multi failed", StatusCode: 500}, wantSingle: 1, wantErr: "multi failed: status code: 500, response: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := batchTestQueries()
+			var mutex sync.Mutex
+			singleCalls := 0
+			single := func(options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+				mutex.Lock()
+				singleCalls++
+				mutex.Unlock()
+				return map[string]stablenet.MetricDataSeries{"SNMP_1": fakeSeries(options.MeasurementObid, options.Average)}, nil
+			}
+			multiCalls := 0
+			multi := func(options stablenet.MultiDataQueryOptions) (map[int]map[string]stablenet.MetricDataSeries, error) {
+				multiCalls++
+				return nil, tt.multiErr
+			}
+
+			provider := prefetchBatchedData(queries, single, multi)
+			assert.Equal(t, 1, multiCalls, "the multi-measurement endpoint should be tried once")
+			assert.Equal(t, tt.wantSingle, singleCalls, "number of single calls wrong")
+			got, err := provider(queries[1].dataQueryOptions())
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr, "the error of the multi-measurement endpoint should be returned")
+				return
+			}
+			require.NoError(t, err, "the data should be fetched with single calls")
+			assert.Equal(t, map[string]stablenet.MetricDataSeries{"SNMP_1": fakeSeries(2, 60000)}, got, "data of query B")
+		})
+	}
+}
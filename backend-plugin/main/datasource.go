@@ -8,176 +8,372 @@
 package main
 
 import (
-	"backend-plugin/stablenet"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"runtime/debug"
-	"strconv"
 
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type dataSource struct {
-	validationStore map[int64]bool
+	health  healthStore
+	metrics metricCache
+	// Creates the client for the settings of a datasource. If nil, the client talks to the StableNet® server via HTTP.
+	newClient func(options *stablenet.ConnectOptions, logger log.Logger) stablenet.Client
 }
 
-func newDataSource() datasource.ServeOpts {
-	ds := &dataSource{validationStore: make(map[int64]bool)}
+func (ds *dataSource) createClient(options *stablenet.ConnectOptions, logger log.Logger) stablenet.Client {
+	if ds.newClient != nil {
+		return ds.newClient(options, logger)
+	}
+	return stablenet.NewStableNetClient(options, stablenet.WithLogger(logger), stablenet.WithTracer(tracing.DefaultTracer()), stablenet.WithRegisterer(prometheus.DefaultRegisterer))
+}
 
-	addClientThen := func(next http.HandlerFunc) http.HandlerFunc {
-		return func(rw http.ResponseWriter, req *http.Request) {
-			defer func() {
-				if err := recover(); err != nil {
-					backend.Logger.Error(fmt.Sprintf("An error occured in resource query %s: %v\n%s", req.URL.RawPath, err, debug.Stack()))
-				}
-			}()
+func newDataSource() datasource.ServeOpts {
+	ds := &dataSource{}
 
-			pluginContext := backend.PluginConfigFromContext(req.Context())
-			options, err := loadStableNetSettings(pluginContext.DataSourceInstanceSettings)
+	return datasource.ServeOpts{
+		CallResourceHandler: httpadapter.New(ds.resourceHandler()),
+		CheckHealthHandler:  ds,
+		QueryDataHandler:    ds,
+	}
+}
 
-			if err != nil {
-				http.Error(rw, "The datasource configuration is not valid, please check make sure that the health test is successful.", http.StatusInternalServerError)
-			}
+// Returns the handler of the resources the frontend requests, all of them are read-only.
+func (ds *dataSource) resourceHandler() http.Handler {
+	router := newResourceRouter()
+	router.handle("/devices", ds.withClient(handleDeviceQuery), http.MethodGet)
+	router.handle("/measurements", ds.withClient(handleMeasurementQuery), http.MethodGet)
+	router.handle("/metrics", ds.withClient(handleMetricQuery), http.MethodGet)
+	router.handle("/statistic-link", ds.withClient(handleStatisticLinkQuery), http.MethodGet)
+	router.handle("/search", ds.withClient(handleSearch(newSearchCache())), http.MethodGet)
+	return router
+}
 
-			valid, present := ds.validationStore[pluginContext.DataSourceInstanceSettings.ID]
-			if !present {
-				valid, _ = ds.checkAndUpdateHealth(options, pluginContext.DataSourceInstanceSettings.ID)
-			}
-			if !valid {
-				http.Error(rw, "The datasource is not valid, please check the data source configuration and make sure that the test is successful.", http.StatusInternalServerError)
-				return
-			}
+// Passes the client of the datasource of the request to the handler, if the datasource is configured and valid.
+func (ds *dataSource) withClient(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		pluginContext := backend.PluginConfigFromContext(req.Context())
+		logger := contextLogger(req.Context(), pluginContext).With("endpoint", req.URL.Path)
 
-			client := stablenet.NewStableNetClient(options)
-			ctx := context.WithValue(req.Context(), "SnClient", client)
-			next.ServeHTTP(rw, req.WithContext(ctx))
+		options, err := loadStableNetSettings(pluginContext.DataSourceInstanceSettings)
+		if err != nil {
+			logger.Warn("Invalid datasource settings", "error", err)
+			writeError(rw, http.StatusInternalServerError, codeInvalidSettings, "The datasource configuration is not valid, please check make sure that the health test is successful.")
+			return
 		}
-	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/devices", addClientThen(handleDeviceQuery))
-	mux.HandleFunc("/measurements", addClientThen(handleMeasurementQuery))
-	mux.HandleFunc("/metrics", addClientThen(handleMetricQuery))
+		client := ds.createClient(options, logger)
+		if !ds.isValid(req.Context(), client, keyOf(pluginContext.DataSourceInstanceSettings)) {
+			writeError(rw, http.StatusInternalServerError, codeInvalidDataSource, "The datasource is not valid, please check the data source configuration and make sure that the test is successful.")
+			return
+		}
 
-	return datasource.ServeOpts{
-		CallResourceHandler: httpadapter.New(mux),
-		CheckHealthHandler:  ds,
-		QueryDataHandler:    ds,
+		ctx := context.WithValue(req.Context(), "SnClient", client)
+		next.ServeHTTP(rw, req.WithContext(ctx))
 	}
 }
 
 func (ds *dataSource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	logger := contextLogger(ctx, req.PluginContext)
 	defer func() {
 		if err := recover(); err != nil {
-			backend.Logger.Error(fmt.Sprintf("An error occured: %v\n%s", err, debug.Stack()))
+			logger.Error("Panic in QueryData", "error", err, "stack", string(debug.Stack()))
 		}
 	}()
 
+	ctx, span := tracing.DefaultTracer().Start(ctx, "QueryData", trace.WithAttributes(attribute.Int("stablenet.query.count", len(req.Queries))))
+	defer span.End()
+
 	options, err := loadStableNetSettings(req.PluginContext.DataSourceInstanceSettings)
 	if err != nil {
 		return nil, err
 	}
 
-	// We need this for testing purposes. Go's httptest package only allows to mock http, not https, and
-	// it is not meant to separate ip and port. Thus, for testing purposes, we inject the test url here.
-	if ctx.Value("sn_address") != nil {
-		options.Address = ctx.Value("sn_address").(string)
-	}
-
-	valid, present := ds.validationStore[req.PluginContext.DataSourceInstanceSettings.ID]
-	if !present {
-		valid, _ = ds.checkAndUpdateHealth(options, req.PluginContext.DataSourceInstanceSettings.ID)
-	}
-	if !valid {
+	client := ds.createClient(options, logger)
+	key := keyOf(req.PluginContext.DataSourceInstanceSettings)
+	if !ds.isValid(ctx, client, key) {
 		responses := backend.Responses{"queryResponse": backend.DataResponse{Error: errors.New("the datasource is not valid, please check the data source configuration and make sure that the test is successful")}}
 		return &backend.QueryDataResponse{Responses: responses}, nil
 	}
 
+	response := backend.NewQueryDataResponse()
+	response.Responses = make(map[string]backend.DataResponse)
 	queries := make([]MetricQuery, 0, len(req.Queries))
-	for index, singleRequest := range req.Queries {
-		target := &Target{}
-		err := json.Unmarshal(singleRequest.JSON, target)
+	for _, singleRequest := range req.Queries {
+		query, err := parseQuery(singleRequest)
 		if err != nil {
-			return nil, fmt.Errorf("could not deserialize query %d: %v", index, err)
+			logger.Warn("Invalid query", "refId", singleRequest.RefID, "error", err)
+			response.Responses[singleRequest.RefID] = errorDataResponse(err)
+			continue
 		}
-		query := target.toQuery(singleRequest.TimeRange, singleRequest.RefID)
-		if (len(query.Metrics) == 0) && query.StatisticLink == nil {
+		if query.isEmpty() {
 			continue
 		}
 		queries = append(queries, query)
 	}
 
-	client := stablenet.NewStableNetClient(options)
-	queries, err = ExpandStatisticLinks(queries, client.FetchMetricsForMeasurement)
-	if err != nil {
-		return nil, err
+	queries, failed := expandQueriesTraced(ctx, queries, client, &ds.metrics, key)
+	for refId, err := range failed {
+		logger.Error("Expanding statistic link failed", "refId", refId, "error", err)
+		response.Responses[refId] = errorDataResponse(err)
 	}
-	response := backend.NewQueryDataResponse()
-	response.Responses = make(map[string]backend.DataResponse)
+	// a RefId with several measurements reports only the first warning about its average
+	warnings := make(map[string]string)
+	for _, query := range queries {
+		if warning := query.averageWarning(); warning != "" && warnings[query.RefId] == "" {
+			warnings[query.RefId] = warning
+		}
+	}
+
+	var multi multiDataProvider
+	if entry, _ := ds.health.get(key); entry.info != nil && entry.info.SupportsMultiMeasurementData() {
+		multi = func(options stablenet.MultiDataQueryOptions) (map[int]map[string]stablenet.MetricDataSeries, error) {
+			return client.FetchDataForMeasurements(ctx, options)
+		}
+	}
+	single := func(options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+		return client.FetchDataForMetrics(ctx, options)
+	}
+	provider := prefetchBatchedData(queries, single, multi)
+
+	// the series of post-processed queries are collected per RefId, since these queries are expanded into several queries.
+	// The expanded queries of a RefId share the time range and average, the first one is used for the post-processing.
+	postProcessed := make(map[string]MetricQuery)
+	inputsByRefId := make(map[string][]aggregationInput)
 	for _, query := range queries {
-		frames, err := query.FetchData(client.FetchDataForMetrics)
+		if response.Responses[query.RefId].Error != nil {
+			continue
+		}
+		series, err := query.FetchSeries(provider)
+		if err != nil {
+			logger.Error("Fetching data failed", "refId", query.RefId, "measurementObid", query.MeasurementObid, "error", err)
+			_ = tracing.Error(span, err)
+			response.Responses[query.RefId] = errorDataResponse(err)
+			continue
+		}
+		if query.isPostProcessed() {
+			if _, ok := postProcessed[query.RefId]; !ok {
+				postProcessed[query.RefId] = query
+			}
+			inputsByRefId[query.RefId] = append(inputsByRefId[query.RefId], aggregationInputs(query, series)...)
+			continue
+		}
+		// a statistic link expands into several queries with the same RefId, their frames belong to the same response
+		dataResponse := response.Responses[query.RefId]
+		dataResponse.Frames = append(dataResponse.Frames, query.buildFrames(series)...)
+		response.Responses[query.RefId] = dataResponse
+	}
+
+	labeler := newMeasurementLabeler(ctx, client)
+	for refId, query := range postProcessed {
+		if response.Responses[refId].Error != nil {
+			continue
+		}
+		frames, err := query.postProcess(inputsByRefId[refId], labeler)
 		if err != nil {
-			return nil, fmt.Errorf("could not fetch data for query %v: %v", query, err)
+			logger.Error("Processing data failed", "refId", refId, "error", err)
+			response.Responses[refId] = errorDataResponse(err)
+			continue
 		}
-		response.Responses[query.RefId] = backend.DataResponse{Frames: frames}
+		response.Responses[refId] = backend.DataResponse{Frames: frames}
+	}
+	for refId, warning := range warnings {
+		addWarning(response.Responses[refId], warning)
 	}
 	return response, nil
 }
 
+// Expands the statistic links and rankings and resolves the metric patterns of the queries. If a query cannot be
+// expanded, the error is returned for the RefId of the query instead of failing all queries. The expanded queries are
+// described by the metrics in the cache of the datasource.
+func expandQueriesTraced(ctx context.Context, queries []MetricQuery, client stablenet.Client, cache *metricCache, datasource datasourceKey) ([]MetricQuery, map[string]error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "ExpandQueries", trace.WithAttributes(attribute.Int("stablenet.query.count", len(queries))))
+	defer span.End()
+	fetchMetrics := func(measurementObid int) ([]stablenet.Metric, error) {
+		return client.FetchMetricsForMeasurement(ctx, measurementObid)
+	}
+	listMeasurements := func(deviceObid int, filter string, limit int) ([]stablenet.Measurement, error) {
+		return client.FetchAllMeasurementsForDevice(ctx, deviceObid, filter, limit)
+	}
+	// the supplier is shared by all queries, such that the metrics of a measurement are fetched only once even though
+	// they are described after the expansion
+	metricSupplier := memoizeMetricSupplier(fetchMetrics)
+	linkExpanded, failed := ExpandStatisticLinks(queries, metricSupplier)
+	for _, err := range failed {
+		_ = tracing.Error(span, err)
+	}
+	result := make([]MetricQuery, 0, len(linkExpanded))
+	for _, query := range linkExpanded {
+		if query.Ranking != nil {
+			rankedQueries, err := expandRanking(query, listMeasurements, metricSupplier)
+			if err != nil {
+				failed[query.RefId] = fmt.Errorf("could not expand ranking: %w", err)
+				_ = tracing.Error(span, err)
+				continue
+			}
+			result = append(result, rankedQueries...)
+			continue
+		}
+		// the metric patterns of a statistic link are already applied by its expansion
+		if query.StatisticLink == nil && len(query.MetricPatterns) > 0 {
+			resolved, err := resolveMetricPatterns(query, metricSupplier)
+			if err != nil {
+				failed[query.RefId] = fmt.Errorf("could not resolve metric patterns: %w", err)
+				_ = tracing.Error(span, err)
+				continue
+			}
+			// a pattern matching none of the metrics results in no data instead of an error
+			if len(resolved.Metrics) > 0 {
+				result = append(result, resolved)
+			}
+			continue
+		}
+		result = append(result, query)
+	}
+	describingSupplier := cache.supplier(datasource, metricSupplier)
+	for i := range result {
+		describeMetrics(&result[i], describingSupplier)
+	}
+	span.SetAttributes(attribute.Int("stablenet.expanded_query.count", len(result)))
+	return result, failed
+}
+
+// Queries the devices whose name contains the filter. The devices can also be searched by the parameters ip and
+// vendor, where the vendor is the IANA enterprise number of the vendor.
 func handleDeviceQuery(rw http.ResponseWriter, req *http.Request) {
-	filter := req.URL.Query().Get("filter")
+	params := req.URL.Query()
+	var search stablenet.DeviceSearch
+	var vendor string
+	textParams := []struct {
+		name   string
+		target *string
+	}{{"filter", &search.Name}, {"ip", &search.IPAddress}, {"vendor", &vendor}}
+	for _, param := range textParams {
+		var err error
+		if *param.target, err = textParam(params, param.name, maxFilterLength); err != nil {
+			writeParamError(rw, err)
+			return
+		}
+	}
+	if vendor != "" {
+		var err error
+		if search.Vendor, err = stablenet.ParseVendor(vendor); err != nil {
+			writeParamError(rw, &paramError{Name: "vendor", Reason: err.Error()})
+			return
+		}
+	}
 
-	snClient := req.Context().Value("SnClient").(*stablenet.StableNetClient)
+	snClient := req.Context().Value("SnClient").(stablenet.Client)
 
-	devices, err := snClient.QueryDevices(filter)
+	var devices *stablenet.DeviceQueryResult
+	var err error
+	if search == (stablenet.DeviceSearch{Name: search.Name}) {
+		devices, err = snClient.QueryDevices(req.Context(), search.Name)
+	} else {
+		devices, err = snClient.SearchDevices(req.Context(), search)
+	}
 	if err != nil {
-		http.Error(rw, fmt.Sprintf("could not query devices: %v", err), http.StatusInternalServerError)
+		writeClientError(rw, "query devices", err)
 		return
 	}
 
 	encodeJson(rw, devices)
 }
 
+// Queries the measurements of a device whose name contains the filter. The parameter type restricts the measurements
+// to the ones whose type contains it, e.g. "interface" or "ping".
 func handleMeasurementQuery(rw http.ResponseWriter, req *http.Request) {
-	deviceObid, err := strconv.Atoi(req.URL.Query().Get("deviceObid"))
+	params := req.URL.Query()
+	deviceObid, err := obidParam(params, "deviceObid")
 	if err != nil {
-		http.Error(rw, fmt.Sprintf("could not parse deviceObid query param: %v", err), http.StatusBadRequest)
+		writeParamError(rw, err)
+		return
+	}
+	filter, err := textParam(params, "filter", maxFilterLength)
+	if err != nil {
+		writeParamError(rw, err)
+		return
+	}
+	measurementType, err := textParam(params, "type", maxFilterLength)
+	if err != nil {
+		writeParamError(rw, err)
 		return
 	}
 
-	filter := req.URL.Query().Get("filter")
-
-	snClient := req.Context().Value("SnClient").(*stablenet.StableNetClient)
+	snClient := req.Context().Value("SnClient").(stablenet.Client)
 
-	measurements, err := snClient.FetchMeasurementsForDevice(deviceObid, filter)
+	var measurements *stablenet.MeasurementQueryResult
+	if measurementType == "" {
+		measurements, err = snClient.FetchMeasurementsForDevice(req.Context(), deviceObid, filter)
+	} else {
+		measurements, err = snClient.SearchMeasurements(req.Context(), stablenet.MeasurementSearch{DeviceObid: deviceObid, Name: filter, Type: measurementType})
+	}
 	if err != nil {
-		http.Error(rw, fmt.Sprintf("could not query measurements: %v", err), http.StatusInternalServerError)
+		writeClientError(rw, "query measurements", err)
 		return
 	}
 	encodeJson(rw, measurements)
 }
 
+// metricDescription is a metric as the metrics resource returns it, with its Grafana® unit and the polling interval of
+// its measurement in milliseconds.
+type metricDescription struct {
+	stablenet.Metric
+	GrafanaUnit     string `json:"grafanaUnit,omitempty"`
+	PollingInterval int64  `json:"pollingInterval,omitempty"`
+}
+
 func handleMetricQuery(rw http.ResponseWriter, req *http.Request) {
-	measurementObid, err := strconv.Atoi(req.URL.Query().Get("measurementObid"))
+	measurementObid, err := obidParam(req.URL.Query(), "measurementObid")
+	if err != nil {
+		writeParamError(rw, err)
+		return
+	}
+
+	snClient := req.Context().Value("SnClient").(stablenet.Client)
+
+	metrics, err := snClient.FetchMetricsForMeasurement(req.Context(), measurementObid)
+	if err != nil {
+		writeClientError(rw, "query metrics", err)
+		return
+	}
+
+	pollingInterval := stablenet.PollingInterval(metrics).Milliseconds()
+	result := make([]metricDescription, 0, len(metrics))
+	for _, metric := range metrics {
+		result = append(result, metricDescription{Metric: metric, GrafanaUnit: grafanaUnit(metric.Unit), PollingInterval: pollingInterval})
+	}
+	encodeJson(rw, result)
+}
+
+func handleStatisticLinkQuery(rw http.ResponseWriter, req *http.Request) {
+	link, err := textParam(req.URL.Query(), "link", maxLinkLength)
 	if err != nil {
-		http.Error(rw, fmt.Sprintf("could not extract measurementObid from request body: %v", err), http.StatusBadRequest)
+		writeParamError(rw, err)
 		return
 	}
 
-	snClient := req.Context().Value("SnClient").(*stablenet.StableNetClient)
+	snClient := req.Context().Value("SnClient").(stablenet.Client)
 
-	metrics, err := snClient.FetchMetricsForMeasurement(measurementObid)
+	resolution, err := stablenet.ResolveStatisticLink(req.Context(), snClient, link)
 	if err != nil {
-		http.Error(rw, fmt.Sprintf("could not query metrics: %v", err), http.StatusInternalServerError)
+		writeClientError(rw, "resolve statistic link", err)
 		return
 	}
 
-	encodeJson(rw, metrics)
+	encodeJson(rw, resolution)
 }
 
 // Encoding a json only results in an error if the data to be serialized does contain unserializable types, e.g. functions, channels, etc.
@@ -8,70 +8,314 @@
 package main
 
 import (
-	"backend-plugin/mock"
-	"backend-plugin/stablenet"
 	"context"
 	"encoding/json"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/mock"
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// Ten minutes whose start is a multiple of the average the mock server chooses, so that it returns ten entries.
+var testTimeRange = backend.TimeRange{From: time.UnixMilli(1699999980000), To: time.UnixMilli(1699999980000).Add(10 * time.Minute)}
+
 type handlerTests struct {
 	name       string
 	urlParams  string
 	filter     string
 	wantStatus int
+	wantCode   string
 	wantErrMsg string
 }
 
-func TestDataSource_QueryData(t *testing.T) {
-	handler := mock.CreateHandler(
-		mock.CreateMockServer(testStableNetUsername, testStableNetPassword),
-	)
-	server := httptest.NewServer(handler)
-	defer server.Close()
+// Returns a request of the datasource 5 for the queries. Each query is given as JSON or as a value that is marshalled
+// to JSON, its RefId is "A", "B" and so on.
+func newQueryDataRequest(t *testing.T, queries ...interface{}) *backend.QueryDataRequest {
+	request := &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			ID:                      5,
+			URL:                     testStableNetUrl,
+			User:                    testStableNetUsername,
+			DecryptedSecureJSONData: map[string]string{"password": testStableNetPassword},
+		}},
+	}
+	for i, query := range queries {
+		content, ok := query.(string)
+		if !ok {
+			marshalled, err := json.Marshal(query)
+			require.NoError(t, err, "marshalling the query failed")
+			content = string(marshalled)
+		}
+		request.Queries = append(request.Queries, backend.DataQuery{RefID: string(rune('A' + i)), JSON: []byte(content), TimeRange: testTimeRange})
+	}
+	return request
+}
 
-	instanceSettings := backend.DataSourceInstanceSettings{
-		ID:   5,
-		URL:  testStableNetUrl,
-		User: testStableNetUsername,
-		DecryptedSecureJSONData: map[string]string{
-			"password": testStableNetPassword,
-		},
+// Runs the queries with the datasource 5, whose settings were checked already, against the default fixtures of the
+// mock server.
+func runQueryData(t *testing.T, queries ...interface{}) *backend.QueryDataResponse {
+	snServer := mock.CreateMockServer(testStableNetUsername, testStableNetPassword)
+	datasource := checkedDataSource(snServer, map[int64]bool{5: true})
+	got, err := datasource.QueryData(context.Background(), newQueryDataRequest(t, queries...))
+	require.NoError(t, err, "errors of single queries should be reported in their responses")
+	return got
+}
+
+// Returns a statistic link query for the first metric of measurement 1001 as stored by earlier versions of the plugin,
+// with the given fields in addition.
+func legacyLinkQuery(fields map[string]interface{}) map[string]interface{} {
+	query := map[string]interface{}{"StatisticLink": "?id=1001&value0=1", "includeAvgStats": true, "mode": StatisticLink}
+	for key, value := range fields {
+		query[key] = value
 	}
+	return query
+}
 
-	dataQueryByteData, _ := json.Marshal(map[string]interface{}{
-		"StatisticLink":   "?id=1001",
-		"includeMinStats": true,
-		"mode":            StatisticLink,
-	})
+func legacyPatternQuery(pattern MetricPattern) map[string]interface{} {
+	return map[string]interface{}{
+		"selectedMeasurement": map[string]interface{}{"value": 1001},
+		"metricPrefix":        "Host",
+		"includeAvgStats":     true,
+		"useMetricPatterns":   true,
+		"metricPatterns":      []MetricPattern{pattern},
+	}
+}
+
+func legacyRankingQuery(ranking Ranking) map[string]interface{} {
+	return map[string]interface{}{"mode": TopN, "includeAvgStats": true, "ranking": ranking}
+}
 
-	request := backend.QueryDataRequest{
-		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &instanceSettings},
-		Queries: []backend.DataQuery{
-			{RefID: "A", JSON: dataQueryByteData},
+func uptimeQuery(average int64, transformations map[string][]Transformation) QueryModel {
+	return QueryModel{QueryVersion: queryVersion, MeasurementObid: 1001, Metrics: []MetricRef{{Key: "SNMP_1", Name: "Uptime"}}, Average: average, Transformations: transformations}
+}
+
+func TestDataSource_QueryData(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      interface{}
+		wantStatus backend.Status
+		wantErr    string
+		check      func(t *testing.T, frames data.Frames)
+	}{
+		{
+			name:  "statistic link",
+			query: legacyLinkQuery(map[string]interface{}{"includeAvgStats": false, "includeMinStats": true}),
+			check: func(t *testing.T, frames data.Frames) {
+				require.Equal(t, 1, len(frames), "number of frames wrong")
+				assert.Equal(t, mock.DefaultMetrics[0].Name, frames[0].Name, "name of frame is wrong")
+				assert.Equal(t, 10, frames[0].Rows(), "one row per minute of the time range expected")
+				assert.Equal(t, 5.0, frames[0].Fields[1].At(0), "value is wrong")
+			},
+		},
+		{
+			name:       "statistic link of unknown measurement",
+			query:      legacyLinkQuery(map[string]interface{}{"StatisticLink": "?id=4711"}),
+			wantStatus: backend.StatusNotFound,
+			wantErr:    "StableNet® could not find the requested entity: could not resolve statistic link: could not fetch metrics for measurement 4711: retrieving metrics for measurement 4711 failed: status code: 404, response: 404 page not found",
+		},
+		{
+			name:  "glob pattern",
+			query: legacyPatternQuery(MetricPattern{Mode: MatchGlob, Pattern: "Up*"}),
+			check: func(t *testing.T, frames data.Frames) {
+				require.Equal(t, 1, len(frames), "number of frames wrong")
+				assert.Equal(t, "Host Uptime", frames[0].Name, "name of frame is wrong")
+			},
+		},
+		{
+			name:    "invalid regex pattern",
+			query:   legacyPatternQuery(MetricPattern{Mode: MatchRegex, Pattern: "("}),
+			wantErr: "invalid query: field \"metricPatterns\": invalid regular expression \"(\": error parsing regexp: missing closing ): `(`",
+		},
+		{
+			name:  "pattern without matches",
+			query: legacyPatternQuery(MetricPattern{Mode: MatchExact, Pattern: "SNMP_4711"}),
+			check: func(t *testing.T, frames data.Frames) {
+				assert.Empty(t, frames, "a pattern without matches should not return data")
+			},
+		},
+		{
+			name:  "aggregation",
+			query: legacyLinkQuery(map[string]interface{}{"aggregation": Aggregation{Function: AggregateSum}}),
+			check: func(t *testing.T, frames data.Frames) {
+				require.Equal(t, 1, len(frames), "the aggregation should replace the inputs")
+				assert.Equal(t, "sum", frames[0].Name, "name of aggregated frame")
+			},
+		},
+		{
+			name:  "aggregation by device keeping the inputs",
+			query: legacyLinkQuery(map[string]interface{}{"aggregation": Aggregation{Function: AggregateCount, GroupBy: GroupByDevice, KeepInputs: true}}),
+			check: func(t *testing.T, frames data.Frames) {
+				require.Equal(t, 2, len(frames), "the aggregation should be returned next to the inputs")
+				assert.Equal(t, mock.DefaultMetrics[0].Name, frames[0].Name, "name of input frame")
+				assert.Equal(t, "count (Bach)", frames[1].Name, "name of aggregated frame")
+			},
+		},
+		{
+			name:    "unknown aggregation function",
+			query:   legacyLinkQuery(map[string]interface{}{"aggregation": Aggregation{Function: "median"}}),
+			wantErr: "invalid query: field \"aggregation\": unknown aggregation function \"median\"",
+		},
+		{
+			name:  "ranking",
+			query: legacyRankingQuery(Ranking{DeviceObid: 9000, Metric: MetricPattern{Mode: MatchExact, Pattern: "SNMP_1"}, RankBy: RankByAvg, Limit: 3}),
+			check: func(t *testing.T, frames data.Frames) {
+				require.Equal(t, 1, len(frames), "only measurement 1001 has the metric")
+				assert.Equal(t, "Host Uptime", frames[0].Name, "name of frame")
+			},
+		},
+		{
+			name:    "ranking without limit",
+			query:   legacyRankingQuery(Ranking{DeviceObid: 9000, Metric: MetricPattern{Mode: MatchExact, Pattern: "SNMP_1"}, RankBy: RankByAvg}),
+			wantErr: "invalid query: field \"ranking\": the number of series must be positive, but is 0",
+		},
+		{
+			name:  "summary",
+			query: legacyLinkQuery(map[string]interface{}{"format": FormatSummary}),
+			check: func(t *testing.T, frames data.Frames) {
+				require.Equal(t, 1, len(frames), "the summary should replace the time series")
+				assert.Equal(t, "Summary", frames[0].Name, "name of summary frame")
+				require.Equal(t, 1, frames[0].Rows(), "the summary should contain one row per metric")
+				assert.Equal(t, []interface{}{"Bach", "Host", mock.DefaultMetrics[0].Name}, frames[0].RowCopy(0)[:3], "labels of summary row wrong")
+			},
+		},
+		{
+			name:  "summary with percentile 0",
+			query: legacyLinkQuery(map[string]interface{}{"format": FormatSummary, "summaryPercentile": 0}),
+			check: func(t *testing.T, frames data.Frames) {
+				require.Equal(t, 1, len(frames), "the summary should replace the time series")
+				assert.Equal(t, "P0", frames[0].Fields[7].Name, "the percentile 0 should not be replaced by the default")
+			},
+		},
+		{
+			name:    "summary with invalid percentile",
+			query:   legacyLinkQuery(map[string]interface{}{"format": FormatSummary, "summaryPercentile": 150}),
+			wantErr: "invalid query: field \"summaryPercentile\": the percentile 150 is not between 0 and 100",
+		},
+		{
+			name:  "summary of a link with its own time range",
+			query: `{"queryVersion": 1, "mode": 10, "statisticLink": "?0id=1001&0value0=1&0interval=300000&0last=0,60", "useLinkTimeRange": true, "useLinkAverage": true, "format": "summary", "average": 60000}`,
+			check: func(t *testing.T, frames data.Frames) {
+				require.Equal(t, 1, len(frames), "number of frames wrong")
+				wantMeta := FrameMetadata{Start: testTimeRange.From, End: testTimeRange.To, Average: 60000}
+				assert.Equal(t, wantMeta, frames[0].Meta.Custom, "the summary should cover the time range and average of the query, not of the link")
+				assert.Equal(t, 100.0, frames[0].Fields[9].At(0), "the data of the time range of the query should be summarized")
+			},
+		},
+		{
+			name:  "average left to StableNet®",
+			query: uptimeQuery(0, nil),
+			check: func(t *testing.T, frames data.Frames) {
+				assert.Equal(t, int64(0), frames[0].Meta.Custom.(FrameMetadata).Average, "a query without average should not get one")
+				assert.Equal(t, "s", frames[0].Fields[1].Config.Unit, "the unit of the metric should be set")
+				assert.Empty(t, frames[0].Meta.Notices, "no warning expected")
+			},
+		},
+		{
+			name:  "average shorter than the polling interval",
+			query: uptimeQuery(30000, nil),
+			check: func(t *testing.T, frames data.Frames) {
+				assert.Equal(t, []data.Notice{{Severity: data.NoticeSeverityWarning, Text: "The average of 30s is shorter than the polling interval of 1m of measurement 1001, StableNet® has at most one value per polling interval."}}, frames[0].Meta.Notices, "a short average should be warned about")
+			},
+		},
+		{
+			name:  "transformed metric",
+			query: uptimeQuery(0, map[string][]Transformation{"SNMP_1": {{Kind: TransformMultiply, Factor: 2}}}),
+			check: func(t *testing.T, frames data.Frames) {
+				assert.Nil(t, frames[0].Fields[1].Config, "transformed metrics should have no unit")
+			},
+		},
+		{
+			name:  "versioned query",
+			query: `{"refId": "A", "queryVersion": 1, "measurementObid": 1001, "metrics": [{"key": "SNMP_1", "name": "Uptime"}]}`,
+			check: func(t *testing.T, frames data.Frames) {
+				require.Equal(t, 1, len(frames), "number of frames")
+				assert.Equal(t, "Uptime", frames[0].Name, "name of frame")
+				assert.Equal(t, "Avg", frames[0].Fields[1].Name, "the statistics should default to avg")
+			},
+		},
+		{
+			name:       "unknown mode",
+			query:      `{"refId": "A", "queryVersion": 1, "mode": 7}`,
+			wantStatus: backend.StatusBadRequest,
+			wantErr:    "invalid query: field \"mode\": unknown mode 7",
 		},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runQueryData(t, tt.query)
+			response := got.Responses["A"]
+			if tt.wantErr != "" {
+				assert.EqualError(t, response.Error, tt.wantErr, "error wrong")
+				if tt.wantStatus != 0 {
+					assert.Equal(t, tt.wantStatus, response.Status, "status wrong")
+				}
+				return
+			}
+			require.NoError(t, response.Error, "the query should succeed")
+			tt.check(t, response.Frames)
+		})
+	}
+}
 
-	ctx := context.WithValue(context.Background(), "sn_address", server.URL)
+func TestDataSource_QueryData_SingleErrors(t *testing.T) {
+	got := runQueryData(t, legacyLinkQuery(nil), legacyLinkQuery(map[string]interface{}{"StatisticLink": "?id=4711"}), `{"queryVersion": 1, "mode": 7}`)
 
-	datasource := dataSource{validationStore: map[int64]bool{5: true}}
-	got, err := datasource.QueryData(ctx, &request)
+	require.Equal(t, 3, len(got.Responses), "number of responses wrong")
+	assert.NoError(t, got.Responses["A"].Error, "the valid query should succeed")
+	assert.Equal(t, 1, len(got.Responses["A"].Frames), "number of frames of the valid query wrong")
+	assert.Equal(t, backend.StatusNotFound, got.Responses["B"].Status, "status of the query of an unknown measurement wrong")
+	assert.Equal(t, backend.StatusBadRequest, got.Responses["C"].Status, "status of the invalid query wrong")
+}
 
-	require.NoError(t, err, "no error expected")
-	require.Equal(t, 1, len(got.Responses), "number of responses wrong")
+// offsetClient moves the values of a measurement by an offset, as if its device was polled at other times.
+type offsetClient struct {
+	stablenet.Client
+	measurementObid int
+	offset          time.Duration
+}
 
-	frames := got.Responses["A"].Frames
-	require.Equal(t, 1, len(frames), "number of frames wrong")
+func (c *offsetClient) FetchDataForMetrics(ctx context.Context, options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+	result, err := c.Client.FetchDataForMetrics(ctx, options)
+	if err != nil || options.MeasurementObid != c.measurementObid {
+		return result, err
+	}
+	for _, series := range result {
+		for index := range series {
+			series[index].Time = series[index].Time.Add(c.offset)
+		}
+	}
+	return result, nil
+}
+
+func TestDataSource_QueryData_AggregationWithoutAverage(t *testing.T) {
+	fixtures := mock.DefaultFixtures(testStableNetUsername, testStableNetPassword)
+	fixtures.Measurements[2].Metrics = fixtures.Measurements[0].Metrics
+	snServer := mock.NewServer(fixtures)
+	datasource := checkedDataSource(snServer, map[int64]bool{5: true})
+	datasource.newClient = func(options *stablenet.ConnectOptions, _ log.Logger) stablenet.Client {
+		return &offsetClient{Client: mock.NewClient(snServer, options.Username, options.Password), measurementObid: 1003, offset: 30 * time.Second}
+	}
+	query := map[string]interface{}{"queryVersion": queryVersion, "mode": StatisticLink, "statisticLink": "?0id=1001&0value0=1&1id=1003&1value0=1", "aggregation": Aggregation{Function: AggregateCount}}
 
-	assert.Equal(t, mock.DefaultMetrics[0].Name, frames[0].Name, "name of frame is wrong")
-	assert.Equal(t, 5.0, frames[0].Fields[1].At(0), "value is wrong")
+	got, err := datasource.QueryData(context.Background(), newQueryDataRequest(t, query))
+	require.NoError(t, err, "no error expected")
+	require.NoError(t, got.Responses["A"].Error, "the query should succeed")
+	require.Equal(t, 1, len(got.Responses["A"].Frames), "number of frames wrong")
+	frame := got.Responses["A"].Frames[0]
+	assert.Equal(t, int64(60000), frame.Meta.Custom.(FrameMetadata).Average, "the average chosen by StableNet® should be reported")
+	require.Equal(t, 10, frame.Rows(), "the values of both devices should be aligned onto the same grid")
+	for row := 0; row < frame.Rows(); row++ {
+		assert.Equal(t, 2.0, frame.Fields[1].At(row), "both devices should be counted in row %d", row)
+	}
 }
 
 func TestHandleDeviceQuery(t *testing.T) {
@@ -80,7 +324,7 @@ func TestHandleDeviceQuery(t *testing.T) {
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
-	client := stablenet.NewStableNetClient(&stablenet.ConnectOptions{Username: testStableNetUsername, Password: testStableNetPassword, Address: server.URL})
+	client := stablenet.NewClient(server.URL, stablenet.WithCredentials(testStableNetUsername, testStableNetPassword))
 	t.Run("empty device filter", func(t *testing.T) {
 		request := httptest.NewRequest("GET", "http://example.org/", strings.NewReader(""))
 		ctx := context.WithValue(request.Context(), "SnClient", client)
@@ -96,7 +340,7 @@ func TestHandleDeviceQuery(t *testing.T) {
 			"$orderBy": {"name"},
 			"$top":     {"100"},
 		}
-		assert.Equal(t, url.Values(wantQueryParam), snServer.LastQueries, "no queries wrong params")
+		assert.Equal(t, url.Values(wantQueryParam), snServer.LastQuery(), "no queries wrong params")
 	})
 
 	t.Run("device filter", func(t *testing.T) {
@@ -108,23 +352,60 @@ func TestHandleDeviceQuery(t *testing.T) {
 		assert.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
 		var got stablenet.DeviceQueryResult
 		_ = json.Unmarshal(recorder.Body.Bytes(), &got)
-		assert.Equal(t, mock.DefaultDevices, got.Data, "devices wrong")
+		assert.Equal(t, mock.DefaultDevices[:1], got.Data, "devices wrong")
 		wantQueryParam := map[string][]string{
 			"$orderBy": {"name"},
 			"$filter":  {"name ct 'bach'"},
 			"$top":     {"100"},
 		}
-		assert.Equal(t, url.Values(wantQueryParam), snServer.LastQueries, "no queries wrong params")
+		assert.Equal(t, url.Values(wantQueryParam), snServer.LastQuery(), "no queries wrong params")
+	})
+	searchTests := []struct {
+		name       string
+		urlParams  string
+		wantFilter string
+		wantObids  []int
+	}{
+		{name: "ip", urlParams: "?ip=10.1.2", wantFilter: "ip ct '10.1.2'", wantObids: []int{9000, 9001}},
+		{name: "ip and vendor", urlParams: "?ip=10.1&vendor=2636", wantFilter: "ip ct '10.1' and vendor eq '2636'", wantObids: []int{9001}},
+		{name: "vendor with filter", urlParams: "?vendor=311&filter=m", wantFilter: "name ct 'm' and vendor eq '311'", wantObids: []int{9002}},
+		{name: "vendor number", urlParams: "?vendor=9", wantFilter: "vendor eq '9'", wantObids: []int{9000}},
+		{name: "quoted device filter", urlParams: "?filter=" + url.QueryEscape("o'b"), wantFilter: "name ct 'o''b'", wantObids: []int{}},
+	}
+	for _, tt := range searchTests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "http://example.org/"+tt.urlParams, strings.NewReader(""))
+			request = request.WithContext(context.WithValue(request.Context(), "SnClient", client))
+			recorder := httptest.NewRecorder()
+			handleDeviceQuery(recorder, request)
+			require.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
+			var got stablenet.DeviceQueryResult
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got), "no error expected")
+			obids := make([]int, 0, len(got.Data))
+			for _, device := range got.Data {
+				obids = append(obids, device.Obid)
+			}
+			assert.Equal(t, tt.wantObids, obids, "devices wrong")
+			assert.Equal(t, url.Values{"$orderBy": {"name"}, "$filter": {tt.wantFilter}, "$top": {"100"}}, snServer.LastQuery(), "query params are wrong")
+		})
+	}
+	t.Run("vendor name", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "http://example.org/?vendor=microsoft", strings.NewReader(""))
+		request = request.WithContext(context.WithValue(request.Context(), "SnClient", client))
+		recorder := httptest.NewRecorder()
+		handleDeviceQuery(recorder, request)
+		assert.Equal(t, 400, recorder.Result().StatusCode, "status is wrong")
+		assert.Equal(t, resourceError{Code: codeInvalidParam, Message: "invalid query param vendor: \"microsoft\" is not an IANA enterprise number, e.g. 9 for Cisco"}, resourceErrorOf(t, recorder), "error wrong")
 	})
 	t.Run("server error", func(t *testing.T) {
-		client := stablenet.NewStableNetClient(&stablenet.ConnectOptions{Username: "", Password: "", Address: server.URL})
+		client := stablenet.NewClient(server.URL, stablenet.WithCredentials("", ""))
 		request := httptest.NewRequest("GET", "http://example.org/", strings.NewReader(""))
 		ctx := context.WithValue(request.Context(), "SnClient", client)
 		request = request.WithContext(ctx)
 		recorder := httptest.NewRecorder()
 		handleDeviceQuery(recorder, request)
-		assert.Equal(t, 500, recorder.Result().StatusCode, "status is wrong")
-		assert.Equal(t, "could not query devices: retrieving devices matching query \"\" failed: status code: 401, response: Authentication Error\n\n", recorder.Body.String(), "error message is wrong")
+		assert.Equal(t, 403, recorder.Result().StatusCode, "status is wrong")
+		assert.Equal(t, resourceError{Code: codeForbidden, Message: "could not query devices: retrieving devices matching query \"\" failed: status code: 401, response: Authentication Error\n"}, resourceErrorOf(t, recorder), "error wrong")
 	})
 }
 
@@ -133,10 +414,12 @@ func TestHandleMeasurementQuery(t *testing.T) {
 	handler := mock.CreateHandler(snServer)
 	server := httptest.NewServer(handler)
 	defer server.Close()
-	client := stablenet.NewStableNetClient(&stablenet.ConnectOptions{Username: snServer.Username, Password: snServer.Password, Address: server.URL})
+	client := stablenet.NewClient(server.URL, stablenet.WithCredentials(snServer.Username, snServer.Password))
 	requestErrorTests := []handlerTests{
-		{name: "no device obid", urlParams: "?obid=4500&filter=host", wantStatus: 400, wantErrMsg: "could not parse deviceObid query param: strconv.Atoi: parsing \"\": invalid syntax"},
-		{name: "unparsable device obid", urlParams: "?deviceObid=a_string", wantStatus: 400, wantErrMsg: "could not parse deviceObid query param: strconv.Atoi: parsing \"a_string\": invalid syntax"},
+		{name: "no device obid", urlParams: "?obid=4500&filter=host", wantStatus: 400, wantCode: codeInvalidParam, wantErrMsg: "invalid query param deviceObid: the param is required"},
+		{name: "unparsable device obid", urlParams: "?deviceObid=a_string", wantStatus: 400, wantCode: codeInvalidParam, wantErrMsg: "invalid query param deviceObid: \"a_string\" is not a number between 1 and 2147483647"},
+		{name: "too large device obid", urlParams: "?deviceObid=2147483648", wantStatus: 400, wantCode: codeInvalidParam, wantErrMsg: "invalid query param deviceObid: \"2147483648\" is not a number between 1 and 2147483647"},
+		{name: "too long type", urlParams: "?deviceObid=9000&type=" + strings.Repeat("x", 201), wantStatus: 400, wantCode: codeInvalidParam, wantErrMsg: "invalid query param type: the text is longer than 200 characters"},
 	}
 	for _, tt := range requestErrorTests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -145,55 +428,67 @@ func TestHandleMeasurementQuery(t *testing.T) {
 			recorder := httptest.NewRecorder()
 			handleMeasurementQuery(recorder, request)
 			assert.Equal(t, tt.wantStatus, recorder.Result().StatusCode, "status is wrong")
-			assert.Equal(t, tt.wantErrMsg+"\n", recorder.Body.String(), "error message is wrong")
+			assert.Equal(t, resourceError{Code: tt.wantCode, Message: tt.wantErrMsg}, resourceErrorOf(t, recorder), "error wrong")
 		})
 	}
 
 	t.Run("success without filter", func(t *testing.T) {
-		request := httptest.NewRequest("GET", "http://example.org/?deviceObid=4500", strings.NewReader(""))
+		request := httptest.NewRequest("GET", "http://example.org/?deviceObid=9000", strings.NewReader(""))
 		ctx := context.WithValue(request.Context(), "SnClient", client)
 		request = request.WithContext(ctx)
 		recorder := httptest.NewRecorder()
 		handleMeasurementQuery(recorder, request)
 		assert.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
 		wantValues := map[string][]string{
-			"$filter":  {"destDeviceId eq '4500'"},
+			"$filter":  {"destDeviceId eq '9000'"},
 			"$orderBy": {"name"},
 			"$top":     {"100"},
 		}
-		assert.Equal(t, url.Values(wantValues), snServer.LastQueries, "query params are wrong")
+		assert.Equal(t, url.Values(wantValues), snServer.LastQuery(), "query params are wrong")
 		var got stablenet.MeasurementQueryResult
 		err := json.Unmarshal(recorder.Body.Bytes(), &got)
 		require.NoError(t, err, "no error expected")
-		assert.Equal(t, snServer.Measurements, got.Data, "measurements differ")
+		assert.Equal(t, snServer.Measurements[:2], got.Data, "measurements differ")
 	})
 	t.Run("success with filter", func(t *testing.T) {
-		request := httptest.NewRequest("GET", "http://example.org/?deviceObid=4500&filter=processor", strings.NewReader(""))
+		request := httptest.NewRequest("GET", "http://example.org/?deviceObid=9000&filter=processor", strings.NewReader(""))
 		ctx := context.WithValue(request.Context(), "SnClient", client)
 		request = request.WithContext(ctx)
 		recorder := httptest.NewRecorder()
 		handleMeasurementQuery(recorder, request)
 		assert.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
 		wantValues := map[string][]string{
-			"$filter":  {"destDeviceId eq '4500' and name ct 'processor'"},
+			"$filter":  {"destDeviceId eq '9000' and name ct 'processor'"},
 			"$orderBy": {"name"},
 			"$top":     {"100"},
 		}
-		assert.Equal(t, url.Values(wantValues), snServer.LastQueries, "query params are wrong")
+		assert.Equal(t, url.Values(wantValues), snServer.LastQuery(), "query params are wrong")
 		var got stablenet.MeasurementQueryResult
 		err := json.Unmarshal(recorder.Body.Bytes(), &got)
 		require.NoError(t, err, "no error expected")
-		assert.Equal(t, snServer.Measurements, got.Data, "measurements differ")
+		assert.Equal(t, snServer.Measurements[1:2], got.Data, "measurements differ")
+	})
+	t.Run("success with type", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "http://example.org/?deviceObid=9001&type=interface", strings.NewReader(""))
+		request = request.WithContext(context.WithValue(request.Context(), "SnClient", client))
+		recorder := httptest.NewRecorder()
+		handleMeasurementQuery(recorder, request)
+		assert.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
+		wantValues := url.Values{"$filter": {"destDeviceId eq '9001' and type ct 'interface'"}, "$orderBy": {"name"}, "$top": {"100"}}
+		assert.Equal(t, wantValues, snServer.LastQuery(), "query params are wrong")
+		var got stablenet.MeasurementQueryResult
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got), "no error expected")
+		assert.Equal(t, snServer.Measurements[2:], got.Data, "measurements differ")
 	})
 	t.Run("server error", func(t *testing.T) {
-		client := stablenet.NewStableNetClient(&stablenet.ConnectOptions{Username: "", Password: "", Address: server.URL})
+		client := stablenet.NewClient(server.URL, stablenet.WithCredentials("", ""))
 		request := httptest.NewRequest("GET", "http://example.org/?deviceObid=1111", strings.NewReader(""))
 		ctx := context.WithValue(request.Context(), "SnClient", client)
 		request = request.WithContext(ctx)
 		recorder := httptest.NewRecorder()
 		handleMeasurementQuery(recorder, request)
-		assert.Equal(t, 500, recorder.Result().StatusCode, "status is wrong")
-		assert.Equal(t, "could not query measurements: retrieving measurements for device filter \"destDeviceId eq '1111'\" failed: status code: 401, response: Authentication Error\n\n", recorder.Body.String(), "error message is wrong")
+		assert.Equal(t, 403, recorder.Result().StatusCode, "status is wrong")
+		assert.Equal(t, resourceError{Code: codeForbidden, Message: "could not query measurements: retrieving measurements for device filter \"destDeviceId eq '1111'\" failed: status code: 401, response: Authentication Error\n"}, resourceErrorOf(t, recorder), "error wrong")
 	})
 }
 
@@ -202,10 +497,10 @@ func TestHandleMetricQuery(t *testing.T) {
 	handler := mock.CreateHandler(snServer)
 	server := httptest.NewServer(handler)
 	defer server.Close()
-	client := stablenet.NewStableNetClient(&stablenet.ConnectOptions{Username: snServer.Username, Password: snServer.Password, Address: server.URL})
+	client := stablenet.NewClient(server.URL, stablenet.WithCredentials(snServer.Username, snServer.Password))
 	tests := []handlerTests{
-		{name: "no measurement obid", urlParams: "?obid=4500&filter=host", wantStatus: 400, wantErrMsg: "could not extract measurementObid from request body: strconv.Atoi: parsing \"\": invalid syntax"},
-		{name: "unparsable measurement obid", urlParams: "?measurementObid=string", wantStatus: 400, wantErrMsg: "could not extract measurementObid from request body: strconv.Atoi: parsing \"string\": invalid syntax"},
+		{name: "no measurement obid", urlParams: "?obid=4500&filter=host", wantStatus: 400, wantCode: codeInvalidParam, wantErrMsg: "invalid query param measurementObid: the param is required"},
+		{name: "unparsable measurement obid", urlParams: "?measurementObid=string", wantStatus: 400, wantCode: codeInvalidParam, wantErrMsg: "invalid query param measurementObid: \"string\" is not a number between 1 and 2147483647"},
 		{name: "success", urlParams: "?measurementObid=1001", wantStatus: 200},
 	}
 	for _, tt := range tests {
@@ -217,24 +512,83 @@ func TestHandleMetricQuery(t *testing.T) {
 			handleMetricQuery(recorder, request)
 			assert.Equal(t, tt.wantStatus, recorder.Result().StatusCode, "status is wrong")
 			if len(tt.wantErrMsg) == 0 {
-				var got []stablenet.Metric
+				var got []metricDescription
 				err := json.Unmarshal(recorder.Body.Bytes(), &got)
 				require.NoError(t, err, "no error expected")
-				assert.Equal(t, snServer.Metrics, got, "metrics differ")
+				want := []metricDescription{
+					{Metric: mock.DefaultMetrics[0], GrafanaUnit: "s", PollingInterval: 60000},
+					{Metric: mock.DefaultMetrics[1], GrafanaUnit: "percent", PollingInterval: 60000},
+				}
+				assert.Equal(t, want, got, "metrics differ")
 			} else {
-				assert.Equal(t, tt.wantErrMsg+"\n", recorder.Body.String(), "error message is wrong")
+				assert.Equal(t, resourceError{Code: tt.wantCode, Message: tt.wantErrMsg}, resourceErrorOf(t, recorder), "error wrong")
 			}
 		})
 	}
 	t.Run("server error", func(t *testing.T) {
-		client := stablenet.NewStableNetClient(&stablenet.ConnectOptions{Username: "", Password: "", Address: server.URL})
+		client := stablenet.NewClient(server.URL, stablenet.WithCredentials("", ""))
 		request := httptest.NewRequest("GET", "http://example.org/?measurementObid=1001", strings.NewReader(""))
 		ctx := context.WithValue(request.Context(), "SnClient", client)
 		request = request.WithContext(ctx)
 		recorder := httptest.NewRecorder()
 		handleMetricQuery(recorder, request)
-		assert.Equal(t, 500, recorder.Result().StatusCode, "status is wrong")
-		assert.Equal(t, "could not query metrics: retrieving metrics for measurement 1001 failed: status code: 401, response: Authentication Error\n\n", recorder.Body.String(), "error message is wrong")
+		assert.Equal(t, 403, recorder.Result().StatusCode, "status is wrong")
+		assert.Equal(t, resourceError{Code: codeForbidden, Message: "could not query metrics: retrieving metrics for measurement 1001 failed: status code: 401, response: Authentication Error\n"}, resourceErrorOf(t, recorder), "error wrong")
+	})
+}
+
+func TestHandleStatisticLinkQuery(t *testing.T) {
+	snServer := mock.CreateMockServer("infosim", "stablenet")
+	client := mock.NewClient(snServer, snServer.Username, snServer.Password)
+	query := func(client stablenet.Client, link string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "http://example.org/?link="+url.QueryEscape(link), strings.NewReader(""))
+		ctx := context.WithValue(request.Context(), "SnClient", client)
+		request = request.WithContext(ctx)
+		recorder := httptest.NewRecorder()
+		handleStatisticLinkQuery(recorder, request)
+		return recorder
+	}
+	errorTests := []handlerTests{
+		{name: "no link", urlParams: "", wantStatus: 400, wantCode: codeInvalidParam, wantErrMsg: "could not resolve statistic link: the link \"\" does not carry at least a measurement id"},
+		{name: "invalid link", urlParams: "?id=abc", wantStatus: 400, wantCode: codeInvalidParam, wantErrMsg: "could not resolve statistic link: the link \"?id=abc\" is invalid: invalid measurement id \"abc\" in parameter id"},
+		{name: "too long link", urlParams: "?id=1001&" + strings.Repeat("x", 4096), wantStatus: 400, wantCode: codeInvalidParam, wantErrMsg: "invalid query param link: the text is longer than 4096 characters"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := query(client, tt.urlParams)
+			assert.Equal(t, tt.wantStatus, recorder.Result().StatusCode, "status is wrong")
+			assert.Equal(t, resourceError{Code: tt.wantCode, Message: tt.wantErrMsg}, resourceErrorOf(t, recorder), "error wrong")
+		})
+	}
+	t.Run("success", func(t *testing.T) {
+		recorder := query(client, "https://localhost:5443/PlotServlet?0id=1001&0value0=1&1id=4711")
+		require.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
+		var got stablenet.LinkResolution
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got), "no error expected")
+		want := []stablenet.ResolvedMeasurement{{
+			Obid:    1001,
+			Name:    "Host",
+			Device:  &mock.DefaultDevices[0],
+			Metrics: mock.DefaultMetrics[:1],
+		}}
+		assert.Equal(t, want, got.Measurements, "resolved measurements wrong")
+		require.Equal(t, 1, len(got.Unresolved), "number of unresolved measurements")
+		assert.Equal(t, 4711, got.Unresolved[0].Obid, "obid of unresolved measurement")
+		assert.Contains(t, got.Unresolved[0].Error, "retrieving metrics for measurement 4711 failed: status code: 404", "error of unresolved measurement")
+	})
+	t.Run("no requested metric", func(t *testing.T) {
+		recorder := query(client, "https://localhost:5443/PlotServlet?id=1001&value0=3")
+		require.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
+		var got stablenet.LinkResolution
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got), "no error expected")
+		assert.Empty(t, got.Measurements, "no measurement should be resolved")
+		assert.Equal(t, []stablenet.UnresolvedMeasurement{{Obid: 1001, Error: "measurement 1001 has none of the metrics requested by the link"}}, got.Unresolved, "unresolved measurements wrong")
+	})
+	t.Run("server error", func(t *testing.T) {
+		client := mock.NewClient(snServer, "", "")
+		recorder := query(client, "?id=1001")
+		assert.Equal(t, 403, recorder.Result().StatusCode, "status is wrong")
+		assert.Equal(t, resourceError{Code: codeForbidden, Message: "could not resolve statistic link: could not fetch metrics for measurement 1001: retrieving metrics for measurement 1001 failed: status code: 401, response: Authentication Error"}, resourceErrorOf(t, recorder), "error wrong")
 	})
 }
 
@@ -0,0 +1,80 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Returns the HTTP status code the resource handlers answer with for the given error.
+// Rejected StableNet® credentials are reported as 403, since Grafana® treats a 401 as an expired Grafana® session.
+func httpStatusForError(err error) int {
+	switch {
+	case isQueryError(err):
+		return http.StatusBadRequest
+	case errors.Is(err, stablenet.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, stablenet.ErrUnauthorized), errors.Is(err, stablenet.ErrLicense), errors.Is(err, stablenet.ErrUnsupportedVersion):
+		return http.StatusForbidden
+	case errors.Is(err, stablenet.ErrTimeout):
+		return http.StatusGatewayTimeout
+	case isStableNetError(err):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// Returns the status of a DataResponse for the given error.
+func dataStatusForError(err error) backend.Status {
+	switch {
+	case isQueryError(err):
+		return backend.StatusBadRequest
+	case errors.Is(err, stablenet.ErrNotFound):
+		return backend.StatusNotFound
+	case errors.Is(err, stablenet.ErrUnauthorized):
+		return backend.StatusUnauthorized
+	case errors.Is(err, stablenet.ErrLicense), errors.Is(err, stablenet.ErrUnsupportedVersion):
+		return backend.StatusForbidden
+	case errors.Is(err, stablenet.ErrTimeout):
+		return backend.StatusTimeout
+	case isStableNetError(err):
+		return backend.StatusBadGateway
+	}
+	return backend.StatusInternal
+}
+
+// Returns a DataResponse that reports the error to the panel with the user message of the error. Invalid queries are
+// reported as downstream errors, since they are caused by the user and not by the plugin.
+func errorDataResponse(err error) backend.DataResponse {
+	source := backend.ErrorSourcePlugin
+	if isStableNetError(err) || isQueryError(err) {
+		source = backend.ErrorSourceDownstream
+	}
+	return backend.ErrDataResponseWithSource(dataStatusForError(err), source, stablenet.UserMessage(err))
+}
+
+// Returns true if the error was caused by the StableNet® server, i.e. the request failed, the status code was unexpected,
+// or the answer was invalid.
+func isStableNetError(err error) bool {
+	var statusErr *stablenet.StatusError
+	var requestErr *stablenet.RequestError
+	var parseErr *stablenet.ParseError
+	return errors.As(err, &statusErr) || errors.As(err, &requestErr) || errors.As(err, &parseErr) ||
+		errors.Is(err, stablenet.ErrNotFound) || errors.Is(err, stablenet.ErrLicense) || errors.Is(err, stablenet.ErrUnsupportedVersion)
+}
+
+// Returns true if the query itself is invalid, which includes statistic links that cannot be parsed.
+func isQueryError(err error) bool {
+	var queryErr *QueryError
+	var linkErr *stablenet.LinkError
+	return errors.As(err, &queryErr) || errors.As(err, &linkErr)
+}
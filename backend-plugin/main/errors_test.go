@@ -0,0 +1,50 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorStatusMapping(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantHttpStatus int
+		wantDataStatus backend.Status
+		wantSource     backend.ErrorSource
+	}{
+		{name: "not found", err: &stablenet.StatusError{StatusCode: 404}, wantHttpStatus: http.StatusNotFound, wantDataStatus: backend.StatusNotFound, wantSource: backend.ErrorSourceDownstream},
+		{name: "unauthorized", err: &stablenet.StatusError{StatusCode: 401}, wantHttpStatus: http.StatusForbidden, wantDataStatus: backend.StatusUnauthorized, wantSource: backend.ErrorSourceDownstream},
+		{name: "license", err: stablenet.ErrLicense, wantHttpStatus: http.StatusForbidden, wantDataStatus: backend.StatusForbidden, wantSource: backend.ErrorSourceDownstream},
+		{name: "version", err: &stablenet.VersionError{Version: "8.0.0"}, wantHttpStatus: http.StatusForbidden, wantDataStatus: backend.StatusForbidden, wantSource: backend.ErrorSourceDownstream},
+		{name: "timeout", err: &stablenet.RequestError{Err: context.DeadlineExceeded}, wantHttpStatus: http.StatusGatewayTimeout, wantDataStatus: backend.StatusTimeout, wantSource: backend.ErrorSourceDownstream},
+		{name: "server error", err: fmt.Errorf("wrapped: %w", &stablenet.StatusError{StatusCode: 500}), wantHttpStatus: http.StatusBadGateway, wantDataStatus: backend.StatusBadGateway, wantSource: backend.ErrorSourceDownstream},
+		{name: "invalid answer", err: &stablenet.ParseError{Err: errors.New("EOF")}, wantHttpStatus: http.StatusBadGateway, wantDataStatus: backend.StatusBadGateway, wantSource: backend.ErrorSourceDownstream},
+		{name: "invalid query", err: &QueryError{Field: "mode", Err: errors.New("unknown mode 5")}, wantHttpStatus: http.StatusBadRequest, wantDataStatus: backend.StatusBadRequest, wantSource: backend.ErrorSourceDownstream},
+		{name: "plugin error", err: errors.New("bug"), wantHttpStatus: http.StatusInternalServerError, wantDataStatus: backend.StatusInternal, wantSource: backend.ErrorSourcePlugin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantHttpStatus, httpStatusForError(tt.err), "http status wrong")
+			assert.Equal(t, tt.wantDataStatus, dataStatusForError(tt.err), "data status wrong")
+			response := errorDataResponse(tt.err)
+			assert.Equal(t, tt.wantDataStatus, response.Status, "status of data response wrong")
+			assert.Equal(t, tt.wantSource, response.ErrorSource, "error source of data response wrong")
+			assert.EqualError(t, response.Error, stablenet.UserMessage(tt.err), "error of data response wrong")
+		})
+	}
+}
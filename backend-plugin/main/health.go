@@ -8,62 +8,121 @@
 package main
 
 import (
-	"backend-plugin/stablenet"
 	"context"
-	"fmt"
-	"regexp"
 	"runtime/debug"
+	"sync"
+	"time"
 
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
 
 func (ds *dataSource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	logger := contextLogger(ctx, req.PluginContext)
 	defer func() {
 		if err := recover(); err != nil {
-			backend.Logger.Error(fmt.Sprintf("An error occured: %v\n%s", err, debug.Stack()))
+			logger.Error("Panic in CheckHealth", "error", err, "stack", string(debug.Stack()))
 		}
 	}()
 
-	backend.Logger.Debug(fmt.Sprintf("URL: %s, User: %s", req.PluginContext.DataSourceInstanceSettings.URL, req.PluginContext.DataSourceInstanceSettings.User))
-
 	options, err := loadStableNetSettings(req.PluginContext.DataSourceInstanceSettings)
 	if err != nil {
 		return nil, err
 	}
 
-	// We need this for testing purposes, since Go's httptest package only allows to mock http, not https, and is not meant to separate ip and port.
-	// Thus, for testing purposes, we inject the test url here.
-	if ctx.Value("sn_address") != nil {
-		options.Address = ctx.Value("sn_address").(string)
-	}
-
-	valid, msg := ds.checkAndUpdateHealth(options, req.PluginContext.DataSourceInstanceSettings.ID)
+	valid, msg := ds.checkAndUpdateHealth(ctx, ds.createClient(options, logger), keyOf(req.PluginContext.DataSourceInstanceSettings))
 	status := backend.HealthStatusError
 	if valid {
 		status = backend.HealthStatusOk
 	}
+	logger.Debug("Health check finished", "valid", valid, "message", msg)
 
 	return &backend.CheckHealthResult{Status: status, Message: msg}, nil
 }
 
-func (ds *dataSource) checkAndUpdateHealth(options *stablenet.ConnectOptions, datasourceId int64) (bool, string) {
-	client := stablenet.NewStableNetClient(options)
+func (ds *dataSource) checkAndUpdateHealth(ctx context.Context, client stablenet.Client, key datasourceKey) (bool, string) {
+	info, err := client.QueryStableNetInfo(ctx)
+	if err != nil {
+		return false, stablenet.UserMessage(err)
+	}
 
-	info, errStr := client.QueryStableNetInfo()
-	if errStr != nil {
-		return false, *errStr
+	if err := info.CheckCompatibility(); err != nil {
+		ds.health.put(key, healthEntry{valid: false, info: info})
+		return false, stablenet.UserMessage(err)
 	}
 
-	versionRegex := regexp.MustCompile(`^(?:9|[1-9]\d)\.`)
-	if !versionRegex.MatchString(info.ServerVersion.Version) {
-		ds.validationStore[datasourceId] = false
-		return false, fmt.Sprintf("The StableNet® version %s does not support Grafana®.", info.ServerVersion.Version)
+	ds.health.put(key, healthEntry{valid: true, info: info})
+	return true, "Connection to StableNet® successful"
+}
+
+// Returns whether the datasource is valid, and checks its health if it was not checked recently.
+func (ds *dataSource) isValid(ctx context.Context, client stablenet.Client, key datasourceKey) bool {
+	entry, present := ds.health.get(key)
+	recordCacheLookup("validation", present)
+	if !present {
+		valid, _ := ds.checkAndUpdateHealth(ctx, client, key)
+		return valid
 	}
-	if !info.License.Modules.IsRestReportingLicensed() {
-		ds.validationStore[datasourceId] = false
-		return false, "The StableNet® server does not have the required license \"rest-reporting\"."
+	return entry.valid
+}
+
+// The health of a server is checked again after this time, since the server may have been updated meanwhile.
+const healthTTL = 10 * time.Minute
+
+// datasourceKey identifies the settings of a datasource. Saving the settings changes the key, such that the server of
+// the new settings is checked.
+type datasourceKey struct {
+	id      int64
+	updated time.Time
+}
+
+func keyOf(settings *backend.DataSourceInstanceSettings) datasourceKey {
+	return datasourceKey{id: settings.ID, updated: settings.Updated}
+}
+
+type healthEntry struct {
+	valid   bool
+	info    *stablenet.ServerInfo
+	checked time.Time
+}
+
+// healthStore keeps the last health check of each datasource. Grafana® runs queries, resource requests and health
+// checks concurrently, thus the store is guarded by a mutex. The zero value is an empty store.
+type healthStore struct {
+	mutex   sync.RWMutex
+	entries map[datasourceKey]healthEntry
+	now     func() time.Time
+}
+
+func (s *healthStore) get(key datasourceKey) (healthEntry, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	entry, ok := s.entries[key]
+	if ok && s.currentTime().Sub(entry.checked) >= healthTTL {
+		return healthEntry{}, false
 	}
+	return entry, ok
+}
 
-	ds.validationStore[datasourceId] = true
-	return true, "Connection to StableNet® successful"
+// Stores the entry, replacing the entries of older settings of the same datasource.
+func (s *healthStore) put(key datasourceKey, entry healthEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.entries == nil {
+		s.entries = make(map[datasourceKey]healthEntry)
+	}
+	for existing := range s.entries {
+		if existing.id == key.id {
+			delete(s.entries, existing)
+		}
+	}
+	entry.checked = s.currentTime()
+	s.entries[key] = entry
+}
+
+func (s *healthStore) currentTime() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
 }
@@ -8,13 +8,16 @@
 package main
 
 import (
-	"backend-plugin/mock"
-	"backend-plugin/stablenet"
 	"context"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/mock"
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -23,6 +26,22 @@ var testStableNetUrl = "http://127.0.0.1:5443"
 var testStableNetUsername = "username"
 var testStableNetPassword = "changeme!"
 
+// Returns a client factory for the datasource that creates in-memory clients of the mock server.
+func mockClients(server *mock.SnServer) func(*stablenet.ConnectOptions, log.Logger) stablenet.Client {
+	return func(options *stablenet.ConnectOptions, _ log.Logger) stablenet.Client {
+		return mock.NewClient(server, options.Username, options.Password)
+	}
+}
+
+// Returns a datasource for the mock server, whose settings with the given IDs were checked already.
+func checkedDataSource(server *mock.SnServer, valid map[int64]bool) *dataSource {
+	ds := &dataSource{newClient: mockClients(server)}
+	for id, v := range valid {
+		ds.health.put(datasourceKey{id: id}, healthEntry{valid: v})
+	}
+	return ds
+}
+
 func TestDataSource_CheckHealth(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -39,9 +58,6 @@ func TestDataSource_CheckHealth(t *testing.T) {
 	}
 
 	snServer := mock.CreateMockServer(testStableNetUsername, testStableNetPassword)
-	handler := mock.CreateHandler(snServer)
-	server := httptest.NewServer(handler)
-	defer server.Close()
 
 	instanceSettings := backend.DataSourceInstanceSettings{
 		ID:   5,
@@ -68,19 +84,16 @@ func TestDataSource_CheckHealth(t *testing.T) {
 				},
 			}
 
-			ds := dataSource{validationStore: make(map[int64]bool)}
-			ctx := context.WithValue(context.Background(), "sn_address", server.URL)
+			ds := checkedDataSource(snServer, nil)
 
-			got, err := ds.CheckHealth(ctx, healthReq)
+			got, err := ds.CheckHealth(context.Background(), healthReq)
 
 			require.Nil(t, err, "no error expected")
 			assert.Equal(t, tt.wantStatus, got.Status, "status is wrong")
 
-			if tt.wantStatus == backend.HealthStatusError {
-				assert.False(t, ds.validationStore[5], "validationStore should be set to false")
-			} else {
-				assert.True(t, ds.validationStore[5], "validationStore should be set to true")
-			}
+			entry, present := ds.health.get(datasourceKey{id: 5})
+			assert.True(t, present, "the health should be stored")
+			assert.Equal(t, tt.wantStatus == backend.HealthStatusOk, entry.valid, "stored validity is wrong")
 
 			assert.Equal(t, tt.wantBody, got.Message, "response message not correct")
 		})
@@ -97,11 +110,76 @@ func TestDataSource_CheckHealth(t *testing.T) {
 			},
 		}
 
-		ds := dataSource{validationStore: make(map[int64]bool)}
-		ctx := context.WithValue(context.Background(), "sn_address", server.URL)
-		got, err := ds.CheckHealth(ctx, healthReq)
+		ds := checkedDataSource(snServer, nil)
+		got, err := ds.CheckHealth(context.Background(), healthReq)
 		require.Nil(t, err, "the error should be nil")
 		assert.Equal(t, backend.HealthStatusError, got.Status, "the health status is wrong")
 		assert.Equal(t, "The StableNet® server could be reached, but the credentials were invalid.", got.Message, "the message is wrong")
 	})
 }
+
+func TestHealthStore(t *testing.T) {
+	var store healthStore
+	now := time.UnixMilli(1699999980000)
+	store.now = func() time.Time { return now }
+	saved := datasourceKey{id: 5, updated: now}
+
+	store.put(datasourceKey{id: 5}, healthEntry{valid: true})
+	store.put(datasourceKey{id: 6}, healthEntry{valid: true})
+	store.put(saved, healthEntry{valid: false})
+	_, present := store.get(datasourceKey{id: 5})
+	assert.False(t, present, "saving the settings should replace the entry of the old settings")
+	entry, present := store.get(saved)
+	assert.True(t, present && !entry.valid, "the entry of the new settings should be stored")
+	_, present = store.get(datasourceKey{id: 6})
+	assert.True(t, present, "other datasources should be kept")
+
+	now = now.Add(healthTTL)
+	_, present = store.get(saved)
+	assert.False(t, present, "the server should be checked again after a while")
+}
+
+// Grafana® runs queries, resource requests and health checks concurrently, the first requests of a datasource check
+// its health while others read it. Run with -race.
+func TestDataSource_Concurrent(t *testing.T) {
+	snServer := mock.CreateMockServer(testStableNetUsername, testStableNetPassword)
+	snServer.Info.ServerVersion = stablenet.ServerVersion{Version: "9.0.0"}
+	snServer.Info.License.Modules.Modules = []stablenet.Module{{Name: "rest-reporting"}}
+	ds := &dataSource{newClient: mockClients(snServer)}
+	handler := ds.resourceHandler()
+	settings := &backend.DataSourceInstanceSettings{
+		ID:                      5,
+		URL:                     testStableNetUrl,
+		User:                    testStableNetUsername,
+		DecryptedSecureJSONData: map[string]string{"password": testStableNetPassword},
+	}
+	pluginContext := backend.PluginContext{DataSourceInstanceSettings: settings}
+	queryJson := []byte(`{"queryVersion": 1, "measurementObid": 1001, "metrics": [{"key": "SNMP_1", "name": "Uptime"}]}`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			request := &backend.QueryDataRequest{PluginContext: pluginContext, Queries: []backend.DataQuery{{RefID: "A", JSON: queryJson, TimeRange: testTimeRange}}}
+			got, err := ds.QueryData(context.Background(), request)
+			assert.NoError(t, err, "no error expected")
+			assert.NoError(t, got.Responses["A"].Error, "the query should succeed")
+		}()
+		go func() {
+			defer wg.Done()
+			request := httptest.NewRequest("GET", "http://example.org/metrics?measurementObid=1001", nil)
+			request = request.WithContext(backend.WithPluginContext(request.Context(), pluginContext))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			assert.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
+		}()
+		go func() {
+			defer wg.Done()
+			got, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: pluginContext})
+			assert.NoError(t, err, "no error expected")
+			assert.Equal(t, backend.HealthStatusOk, got.Status, "status is wrong")
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,25 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Returns a logger that carries the request context (e.g. trace ids) and the id of the datasource, if present.
+// Never add credentials, urls with user info or response bodies as values without redacting them first.
+func contextLogger(ctx context.Context, pluginContext backend.PluginContext) log.Logger {
+	logger := backend.Logger.FromContext(ctx)
+	if pluginContext.DataSourceInstanceSettings != nil {
+		logger = logger.With("datasourceId", pluginContext.DataSourceInstanceSettings.ID)
+	}
+	return logger
+}
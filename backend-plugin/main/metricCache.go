@@ -0,0 +1,98 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
+
+// The metrics that describe the units and polling intervals of the queries are cached for some minutes per datasource,
+// such that a panel that is refreshed often does not fetch the metrics of its measurements with every refresh. The
+// expansion of statistic links, rankings and metric patterns always fetches the current metrics.
+const (
+	metricCacheTTL        = 5 * time.Minute
+	maxMetricCacheEntries = 1000
+)
+
+type metricCacheKey struct {
+	datasource      datasourceKey
+	measurementObid int
+}
+
+type metricCacheEntry struct {
+	metrics []stablenet.Metric
+	expires time.Time
+}
+
+// metricCache keeps the metrics of the measurements of each datasource. Like the healthStore, it is shared by
+// concurrent requests and the zero value is an empty cache.
+type metricCache struct {
+	mutex   sync.Mutex
+	entries map[metricCacheKey]metricCacheEntry
+	now     func() time.Time
+}
+
+func (c *metricCache) get(key metricCacheKey) ([]stablenet.Metric, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	hit := ok && c.currentTime().Before(entry.expires)
+	recordCacheLookup("metric_descriptions", hit)
+	if !hit {
+		return nil, false
+	}
+	return entry.metrics, true
+}
+
+// Stores the metrics. If the cache is full, the expired entries are dropped, or all entries if none has expired.
+func (c *metricCache) put(key metricCacheKey, metrics []stablenet.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.currentTime()
+	if c.entries == nil {
+		c.entries = make(map[metricCacheKey]metricCacheEntry)
+	}
+	if len(c.entries) >= maxMetricCacheEntries {
+		for existing, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, existing)
+			}
+		}
+		if len(c.entries) >= maxMetricCacheEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = metricCacheEntry{metrics: metrics, expires: now.Add(metricCacheTTL)}
+}
+
+func (c *metricCache) currentTime() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// Returns a supplier of the metrics of the datasource that answers from the cache and fetches the missing metrics with
+// the given supplier.
+func (c *metricCache) supplier(datasource datasourceKey, metricSupplier func(int) ([]stablenet.Metric, error)) func(int) ([]stablenet.Metric, error) {
+	return func(measurementObid int) ([]stablenet.Metric, error) {
+		key := metricCacheKey{datasource: datasource, measurementObid: measurementObid}
+		if metrics, ok := c.get(key); ok {
+			return metrics, nil
+		}
+		metrics, err := metricSupplier(measurementObid)
+		if err != nil {
+			return nil, err
+		}
+		c.put(key, metrics)
+		return metrics, nil
+	}
+}
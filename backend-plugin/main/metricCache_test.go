@@ -0,0 +1,69 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/mock"
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricCache_Supplier(t *testing.T) {
+	now := time.UnixMilli(1699999980000)
+	cache := metricCache{now: func() time.Time { return now }}
+	fetches := 0
+	fetch := func(measurementObid int) ([]stablenet.Metric, error) {
+		fetches++
+		if measurementObid == 4711 {
+			return nil, errors.New("not found")
+		}
+		return []stablenet.Metric{{Key: "SNMP_1", Interval: int64(measurementObid)}}, nil
+	}
+	first := cache.supplier(datasourceKey{id: 5}, fetch)
+
+	metrics, err := first(1001)
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, []stablenet.Metric{{Key: "SNMP_1", Interval: 1001}}, metrics, "metrics wrong")
+	_, _ = cache.supplier(datasourceKey{id: 5}, fetch)(1001)
+	assert.Equal(t, 1, fetches, "the metrics should be cached across suppliers of the same datasource")
+
+	_, _ = cache.supplier(datasourceKey{id: 6}, fetch)(1001)
+	assert.Equal(t, 2, fetches, "the metrics should be cached per datasource")
+
+	_, err = first(4711)
+	assert.EqualError(t, err, "not found", "the error should be returned")
+	_, _ = first(4711)
+	assert.Equal(t, 4, fetches, "errors should not be cached")
+
+	now = now.Add(metricCacheTTL)
+	_, _ = first(1001)
+	assert.Equal(t, 5, fetches, "expired metrics should be fetched again")
+}
+
+func TestDataSource_QueryData_CachedMetrics(t *testing.T) {
+	snServer := mock.CreateMockServer(testStableNetUsername, testStableNetPassword)
+	client := &countingClient{Client: mock.NewClient(snServer, testStableNetUsername, testStableNetPassword)}
+	datasource := checkedDataSource(snServer, map[int64]bool{5: true})
+	datasource.newClient = func(*stablenet.ConnectOptions, log.Logger) stablenet.Client { return client }
+
+	request := newQueryDataRequest(t, uptimeQuery(0, nil))
+	for i := 0; i < 2; i++ {
+		got, err := datasource.QueryData(context.Background(), request)
+		require.NoError(t, err, "no error expected")
+		require.NoError(t, got.Responses["A"].Error, "the query should succeed")
+		assert.Equal(t, "s", got.Responses["A"].Frames[0].Fields[1].Config.Unit, "the unit of the metric should be set")
+	}
+	assert.Equal(t, int32(1), client.metricFetches.Load(), "the metrics should be fetched once for both requests")
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
+
+type MatchMode string
+
+const (
+	// MatchExact selects the metric whose key equals the pattern, e.g. "SNMP_1000".
+	MatchExact MatchMode = "exact"
+	// MatchSuffix selects the metrics whose key equals the pattern after the source prefix, e.g. "1000" matches
+	// "SNMP_1000" and "EXTERN_1000", but not "SNMP_11000". This is how statistic links refer to metrics.
+	MatchSuffix MatchMode = "suffix"
+	// MatchGlob selects the metrics whose name or key matches a glob pattern, e.g. "CPU *".
+	MatchGlob MatchMode = "glob"
+	// MatchRegex selects the metrics whose name or key matches a regular expression.
+	MatchRegex MatchMode = "regex"
+)
+
+// MetricPattern selects metrics of a measurement by key or name. Since it is resolved at query time, metrics that are
+// added to a measurement later on show up without editing the panel.
+type MetricPattern struct {
+	Mode    MatchMode `json:"mode"`
+	Pattern string    `json:"pattern"`
+}
+
+type metricMatcher func(metric stablenet.Metric) bool
+
+func (p MetricPattern) compile() (metricMatcher, error) {
+	switch p.Mode {
+	case MatchExact:
+		return func(metric stablenet.Metric) bool {
+			return metric.Key == p.Pattern
+		}, nil
+	case MatchSuffix:
+		return func(metric stablenet.Metric) bool {
+			return stablenet.KeySuffix(metric.Key) == p.Pattern
+		}, nil
+	case MatchGlob:
+		if _, err := path.Match(p.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern \"%s\": %w", p.Pattern, err)
+		}
+		return func(metric stablenet.Metric) bool {
+			nameMatches, _ := path.Match(p.Pattern, metric.Name)
+			keyMatches, _ := path.Match(p.Pattern, metric.Key)
+			return nameMatches || keyMatches
+		}, nil
+	case MatchRegex:
+		regex, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression \"%s\": %w", p.Pattern, err)
+		}
+		return func(metric stablenet.Metric) bool {
+			return regex.MatchString(metric.Name) || regex.MatchString(metric.Key)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown match mode \"%s\"", p.Mode)
+}
+
+func compileMetricPatterns(patterns []MetricPattern) ([]metricMatcher, error) {
+	result := make([]metricMatcher, 0, len(patterns))
+	for _, pattern := range patterns {
+		matcher, err := pattern.compile()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, matcher)
+	}
+	return result, nil
+}
+
+// Selects the metrics matched by at least one of the matchers, each metric at most once. If there are no matchers,
+// all metrics are selected.
+func selectMetrics(metrics []stablenet.Metric, matchers []metricMatcher) []StringPair {
+	result := make([]StringPair, 0, len(metrics))
+	for _, metric := range metrics {
+		wanted := len(matchers) == 0
+		for _, matcher := range matchers {
+			if matcher(metric) {
+				wanted = true
+				break
+			}
+		}
+		if wanted && !containsMetric(result, metric.Key) {
+			result = append(result, StringPair{Key: metric.Key, Name: metric.Name})
+		}
+	}
+	return result
+}
+
+func containsMetric(metrics []StringPair, key string) bool {
+	for _, metric := range metrics {
+		if metric.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var matcherTestMetrics = []stablenet.Metric{
+	{Key: "SNMP_1", Name: "CPU 1"},
+	{Key: "SNMP_11", Name: "CPU 11"},
+	{Key: "EXTERN_1", Name: "Script Runtime"},
+	{Key: "SNMP1000", Name: "Uptime"},
+	{Key: "SNMP_2", Name: "Memory"},
+}
+
+func TestSelectMetrics(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []MetricPattern
+		want     []string
+	}{
+		{name: "no patterns", patterns: nil, want: []string{"SNMP_1", "SNMP_11", "EXTERN_1", "SNMP1000", "SNMP_2"}},
+		{name: "exact", patterns: []MetricPattern{{Mode: MatchExact, Pattern: "SNMP_1"}}, want: []string{"SNMP_1"}},
+		{name: "exact no match", patterns: []MetricPattern{{Mode: MatchExact, Pattern: "1"}}, want: []string{}},
+		{name: "suffix", patterns: []MetricPattern{{Mode: MatchSuffix, Pattern: "1"}}, want: []string{"SNMP_1", "EXTERN_1"}},
+		{name: "suffix without underscore", patterns: []MetricPattern{{Mode: MatchSuffix, Pattern: "1000"}}, want: []string{"SNMP1000"}},
+		{name: "glob on name", patterns: []MetricPattern{{Mode: MatchGlob, Pattern: "CPU *"}}, want: []string{"SNMP_1", "SNMP_11"}},
+		{name: "glob on key", patterns: []MetricPattern{{Mode: MatchGlob, Pattern: "EXTERN_*"}}, want: []string{"EXTERN_1"}},
+		{name: "regex on name", patterns: []MetricPattern{{Mode: MatchRegex, Pattern: "^(Uptime|Memory)$"}}, want: []string{"SNMP1000", "SNMP_2"}},
+		{name: "regex on key", patterns: []MetricPattern{{Mode: MatchRegex, Pattern: "_1+$"}}, want: []string{"SNMP_1", "SNMP_11", "EXTERN_1"}},
+		{name: "no duplicates", patterns: []MetricPattern{{Mode: MatchExact, Pattern: "SNMP_1"}, {Mode: MatchGlob, Pattern: "CPU*"}}, want: []string{"SNMP_1", "SNMP_11"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, err := compileMetricPatterns(tt.patterns)
+			require.NoError(t, err, "no error expected")
+			got := selectMetrics(matcherTestMetrics, matchers)
+			keys := make([]string, 0, len(got))
+			for _, metric := range got {
+				keys = append(keys, metric.Key)
+			}
+			assert.Equal(t, tt.want, keys, "selected metrics wrong")
+		})
+	}
+}
+
+func TestCompileMetricPatterns_Error(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern MetricPattern
+		wantErr string
+	}{
+		{name: "invalid glob", pattern: MetricPattern{Mode: MatchGlob, Pattern: "CPU["}, wantErr: "invalid glob pattern \"CPU[\": syntax error in pattern"},
+		{name: "invalid regex", pattern: MetricPattern{Mode: MatchRegex, Pattern: "CPU("}, wantErr: "invalid regular expression \"CPU(\": error parsing regexp: missing closing ): `CPU(`"},
+		{name: "unknown mode", pattern: MetricPattern{Mode: "fuzzy", Pattern: "CPU"}, wantErr: "unknown match mode \"fuzzy\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compileMetricPatterns([]MetricPattern{{Mode: MatchExact, Pattern: "SNMP_1"}, tt.pattern})
+			assert.Nil(t, got, "matchers should be nil in case of an error")
+			assert.EqualError(t, err, tt.wantErr, "error message wrong")
+		})
+	}
+}
+
+func TestResolveMetricPatterns(t *testing.T) {
+	supplier := func(obid int) ([]stablenet.Metric, error) {
+		if obid == 1001 {
+			return matcherTestMetrics, nil
+		}
+		return nil, errors.New("measurement not found")
+	}
+	query := MetricQuery{MeasurementObid: 1001, MetricPrefix: "Host", MetricPatterns: []MetricPattern{{Mode: MatchGlob, Pattern: "CPU*"}}}
+	t.Run("success", func(t *testing.T) {
+		got, err := resolveMetricPatterns(query, supplier)
+		require.NoError(t, err, "no error expected")
+		assert.Equal(t, []StringPair{{Key: "SNMP_1", Name: "Host CPU 1"}, {Key: "SNMP_11", Name: "Host CPU 11"}}, got.Metrics, "resolved metrics wrong")
+		assert.Nil(t, query.Metrics, "original query must not be modified")
+	})
+	t.Run("invalid pattern", func(t *testing.T) {
+		q := query.shallowClone()
+		q.MetricPatterns = []MetricPattern{{Mode: MatchRegex, Pattern: "("}}
+		_, err := resolveMetricPatterns(q, supplier)
+		assert.EqualError(t, err, "invalid regular expression \"(\": error parsing regexp: missing closing ): `(`", "error message wrong")
+	})
+	t.Run("supplier error", func(t *testing.T) {
+		q := query.shallowClone()
+		q.MeasurementObid = 4711
+		_, err := resolveMetricPatterns(q, supplier)
+		assert.EqualError(t, err, "could not fetch metrics for measurement 4711: measurement not found", "error message wrong")
+	})
+}
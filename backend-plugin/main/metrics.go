@@ -0,0 +1,45 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The collectors are registered at the default registry, which the Grafana® plugin SDK exposes as plugin metrics.
+// The collectors of the StableNet® requests themselves are located in the stablenet package.
+var (
+	cacheLookupCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "stablenet_datasource",
+		Name:      "cache_lookups_total",
+		Help:      "Number of cache lookups by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	statisticLinkExpansionSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "stablenet_datasource",
+		Name:      "statistic_link_expanded_queries",
+		Help:      "Number of measurement queries a single statistic link expands into.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	statisticLinkExpandedMetrics = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "stablenet_datasource",
+		Name:      "statistic_link_expanded_metrics",
+		Help:      "Number of metrics a single statistic link expands into.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+)
+
+func recordCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookupCounter.WithLabelValues(cache, result).Inc()
+}
@@ -8,7 +8,6 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -18,7 +17,7 @@ import (
 func main() {
 	err := datasource.Serve(newDataSource())
 	if err != nil {
-		backend.Logger.Error(fmt.Sprintf("could not initialize datasource: %v", err))
+		backend.Logger.Error("Could not initialize datasource", "error", err)
 		os.Exit(1)
 	}
 }
@@ -8,13 +8,13 @@
 package main
 
 import (
-	"backend-plugin/stablenet"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strconv"
 	"time"
 
-	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
@@ -23,58 +23,137 @@ type Mode int
 const (
 	Measurement   Mode = 0
 	StatisticLink Mode = 10
+	TopN          Mode = 20
 )
 
-// Target describes the query coming directly from the frontend. It contains a lot of information which isn't needed
-// at all for querying data, but must be included in the Target in order to persist the whole config panel.
-// The unneeded elements aren't listed in the Go struct. The format of the Target is also not ideal for the data request,
-// but it's suited for the config panel itself. We convert the Target into a more suitable struct in the Target#toQuery method.
+// Target describes the query as stored by the query editor of plugin 1.x to 3.x. It contains a lot of information
+// which isn't needed at all for querying data, but must be included in the Target in order to persist the whole config
+// panel. The unneeded elements aren't listed in the Go struct. Since the Target has no queryVersion, it is recognized
+// and converted into the current QueryModel by the Target#migrate method.
 type Target struct {
 	Mode                Mode
-	SelectedMeasurement struct {
-		Value int
-	} `json:"selectedMeasurement"`
-	Interval         int64    `json:"customInterval"`
-	ChosenMetrics    []string `json:"chosenMetrics"`
-	MetricPrefix     string   `json:"metricPrefix"`
-	IncludeMinStats  bool     `json:"includeMinStats"`
-	IncludeAvgStats  bool     `json:"includeAvgStats"`
-	IncludeMaxStats  bool     `json:"includeMaxStats"`
-	StatisticLink    string   `json:"StatisticLink"`
-	AveragePeriod    string   `json:"averagePeriod"`
-	AverageUnit      int      `json:"averageUnit"`
-	UseCustomAverage bool     `json:"useCustomAverage"`
-	Metrics          []struct {
+	SelectedMeasurement legacyMeasurement   `json:"selectedMeasurement"`
+	Interval            int64               `json:"customInterval"`
+	ChosenMetrics       legacyChosenMetrics `json:"chosenMetrics"`
+	MetricPrefix        string              `json:"metricPrefix"`
+	IncludeMinStats     bool                `json:"includeMinStats"`
+	IncludeAvgStats     bool                `json:"includeAvgStats"`
+	IncludeMaxStats     bool                `json:"includeMaxStats"`
+	StatisticLink       string              `json:"StatisticLink"`
+	// Statistics replaces the include flags above, which are only evaluated for targets saved without statistics.
+	Statistics []stablenet.Statistic `json:"statistics"`
+	// StatisticWindow is the sliding window of the windowed statistics in milliseconds.
+	StatisticWindow int64 `json:"statisticWindow"`
+	// TimeShift moves the range of the query into the past by the given milliseconds, e.g. to compare with last week.
+	TimeShift        int64      `json:"timeShift"`
+	Comparison       Comparison `json:"comparison"`
+	AveragePeriod    string     `json:"averagePeriod"`
+	AverageUnit      int        `json:"averageUnit"`
+	UseCustomAverage bool       `json:"useCustomAverage"`
+	UseLinkTimeRange bool       `json:"useLinkTimeRange"`
+	UseLinkAverage   bool       `json:"useLinkAverage"`
+	// UseMetricPatterns selects the metrics by MetricPatterns instead of ChosenMetrics. For statistic links, the
+	// patterns further restrict the metrics of the link.
+	UseMetricPatterns bool            `json:"useMetricPatterns"`
+	MetricPatterns    []MetricPattern `json:"metricPatterns"`
+	// Transformations are keyed by metric key, the key AllMetrics applies to all other metrics.
+	Transformations map[string][]Transformation `json:"transformations"`
+	Aggregation     *Aggregation                `json:"aggregation"`
+	Ranking         *Ranking                    `json:"ranking"`
+	Format          Format                      `json:"format"`
+	// SummaryPercentile is the percentile shown in the summary format, it defaults to the 95th percentile if it is nil.
+	SummaryPercentile *float64 `json:"summaryPercentile"`
+	Metrics           []struct {
 		Text string
 		Key  string
 	}
 }
 
-func (t *Target) toQuery(timeRange backend.TimeRange, refId string) MetricQuery {
-	result := MetricQuery{
-		Start:           timeRange.From,
-		End:             timeRange.To,
-		RefId:           refId,
-		IncludeMinStats: t.IncludeMinStats,
-		IncludeAvgStats: t.IncludeAvgStats,
-		IncludeMaxStats: t.IncludeMaxStats,
+// legacyMeasurement is the selected measurement of a Target. Older editors stored the plain obid instead of the
+// selected option.
+type legacyMeasurement struct {
+	Value int
+}
+
+func (m *legacyMeasurement) UnmarshalJSON(bytes []byte) error {
+	if err := json.Unmarshal(bytes, &m.Value); err == nil {
+		return nil
+	}
+	var option struct{ Value int }
+	if err := json.Unmarshal(bytes, &option); err != nil {
+		return err
 	}
+	m.Value = option.Value
+	return nil
+}
+
+// legacyChosenMetrics are the keys of the chosen metrics of a Target. Older editors stored an object with a flag per
+// metric key instead of a list.
+type legacyChosenMetrics []string
+
+func (c *legacyChosenMetrics) UnmarshalJSON(bytes []byte) error {
+	var keys []string
+	if err := json.Unmarshal(bytes, &keys); err == nil {
+		*c = keys
+		return nil
+	}
+	var flags map[string]bool
+	if err := json.Unmarshal(bytes, &flags); err != nil {
+		return err
+	}
+	result := make([]string, 0, len(flags))
+	for key, chosen := range flags {
+		if chosen {
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	*c = result
+	return nil
+}
 
+// Converts the editor state into the current QueryModel. Like the editor, a statistic link or ranking that is not
+// defined yet falls back to the measurement of the target.
+func (t *Target) migrate() QueryModel {
+	result := QueryModel{
+		QueryVersion:      queryVersion,
+		Mode:              Measurement,
+		Average:           t.Interval,
+		Statistics:        t.statistics(),
+		StatisticWindow:   t.StatisticWindow,
+		TimeShift:         t.TimeShift,
+		Comparison:        t.Comparison,
+		Transformations:   t.Transformations,
+		Aggregation:       t.Aggregation,
+		Format:            t.Format,
+		SummaryPercentile: t.SummaryPercentile,
+	}
 	period, err := strconv.Atoi(t.AveragePeriod)
 	if t.UseCustomAverage && err == nil {
-		result.Interval = int64(period * t.AverageUnit)
-	} else {
-		result.Interval = t.Interval
+		result.Average = int64(period * t.AverageUnit)
 	}
-	if t.Mode == StatisticLink && t.StatisticLink != "" {
-		result.StatisticLink = &t.StatisticLink
+	if t.Mode == TopN && t.Ranking != nil {
+		result.Mode = TopN
+		result.Ranking = t.Ranking
+	} else if t.Mode == StatisticLink && t.StatisticLink != "" {
+		result.Mode = StatisticLink
+		result.StatisticLink = t.StatisticLink
+		result.UseLinkTimeRange = t.UseLinkTimeRange
+		result.UseLinkAverage = t.UseLinkAverage
+		if t.UseMetricPatterns {
+			result.MetricPatterns = t.MetricPatterns
+		}
+	} else if t.UseMetricPatterns {
+		result.MeasurementObid = t.SelectedMeasurement.Value
+		result.MetricPrefix = t.MetricPrefix
+		result.MetricPatterns = t.MetricPatterns
 	} else {
 		result.MeasurementObid = t.SelectedMeasurement.Value
-		metrics := make([]StringPair, 0, 0)
+		metrics := make([]MetricRef, 0, len(t.ChosenMetrics))
 		for _, metric := range t.ChosenMetrics {
 			for _, s := range t.Metrics {
 				if s.Key == metric {
-					metrics = append(metrics, StringPair{Key: metric, Name: fmt.Sprintf("%s %s", t.MetricPrefix, s.Text)})
+					metrics = append(metrics, MetricRef{Key: metric, Name: fmt.Sprintf("%s %s", t.MetricPrefix, s.Text)})
 				}
 			}
 		}
@@ -83,32 +162,143 @@ func (t *Target) toQuery(timeRange backend.TimeRange, refId string) MetricQuery
 	return result
 }
 
+// Returns the statistics of the target. Targets saved before the statistics were introduced include min, max and avg
+// by their flags.
+func (t *Target) statistics() []stablenet.Statistic {
+	if len(t.Statistics) > 0 {
+		return t.Statistics
+	}
+	result := make([]stablenet.Statistic, 0, 3)
+	if t.IncludeMinStats {
+		result = append(result, stablenet.StatisticMin)
+	}
+	if t.IncludeMaxStats {
+		result = append(result, stablenet.StatisticMax)
+	}
+	if t.IncludeAvgStats {
+		result = append(result, stablenet.StatisticAvg)
+	}
+	return result
+}
+
 type MetricQuery struct {
-	Start           time.Time
-	End             time.Time
-	Interval        int64 `json:"customInterval"`
-	IncludeAvgStats bool
-	IncludeMaxStats bool
-	IncludeMinStats bool
-	StatisticLink   *string
-	MeasurementObid int
-	Metrics         []StringPair
-	RefId           string
+	Start             time.Time
+	End               time.Time
+	Interval          int64 `json:"customInterval"`
+	Statistics        []stablenet.Statistic
+	StatisticWindow   time.Duration
+	TimeShift         time.Duration
+	Comparison        Comparison
+	StatisticLink     *string
+	UseLinkTimeRange  bool
+	UseLinkAverage    bool
+	MeasurementObid   int
+	Metrics           []StringPair
+	MetricPatterns    []MetricPattern
+	MetricPrefix      string
+	Transformations   map[string][]Transformation
+	Aggregation       *Aggregation
+	Ranking           *Ranking
+	Format            Format
+	SummaryPercentile *float64
+	RefId             string
+	// Units contains the Grafana® units by metric key and PollingInterval the polling interval of the measurement.
+	// Both are only known once the query is expanded.
+	Units           map[string]string
+	PollingInterval time.Duration
+}
+
+// FrameMetadata is attached to every frame and reports the time range and average that were effectively used to query
+// the data. They differ from the ones of the panel if the query takes them from a statistic link.
+type FrameMetadata struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Average int64     `json:"average"`
+	// TimeShift is the shift of the data in milliseconds, the timestamps of the frame are already moved forward by it.
+	TimeShift int64 `json:"timeShift,omitempty"`
 }
 
 func (m *MetricQuery) shallowClone() MetricQuery {
 	return MetricQuery{
-		Start:           m.Start,
-		End:             m.End,
-		Interval:        m.Interval,
-		IncludeAvgStats: m.IncludeAvgStats,
-		IncludeMaxStats: m.IncludeMaxStats,
-		IncludeMinStats: m.IncludeMinStats,
-		StatisticLink:   m.StatisticLink,
-		MeasurementObid: m.MeasurementObid,
-		Metrics:         m.Metrics,
-		RefId:           m.RefId,
+		Start:             m.Start,
+		End:               m.End,
+		Interval:          m.Interval,
+		Statistics:        m.Statistics,
+		StatisticWindow:   m.StatisticWindow,
+		TimeShift:         m.TimeShift,
+		Comparison:        m.Comparison,
+		StatisticLink:     m.StatisticLink,
+		UseLinkTimeRange:  m.UseLinkTimeRange,
+		UseLinkAverage:    m.UseLinkAverage,
+		MeasurementObid:   m.MeasurementObid,
+		Metrics:           m.Metrics,
+		MetricPatterns:    m.MetricPatterns,
+		MetricPrefix:      m.MetricPrefix,
+		Transformations:   m.Transformations,
+		Aggregation:       m.Aggregation,
+		Ranking:           m.Ranking,
+		Format:            m.Format,
+		SummaryPercentile: m.SummaryPercentile,
+		RefId:             m.RefId,
+		Units:             m.Units,
+		PollingInterval:   m.PollingInterval,
+	}
+}
+
+// A query without metrics is skipped, unless its metrics are only known after expanding it.
+func (m *MetricQuery) isEmpty() bool {
+	return len(m.Metrics) == 0 && m.StatisticLink == nil && len(m.MetricPatterns) == 0 && m.Ranking == nil
+}
+
+// Aggregated, ranked and summarized queries are processed once all series of their RefId are fetched.
+func (m *MetricQuery) isPostProcessed() bool {
+	return m.Ranking != nil || m.Aggregation != nil || m.Format == FormatSummary
+}
+
+// Returns the frames of a RefId computed from all of its series. A summary covers the series selected by a ranking or
+// all series otherwise, the result of an aggregation is not summarized.
+func (m *MetricQuery) postProcess(inputs []aggregationInput, labeler measurementLabeler) ([]*data.Frame, error) {
+	// StableNet® chooses the average of a query without one, the series are combined on the grid of that average
+	if m.Interval == 0 {
+		m.Interval = longestInterval(inputs).Milliseconds()
+	}
+	var frames []*data.Frame
+	switch {
+	case m.Ranking != nil:
+		ranked, err := rankInputs(inputs, *m.Ranking, *m)
+		if err != nil {
+			return nil, fmt.Errorf("could not rank data: %w", err)
+		}
+		inputs = ranked
+		frames = m.framesOf(inputs)
+	case m.Aggregation != nil:
+		aggregated, err := aggregate(inputs, *m.Aggregation, *m, labeler)
+		if err != nil {
+			return nil, fmt.Errorf("could not aggregate data: %w", err)
+		}
+		if m.Aggregation.KeepInputs {
+			frames = m.framesOf(inputs)
+		}
+		frames = append(frames, aggregated...)
+	default:
+		frames = m.framesOf(inputs)
+	}
+	if m.Format != FormatSummary {
+		return frames, nil
+	}
+	frame, err := summaryFrame(inputs, *m, labeler)
+	if err != nil {
+		return nil, fmt.Errorf("could not summarize data: %w", err)
+	}
+	return []*data.Frame{frame}, nil
+}
+
+func (m *MetricQuery) framesOf(inputs []aggregationInput) []*data.Frame {
+	frames := make([]*data.Frame, 0, len(inputs))
+	for _, input := range inputs {
+		frames = append(frames, m.newFrame(input.MetricName, input.Series, nil, input.Unit))
 	}
+	return frames
 }
 
 type StringPair struct {
@@ -127,23 +317,60 @@ func (m *MetricQuery) metricKeys() []string {
 func (m *MetricQuery) keyNameMap() map[string]string {
 	result := make(map[string]string)
 	for _, metric := range m.Metrics {
-		result[metric.Key] = metric.Name
+		result[metric.Key] = m.seriesName(metric.Name)
 	}
 	return result
 }
 
-func (m *MetricQuery) FetchData(provider func(stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error)) ([]*data.Frame, error) {
-	options := stablenet.DataQueryOptions{
+// Appends the time shift to the name of a metric, e.g. "Uptime (−7d)".
+func (m *MetricQuery) seriesName(name string) string {
+	if m.TimeShift == 0 {
+		return name
+	}
+	switch m.Comparison {
+	case CompareDifference:
+		return fmt.Sprintf("%s (difference to %s)", name, formatTimeShift(m.TimeShift))
+	case CompareRatio:
+		return fmt.Sprintf("%s (ratio to %s)", name, formatTimeShift(m.TimeShift))
+	}
+	return fmt.Sprintf("%s (%s)", name, formatTimeShift(m.TimeShift))
+}
+
+// The options request the range shifted by the time shift of the query. They also request the metrics the
+// transformations refer to, even if they are not shown themselves.
+func (m *MetricQuery) dataQueryOptions() stablenet.DataQueryOptions {
+	return m.dataQueryOptionsShiftedBy(m.TimeShift)
+}
+
+func (m *MetricQuery) dataQueryOptionsShiftedBy(shift time.Duration) stablenet.DataQueryOptions {
+	return stablenet.DataQueryOptions{
 		MeasurementObid: m.MeasurementObid,
-		Metrics:         m.metricKeys(),
-		Start:           m.Start,
-		End:             m.End,
+		Metrics:         appendMissing(m.metricKeys(), referencedMetrics(m.Transformations)),
+		Start:           m.Start.Add(-shift),
+		End:             m.End.Add(-shift),
 		Average:         m.Interval,
 	}
-	snData, err := provider(options)
+}
+
+// Returns the options of all data the query needs. A comparison additionally needs the data of the unshifted range.
+func (m *MetricQuery) requiredDataQueryOptions() []stablenet.DataQueryOptions {
+	result := []stablenet.DataQueryOptions{m.dataQueryOptions()}
+	if m.Comparison != CompareNone && m.TimeShift != 0 {
+		result = append(result, m.dataQueryOptionsShiftedBy(0))
+	}
+	return result
+}
+
+func (m *MetricQuery) FetchData(provider func(stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error)) ([]*data.Frame, error) {
+	snData, err := m.FetchSeries(provider)
 	if err != nil {
-		return nil, fmt.Errorf("could not retrieve metrics from StableNet(R): %v", err)
+		return nil, err
 	}
+	return m.buildFrames(snData), nil
+}
+
+// Creates a frame for each metric, ordered by metric key.
+func (m *MetricQuery) buildFrames(snData map[string]stablenet.MetricDataSeries) []*data.Frame {
 	keys := make([]string, 0, len(snData))
 	for key := range snData {
 		keys = append(keys, key)
@@ -152,22 +379,90 @@ func (m *MetricQuery) FetchData(provider func(stablenet.DataQueryOptions) (map[s
 	frames := make([]*data.Frame, 0, len(snData))
 	names := m.keyNameMap()
 	for _, key := range keys {
-		columns := make([]*data.Field, 0, 4)
-		columns = append(columns, data.NewField("Time", nil, []time.Time{}))
-		if m.IncludeMinStats {
-			columns = append(columns, data.NewField("Min", nil, []float64{}))
+		frames = append(frames, m.newFrame(names[key], snData[key], nil, m.unitOf(key)))
+	}
+	return frames
+}
+
+// FetchSeries fetches and transforms the data of the metrics. Metrics that are only fetched as reference of a
+// transformation are not part of the result. Shifted series are moved forward onto the range of the query, a
+// comparison replaces them by their difference or ratio to the series of the range of the query.
+func (m *MetricQuery) FetchSeries(provider func(stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error)) (map[string]stablenet.MetricDataSeries, error) {
+	for _, statistic := range m.Statistics {
+		if err := statistic.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateTimeShift(m.TimeShift, m.Comparison); err != nil {
+		return nil, err
+	}
+	snData, err := m.fetchTransformedSeries(provider, m.TimeShift)
+	if err != nil {
+		return nil, err
+	}
+	if m.Comparison != CompareNone {
+		current, err := m.fetchTransformedSeries(provider, 0)
+		if err != nil {
+			return nil, err
 		}
-		if m.IncludeMaxStats {
-			columns = append(columns, data.NewField("Max", nil, []float64{}))
+		step := time.Duration(m.Interval) * time.Millisecond
+		for key, series := range current {
+			current[key] = compareSeries(series, snData[key], m.Comparison, step)
 		}
-		if m.IncludeAvgStats {
-			columns = append(columns, data.NewField("Avg", nil, []float64{}))
+		snData = current
+	}
+	names := m.keyNameMap()
+	for _, reference := range referencedMetrics(m.Transformations) {
+		if _, requested := names[reference]; !requested {
+			delete(snData, reference)
 		}
-		frame := data.NewFrame(names[key], columns...)
-		for _, row := range snData[key].AsTable(m.IncludeMinStats, m.IncludeMaxStats, m.IncludeAvgStats) {
-			frame.AppendRow(row...)
+	}
+	return snData, nil
+}
+
+func (m *MetricQuery) fetchTransformedSeries(provider func(stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error), shift time.Duration) (map[string]stablenet.MetricDataSeries, error) {
+	snData, err := provider(m.dataQueryOptionsShiftedBy(shift))
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve metrics from StableNet(R): %w", err)
+	}
+	snData, err = applyTransformations(snData, m.Transformations)
+	if err != nil {
+		return nil, fmt.Errorf("could not transform metrics: %w", err)
+	}
+	if shift != 0 {
+		for key, series := range snData {
+			snData[key] = shiftSeries(series, shift)
+		}
+	}
+	return snData, nil
+}
+
+// Creates a frame with a time column and a column for each statistic of the query. The unit is the Grafana® unit of
+// the statistics, if any.
+func (m *MetricQuery) newFrame(name string, series stablenet.MetricDataSeries, labels data.Labels, unit string) *data.Frame {
+	columns := make([]*data.Field, 0, len(m.Statistics)+1)
+	columns = append(columns, data.NewField("Time", nil, []time.Time{}))
+	for _, statistic := range m.Statistics {
+		field := data.NewField(statistic.ColumnName(), labels, []float64{})
+		if unit != "" {
+			field.Config = &data.FieldConfig{Unit: unit}
 		}
-		frames = append(frames, frame)
+		columns = append(columns, field)
+	}
+	frame := data.NewFrame(name, columns...)
+	frame.Meta = &data.FrameMeta{Custom: FrameMetadata{Start: m.Start, End: m.End, Average: m.Interval, TimeShift: m.TimeShift.Milliseconds()}}
+	for _, row := range series.AsTable(m.Statistics, m.statisticWindow()) {
+		frame.AppendRow(row...)
+	}
+	return frame
+}
+
+// The sliding window of the windowed statistics if the query does not define one.
+const defaultStatisticWindow = time.Hour
+
+func (m *MetricQuery) statisticWindow() time.Duration {
+	if m.StatisticWindow <= 0 {
+		return defaultStatisticWindow
 	}
-	return frames, nil
+	return m.StatisticWindow
 }
@@ -0,0 +1,270 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// queryVersion is the version of the QueryModel. Queries without a version are Targets of older query editors.
+const queryVersion = 1
+
+// The top-level fields of the QueryModel. Other top-level fields, e.g. the ones Grafana® adds to every query or the ones
+// of newer Grafana® versions, are ignored, while the fields of the plugin are decoded strictly.
+var queryModelFields = jsonFieldNames(reflect.TypeOf(QueryModel{}))
+
+// Returns the JSON names of the fields of the struct.
+func jsonFieldNames(structType reflect.Type) map[string]bool {
+	result := make(map[string]bool, structType.NumField())
+	for index := 0; index < structType.NumField(); index++ {
+		name, _, _ := strings.Cut(structType.Field(index).Tag.Get("json"), ",")
+		result[name] = true
+	}
+	return result
+}
+
+// QueryModel is the stable JSON shape of a query. Unlike the Target, it only contains what is needed to query the
+// data, such that provisioned dashboards can be written by hand, e.g.
+//
+//	{"queryVersion": 1, "mode": 0, "measurementObid": 1001, "metrics": [{"key": "SNMP_1", "name": "Uptime"}], "statistics": ["avg", "p95"]}
+//
+// The mode is 0 for measurements, 10 for statistic links and 20 for rankings. Durations are given in milliseconds,
+// the average defaults to the one of StableNet® and missing statistics default to avg.
+type QueryModel struct {
+	QueryVersion      int                         `json:"queryVersion"`
+	Mode              Mode                        `json:"mode"`
+	MeasurementObid   int                         `json:"measurementObid,omitempty"`
+	Metrics           []MetricRef                 `json:"metrics,omitempty"`
+	MetricPatterns    []MetricPattern             `json:"metricPatterns,omitempty"`
+	MetricPrefix      string                      `json:"metricPrefix,omitempty"`
+	StatisticLink     string                      `json:"statisticLink,omitempty"`
+	UseLinkTimeRange  bool                        `json:"useLinkTimeRange,omitempty"`
+	UseLinkAverage    bool                        `json:"useLinkAverage,omitempty"`
+	Ranking           *Ranking                    `json:"ranking,omitempty"`
+	Average           int64                       `json:"average,omitempty"`
+	Statistics        []stablenet.Statistic       `json:"statistics,omitempty"`
+	StatisticWindow   int64                       `json:"statisticWindow,omitempty"`
+	TimeShift         int64                       `json:"timeShift,omitempty"`
+	Comparison        Comparison                  `json:"comparison,omitempty"`
+	Transformations   map[string][]Transformation `json:"transformations,omitempty"`
+	Aggregation       *Aggregation                `json:"aggregation,omitempty"`
+	Format            Format                      `json:"format,omitempty"`
+	SummaryPercentile *float64                    `json:"summaryPercentile,omitempty"`
+}
+
+// MetricRef is a metric of a measurement with the name its series is shown with. The name defaults to the key.
+type MetricRef struct {
+	Key  string `json:"key"`
+	Name string `json:"name,omitempty"`
+}
+
+// QueryError reports an invalid query. The field is the JSON path of the invalid field, if the error concerns a
+// single field.
+type QueryError struct {
+	Field string
+	Err   error
+}
+
+func (e *QueryError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid query: %v", e.Err)
+	}
+	return fmt.Sprintf("invalid query: field \"%s\": %v", e.Field, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// Parses and validates a query of any version and converts it into a MetricQuery.
+func parseQuery(query backend.DataQuery) (MetricQuery, error) {
+	model, err := decodeQueryModel(query.JSON)
+	if err != nil {
+		return MetricQuery{}, err
+	}
+	if err := model.validate(); err != nil {
+		return MetricQuery{}, err
+	}
+	return model.toQuery(query.TimeRange, query.RefID), nil
+}
+
+// Decodes the QueryModel of the current version strictly, i.e. unknown fields within the fields of the QueryModel are
+// rejected. Queries without a version are decoded as Target and migrated.
+func decodeQueryModel(raw []byte) (QueryModel, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return QueryModel{}, &QueryError{Err: fmt.Errorf("the query is not a JSON object: %w", err)}
+	}
+	version, versioned := fields["queryVersion"]
+	if !versioned {
+		target := Target{}
+		if err := json.Unmarshal(raw, &target); err != nil {
+			return QueryModel{}, &QueryError{Err: err}
+		}
+		return target.migrate(), nil
+	}
+	var number int
+	if err := json.Unmarshal(version, &number); err != nil {
+		return QueryModel{}, &QueryError{Field: "queryVersion", Err: errors.New("must be a number")}
+	}
+	if number != queryVersion {
+		return QueryModel{}, &QueryError{Field: "queryVersion", Err: fmt.Errorf("version %d is not supported, the plugin supports version %d", number, queryVersion)}
+	}
+	for field := range fields {
+		if !queryModelFields[field] {
+			delete(fields, field)
+		}
+	}
+	stripped, err := json.Marshal(fields)
+	if err != nil {
+		return QueryModel{}, &QueryError{Err: err}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(stripped))
+	decoder.DisallowUnknownFields()
+	var model QueryModel
+	if err := decoder.Decode(&model); err != nil {
+		return QueryModel{}, &QueryError{Err: err}
+	}
+	return model, nil
+}
+
+func (q *QueryModel) validate() error {
+	invalid := func(field string, err error) error {
+		var nested *QueryError
+		if errors.As(err, &nested) && nested.Field != "" {
+			return &QueryError{Field: field + "." + nested.Field, Err: nested.Err}
+		}
+		return &QueryError{Field: field, Err: err}
+	}
+	switch q.Mode {
+	case Measurement, StatisticLink, TopN:
+	default:
+		return invalid("mode", fmt.Errorf("unknown mode %d", q.Mode))
+	}
+	if q.Mode == Measurement && (len(q.Metrics) > 0 || len(q.MetricPatterns) > 0) && q.MeasurementObid <= 0 {
+		return invalid("measurementObid", errors.New("a measurement is required to query metrics"))
+	}
+	if q.Mode == StatisticLink && q.StatisticLink == "" {
+		return invalid("statisticLink", errors.New("a statistic link is required in statistic link mode"))
+	}
+	if q.Mode == TopN && q.Ranking == nil {
+		return invalid("ranking", errors.New("a ranking is required in top N mode"))
+	}
+	if q.Mode == TopN {
+		if err := q.Ranking.validate(); err != nil {
+			return invalid("ranking", err)
+		}
+	}
+	for index, metric := range q.Metrics {
+		if metric.Key == "" {
+			return invalid(fmt.Sprintf("metrics[%d].key", index), errors.New("must not be empty"))
+		}
+	}
+	if _, err := compileMetricPatterns(q.MetricPatterns); err != nil {
+		return invalid("metricPatterns", err)
+	}
+	if q.Average < 0 {
+		return invalid("average", fmt.Errorf("must not be negative, but is %d", q.Average))
+	}
+	for index, statistic := range q.Statistics {
+		if err := statistic.Validate(); err != nil {
+			return invalid(fmt.Sprintf("statistics[%d]", index), err)
+		}
+	}
+	if q.StatisticWindow < 0 {
+		return invalid("statisticWindow", fmt.Errorf("must not be negative, but is %d", q.StatisticWindow))
+	}
+	if err := validateTimeShift(time.Duration(q.TimeShift)*time.Millisecond, q.Comparison); err != nil {
+		return invalid("timeShift", err)
+	}
+	if err := validateTransformations(q.Transformations); err != nil {
+		return invalid("transformations", err)
+	}
+	if q.Aggregation != nil {
+		if err := q.Aggregation.validate(); err != nil {
+			return invalid("aggregation", err)
+		}
+	}
+	switch q.Format {
+	case FormatTimeSeries, FormatSummary:
+	default:
+		return invalid("format", fmt.Errorf("unknown format \"%s\"", q.Format))
+	}
+	if q.SummaryPercentile != nil && (*q.SummaryPercentile < 0 || *q.SummaryPercentile > 100) {
+		return invalid("summaryPercentile", fmt.Errorf("the percentile %v is not between 0 and 100", *q.SummaryPercentile))
+	}
+	return nil
+}
+
+// Returns the statistics without repetitions, a statistic listed twice would result in two columns of the same name.
+func uniqueStatistics(statistics []stablenet.Statistic) []stablenet.Statistic {
+	result := make([]stablenet.Statistic, 0, len(statistics))
+	for _, statistic := range statistics {
+		if !slices.Contains(result, statistic) {
+			result = append(result, statistic)
+		}
+	}
+	return result
+}
+
+func (q *QueryModel) toQuery(timeRange backend.TimeRange, refId string) MetricQuery {
+	result := MetricQuery{
+		Start:             timeRange.From,
+		End:               timeRange.To,
+		RefId:             refId,
+		Interval:          q.Average,
+		Statistics:        uniqueStatistics(q.Statistics),
+		StatisticWindow:   time.Duration(q.StatisticWindow) * time.Millisecond,
+		TimeShift:         time.Duration(q.TimeShift) * time.Millisecond,
+		Comparison:        q.Comparison,
+		Transformations:   q.Transformations,
+		Aggregation:       q.Aggregation,
+		Format:            q.Format,
+		SummaryPercentile: q.SummaryPercentile,
+	}
+	// an empty list is kept, since migrated targets without any statistic have always been queried without one
+	if q.Statistics == nil {
+		result.Statistics = []stablenet.Statistic{stablenet.StatisticAvg}
+	}
+	switch q.Mode {
+	case TopN:
+		result.Ranking = q.Ranking
+	case StatisticLink:
+		link := q.StatisticLink
+		result.StatisticLink = &link
+		result.UseLinkTimeRange = q.UseLinkTimeRange
+		result.UseLinkAverage = q.UseLinkAverage
+		result.MetricPatterns = q.MetricPatterns
+	default:
+		result.MeasurementObid = q.MeasurementObid
+		result.MetricPrefix = q.MetricPrefix
+		result.MetricPatterns = q.MetricPatterns
+		if len(q.MetricPatterns) > 0 {
+			break
+		}
+		result.Metrics = make([]StringPair, 0, len(q.Metrics))
+		for _, metric := range q.Metrics {
+			name := metric.Name
+			if name == "" {
+				name = metric.Key
+			}
+			result.Metrics = append(result.Metrics, StringPair{Key: metric.Key, Name: name})
+		}
+	}
+	return result
+}
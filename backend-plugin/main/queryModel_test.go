@@ -0,0 +1,155 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	timeRange := backend.TimeRange{From: time.UnixMilli(1599999960000), To: time.UnixMilli(1600003560000)}
+	raw := `{"refId": "A", "datasource": {"uid": "sn"}, "intervalMs": 60000, "maxDataPoints": 1000, "queryVersion": 1,
+		"mode": 0, "measurementObid": 1001, "metrics": [{"key": "SNMP_1", "name": "Uptime"}, {"key": "SNMP_2"}], "average": 300000,
+		"timeShift": 86400000, "comparison": "ratio"}`
+
+	got, err := parseQuery(backend.DataQuery{RefID: "A", TimeRange: timeRange, JSON: []byte(raw)})
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, "A", got.RefId, "refId wrong")
+	assert.Equal(t, timeRange.From, got.Start, "start wrong")
+	assert.Equal(t, timeRange.To, got.End, "end wrong")
+	assert.Equal(t, 1001, got.MeasurementObid, "measurement wrong")
+	assert.Equal(t, []StringPair{{Key: "SNMP_1", Name: "Uptime"}, {Key: "SNMP_2", Name: "SNMP_2"}}, got.Metrics, "the name of a metric should default to its key")
+	assert.Equal(t, int64(300000), got.Interval, "average wrong")
+	assert.Equal(t, []stablenet.Statistic{stablenet.StatisticAvg}, got.Statistics, "the statistics should default to avg")
+	assert.Equal(t, 24*time.Hour, got.TimeShift, "time shift wrong")
+	assert.Equal(t, CompareRatio, got.Comparison, "comparison wrong")
+
+	raw = `{"queryVersion": 1, "measurementObid": 1001, "metrics": [{"key": "SNMP_1"}], "statistics": ["p95", "avg", "p95"]}`
+	got, err = parseQuery(backend.DataQuery{RefID: "B", TimeRange: timeRange, JSON: []byte(raw)})
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, []stablenet.Statistic{stablenet.StatisticP95, stablenet.StatisticAvg}, got.Statistics, "repeated statistics should be dropped")
+
+	raw = `{"queryVersion": 1, "measurementObid": 1001, "metrics": [{"key": "SNMP_1"}], "selectedDevice": {"value": 9000}, "panelId": 4}`
+	got, err = parseQuery(backend.DataQuery{RefID: "C", TimeRange: timeRange, JSON: []byte(raw)})
+	require.NoError(t, err, "unknown top-level fields should be ignored")
+	assert.Equal(t, 1001, got.MeasurementObid, "measurement wrong")
+}
+
+func TestParseQuery_LegacyStatistics(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []stablenet.Statistic
+	}{
+		{name: "flags", raw: `{"selectedMeasurement": 1001, "chosenMetrics": ["SNMP_1"], "includeMinStats": true, "includeAvgStats": true}`, want: []stablenet.Statistic{stablenet.StatisticMin, stablenet.StatisticAvg}},
+		{name: "all flags off", raw: `{"selectedMeasurement": 1001, "chosenMetrics": ["SNMP_1"], "includeMinStats": false, "includeAvgStats": false, "includeMaxStats": false}`, want: []stablenet.Statistic{}},
+		{name: "statistics", raw: `{"selectedMeasurement": 1001, "chosenMetrics": ["SNMP_1"], "statistics": ["p99"]}`, want: []stablenet.Statistic{stablenet.StatisticP99}},
+		{name: "versioned without statistics", raw: `{"queryVersion": 1, "measurementObid": 1001, "metrics": [{"key": "SNMP_1"}]}`, want: []stablenet.Statistic{stablenet.StatisticAvg}},
+		{name: "versioned without any statistic", raw: `{"queryVersion": 1, "measurementObid": 1001, "metrics": [{"key": "SNMP_1"}], "statistics": []}`, want: []stablenet.Statistic{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQuery(backend.DataQuery{RefID: "A", JSON: []byte(tt.raw)})
+			require.NoError(t, err, "no error expected")
+			assert.Equal(t, tt.want, got.Statistics, "statistics wrong")
+		})
+	}
+}
+
+func TestParseQuery_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr string
+		// the messages of the JSON decoding of the standard library are only checked partially
+		partial bool
+	}{
+		{name: "no object", raw: `[]`, wantErr: "invalid query: the query is not a JSON object: json: cannot unmarshal array", partial: true},
+		{name: "version no number", raw: `{"queryVersion": "1"}`, wantErr: "invalid query: field \"queryVersion\": must be a number"},
+		{name: "future version", raw: `{"queryVersion": 2}`, wantErr: "invalid query: field \"queryVersion\": version 2 is not supported, the plugin supports version 1"},
+		{name: "unknown nested field", raw: `{"queryVersion": 1, "aggregation": {"function": "sum", "by": "device"}}`, wantErr: "invalid query: json: unknown field \"by\""},
+		{name: "wrong type", raw: `{"queryVersion": 1, "measurementObid": "1001"}`, wantErr: "invalid query: json: cannot unmarshal string into Go struct field QueryModel.measurementObid of type int"},
+		{name: "unknown mode", raw: `{"queryVersion": 1, "mode": 5}`, wantErr: "invalid query: field \"mode\": unknown mode 5"},
+		{name: "no measurement", raw: `{"queryVersion": 1, "metrics": [{"key": "SNMP_1"}]}`, wantErr: "invalid query: field \"measurementObid\": a measurement is required to query metrics"},
+		{name: "no metric key", raw: `{"queryVersion": 1, "measurementObid": 1, "metrics": [{"name": "Uptime"}]}`, wantErr: "invalid query: field \"metrics[0].key\": must not be empty"},
+		{name: "no link", raw: `{"queryVersion": 1, "mode": 10}`, wantErr: "invalid query: field \"statisticLink\": a statistic link is required in statistic link mode"},
+		{name: "no ranking", raw: `{"queryVersion": 1, "mode": 20}`, wantErr: "invalid query: field \"ranking\": a ranking is required in top N mode"},
+		{name: "ranking without device", raw: `{"queryVersion": 1, "mode": 20, "ranking": {"metric": {"mode": "exact", "pattern": "SNMP_1"}, "rankBy": "avg", "limit": 5}}`, wantErr: "invalid query: field \"ranking.deviceObid\": a device is required, but the obid is 0"},
+		{name: "unknown statistic", raw: `{"queryVersion": 1, "statistics": ["avg", "median"]}`, wantErr: "invalid query: field \"statistics[1]\": unknown statistic \"median\""},
+		{name: "negative average", raw: `{"queryVersion": 1, "average": -1}`, wantErr: "invalid query: field \"average\": must not be negative, but is -1"},
+		{name: "comparison without shift", raw: `{"queryVersion": 1, "comparison": "difference"}`, wantErr: "invalid query: field \"timeShift\": a comparison requires a time shift"},
+		{name: "unknown format", raw: `{"queryVersion": 1, "format": "table"}`, wantErr: "invalid query: field \"format\": unknown format \"table\""},
+		{name: "invalid legacy target", raw: `{"selectedMeasurement": "x"}`, wantErr: "invalid query: json: cannot unmarshal string", partial: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseQuery(backend.DataQuery{RefID: "A", JSON: []byte(tt.raw)})
+			if tt.partial {
+				assert.ErrorContains(t, err, tt.wantErr, "error message wrong")
+			} else {
+				assert.EqualError(t, err, tt.wantErr, "error message wrong")
+			}
+			var queryErr *QueryError
+			assert.True(t, errors.As(err, &queryErr), "a query error is expected")
+		})
+	}
+}
+
+func TestTarget_migrate(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want QueryModel
+	}{
+		{
+			name: "measurement",
+			raw: `{"mode": 0, "selectedMeasurement": {"label": "Host", "value": 1001}, "chosenMetrics": ["SNMP_1"], "metricPrefix": "Host",
+				"metrics": [{"text": "Uptime", "key": "SNMP_1"}, {"text": "CPU", "key": "SNMP_2"}], "includeMinStats": true, "includeAvgStats": true,
+				"useCustomAverage": true, "averagePeriod": "5", "averageUnit": 60000}`,
+			want: QueryModel{QueryVersion: queryVersion, Mode: Measurement, MeasurementObid: 1001, Metrics: []MetricRef{{Key: "SNMP_1", Name: "Host Uptime"}},
+				Average: 300000, Statistics: []stablenet.Statistic{stablenet.StatisticMin, stablenet.StatisticAvg}},
+		},
+		{
+			name: "older layout",
+			raw:  `{"selectedMeasurement": 1001, "chosenMetrics": {"SNMP_2": true, "SNMP_1": true, "SNMP_3": false}, "metricPrefix": "Host", "metrics": [{"text": "Uptime", "key": "SNMP_1"}, {"text": "CPU", "key": "SNMP_2"}], "includeAvgStats": true}`,
+			want: QueryModel{QueryVersion: queryVersion, Mode: Measurement, MeasurementObid: 1001, Metrics: []MetricRef{{Key: "SNMP_1", Name: "Host Uptime"}, {Key: "SNMP_2", Name: "Host CPU"}},
+				Statistics: []stablenet.Statistic{stablenet.StatisticAvg}},
+		},
+		{
+			name: "statistic link",
+			raw:  `{"mode": 10, "statisticLink": "?id=1001", "useLinkAverage": true, "includeMaxStats": true, "customInterval": 60000}`,
+			want: QueryModel{QueryVersion: queryVersion, Mode: StatisticLink, StatisticLink: "?id=1001", UseLinkAverage: true, Average: 60000,
+				Statistics: []stablenet.Statistic{stablenet.StatisticMax}},
+		},
+		{
+			name: "empty statistic link falls back to measurement",
+			raw:  `{"mode": 10, "statisticLink": "", "selectedMeasurement": {"value": -1}, "chosenMetrics": [], "statistics": ["p95"]}`,
+			want: QueryModel{QueryVersion: queryVersion, Mode: Measurement, MeasurementObid: -1, Metrics: []MetricRef{}, Statistics: []stablenet.Statistic{stablenet.StatisticP95}},
+		},
+		{
+			name: "metric patterns",
+			raw:  `{"mode": 0, "selectedMeasurement": {"value": 1001}, "metricPrefix": "Host", "useMetricPatterns": true, "metricPatterns": [{"mode": "glob", "pattern": "CPU*"}]}`,
+			want: QueryModel{QueryVersion: queryVersion, Mode: Measurement, MeasurementObid: 1001, MetricPrefix: "Host",
+				MetricPatterns: []MetricPattern{{Mode: MatchGlob, Pattern: "CPU*"}}, Statistics: []stablenet.Statistic{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeQueryModel([]byte(tt.raw))
+			require.NoError(t, err, "no error expected")
+			assert.Equal(t, tt.want, got, "migrated query wrong")
+		})
+	}
+}
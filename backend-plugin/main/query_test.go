@@ -8,11 +8,12 @@
 package main
 
 import (
-	"backend-plugin/stablenet"
 	"errors"
 	"fmt"
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 	"time"
 )
@@ -20,15 +21,19 @@ import (
 func TestShallowClone(t *testing.T) {
 	url := "http://example.org"
 	original := MetricQuery{
-		Start:           time.Now(),
-		End:             time.Now().Add(4 * time.Hour),
-		Interval:        90000,
-		IncludeAvgStats: true,
-		IncludeMaxStats: true,
-		IncludeMinStats: true,
-		StatisticLink:   &url,
-		MeasurementObid: 232,
-		Metrics:         []StringPair{{Key: "SNMP_10", Name: "Host"}},
+		Start:            time.Now(),
+		End:              time.Now().Add(4 * time.Hour),
+		Interval:         90000,
+		Statistics:       []stablenet.Statistic{stablenet.StatisticAvg, stablenet.StatisticP95},
+		StatisticWindow:  time.Hour,
+		TimeShift:        24 * time.Hour,
+		Comparison:       CompareRatio,
+		StatisticLink:    &url,
+		UseLinkTimeRange: true,
+		UseLinkAverage:   true,
+		MeasurementObid:  232,
+		Metrics:          []StringPair{{Key: "SNMP_10", Name: "Host"}},
+		RefId:            "A",
 	}
 	got := original.shallowClone()
 	assert.Equal(t, original, got, "clone should be equal to original")
@@ -58,24 +63,50 @@ func TestMetricQuery_FetchData_Error(t *testing.T) {
 	assert.Nil(t, got, "got should be nil in case of an error")
 }
 
+func TestMetricQuery_FetchData_Transformations(t *testing.T) {
+	now := time.Now()
+	query := MetricQuery{
+		Statistics:      []stablenet.Statistic{stablenet.StatisticAvg},
+		MeasurementObid: 2342,
+		Metrics:         []StringPair{{Key: "SNMP_10", Name: "Used"}},
+		Transformations: map[string][]Transformation{"SNMP_10": {{Kind: TransformPercentOf, Reference: "SNMP_20"}}},
+	}
+	got, err := query.FetchData(func(options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+		assert.Equal(t, []string{"SNMP_10", "SNMP_20"}, options.Metrics, "the reference metric must be requested as well")
+		return map[string]stablenet.MetricDataSeries{
+			"SNMP_10": {{Time: now, Avg: 25}},
+			"SNMP_20": {{Time: now, Avg: 200}},
+		}, nil
+	})
+	require.NoError(t, err, "no error expected")
+	require.Equal(t, 1, len(got), "the reference metric must not be returned")
+	assert.Equal(t, []interface{}{now, 12.5}, got[0].RowCopy(0), "transformed row wrong")
+
+	query.Transformations = map[string][]Transformation{"SNMP_10": {{Kind: TransformDivide}}}
+	_, err = query.FetchData(func(options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+		return map[string]stablenet.MetricDataSeries{"SNMP_10": {{Time: now, Avg: 25}}}, nil
+	})
+	assert.EqualError(t, err, "could not transform metrics: transformation 0 of metric SNMP_10 is invalid: cannot divide by zero", "error message wrong")
+}
+
 func TestMetricQuery_FetchData(t *testing.T) {
 	now := time.Now()
 	five := time.Now().Add(5 * time.Minute)
 	tests := []struct {
-		min                bool
-		max                bool
-		avg                bool
+		statistics         []stablenet.Statistic
 		wantReadsFirstLine []interface{}
 		wantHeader         []string
 	}{
-		{min: false, max: false, avg: false, wantReadsFirstLine: []interface{}{now}, wantHeader: []string{"Time"}},
-		{min: false, max: false, avg: true, wantReadsFirstLine: []interface{}{now, 8.0}, wantHeader: []string{"Time", "Avg"}},
-		{min: false, max: true, avg: false, wantReadsFirstLine: []interface{}{now, 10.0}, wantHeader: []string{"Time", "Max"}},
-		{min: false, max: true, avg: true, wantReadsFirstLine: []interface{}{now, 10.0, 8.0}, wantHeader: []string{"Time", "Max", "Avg"}},
-		{min: true, max: false, avg: false, wantReadsFirstLine: []interface{}{now, 6.0}, wantHeader: []string{"Time", "Min"}},
-		{min: true, max: false, avg: true, wantReadsFirstLine: []interface{}{now, 6.0, 8.0}, wantHeader: []string{"Time", "Min", "Avg"}},
-		{min: true, max: true, avg: false, wantReadsFirstLine: []interface{}{now, 6.0, 10.0}, wantHeader: []string{"Time", "Min", "Max"}},
-		{min: true, max: true, avg: true, wantReadsFirstLine: []interface{}{now, 6.0, 10.0, 8.0}, wantHeader: []string{"Time", "Min", "Max", "Avg"}},
+		{statistics: nil, wantReadsFirstLine: []interface{}{now}, wantHeader: []string{"Time"}},
+		{statistics: []stablenet.Statistic{stablenet.StatisticAvg}, wantReadsFirstLine: []interface{}{now, 8.0}, wantHeader: []string{"Time", "Avg"}},
+		{statistics: []stablenet.Statistic{stablenet.StatisticMax}, wantReadsFirstLine: []interface{}{now, 10.0}, wantHeader: []string{"Time", "Max"}},
+		{statistics: []stablenet.Statistic{stablenet.StatisticMax, stablenet.StatisticAvg}, wantReadsFirstLine: []interface{}{now, 10.0, 8.0}, wantHeader: []string{"Time", "Max", "Avg"}},
+		{statistics: []stablenet.Statistic{stablenet.StatisticMin}, wantReadsFirstLine: []interface{}{now, 6.0}, wantHeader: []string{"Time", "Min"}},
+		{statistics: []stablenet.Statistic{stablenet.StatisticMin, stablenet.StatisticAvg}, wantReadsFirstLine: []interface{}{now, 6.0, 8.0}, wantHeader: []string{"Time", "Min", "Avg"}},
+		{statistics: []stablenet.Statistic{stablenet.StatisticMin, stablenet.StatisticMax}, wantReadsFirstLine: []interface{}{now, 6.0, 10.0}, wantHeader: []string{"Time", "Min", "Max"}},
+		{statistics: []stablenet.Statistic{stablenet.StatisticMin, stablenet.StatisticMax, stablenet.StatisticAvg}, wantReadsFirstLine: []interface{}{now, 6.0, 10.0, 8.0}, wantHeader: []string{"Time", "Min", "Max", "Avg"}},
+		{statistics: []stablenet.Statistic{stablenet.StatisticAvg, stablenet.StatisticMin}, wantReadsFirstLine: []interface{}{now, 8.0, 6.0}, wantHeader: []string{"Time", "Avg", "Min"}},
+		{statistics: []stablenet.Statistic{stablenet.StatisticLast, stablenet.StatisticP99}, wantReadsFirstLine: []interface{}{now, 8.0, 8.0}, wantHeader: []string{"Time", "Last", "P99"}},
 	}
 	writes := stablenet.MetricDataSeries{{
 		Time: now.Add(time.Minute),
@@ -107,9 +138,7 @@ func TestMetricQuery_FetchData(t *testing.T) {
 				Start:           time.Now(),
 				End:             time.Now().Add(5 * time.Minute),
 				Interval:        25000,
-				IncludeAvgStats: tt.avg,
-				IncludeMaxStats: tt.max,
-				IncludeMinStats: tt.min,
+				Statistics:      tt.statistics,
 				StatisticLink:   nil,
 				MeasurementObid: 2342,
 				Metrics:         []StringPair{{Key: "SNMP_10", Name: "Writes"}, {Key: "SNMP_20", Name: "Reads"}},
@@ -131,6 +160,74 @@ func TestMetricQuery_FetchData(t *testing.T) {
 			assert.Equal(t, 1, got[1].Rows(), "number of rows in second frame")
 			assert.Equal(t, tt.wantReadsFirstLine, got[1].RowCopy(0), "first line of second frame wrong")
 			assert.Equal(t, tt.wantHeader, frameHeader(got[0]), "frame header of second frame not correct")
+			wantMeta := &data.FrameMeta{Custom: FrameMetadata{Start: query.Start, End: query.End, Average: query.Interval}}
+			assert.Equal(t, wantMeta, got[1].Meta, "metadata of second frame wrong")
+		})
+	}
+}
+
+func TestTarget_statistics(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		want   []stablenet.Statistic
+	}{
+		{name: "legacy flags", target: Target{IncludeMinStats: true, IncludeAvgStats: true}, want: []stablenet.Statistic{stablenet.StatisticMin, stablenet.StatisticAvg}},
+		{name: "statistics", target: Target{IncludeMinStats: true, Statistics: []stablenet.Statistic{stablenet.StatisticP99, stablenet.StatisticAvg}}, want: []stablenet.Statistic{stablenet.StatisticP99, stablenet.StatisticAvg}},
+		{name: "none", target: Target{}, want: []stablenet.Statistic{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.target.statistics(), "statistics wrong")
 		})
 	}
 }
+
+func TestMetricQuery_FetchData_UnknownStatistic(t *testing.T) {
+	query := MetricQuery{Statistics: []stablenet.Statistic{"median"}}
+	_, err := query.FetchData(func(options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+		t.Error("no data should be fetched for an unknown statistic")
+		return nil, nil
+	})
+	assert.EqualError(t, err, "unknown statistic \"median\"", "error message wrong")
+}
+
+func TestMetricQuery_FetchData_TimeShift(t *testing.T) {
+	start := time.UnixMilli(1599999960000)
+	query := MetricQuery{
+		Start:           start,
+		End:             start.Add(time.Hour),
+		Interval:        60000,
+		Statistics:      []stablenet.Statistic{stablenet.StatisticAvg},
+		TimeShift:       7 * 24 * time.Hour,
+		MeasurementObid: 2342,
+		Metrics:         []StringPair{{Key: "SNMP_10", Name: "Used"}},
+	}
+	provider := func(options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+		if options.Start.Equal(start) {
+			return map[string]stablenet.MetricDataSeries{"SNMP_10": seriesOf(start, time.Minute, 30, 40)}, nil
+		}
+		assert.Equal(t, start.Add(-query.TimeShift), options.Start, "start of shifted range wrong")
+		assert.Equal(t, start.Add(time.Hour-query.TimeShift), options.End, "end of shifted range wrong")
+		return map[string]stablenet.MetricDataSeries{"SNMP_10": seriesOf(start.Add(-query.TimeShift), time.Minute, 10, 20)}, nil
+	}
+
+	got, err := query.FetchData(provider)
+	require.NoError(t, err, "no error expected")
+	require.Equal(t, 1, len(got), "number of frames")
+	assert.Equal(t, "Used (−7d)", got[0].Name, "name of shifted frame wrong")
+	assert.Equal(t, []interface{}{start, 10.0}, got[0].RowCopy(0), "the shifted series should line up with the range")
+	assert.Equal(t, int64(7*24*60*60*1000), got[0].Meta.Custom.(FrameMetadata).TimeShift, "time shift of metadata wrong")
+
+	query.Comparison = CompareDifference
+	assert.Equal(t, 2, len(query.requiredDataQueryOptions()), "a comparison needs the current and the shifted range")
+	got, err = query.FetchData(provider)
+	require.NoError(t, err, "no error expected")
+	require.Equal(t, 1, len(got), "number of frames")
+	assert.Equal(t, "Used (difference to −7d)", got[0].Name, "name of compared frame wrong")
+	assert.Equal(t, []interface{}{start, 20.0}, got[0].RowCopy(0), "first difference wrong")
+
+	query.TimeShift = 0
+	_, err = query.FetchData(provider)
+	assert.EqualError(t, err, "a comparison requires a time shift", "error message wrong")
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
+
+// The maximum number of measurements a ranking may query. Every measurement costs at least one request for its metrics,
+// thus larger rankings would put too much load onto the StableNet® server.
+const maxRankedMeasurements = 500
+
+type RankFunction string
+
+const (
+	RankByAvg        RankFunction = "avg"
+	RankByMax        RankFunction = "max"
+	RankByLast       RankFunction = "last"
+	RankByPercentile RankFunction = "percentile"
+)
+
+// Ranking selects the top or bottom N series of a metric across the measurements of a device, e.g. the ten busiest
+// interfaces of a router.
+type Ranking struct {
+	DeviceObid        int           `json:"deviceObid"`
+	MeasurementFilter string        `json:"measurementFilter"`
+	Metric            MetricPattern `json:"metric"`
+	RankBy            RankFunction  `json:"rankBy"`
+	Percentile        float64       `json:"percentile,omitempty"`
+	Bottom            bool          `json:"bottom"`
+	Limit             int           `json:"limit"`
+	// Others combines the series that are not selected into one series with the given function. It is omitted if empty.
+	Others AggregationFunction `json:"others,omitempty"`
+}
+
+// Validates the ranking. An invalid device is reported for the field deviceObid, the other errors for the ranking.
+func (r *Ranking) validate() error {
+	if r.DeviceObid <= 0 {
+		return &QueryError{Field: "deviceObid", Err: fmt.Errorf("a device is required, but the obid is %d", r.DeviceObid)}
+	}
+	switch r.RankBy {
+	case RankByAvg, RankByMax, RankByLast:
+	case RankByPercentile:
+		if r.Percentile < 0 || r.Percentile > 100 {
+			return fmt.Errorf("the percentile %v is not between 0 and 100", r.Percentile)
+		}
+	default:
+		return fmt.Errorf("unknown rank function \"%s\"", r.RankBy)
+	}
+	if r.Limit <= 0 {
+		return fmt.Errorf("the number of series must be positive, but is %d", r.Limit)
+	}
+	if len(r.Others) > 0 {
+		return (&Aggregation{Function: r.Others}).validate()
+	}
+	return nil
+}
+
+// Computes the value a series is ranked by. Series without data are ranked by NaN, which sorts them last.
+func (r *Ranking) score(series stablenet.MetricDataSeries) float64 {
+	if len(series) == 0 {
+		return math.NaN()
+	}
+	values := make([]float64, 0, len(series))
+	for _, entry := range series {
+		values = append(values, entry.Avg)
+	}
+	switch r.RankBy {
+	case RankByAvg:
+		return aggregateValues(values, Aggregation{Function: AggregateAvg})
+	case RankByMax:
+		maxValues := make([]float64, 0, len(series))
+		for _, entry := range series {
+			maxValues = append(maxValues, entry.Max)
+		}
+		return aggregateValues(maxValues, Aggregation{Function: AggregateMax})
+	case RankByLast:
+		return values[len(values)-1]
+	case RankByPercentile:
+		return percentile(values, r.Percentile)
+	}
+	return math.NaN()
+}
+
+// Expands a ranking query into one query per measurement of the device that has a metric matching the ranking. The
+// metrics of the measurements are fetched concurrently.
+func expandRanking(query MetricQuery, listMeasurements func(deviceObid int, filter string, limit int) ([]stablenet.Measurement, error), metricSupplier func(int) ([]stablenet.Metric, error)) ([]MetricQuery, error) {
+	ranking := query.Ranking
+	if err := ranking.validate(); err != nil {
+		return nil, err
+	}
+	matcher, err := ranking.Metric.compile()
+	if err != nil {
+		return nil, err
+	}
+	measurements, err := listMeasurements(ranking.DeviceObid, ranking.MeasurementFilter, maxRankedMeasurements)
+	if err != nil {
+		return nil, err
+	}
+	return expandMeasurements(query, measurements, []metricMatcher{matcher}, metricSupplier)
+}
+
+// Expands the query into one query per measurement that has a metric matching one of the matchers. The series are
+// named by the measurement and the metric, since the metrics of different measurements often have the same name. The
+// metrics of the measurements are fetched concurrently.
+func expandMeasurements(query MetricQuery, measurements []stablenet.Measurement, matchers []metricMatcher, metricSupplier func(int) ([]stablenet.Metric, error)) ([]MetricQuery, error) {
+	results := make([][]StringPair, len(measurements))
+	errs := make([]error, len(measurements))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentMeasurementRequests)
+	for index, measurement := range measurements {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			metrics, err := metricSupplier(measurement.Obid)
+			// a measurement may be deleted after it has been listed
+			if errors.Is(err, stablenet.ErrNotFound) {
+				return
+			}
+			if err != nil {
+				errs[index] = fmt.Errorf("could not fetch metrics for measurement %d: %w", measurement.Obid, err)
+				return
+			}
+			results[index] = selectMetrics(metrics, matchers)
+		}()
+	}
+	wg.Wait()
+
+	expanded := make([]MetricQuery, 0, len(measurements))
+	for index, measurement := range measurements {
+		if errs[index] != nil {
+			return nil, errs[index]
+		}
+		if len(results[index]) == 0 {
+			continue
+		}
+		measurementQuery := query.shallowClone()
+		measurementQuery.MeasurementObid = measurement.Obid
+		measurementQuery.Metrics = make([]StringPair, 0, len(results[index]))
+		for _, metric := range results[index] {
+			measurementQuery.Metrics = append(measurementQuery.Metrics, StringPair{Key: metric.Key, Name: fmt.Sprintf("%s %s", measurement.Name, metric.Name)})
+		}
+		expanded = append(expanded, measurementQuery)
+	}
+	return expanded, nil
+}
+
+// Returns the top or bottom N inputs in the order of their rank and, if requested, an input that combines the others.
+func rankInputs(inputs []aggregationInput, ranking Ranking, query MetricQuery) ([]aggregationInput, error) {
+	if err := ranking.validate(); err != nil {
+		return nil, err
+	}
+	scores := make([]float64, len(inputs))
+	for index, input := range inputs {
+		scores[index] = ranking.score(input.Series)
+	}
+	order := make([]int, len(inputs))
+	for index := range order {
+		order[index] = index
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		first, second := scores[order[i]], scores[order[j]]
+		if math.IsNaN(first) || math.IsNaN(second) {
+			return !math.IsNaN(first) && math.IsNaN(second)
+		}
+		if ranking.Bottom {
+			return first < second
+		}
+		return first > second
+	})
+
+	limit := min(ranking.Limit, len(order))
+	result := make([]aggregationInput, 0, limit+1)
+	for _, index := range order[:limit] {
+		result = append(result, inputs[index])
+	}
+	if len(ranking.Others) == 0 || limit == len(order) {
+		return result, nil
+	}
+	others := make([]stablenet.MetricDataSeries, 0, len(order)-limit)
+	otherInputs := make([]aggregationInput, 0, len(order)-limit)
+	for _, index := range order[limit:] {
+		others = append(others, inputs[index].Series)
+		otherInputs = append(otherInputs, inputs[index])
+	}
+	combined := combineSeries(others, time.Duration(query.Interval)*time.Millisecond, Aggregation{Function: ranking.Others})
+	return append(result, aggregationInput{MetricName: fmt.Sprintf("others (%s)", ranking.Others), Unit: commonUnit(otherInputs), Series: combined}), nil
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRanking_score(t *testing.T) {
+	series := stablenet.MetricDataSeries{
+		{Min: 0, Max: 5, Avg: 2},
+		{Min: 0, Max: 9, Avg: 4},
+		{Min: 0, Max: 7, Avg: 3},
+	}
+	tests := []struct {
+		ranking Ranking
+		want    float64
+	}{
+		{ranking: Ranking{RankBy: RankByAvg}, want: 3},
+		{ranking: Ranking{RankBy: RankByMax}, want: 9},
+		{ranking: Ranking{RankBy: RankByLast}, want: 3},
+		{ranking: Ranking{RankBy: RankByPercentile, Percentile: 50}, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.ranking.RankBy), func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.ranking.score(series), "score wrong")
+		})
+	}
+	assert.True(t, math.IsNaN((&Ranking{RankBy: RankByAvg}).score(nil)), "empty series should score NaN")
+}
+
+func TestRankInputs(t *testing.T) {
+	start := time.UnixMilli(1599999960000)
+	inputs := []aggregationInput{
+		{MeasurementObid: 1, MetricName: "eth0", Series: seriesOf(start, time.Minute, 10, 20)},
+		{MeasurementObid: 2, MetricName: "eth1", Series: seriesOf(start, time.Minute, 50, 60)},
+		{MeasurementObid: 3, MetricName: "eth2", Series: nil},
+		{MeasurementObid: 4, MetricName: "eth3", Series: seriesOf(start, time.Minute, 30, 40)},
+		{MeasurementObid: 5, MetricName: "eth4", Series: seriesOf(start, time.Minute, 1, 2)},
+	}
+	query := MetricQuery{Interval: 60000, Statistics: []stablenet.Statistic{stablenet.StatisticAvg}}
+	names := func(ranking Ranking) []string {
+		ranked, err := rankInputs(inputs, ranking, query)
+		require.NoError(t, err, "no error expected")
+		result := make([]string, 0, len(ranked))
+		for _, input := range ranked {
+			result = append(result, input.MetricName)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"eth1", "eth3"}, names(Ranking{DeviceObid: 9000, RankBy: RankByAvg, Limit: 2}), "top 2 wrong")
+	assert.Equal(t, []string{"eth4", "eth0"}, names(Ranking{DeviceObid: 9000, RankBy: RankByAvg, Limit: 2, Bottom: true}), "bottom 2 wrong")
+	assert.Equal(t, []string{"eth1", "eth3", "eth0", "eth4", "eth2"}, names(Ranking{DeviceObid: 9000, RankBy: RankByLast, Limit: 10, Others: AggregateSum}), "series without data should be last")
+
+	ranked, err := rankInputs(inputs, Ranking{DeviceObid: 9000, RankBy: RankByMax, Limit: 1, Others: AggregateSum}, query)
+	require.NoError(t, err, "no error expected")
+	require.Equal(t, 2, len(ranked), "number of ranked series")
+	assert.Equal(t, "others (sum)", ranked[1].MetricName, "name of others series")
+	require.NotEmpty(t, ranked[1].Series, "others series should contain data")
+	assert.Equal(t, start, ranked[1].Series[0].Time, "time of first value of others series wrong")
+	assert.Equal(t, 41.0, ranked[1].Series[0].Avg, "first value of others series wrong")
+}
+
+func TestRanking_validate(t *testing.T) {
+	tests := []struct {
+		ranking Ranking
+		wantErr string
+	}{
+		{ranking: Ranking{DeviceObid: 9000, RankBy: "median", Limit: 1}, wantErr: "unknown rank function \"median\""},
+		{ranking: Ranking{DeviceObid: 9000, RankBy: RankByPercentile, Percentile: -1, Limit: 1}, wantErr: "the percentile -1 is not between 0 and 100"},
+		{ranking: Ranking{DeviceObid: 9000, RankBy: RankByAvg}, wantErr: "the number of series must be positive, but is 0"},
+		{ranking: Ranking{DeviceObid: 9000, RankBy: RankByAvg, Limit: 1, Others: "median"}, wantErr: "unknown aggregation function \"median\""},
+		{ranking: Ranking{RankBy: RankByAvg, Limit: 1}, wantErr: "invalid query: field \"deviceObid\": a device is required, but the obid is 0"},
+		{ranking: Ranking{DeviceObid: -1, RankBy: RankByAvg, Limit: 1}, wantErr: "invalid query: field \"deviceObid\": a device is required, but the obid is -1"},
+	}
+	for _, tt := range tests {
+		assert.EqualError(t, tt.ranking.validate(), tt.wantErr, "error message wrong")
+	}
+}
+
+func TestExpandRanking(t *testing.T) {
+	ranking := &Ranking{DeviceObid: 9000, MeasurementFilter: "eth", Metric: MetricPattern{Mode: MatchSuffix, Pattern: "1"}, RankBy: RankByAvg, Limit: 5}
+	query := MetricQuery{RefId: "A", Interval: 60000, Ranking: ranking}
+	listMeasurements := func(deviceObid int, filter string, limit int) ([]stablenet.Measurement, error) {
+		assert.Equal(t, 9000, deviceObid, "device obid wrong")
+		assert.Equal(t, "eth", filter, "filter wrong")
+		assert.Equal(t, maxRankedMeasurements, limit, "limit wrong")
+		return []stablenet.Measurement{{Obid: 1, Name: "eth0"}, {Obid: 2, Name: "eth1"}, {Obid: 3, Name: "eth2"}, {Obid: 4, Name: "eth3"}}, nil
+	}
+	metricSupplier := func(obid int) ([]stablenet.Metric, error) {
+		switch obid {
+		case 2:
+			return []stablenet.Metric{{Key: "SNMP_2", Name: "Out"}}, nil
+		case 3:
+			return nil, &stablenet.StatusError{Message: "retrieving metrics failed", StatusCode: 404}
+		}
+		return []stablenet.Metric{{Key: "SNMP_1", Name: "In"}, {Key: "SNMP_2", Name: "Out"}}, nil
+	}
+	t.Run("success", func(t *testing.T) {
+		got, err := expandRanking(query, listMeasurements, metricSupplier)
+		require.NoError(t, err, "no error expected")
+		require.Equal(t, 2, len(got), "measurements without matching metric or deleted measurements should be skipped")
+		assert.Equal(t, 1, got[0].MeasurementObid, "obid of first query")
+		assert.Equal(t, []StringPair{{Key: "SNMP_1", Name: "eth0 In"}}, got[0].Metrics, "metrics of first query")
+		assert.Equal(t, 4, got[1].MeasurementObid, "obid of second query")
+		assert.Equal(t, "A", got[1].RefId, "RefId of second query")
+	})
+	t.Run("metric error", func(t *testing.T) {
+		failing := func(obid int) ([]stablenet.Metric, error) {
+			return nil, fmt.Errorf("server down")
+		}
+		_, err := expandRanking(query, listMeasurements, failing)
+		assert.EqualError(t, err, "could not fetch metrics for measurement 1: server down", "error message wrong")
+	})
+	t.Run("listing error", func(t *testing.T) {
+		failing := func(int, string, int) ([]stablenet.Measurement, error) {
+			return nil, errors.New("too many measurements")
+		}
+		_, err := expandRanking(query, failing, metricSupplier)
+		assert.EqualError(t, err, "too many measurements", "error message wrong")
+	})
+	t.Run("invalid ranking", func(t *testing.T) {
+		q := query.shallowClone()
+		q.Ranking = &Ranking{DeviceObid: 9000, RankBy: RankByAvg, Limit: 1, Metric: MetricPattern{Mode: MatchRegex, Pattern: "("}}
+		_, err := expandRanking(q, listMeasurements, metricSupplier)
+		assert.EqualError(t, err, "invalid regular expression \"(\": error parsing regexp: missing closing ): `(`", "error message wrong")
+	})
+}
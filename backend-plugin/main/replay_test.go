@@ -0,0 +1,177 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The replay tests run QueryData against recorded responses of StableNet® and compare the frames with golden files.
+// Every directory in test-data/replay is a scenario with the files
+//   - scenario.json: the time range and the queries,
+//   - recording.json: the requests to StableNet® with their responses,
+//   - frames.golden.json: the expected responses of QueryData.
+//
+// A scenario whose recording was written or edited by hand, e.g. to contain quirks of StableNet® that are hard to
+// provoke, is marked as synthetic and its directory name starts with "synthetic-". Such a recording only shows that the
+// plugin copes with the responses as they are assumed to be, it is not a regression test against a real server and
+// is not replaced by -record.
+//
+// The golden files are rewritten by
+//
+//	go test ./main -run TestQueryData_Replay -update
+//
+// and the recordings are replaced by the responses of a real server (with -record, which implies -update):
+//
+//	STABLENET_ADDRESS=https://stablenet:5443 STABLENET_USERNAME=... STABLENET_PASSWORD=... go test ./main -run TestQueryData_Replay -record
+var (
+	updateGolden = flag.Bool("update", false, "rewrite the golden files of the replay tests")
+	record       = flag.Bool("record", false, "record the replay scenarios from the server given by STABLENET_ADDRESS")
+)
+
+const replayDirectory = "test-data/replay"
+
+type replayScenario struct {
+	Description string `json:"description"`
+	// Whether the recording was written or edited by hand instead of recorded from a server.
+	Synthetic bool              `json:"synthetic"`
+	From      time.Time         `json:"from"`
+	To        time.Time         `json:"to"`
+	Queries   []json.RawMessage `json:"queries"`
+}
+
+// goldenResponse is the part of a backend.DataResponse that is compared with the golden file.
+type goldenResponse struct {
+	RefId  string            `json:"refId"`
+	Status int               `json:"status,omitempty"`
+	Error  string            `json:"error,omitempty"`
+	Frames []json.RawMessage `json:"frames"`
+}
+
+func TestQueryData_Replay(t *testing.T) {
+	entries, err := os.ReadDir(replayDirectory)
+	require.NoError(t, err, "reading the scenarios failed")
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		t.Run(entry.Name(), func(t *testing.T) {
+			runReplayScenario(t, filepath.Join(replayDirectory, entry.Name()))
+		})
+	}
+}
+
+func runReplayScenario(t *testing.T, directory string) {
+	content, err := os.ReadFile(filepath.Join(directory, "scenario.json"))
+	require.NoError(t, err, "reading the scenario failed")
+	var scenario replayScenario
+	require.NoError(t, json.Unmarshal(content, &scenario), "parsing the scenario failed")
+	require.Equal(t, strings.HasPrefix(filepath.Base(directory), "synthetic-"), scenario.Synthetic, "the directory of a scenario should start with \"synthetic-\" if and only if the scenario is synthetic")
+	if *record && scenario.Synthetic {
+		t.Skip("the recording of a synthetic scenario is written by hand")
+	}
+
+	recordingFile := filepath.Join(directory, "recording.json")
+	var newClient func(options *stablenet.ConnectOptions, logger log.Logger) stablenet.Client
+	var recorder *stablenet.Recorder
+	var replayer *stablenet.Replayer
+	if *record {
+		address := os.Getenv("STABLENET_ADDRESS")
+		require.NotEmpty(t, address, "STABLENET_ADDRESS is required for recording")
+		recorder = &stablenet.Recorder{}
+		newClient = func(options *stablenet.ConnectOptions, logger log.Logger) stablenet.Client {
+			return stablenet.NewClient(address, stablenet.WithCredentials(os.Getenv("STABLENET_USERNAME"), os.Getenv("STABLENET_PASSWORD")), stablenet.WithRecorder(recorder), stablenet.WithLogger(logger))
+		}
+	} else {
+		recording, err := stablenet.LoadRecording(recordingFile)
+		require.NoError(t, err, "loading the recording failed")
+		replayer = stablenet.NewReplayer(recording)
+		newClient = func(options *stablenet.ConnectOptions, logger log.Logger) stablenet.Client {
+			return stablenet.NewClient("https://stablenet.invalid:5443", stablenet.WithHTTPClient(&http.Client{Transport: replayer}), stablenet.WithLogger(logger))
+		}
+	}
+
+	request := &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			ID:                      5,
+			URL:                     "https://stablenet.invalid:5443",
+			User:                    "infosim",
+			DecryptedSecureJSONData: map[string]string{"password": "stablenet"},
+		}},
+	}
+	for _, query := range scenario.Queries {
+		var refId struct {
+			RefId string `json:"refId"`
+		}
+		require.NoError(t, json.Unmarshal(query, &refId), "parsing the query failed")
+		request.Queries = append(request.Queries, backend.DataQuery{RefID: refId.RefId, JSON: query, TimeRange: backend.TimeRange{From: scenario.From, To: scenario.To}})
+	}
+
+	// the health check is not cached, so that the server info is part of the recording
+	ds := &dataSource{newClient: newClient}
+	got, err := ds.QueryData(context.Background(), request)
+	require.NoError(t, err, "no error expected")
+	require.NotNil(t, got, "QueryData should not panic")
+
+	if recorder != nil {
+		require.NoError(t, recorder.Save(recordingFile), "saving the recording failed")
+	} else {
+		assert.Empty(t, replayer.Unused(), "the recording contains requests that were not sent, it may be outdated")
+	}
+
+	actual := goldenResponses(t, got)
+	goldenFile := filepath.Join(directory, "frames.golden.json")
+	if *updateGolden || *record {
+		require.NoError(t, os.WriteFile(goldenFile, append(actual, '\n'), 0644), "writing the golden file failed")
+		return
+	}
+	expected, err := os.ReadFile(goldenFile)
+	require.NoError(t, err, "reading the golden file failed, run the test with -update to create it")
+	assert.JSONEq(t, string(expected), string(actual), "the frames differ from %s, run the test with -update if the change is intended", goldenFile)
+}
+
+func goldenResponses(t *testing.T, response *backend.QueryDataResponse) []byte {
+	refIds := make([]string, 0, len(response.Responses))
+	for refId := range response.Responses {
+		refIds = append(refIds, refId)
+	}
+	sort.Strings(refIds)
+	result := make([]goldenResponse, 0, len(refIds))
+	for _, refId := range refIds {
+		dataResponse := response.Responses[refId]
+		golden := goldenResponse{RefId: refId, Frames: make([]json.RawMessage, 0, len(dataResponse.Frames))}
+		if dataResponse.Error != nil {
+			golden.Status = int(dataResponse.Status)
+			golden.Error = dataResponse.Error.Error()
+		}
+		for _, frame := range dataResponse.Frames {
+			content, err := json.Marshal(frame)
+			require.NoError(t, err, "marshalling the frame failed")
+			golden.Frames = append(golden.Frames, content)
+		}
+		result = append(result, golden)
+	}
+	content, err := json.MarshalIndent(result, "", "  ")
+	require.NoError(t, err, "marshalling the responses failed")
+	return content
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// The codes of the resource errors, which let the frontend tell the kinds of failures apart without parsing messages.
+const (
+	codeInvalidParam      = "invalidParam"
+	codeUnknownResource   = "unknownResource"
+	codeMethodNotAllowed  = "methodNotAllowed"
+	codeInvalidSettings   = "invalidSettings"
+	codeInvalidDataSource = "invalidDataSource"
+	codeNotFound          = "notFound"
+	codeForbidden         = "forbidden"
+	codeTimeout           = "timeout"
+	codeStableNetError    = "stableNetError"
+	codeInternal          = "internal"
+)
+
+// The bounds of the query params of the resources. Obids are 32 bit integers in StableNet®, and longer filters than
+// these are not typed by users but would only be forwarded as large OData filters.
+const (
+	maxObid         = math.MaxInt32
+	maxFilterLength = 200
+	maxLinkLength   = 4096
+)
+
+// resourceError is the JSON body of every failed resource request. Grafana® shows the message of the body to users.
+type resourceError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Writes a resource error with the given status.
+func writeError(rw http.ResponseWriter, status int, code string, message string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(status)
+	encodeJson(rw, resourceError{Code: code, Message: message})
+}
+
+// Writes the error of an invalid query param, see paramError.
+func writeParamError(rw http.ResponseWriter, err error) {
+	writeError(rw, http.StatusBadRequest, codeInvalidParam, err.Error())
+}
+
+// Writes the error of a failed request to StableNet®, the message tells which action failed.
+func writeClientError(rw http.ResponseWriter, action string, err error) {
+	writeError(rw, httpStatusForError(err), errorCode(err), fmt.Sprintf("could not %s: %v", action, err))
+}
+
+// Returns the code of the resource error for an error of the client, matching httpStatusForError.
+func errorCode(err error) string {
+	switch {
+	case isQueryError(err):
+		return codeInvalidParam
+	case errors.Is(err, stablenet.ErrNotFound):
+		return codeNotFound
+	case errors.Is(err, stablenet.ErrUnauthorized), errors.Is(err, stablenet.ErrLicense), errors.Is(err, stablenet.ErrUnsupportedVersion):
+		return codeForbidden
+	case errors.Is(err, stablenet.ErrTimeout):
+		return codeTimeout
+	case isStableNetError(err):
+		return codeStableNetError
+	}
+	return codeInternal
+}
+
+// paramError is returned for a query param that is missing, malformed, or out of its bounds.
+type paramError struct {
+	Name   string
+	Reason string
+}
+
+func (e *paramError) Error() string {
+	return fmt.Sprintf("invalid query param %s: %s", e.Name, e.Reason)
+}
+
+// Returns the obid given by the query param, which is required.
+func obidParam(params url.Values, name string) (int, error) {
+	if !params.Has(name) || params.Get(name) == "" {
+		return 0, &paramError{Name: name, Reason: "the param is required"}
+	}
+	return intParam(params, name, 1, maxObid, 0)
+}
+
+// Returns the integer given by the query param, or the default if the param is missing.
+func intParam(params url.Values, name string, min, max, defaultValue int) (int, error) {
+	value := params.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil || result < min || result > max {
+		return 0, &paramError{Name: name, Reason: fmt.Sprintf("%q is not a number between %d and %d", value, min, max)}
+	}
+	return result, nil
+}
+
+// Returns the text given by the query param without surrounding spaces, which is empty if the param is missing.
+func textParam(params url.Values, name string, maxLength int) (string, error) {
+	value := strings.TrimSpace(params.Get(name))
+	if len(value) > maxLength {
+		return "", &paramError{Name: name, Reason: fmt.Sprintf("the text is longer than %d characters", maxLength)}
+	}
+	return value, nil
+}
+
+type resourceRoute struct {
+	methods []string
+	handler http.HandlerFunc
+}
+
+// resourceRouter dispatches the resource requests by their exact path. In contrast to http.ServeMux, it answers
+// unknown paths, wrong methods and panics of the handlers with resource errors, such that the frontend can rely on
+// every failed request carrying a JSON body.
+type resourceRouter struct {
+	routes map[string]resourceRoute
+}
+
+func newResourceRouter() *resourceRouter {
+	return &resourceRouter{routes: make(map[string]resourceRoute)}
+}
+
+// Registers the handler for the path and the given methods.
+func (r *resourceRouter) handle(path string, handler http.HandlerFunc, methods ...string) {
+	r.routes[path] = resourceRoute{methods: methods, handler: handler}
+}
+
+func (r *resourceRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	route, ok := r.routes[req.URL.Path]
+	if !ok {
+		writeError(rw, http.StatusNotFound, codeUnknownResource, fmt.Sprintf("there is no resource %s", req.URL.Path))
+		return
+	}
+	if !slices.Contains(route.methods, req.Method) {
+		rw.Header().Set("Allow", strings.Join(route.methods, ", "))
+		writeError(rw, http.StatusMethodNotAllowed, codeMethodNotAllowed, fmt.Sprintf("the resource %s does not support the method %s", req.URL.Path, req.Method))
+		return
+	}
+
+	tracked := &trackingResponseWriter{ResponseWriter: rw}
+	defer func() {
+		if err := recover(); err != nil {
+			logger := contextLogger(req.Context(), backend.PluginConfigFromContext(req.Context())).With("endpoint", req.URL.Path)
+			logger.Error("Panic in resource query", "error", err, "stack", string(debug.Stack()))
+			if !tracked.written {
+				writeError(rw, http.StatusInternalServerError, codeInternal, "the request failed unexpectedly, please check the log of Grafana®")
+			}
+		}
+	}()
+	route.handler(tracked, req)
+}
+
+// trackingResponseWriter remembers whether a handler has started its response, since the error of a panic can only be
+// written before.
+type trackingResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *trackingResponseWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trackingResponseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/mock"
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Returns the resource error of a response, which has to be JSON.
+func resourceErrorOf(t *testing.T, recorder *httptest.ResponseRecorder) resourceError {
+	t.Helper()
+	require.Equal(t, "application/json", recorder.Header().Get("Content-Type"), "errors should be JSON")
+	var got resourceError
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got), "the body should be a resource error")
+	return got
+}
+
+func TestResourceRouter(t *testing.T) {
+	router := newResourceRouter()
+	router.handle("/devices", func(rw http.ResponseWriter, req *http.Request) { encodeJson(rw, "devices") }, http.MethodGet)
+	router.handle("/panic", func(rw http.ResponseWriter, req *http.Request) { panic("bug") }, http.MethodGet)
+	router.handle("/late-panic", func(rw http.ResponseWriter, req *http.Request) {
+		encodeJson(rw, "partial")
+		panic("bug")
+	}, http.MethodGet)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantError  *resourceError
+		wantAllow  string
+		wantBody   string
+	}{
+		{name: "success", method: "GET", path: "/devices", wantStatus: 200, wantBody: "\"devices\"\n"},
+		{name: "unknown path", method: "GET", path: "/users", wantStatus: 404, wantError: &resourceError{Code: codeUnknownResource, Message: "there is no resource /users"}},
+		{name: "prefix of path", method: "GET", path: "/devices/9000", wantStatus: 404, wantError: &resourceError{Code: codeUnknownResource, Message: "there is no resource /devices/9000"}},
+		{name: "wrong method", method: "POST", path: "/devices", wantStatus: 405, wantAllow: "GET", wantError: &resourceError{Code: codeMethodNotAllowed, Message: "the resource /devices does not support the method POST"}},
+		{name: "panic", method: "GET", path: "/panic", wantStatus: 500, wantError: &resourceError{Code: codeInternal, Message: "the request failed unexpectedly, please check the log of Grafana®"}},
+		{name: "panic after response", method: "GET", path: "/late-panic", wantStatus: 200, wantBody: "\"partial\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(tt.method, "http://example.org"+tt.path, nil))
+			assert.Equal(t, tt.wantStatus, recorder.Result().StatusCode, "status is wrong")
+			assert.Equal(t, tt.wantAllow, recorder.Header().Get("Allow"), "allowed methods wrong")
+			if tt.wantError != nil {
+				assert.Equal(t, *tt.wantError, resourceErrorOf(t, recorder), "error wrong")
+			} else {
+				assert.Equal(t, tt.wantBody, recorder.Body.String(), "body wrong")
+			}
+		})
+	}
+}
+
+func TestParams(t *testing.T) {
+	params := url.Values{"obid": {"9000"}, "zero": {"0"}, "text": {" core "}, "word": {"abc"}, "empty": {""}}
+
+	obid, err := obidParam(params, "obid")
+	assert.NoError(t, err, "no error expected")
+	assert.Equal(t, 9000, obid, "obid wrong")
+	_, err = obidParam(params, "zero")
+	assert.EqualError(t, err, "invalid query param zero: \"0\" is not a number between 1 and 2147483647", "obids should be positive")
+	_, err = obidParam(params, "empty")
+	assert.EqualError(t, err, "invalid query param empty: the param is required", "obids should be required")
+
+	skip, err := intParam(params, "missing", 0, 10, 5)
+	assert.NoError(t, err, "no error expected")
+	assert.Equal(t, 5, skip, "the default should be used")
+	_, err = intParam(params, "word", 0, 10, 5)
+	assert.EqualError(t, err, "invalid query param word: \"abc\" is not a number between 0 and 10", "error wrong")
+
+	text, err := textParam(params, "text", 4)
+	assert.NoError(t, err, "surrounding spaces should not count")
+	assert.Equal(t, "core", text, "text wrong")
+	_, err = textParam(params, "text", 3)
+	assert.EqualError(t, err, "invalid query param text: the text is longer than 3 characters", "error wrong")
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "not found", err: &stablenet.StatusError{StatusCode: 404}, want: codeNotFound},
+		{name: "unauthorized", err: &stablenet.StatusError{StatusCode: 401}, want: codeForbidden},
+		{name: "license", err: stablenet.ErrLicense, want: codeForbidden},
+		{name: "timeout", err: &stablenet.RequestError{Err: context.DeadlineExceeded}, want: codeTimeout},
+		{name: "server error", err: &stablenet.StatusError{StatusCode: 500}, want: codeStableNetError},
+		{name: "invalid query", err: &QueryError{Field: "mode", Err: errors.New("unknown mode 5")}, want: codeInvalidParam},
+		{name: "plugin error", err: errors.New("bug"), want: codeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, errorCode(tt.err), "code wrong")
+		})
+	}
+}
+
+func TestDataSource_ResourceHandler(t *testing.T) {
+	snServer := mock.CreateMockServer(testStableNetUsername, testStableNetPassword)
+	settings := func(id int64, password string) *backend.DataSourceInstanceSettings {
+		return &backend.DataSourceInstanceSettings{ID: id, URL: testStableNetUrl, User: testStableNetUsername, DecryptedSecureJSONData: map[string]string{"password": password}}
+	}
+	query := func(ds *dataSource, settings *backend.DataSourceInstanceSettings, method string, path string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "http://example.org"+path, strings.NewReader(""))
+		request = request.WithContext(backend.WithPluginContext(request.Context(), backend.PluginContext{DataSourceInstanceSettings: settings}))
+		recorder := httptest.NewRecorder()
+		ds.resourceHandler().ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	t.Run("success", func(t *testing.T) {
+		ds := checkedDataSource(snServer, map[int64]bool{5: true})
+		recorder := query(ds, settings(5, testStableNetPassword), "GET", "/measurements?deviceObid=9000")
+		require.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
+		var got stablenet.MeasurementQueryResult
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got), "no error expected")
+		assert.Equal(t, snServer.Measurements[:2], got.Data, "measurements differ")
+	})
+	t.Run("validated on first request", func(t *testing.T) {
+		snServer.Info.ServerVersion = stablenet.ServerVersion{Version: "9.0.0"}
+		snServer.Info.License.Modules.Modules = []stablenet.Module{{Name: "rest-reporting"}}
+		ds := checkedDataSource(snServer, nil)
+		recorder := query(ds, settings(5, testStableNetPassword), "GET", "/metrics?measurementObid=1001")
+		assert.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
+		entry, present := ds.health.get(datasourceKey{id: 5})
+		assert.True(t, present && entry.valid, "the validation should be stored")
+	})
+
+	tests := []struct {
+		name       string
+		settings   *backend.DataSourceInstanceSettings
+		valid      map[int64]bool
+		method     string
+		path       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "no settings", settings: nil, method: "GET", path: "/devices", wantStatus: 500, wantCode: codeInvalidSettings},
+		{name: "no password", settings: &backend.DataSourceInstanceSettings{ID: 5}, method: "GET", path: "/devices", wantStatus: 500, wantCode: codeInvalidSettings},
+		{name: "invalid datasource", settings: settings(5, testStableNetPassword), valid: map[int64]bool{5: false}, method: "GET", path: "/devices", wantStatus: 500, wantCode: codeInvalidDataSource},
+		{name: "failing validation", settings: settings(5, "wrong"), valid: map[int64]bool{}, method: "GET", path: "/devices", wantStatus: 500, wantCode: codeInvalidDataSource},
+		{name: "wrong method", settings: settings(5, testStableNetPassword), valid: map[int64]bool{5: true}, method: "DELETE", path: "/devices", wantStatus: 405, wantCode: codeMethodNotAllowed},
+		{name: "unknown resource", settings: settings(5, testStableNetPassword), valid: map[int64]bool{5: true}, method: "GET", path: "/", wantStatus: 404, wantCode: codeUnknownResource},
+		{name: "invalid param", settings: settings(5, testStableNetPassword), valid: map[int64]bool{5: true}, method: "GET", path: "/metrics?measurementObid=-1", wantStatus: 400, wantCode: codeInvalidParam},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := checkedDataSource(snServer, tt.valid)
+			recorder := query(ds, tt.settings, tt.method, tt.path)
+			assert.Equal(t, tt.wantStatus, recorder.Result().StatusCode, "status is wrong")
+			assert.Equal(t, tt.wantCode, resourceErrorOf(t, recorder).Code, "code wrong")
+		})
+	}
+}
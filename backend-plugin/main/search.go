@@ -0,0 +1,427 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// The search looks for the words of a text in the paths of the devices, measurements and metrics, e.g. "core1 eth0 in
+// octets" finds the metric "In Octets" of the measurement "core1 eth0" of the device "core1". StableNet® only searches
+// names, thus the search runs in three rounds: the devices and measurements whose name contains one of the words, then
+// the measurements of the best devices and the devices of the found measurements, and finally the metrics of the best
+// measurements.
+const (
+	// The time the requests of a search may take, the hits found until then are returned.
+	searchTimeout = 3 * time.Second
+	// Further words of a search are ignored.
+	maxSearchWords = 6
+	// Words shorter than this are only matched against the names found for the longer words, unless all words are short.
+	minQueryWordLength = 3
+	// The number of devices whose measurements are listed.
+	maxSearchedDevices = 3
+	// The number of devices of found measurements that are fetched.
+	maxFetchedDevices = 20
+	// The number of measurements whose metrics are fetched.
+	maxSearchedMeasurements = 10
+	// The number of hits returned if the request has no limit.
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type SearchHitKind string
+
+const (
+	HitDevice      SearchHitKind = "device"
+	HitMeasurement SearchHitKind = "measurement"
+	HitMetric      SearchHitKind = "metric"
+)
+
+// Devices are listed before their measurements and measurements before their metrics if they have the same score.
+var hitKindOrder = map[SearchHitKind]int{HitDevice: 0, HitMeasurement: 1, HitMetric: 2}
+
+type SearchEntity struct {
+	Obid int    `json:"obid"`
+	Name string `json:"name"`
+}
+
+type SearchMetric struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+// SearchHit is a device, measurement or metric found by a search, with the path of the names that leads to it. The score
+// is the share of the words of the search that are part of the path. The device of a measurement is missing if it could
+// not be fetched.
+type SearchHit struct {
+	Kind        SearchHitKind `json:"kind"`
+	Path        string        `json:"path"`
+	Score       float64       `json:"score"`
+	Device      *SearchEntity `json:"device,omitempty"`
+	Measurement *SearchEntity `json:"measurement,omitempty"`
+	Metric      *SearchMetric `json:"metric,omitempty"`
+}
+
+// Splits the text into lower case words, duplicates and the words beyond maxSearchWords are dropped.
+func searchWords(text string) []string {
+	result := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if !seen[word] && len(result) < maxSearchWords {
+			seen[word] = true
+			result = append(result, word)
+		}
+	}
+	return result
+}
+
+// searcher collects the entities found by the requests of a search, which are sent concurrently.
+type searcher struct {
+	words        []string
+	mutex        sync.Mutex
+	devices      map[int]stablenet.Device
+	measurements map[int]stablenet.Measurement
+	metrics      map[int][]stablenet.Metric
+	err          error
+}
+
+// Searches the words and returns the hits ordered by score. The error is only returned if nothing was found, the flag
+// complete tells whether all requests succeeded and the result may thus be cached.
+func search(ctx context.Context, client stablenet.Client, words []string) (hits []SearchHit, complete bool, err error) {
+	s := &searcher{
+		words:        words,
+		devices:      make(map[int]stablenet.Device),
+		measurements: make(map[int]stablenet.Measurement),
+		metrics:      make(map[int][]stablenet.Metric),
+	}
+
+	var tasks []func() error
+	for _, word := range queryWords(words) {
+		tasks = append(tasks, func() error {
+			devices, err := client.QueryDevices(ctx, word)
+			if err == nil {
+				s.addDevices(devices.Data)
+			}
+			return err
+		}, func() error {
+			measurements, err := client.SearchMeasurements(ctx, stablenet.MeasurementSearch{Name: word})
+			if err == nil {
+				s.addMeasurements(measurements.Data)
+			}
+			return err
+		})
+	}
+	s.run(tasks)
+
+	tasks = nil
+	for _, device := range s.bestDevices() {
+		tasks = append(tasks, func() error {
+			measurements, err := client.FetchMeasurementsForDevice(ctx, device.Obid, "")
+			if err == nil {
+				s.addMeasurements(measurements.Data)
+			}
+			return err
+		})
+	}
+	for _, deviceObid := range s.unknownDevices() {
+		tasks = append(tasks, func() error {
+			device, err := client.FetchDevice(ctx, deviceObid)
+			if err == nil {
+				s.addDevices([]stablenet.Device{*device})
+			}
+			return err
+		})
+	}
+	s.run(tasks)
+
+	tasks = nil
+	for _, measurement := range s.incompleteMeasurements() {
+		tasks = append(tasks, func() error {
+			metrics, err := client.FetchMetricsForMeasurement(ctx, measurement.Obid)
+			if err == nil {
+				s.mutex.Lock()
+				s.metrics[measurement.Obid] = metrics
+				s.mutex.Unlock()
+			}
+			return err
+		})
+	}
+	s.run(tasks)
+
+	hits = s.hits()
+	if len(hits) == 0 && s.err != nil {
+		return nil, false, s.err
+	}
+	return hits, s.err == nil, nil
+}
+
+// The words that are searched by StableNet®, the short ones would match too many names.
+func queryWords(words []string) []string {
+	result := make([]string, 0, len(words))
+	for _, word := range words {
+		if len(word) >= minQueryWordLength {
+			result = append(result, word)
+		}
+	}
+	if len(result) == 0 {
+		return words
+	}
+	return result
+}
+
+// Runs the tasks concurrently and remembers the first error.
+func (s *searcher) run(tasks []func() error) {
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentMeasurementRequests)
+	for _, task := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			if err := task(); err != nil {
+				s.mutex.Lock()
+				if s.err == nil {
+					s.err = err
+				}
+				s.mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *searcher) addDevices(devices []stablenet.Device) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, device := range devices {
+		s.devices[device.Obid] = device
+	}
+}
+
+func (s *searcher) addMeasurements(measurements []stablenet.Measurement) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, measurement := range measurements {
+		s.measurements[measurement.Obid] = measurement
+	}
+}
+
+// Returns the share of the words that are part of the names.
+func (s *searcher) score(names ...string) float64 {
+	path := strings.ToLower(strings.Join(names, " "))
+	matched := 0
+	for _, word := range s.words {
+		if strings.Contains(path, word) {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(s.words))
+}
+
+func (s *searcher) deviceName(measurement stablenet.Measurement) string {
+	return s.devices[measurement.DeviceObid].Name
+}
+
+// The devices whose measurements are listed: the ones with the highest score that do not match all words by themselves.
+func (s *searcher) bestDevices() []stablenet.Device {
+	candidates := make([]stablenet.Device, 0)
+	for _, device := range s.devices {
+		if score := s.score(device.Name); score > 0 && score < 1 {
+			candidates = append(candidates, device)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := s.score(candidates[i].Name), s.score(candidates[j].Name)
+		return a > b || (a == b && candidates[i].Obid < candidates[j].Obid)
+	})
+	return candidates[:min(len(candidates), maxSearchedDevices)]
+}
+
+// The devices of the found measurements that are not known yet, ordered by obid.
+func (s *searcher) unknownDevices() []int {
+	seen := make(map[int]bool)
+	result := make([]int, 0)
+	for _, measurement := range s.measurements {
+		if _, known := s.devices[measurement.DeviceObid]; !known && measurement.DeviceObid != 0 && !seen[measurement.DeviceObid] {
+			seen[measurement.DeviceObid] = true
+			result = append(result, measurement.DeviceObid)
+		}
+	}
+	sort.Ints(result)
+	return result[:min(len(result), maxFetchedDevices)]
+}
+
+// The measurements whose metrics are fetched: the ones with the highest score whose path does not contain all words,
+// such that their metrics may contain the missing ones.
+func (s *searcher) incompleteMeasurements() []stablenet.Measurement {
+	candidates := make([]stablenet.Measurement, 0)
+	for _, measurement := range s.measurements {
+		if score := s.score(s.deviceName(measurement), measurement.Name); score > 0 && score < 1 {
+			candidates = append(candidates, measurement)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a := s.score(s.deviceName(candidates[i]), candidates[i].Name)
+		b := s.score(s.deviceName(candidates[j]), candidates[j].Name)
+		return a > b || (a == b && candidates[i].Obid < candidates[j].Obid)
+	})
+	return candidates[:min(len(candidates), maxSearchedMeasurements)]
+}
+
+// Returns the hits ordered by descending score, then by kind and path. Entities matching none of the words are dropped.
+func (s *searcher) hits() []SearchHit {
+	result := make([]SearchHit, 0)
+	add := func(hit SearchHit, names ...string) {
+		if hit.Score = s.score(names...); hit.Score > 0 {
+			nonEmpty := make([]string, 0, len(names))
+			for _, name := range names {
+				if name != "" {
+					nonEmpty = append(nonEmpty, name)
+				}
+			}
+			hit.Path = strings.Join(nonEmpty, " › ")
+			result = append(result, hit)
+		}
+	}
+	for _, device := range s.devices {
+		add(SearchHit{Kind: HitDevice, Device: &SearchEntity{Obid: device.Obid, Name: device.Name}}, device.Name)
+	}
+	for _, measurement := range s.measurements {
+		var device *SearchEntity
+		if known, ok := s.devices[measurement.DeviceObid]; ok {
+			device = &SearchEntity{Obid: known.Obid, Name: known.Name}
+		}
+		entity := &SearchEntity{Obid: measurement.Obid, Name: measurement.Name}
+		deviceName := s.deviceName(measurement)
+		add(SearchHit{Kind: HitMeasurement, Device: device, Measurement: entity}, deviceName, measurement.Name)
+		for _, metric := range s.metrics[measurement.Obid] {
+			add(SearchHit{Kind: HitMetric, Device: device, Measurement: entity, Metric: &SearchMetric{Key: metric.Key, Name: metric.Name}}, deviceName, measurement.Name, metric.Name)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Kind != b.Kind {
+			return hitKindOrder[a.Kind] < hitKindOrder[b.Kind]
+		}
+		return a.Path < b.Path
+	})
+	return result
+}
+
+// The hits of a search are cached for a minute per datasource, such that typing and deleting a character does not
+// repeat the requests. Only the hits of searches whose requests all succeeded are cached.
+const (
+	searchCacheTTL        = time.Minute
+	maxSearchCacheEntries = 500
+)
+
+type searchCacheKey struct {
+	datasource int64
+	words      string
+}
+
+type searchCacheEntry struct {
+	hits    []SearchHit
+	expires time.Time
+}
+
+type searchCache struct {
+	mutex   sync.Mutex
+	entries map[searchCacheKey]searchCacheEntry
+	now     func() time.Time
+}
+
+func newSearchCache() *searchCache {
+	return &searchCache{entries: make(map[searchCacheKey]searchCacheEntry), now: time.Now}
+}
+
+func (c *searchCache) get(key searchCacheKey) ([]SearchHit, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	hit := ok && c.now().Before(entry.expires)
+	recordCacheLookup("search", hit)
+	if !hit {
+		return nil, false
+	}
+	return entry.hits, true
+}
+
+// Stores the hits. If the cache is full, the expired entries are dropped, or all entries if none has expired.
+func (c *searchCache) put(key searchCacheKey, hits []SearchHit) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.now()
+	if len(c.entries) >= maxSearchCacheEntries {
+		for existing, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, existing)
+			}
+		}
+		if len(c.entries) >= maxSearchCacheEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = searchCacheEntry{hits: hits, expires: now.Add(searchCacheTTL)}
+}
+
+// Searches devices, measurements and metrics whose path contains the words of the parameter text. The optional
+// parameter limit restricts the number of hits.
+func handleSearch(cache *searchCache) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		params := req.URL.Query()
+		text, err := textParam(params, "text", maxFilterLength)
+		if err != nil {
+			writeParamError(rw, err)
+			return
+		}
+		words := searchWords(text)
+		if len(words) == 0 {
+			writeParamError(rw, &paramError{Name: "text", Reason: "the text is empty"})
+			return
+		}
+		limit, err := intParam(params, "limit", 1, maxSearchLimit, defaultSearchLimit)
+		if err != nil {
+			writeParamError(rw, err)
+			return
+		}
+
+		key := searchCacheKey{words: strings.Join(words, " ")}
+		if settings := backend.PluginConfigFromContext(req.Context()).DataSourceInstanceSettings; settings != nil {
+			key.datasource = settings.ID
+		}
+		hits, ok := cache.get(key)
+		if !ok {
+			snClient := req.Context().Value("SnClient").(stablenet.Client)
+			ctx, cancel := context.WithTimeout(req.Context(), searchTimeout)
+			defer cancel()
+			var complete bool
+			hits, complete, err = search(ctx, snClient, words)
+			if err != nil {
+				writeClientError(rw, "search", err)
+				return
+			}
+			if complete {
+				cache.put(key, hits)
+			}
+		}
+		encodeJson(rw, hits[:min(limit, len(hits))])
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/mock"
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingClient counts the device queries, which every search sends first, and the fetches of metrics.
+type countingClient struct {
+	stablenet.Client
+	deviceQueries atomic.Int32
+	metricFetches atomic.Int32
+}
+
+func (c *countingClient) QueryDevices(ctx context.Context, nameFilter string) (*stablenet.DeviceQueryResult, error) {
+	c.deviceQueries.Add(1)
+	return c.Client.QueryDevices(ctx, nameFilter)
+}
+
+func (c *countingClient) FetchMetricsForMeasurement(ctx context.Context, measurementObid int) ([]stablenet.Metric, error) {
+	c.metricFetches.Add(1)
+	return c.Client.FetchMetricsForMeasurement(ctx, measurementObid)
+}
+
+func TestSearchWords(t *testing.T) {
+	assert.Equal(t, []string{"core1", "eth0", "in"}, searchWords("  Core1 eth0 IN core1 "), "words wrong")
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, searchWords("a b c d e f g"), "further words should be dropped")
+	assert.Equal(t, []string{"eth0", "octets"}, queryWords([]string{"eth0", "in", "octets"}), "short words should not be queried")
+	assert.Equal(t, []string{"in"}, queryWords([]string{"in"}), "short words should be queried if there are no others")
+}
+
+func TestSearch(t *testing.T) {
+	snServer := mock.CreateMockServer("infosim", "stablenet")
+	client := mock.NewClient(snServer, snServer.Username, snServer.Password)
+
+	t.Run("metric", func(t *testing.T) {
+		hits, complete, err := search(context.Background(), client, searchWords("bach host uptime"))
+		require.NoError(t, err, "no error expected")
+		assert.True(t, complete, "all requests should succeed")
+		require.NotEmpty(t, hits, "hits expected")
+		assert.Equal(t, SearchHit{
+			Kind:        HitMetric,
+			Path:        "Bach › Host › Uptime",
+			Score:       1,
+			Device:      &SearchEntity{Obid: 9000, Name: "Bach"},
+			Measurement: &SearchEntity{Obid: 1001, Name: "Host"},
+			Metric:      &SearchMetric{Key: "SNMP_1", Name: "Uptime"},
+		}, hits[0], "the metric should be the best hit")
+		paths := make([]string, 0, len(hits))
+		for _, hit := range hits {
+			paths = append(paths, hit.Path)
+		}
+		assert.Equal(t, []string{"Bach › Host › Uptime", "Bach › Host", "Bach › Host › CPU 1", "Bach", "Bach › Processor"}, paths, "hits should be ordered by score, kind and path")
+	})
+	t.Run("device of measurement", func(t *testing.T) {
+		hits, _, err := search(context.Background(), client, searchWords("interface"))
+		require.NoError(t, err, "no error expected")
+		require.Equal(t, 1, len(hits), "number of hits")
+		assert.Equal(t, "Fluss › Interface 1", hits[0].Path, "the device of the measurement should be fetched")
+	})
+	t.Run("nothing found", func(t *testing.T) {
+		hits, complete, err := search(context.Background(), client, searchWords("nowhere"))
+		require.NoError(t, err, "no error expected")
+		assert.True(t, complete, "all requests should succeed")
+		assert.Empty(t, hits, "no hits expected")
+	})
+	t.Run("server error", func(t *testing.T) {
+		_, _, err := search(context.Background(), mock.NewClient(snServer, "", ""), searchWords("bach"))
+		assert.ErrorIs(t, err, stablenet.ErrUnauthorized, "the error should be returned if nothing was found")
+	})
+}
+
+func TestHandleSearch(t *testing.T) {
+	snServer := mock.CreateMockServer("infosim", "stablenet")
+	run := func(cache *searchCache, client stablenet.Client, params url.Values) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "http://example.org/?"+params.Encode(), strings.NewReader(""))
+		ctx := context.WithValue(request.Context(), "SnClient", client)
+		request = request.WithContext(ctx)
+		recorder := httptest.NewRecorder()
+		handleSearch(cache)(recorder, request)
+		return recorder
+	}
+
+	errorTests := []struct {
+		name       string
+		params     url.Values
+		wantStatus int
+		wantErrMsg string
+	}{
+		{name: "no text", params: url.Values{"text": {"  "}}, wantStatus: 400, wantErrMsg: "invalid query param text: the text is empty"},
+		{name: "too long text", params: url.Values{"text": {strings.Repeat("x", 201)}}, wantStatus: 400, wantErrMsg: "invalid query param text: the text is longer than 200 characters"},
+		{name: "invalid limit", params: url.Values{"text": {"bach"}, "limit": {"0"}}, wantStatus: 400, wantErrMsg: "invalid query param limit: \"0\" is not a number between 1 and 100"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := run(newSearchCache(), mock.NewClient(snServer, snServer.Username, snServer.Password), tt.params)
+			assert.Equal(t, tt.wantStatus, recorder.Result().StatusCode, "status is wrong")
+			assert.Equal(t, resourceError{Code: codeInvalidParam, Message: tt.wantErrMsg}, resourceErrorOf(t, recorder), "error wrong")
+		})
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		cache := newSearchCache()
+		now := time.UnixMilli(1699999980000)
+		cache.now = func() time.Time { return now }
+		client := &countingClient{Client: mock.NewClient(snServer, snServer.Username, snServer.Password)}
+
+		recorder := run(cache, client, url.Values{"text": {"Bach Host"}, "limit": {"2"}})
+		require.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
+		var got []SearchHit
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got), "no error expected")
+		require.Equal(t, 2, len(got), "the hits should be limited")
+		assert.Equal(t, "Bach › Host", got[0].Path, "best hit wrong")
+		assert.Equal(t, int32(2), client.deviceQueries.Load(), "one device query per word expected")
+
+		recorder = run(cache, client, url.Values{"text": {"bach  host"}})
+		require.Equal(t, 200, recorder.Result().StatusCode, "status is wrong")
+		assert.Equal(t, int32(2), client.deviceQueries.Load(), "the same words should be answered from the cache")
+
+		now = now.Add(searchCacheTTL)
+		run(cache, client, url.Values{"text": {"bach host"}})
+		assert.Equal(t, int32(4), client.deviceQueries.Load(), "expired hits should be searched again")
+	})
+	t.Run("server error", func(t *testing.T) {
+		recorder := run(newSearchCache(), mock.NewClient(snServer, "", ""), url.Values{"text": {"bach"}})
+		assert.Equal(t, 403, recorder.Result().StatusCode, "status is wrong")
+		got := resourceErrorOf(t, recorder)
+		assert.Equal(t, codeForbidden, got.Code, "code wrong")
+		assert.Contains(t, got.Message, "could not search: ", "error message is wrong")
+	})
+}
@@ -8,12 +8,18 @@
 package main
 
 import (
-	"backend-plugin/stablenet"
+	"encoding/json"
 	"fmt"
 
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
 
+// The non-secret settings of the datasource, which are stored as jsonData by Grafana®.
+type jsonSettings struct {
+	VerboseLogging bool `json:"verboseLogging"`
+}
+
 func loadStableNetSettings(settings *backend.DataSourceInstanceSettings) (*stablenet.ConnectOptions, error) {
 	if settings == nil {
 		return nil, fmt.Errorf("datasource settings are nil, are you in a datasource environment?")
@@ -23,9 +29,17 @@ func loadStableNetSettings(settings *backend.DataSourceInstanceSettings) (*stabl
 		return nil, fmt.Errorf("no password was provided")
 	}
 
+	var jsonData jsonSettings
+	if len(settings.JSONData) != 0 {
+		if err := json.Unmarshal(settings.JSONData, &jsonData); err != nil {
+			return nil, fmt.Errorf("could not parse datasource settings: %v", err)
+		}
+	}
+
 	return &stablenet.ConnectOptions{
-		Address:  settings.URL,
-		Username: settings.User,
-		Password: settings.DecryptedSecureJSONData["password"],
+		Address:        settings.URL,
+		Username:       settings.User,
+		Password:       settings.DecryptedSecureJSONData["password"],
+		VerboseLogging: jsonData.VerboseLogging,
 	}, nil
 }
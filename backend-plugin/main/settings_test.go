@@ -47,3 +47,20 @@ func TestLoadStableNetSettings(t *testing.T) {
 	assert.Equal(t, testStableNetUsername, options.Username, "username not correct")
 	assert.Equal(t, testStableNetPassword, options.Password, "password not correct")
 }
+
+func TestLoadStableNetSettings_JsonData(t *testing.T) {
+	values := backend.DataSourceInstanceSettings{
+		URL:                     testStableNetUrl,
+		User:                    testStableNetUsername,
+		JSONData:                []byte(`{"verboseLogging": true}`),
+		DecryptedSecureJSONData: map[string]string{"password": testStableNetPassword},
+	}
+
+	options, err := loadStableNetSettings(&values)
+	require.NoError(t, err)
+	assert.True(t, options.VerboseLogging, "verbose logging should be enabled")
+
+	values.JSONData = []byte("{")
+	_, err = loadStableNetSettings(&values)
+	assert.EqualError(t, err, "could not parse datasource settings: unexpected end of JSON input")
+}
@@ -8,109 +8,132 @@
 package main
 
 import (
-	"backend-plugin/stablenet"
+	"errors"
 	"fmt"
-	"regexp"
-	"strconv"
-	"strings"
+	"sync"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
 )
 
-func ExpandStatisticLinks(queries []MetricQuery, metricSupplier func(int) ([]stablenet.Metric, error)) ([]MetricQuery, error) {
+// Expands the queries with a statistic link into a query per measurement of the link and passes the other queries
+// through. A link that cannot be expanded fails only its own query, its error is returned for the RefId of the query.
+// The metric supplier is called for every measurement of the links, it should be memoized by the caller if several links
+// refer to the same measurements.
+func ExpandStatisticLinks(queries []MetricQuery, metricSupplier func(int) ([]stablenet.Metric, error)) ([]MetricQuery, map[string]error) {
 	result := make([]MetricQuery, 0, len(queries))
-	for index, query := range queries {
+	failed := make(map[string]error)
+	for _, query := range queries {
 		if query.StatisticLink == nil {
 			result = append(result, query)
 			continue
 		}
 		linkQueries, err := parseStatisticLink(query, metricSupplier)
+		var linkErr *stablenet.LinkError
+		if errors.As(err, &linkErr) {
+			failed[query.RefId] = fmt.Errorf("could not parse statistic link: %w", err)
+			continue
+		}
 		if err != nil {
-			return nil, fmt.Errorf("could not parse statistic link of query %d: %v", index, err)
+			failed[query.RefId] = fmt.Errorf("could not resolve statistic link: %w", err)
+			continue
+		}
+		result = append(result, linkQueries...)
+	}
+	return result, failed
+}
+
+// Several links often refer to the same measurements, thus the metrics of each measurement are only fetched once. The
+// supplier may be called concurrently, e.g. by rankings.
+func memoizeMetricSupplier(metricSupplier func(int) ([]stablenet.Metric, error)) func(int) ([]stablenet.Metric, error) {
+	cache := make(map[int][]stablenet.Metric)
+	var mutex sync.Mutex
+	return func(measurementObid int) ([]stablenet.Metric, error) {
+		mutex.Lock()
+		metrics, ok := cache[measurementObid]
+		mutex.Unlock()
+		recordCacheLookup("metrics", ok)
+		if ok {
+			return metrics, nil
 		}
-		for _, linkQuery := range linkQueries {
-			result = append(result, linkQuery)
+		metrics, err := metricSupplier(measurementObid)
+		if err != nil {
+			return nil, err
 		}
+		mutex.Lock()
+		cache[measurementObid] = metrics
+		mutex.Unlock()
+		return metrics, nil
 	}
-	return result, nil
 }
 
 func parseStatisticLink(originalQuery MetricQuery, metricSupplier func(int) ([]stablenet.Metric, error)) ([]MetricQuery, error) {
-	requested := extractMetricKeysForMeasurements(*originalQuery.StatisticLink)
-	if len(requested) == 0 {
-		return nil, fmt.Errorf("the link \"%s\" does not carry at least a measurement id", *originalQuery.StatisticLink)
+	expanded, err := stablenet.ExpandStatisticLink(*originalQuery.StatisticLink, metricSupplier)
+	if err != nil {
+		return nil, err
 	}
-	allQueries := make([]MetricQuery, 0, 0)
-	for measurementId, metricKeys := range requested {
-		realMetrics, err := metricSupplier(measurementId)
-		if err != nil {
-			return nil, fmt.Errorf("could not fetch metrics for measurement %d: %v", measurementId, err)
+	patterns, err := compileMetricPatterns(originalQuery.MetricPatterns)
+	if err != nil {
+		return nil, err
+	}
+	allQueries := make([]MetricQuery, 0, len(expanded))
+	metricCount := 0
+	now := time.Now()
+	for _, measurement := range expanded {
+		if measurement.Err != nil {
+			return nil, fmt.Errorf("could not fetch metrics for measurement %d: %w", measurement.Obid, measurement.Err)
 		}
-		metrics := filterWantedMetrics(metricKeys, realMetrics)
+		metrics := selectMetrics(measurement.Metrics, patterns)
 		if len(metrics) == 0 {
 			continue
 		}
 
 		query := originalQuery.shallowClone()
 		query.Metrics = metrics
-		query.MeasurementObid = measurementId
+		query.MeasurementObid = measurement.Obid
+		applyLinkOptions(&query, measurement.LinkMeasurement, now)
 
 		allQueries = append(allQueries, query)
+		metricCount += len(metrics)
 	}
+	statisticLinkExpansionSize.Observe(float64(len(allQueries)))
+	statisticLinkExpandedMetrics.Observe(float64(metricCount))
 	return allQueries, nil
 }
 
-func findMeasurementIdsInLink(link string) map[int]int {
-	measurementRegex := regexp.MustCompile("[?&](\\d*)id=(\\d+)")
-	idMatches := measurementRegex.FindAllStringSubmatch(link, -1)
-	result := make(map[int]int)
-	for _, match := range idMatches {
-		index, _ := strconv.Atoi(match[1])
-		measurementId, _ := strconv.Atoi(match[2])
-		result[index] = measurementId
+// Overrides the time range and the average of the query with the ones of the link, if the query asks for it and the
+// link carries them. Otherwise, the time range of the dashboard and the average of the panel are kept. They are also kept
+// for aggregated and summarized queries, which combine the series of all measurements on the grid of the original
+// query, while the measurements of a link may carry different time ranges and averages.
+func applyLinkOptions(query *MetricQuery, measurement stablenet.LinkMeasurement, now time.Time) {
+	if query.isPostProcessed() {
+		return
 	}
-	return result
-}
-
-func extractMetricKeysForMeasurements(link string) map[int][]string {
-	measurementIds := findMeasurementIdsInLink(link)
-	keyRegex := regexp.MustCompile("[?&](\\d*)value\\d*=(\\d+)")
-	keyMatches := keyRegex.FindAllStringSubmatch(link, -1)
-	valueKeys := make(map[int][]string)
-	for _, match := range keyMatches {
-		index, _ := strconv.Atoi(match[1])
-		list, isPresent := valueKeys[index]
-		if !isPresent {
-			list = make([]string, 0, 0)
+	if query.UseLinkTimeRange {
+		if start, end, ok := measurement.TimeRange(now); ok {
+			query.Start = start
+			query.End = end
 		}
-		list = append(list, match[2])
-		valueKeys[index] = list
 	}
-	result := make(map[int][]string)
-	for index, measurementId := range measurementIds {
-		if _, ok := result[measurementId]; !ok {
-			result[measurementId] = make([]string, 0, 0)
-		}
-		list, isPresent := valueKeys[index]
-		if !isPresent {
-			result[measurementId] = make([]string, 0, 0)
-		} else {
-			result[measurementId] = list
-		}
+	if query.UseLinkAverage && measurement.Average > 0 {
+		query.Interval = measurement.Average
 	}
-	return result
 }
 
-func filterWantedMetrics(fromLink []string, realMetrics []stablenet.Metric) []StringPair {
-	result := make([]StringPair, 0, 0)
-	for _, realMetric := range realMetrics {
-		if len(fromLink) == 0 {
-			result = append(result, StringPair{Key: realMetric.Key, Name: realMetric.Name})
-			continue
-		}
-		for _, requestedMetric := range fromLink {
-			if strings.Contains(realMetric.Key, requestedMetric) {
-				result = append(result, StringPair{Key: realMetric.Key, Name: realMetric.Name})
-			}
-		}
+// Resolves the metric patterns of a measurement query into the metrics the measurement currently has.
+func resolveMetricPatterns(query MetricQuery, metricSupplier func(int) ([]stablenet.Metric, error)) (MetricQuery, error) {
+	patterns, err := compileMetricPatterns(query.MetricPatterns)
+	if err != nil {
+		return query, err
+	}
+	realMetrics, err := metricSupplier(query.MeasurementObid)
+	if err != nil {
+		return query, fmt.Errorf("could not fetch metrics for measurement %d: %w", query.MeasurementObid, err)
+	}
+	resolved := query.shallowClone()
+	resolved.Metrics = make([]StringPair, 0, len(realMetrics))
+	for _, metric := range selectMetrics(realMetrics, patterns) {
+		resolved.Metrics = append(resolved.Metrics, StringPair{Key: metric.Key, Name: fmt.Sprintf("%s %s", query.MetricPrefix, metric.Name)})
 	}
-	return result
+	return resolved, nil
 }
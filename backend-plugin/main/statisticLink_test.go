@@ -8,8 +8,8 @@
 package main
 
 import (
-	"backend-plugin/stablenet"
 	"fmt"
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"sort"
@@ -40,29 +40,30 @@ func TestExpandStatisticLinks(t *testing.T) {
 		return nil, fmt.Errorf("measurement %d not found", i)
 	}
 	t.Run("success", func(t *testing.T) {
-		got, err := ExpandStatisticLinks(queries, metricProvider)
-		require.Nil(t, err, "no error expected")
+		got, failed := ExpandStatisticLinks(queries, metricProvider)
+		assert.Empty(t, failed, "no error expected")
 		require.Equal(t, 2, len(got), "expanded queries wrong")
 		assert.Equal(t, 4000, got[0].MeasurementObid, "measurement obid of first query not correct")
 		assert.Equal(t, 2121, got[1].MeasurementObid, "measurement obid of second query not correct")
 	})
 	t.Run("expand error", func(t *testing.T) {
-		q := []MetricQuery{{StatisticLink: ptr("not a link")}}
-		got, err := ExpandStatisticLinks(q, metricProvider)
-		require.Nil(t, got, "should be nil in case of error")
-		assert.EqualError(t, err, "could not parse statistic link of query 0: the link \"not a link\" does not carry at least a measurement id", "error message wrong")
+		q := []MetricQuery{{RefId: "A", StatisticLink: ptr("not a link")}, queries[0], {RefId: "C", StatisticLink: ptr("?id=7000")}}
+		got, failed := ExpandStatisticLinks(q, metricProvider)
+		require.Equal(t, 1, len(got), "the other links should still be expanded")
+		assert.Equal(t, 4000, got[0].MeasurementObid, "measurement obid of expanded query not correct")
+		require.Equal(t, 2, len(failed), "an error per failed query expected")
+		assert.EqualError(t, failed["A"], "could not parse statistic link: the link \"not a link\" does not carry at least a measurement id", "error message wrong")
+		assert.EqualError(t, failed["C"], "could not resolve statistic link: could not fetch metrics for measurement 7000: measurement 7000 not found", "error message wrong")
 	})
 }
 
 func TestParseStatisticLink(t *testing.T) {
 	query := MetricQuery{
-		Start:           time.Now(),
-		End:             time.Now().Add(5 * time.Hour),
-		Interval:        4000,
-		IncludeAvgStats: true,
-		IncludeMaxStats: true,
-		IncludeMinStats: true,
-		StatisticLink:   ptr("http://example.com/measurements/?0id=4000&1id=5000&0value1=4&0value0=2&1value0=23&2id=6000"),
+		Start:         time.Now(),
+		End:           time.Now().Add(5 * time.Hour),
+		Interval:      4000,
+		Statistics:    []stablenet.Statistic{stablenet.StatisticMin, stablenet.StatisticMax, stablenet.StatisticAvg},
+		StatisticLink: ptr("http://example.com/measurements/?0id=4000&1id=5000&0value1=4&0value0=2&1value0=23&2id=6000"),
 	}
 	metricProvider := func(i int) ([]stablenet.Metric, error) {
 		if i == 4000 {
@@ -85,12 +86,19 @@ func TestParseStatisticLink(t *testing.T) {
 		assert.Equal(t, query.Start, one.Start, "start of first query not correct")
 		assert.Equal(t, query.End, one.End, "end of first query not correct")
 		assert.Equal(t, query.Interval, one.Interval, "Interval of first query not correct")
-		assert.Equal(t, query.IncludeMinStats, one.IncludeMinStats, "min of first query not correct")
-		assert.Equal(t, query.IncludeMaxStats, one.IncludeMaxStats, "max of first query not correct")
-		assert.Equal(t, query.IncludeAvgStats, one.IncludeAvgStats, "avg of first query not correct")
+		assert.Equal(t, query.Statistics, one.Statistics, "statistics of first query not correct")
 		assert.Equal(t, 4000, one.MeasurementObid, "measurementObid of first query not correct")
 		assert.Equal(t, []StringPair{{Key: "SNMP_2", Name: "Out"}, {Key: "SNMP_4", Name: "Down"}}, one.Metrics, "metrics of first query not correct")
 	})
+	t.Run("with metric patterns", func(t *testing.T) {
+		q := query.shallowClone()
+		q.MetricPatterns = []MetricPattern{{Mode: MatchRegex, Pattern: "^(Out|VMs)$"}}
+		got, err := parseStatisticLink(q, metricProvider)
+		require.NoError(t, err, "no error expected")
+		require.Equal(t, 2, len(got), "number of expanded queries")
+		assert.Equal(t, []StringPair{{Key: "SNMP_2", Name: "Out"}}, got[0].Metrics, "metrics of first query not correct")
+		assert.Equal(t, []StringPair{{Key: "SCRIPT_23", Name: "VMs"}}, got[1].Metrics, "metrics of second query not correct")
+	})
 	t.Run("carries no link", func(t *testing.T) {
 		q := MetricQuery{StatisticLink: ptr("not a link")}
 		got, err := parseStatisticLink(q, metricProvider)
@@ -105,89 +113,59 @@ func TestParseStatisticLink(t *testing.T) {
 	})
 }
 
-func TestFindMeasurementIdsInLink(t *testing.T) {
-	cases := []struct {
-		name   string
-		link   string
-		wanted map[int]int
-	}{
-		{name: "one without index 1", link: "stablenet.de/?id=33", wanted: map[int]int{0: 33}},
-		{name: "one without index 2", link: "stablenet.de/?chart=555&id=34", wanted: map[int]int{0: 34}},
-		{name: "several measurement ids 1", link: "stablenet.de/?chart=555&0id=34&0value1=1000&0value1=2000&1id=56&1value0=1001", wanted: map[int]int{0: 34, 1: 56}},
-		{name: "several measurement ids 2", link: "stablenet.de/?chart=555&1id=34&1value1=1000&0value1=2000&0id=56&1value0=1001", wanted: map[int]int{1: 34, 0: 56}},
-		{name: "several measurement ids mixed", link: "stablenet.de/?chart=555&id=34&0value1=1000&0value1=2000&1id=56&1value0=1001", wanted: map[int]int{0: 34, 1: 56}},
-	}
-	for _, tt := range cases {
-		t.Run(tt.name, func(t *testing.T) {
-			got := findMeasurementIdsInLink(tt.link)
-			assert.Equal(t, len(tt.wanted), len(got), "length is different")
-			for key, value := range tt.wanted {
-				gotValue, ok := got[key]
-				if !assert.True(t, ok, "key %d not available in got map", key) {
-					continue
-				}
-				assert.Equal(t, value, gotValue, "for key %d the expected value %d differs from the got one %d", key, value, gotValue)
-			}
-		})
-	}
-}
-
-func TestExtractMetricKeysForMeasurement(t *testing.T) {
-	cases := []struct {
-		name   string
-		link   string
-		wanted map[int][]string
-	}{
-		{name: "one measurement, one metric", link: "https://localhost:5443/PlotServlet?id=1643&chart=5504&last=0,1440&offset=0,0&tz=Europe%2FBerlin&value0=1002", wanted: map[int][]string{1643: {"1002"}}},
-		{name: "one measurement, several metrics", link: "https://localhost:5443/PlotServlet?id=1643&chart=5504&last=0,1440&offset=0,0&tz=Europe%2FBerlin&value0=1002&value1=1000&value2=1001", wanted: map[int][]string{1643: {"1002", "1000", "1001"}}},
-		{name: "several measurements", link: "https://localhost:5443/PlotServlet?multicharttype=0&dns=1&log=0&width=1252&height=1126&quality=-1.0&0last=0,1440&0offset=0,0&0interval=60000&0id=1643&0chart=5504&0value0=1000&0value1=1001&0value2=1002&1last=0,1440&1offset=0,0&1interval=60000&1id=3889&1chart=5504&1value0=1", wanted: map[int][]string{1643: {"1000", "1001", "1002"}, 3889: {"1"}}},
-		{name: "ping measurements w/o valueIds", link: "https://localhost:5443/PlotServlet?multicharttype=0&dns=1&log=0&width=1252&height=1126&quality=-1.0&0last=0,1440&0offset=0,0&0interval=60000&0id=3088&0chart=100&1last=0,1440&1offset=0,0&1interval=60000&1id=7228&1chart=100&tz=Europe/Berlin", wanted: map[int][]string{3088: {}, 7228: {}}},
+func TestParseStatisticLink_LinkOptions(t *testing.T) {
+	start := time.UnixMilli(1574839083813)
+	end := time.UnixMilli(1574840883813)
+	link := "https://localhost:5443/PlotServlet?0id=4000&0start=1574839083813&0end=1574840883813&0interval=300000&1id=5000&1last=0,60&1interval=60000&2id=6000"
+	metricProvider := func(i int) ([]stablenet.Metric, error) {
+		return []stablenet.Metric{{Key: "SNMP_1", Name: "In"}}, nil
 	}
-	for _, tt := range cases {
-		t.Run(tt.name, func(t *testing.T) {
-			got := extractMetricKeysForMeasurements(tt.link)
-			assert.Equal(t, len(tt.wanted), len(got), "lenght is different")
-			for key, value := range tt.wanted {
-				gotValue, ok := got[key]
-				if !assert.True(t, ok, "key %d not available in got map", key) {
-					continue
-				}
-				assert.Equal(t, value, gotValue, "for key %d the expected value %d differs from the got one %d", key, value, gotValue)
-			}
-		})
+	query := MetricQuery{
+		Start:         time.Now().Add(-5 * time.Hour),
+		End:           time.Now(),
+		Interval:      4000,
+		StatisticLink: &link,
 	}
-}
-
-func TestFilterWantedMetrics(t *testing.T) {
-	cases := []struct {
+	tests := []struct {
 		name          string
-		metrics       []stablenet.Metric
-		wantedMetrics []string
-		wanted        []int
+		timeRange     bool
+		average       bool
+		wantIntervals []int64
 	}{
-		{name: "Wanted Metrics 1", metrics: []stablenet.Metric{{Name: "Uptime", Key: "SNMP1001"}, {Name: "Processes", Key: "SNMP1000"}, {Name: "Users", Key: "SNMP1002"}}, wantedMetrics: []string{"1001", "1002"}, wanted: []int{0, 2}},
-		{name: "Wanted Metrics 2", metrics: []stablenet.Metric{{Name: "Uptime", Key: "SNMP1010"}, {Name: "Processes", Key: "SNMP1020"}}, wantedMetrics: []string{"1000"}, wanted: []int{}},
-		{name: "Wanted Metrics is empty", metrics: []stablenet.Metric{{Name: "Uptime", Key: "SNMP1010"}, {Name: "Processes", Key: "SNMP1020"}}, wantedMetrics: []string{}, wanted: []int{0, 1}},
+		{name: "no options", wantIntervals: []int64{4000, 4000, 4000}},
+		{name: "time range", timeRange: true, wantIntervals: []int64{4000, 4000, 4000}},
+		{name: "average", average: true, wantIntervals: []int64{300000, 60000, 4000}},
+		{name: "both", timeRange: true, average: true, wantIntervals: []int64{300000, 60000, 4000}},
 	}
-	for _, tt := range cases {
+	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := filterWantedMetrics(tt.wantedMetrics, tt.metrics)
-			assert.Equal(t, len(tt.wanted), len(got))
-			for _, wantedIndex := range tt.wanted {
-				wanted := tt.metrics[wantedIndex]
-				contained := false
-				for _, metric := range got {
-					if metric.Key == wanted.Key && metric.Name == wanted.Name {
-						contained = true
-					}
+			q := query.shallowClone()
+			q.UseLinkTimeRange = tt.timeRange
+			q.UseLinkAverage = tt.average
+			got, err := parseStatisticLink(q, metricProvider)
+			require.NoError(t, err, "no error expected")
+			require.Equal(t, 3, len(got), "number of expanded queries")
+			for index, wantInterval := range tt.wantIntervals {
+				assert.Equal(t, wantInterval, got[index].Interval, "interval of query %d wrong", index)
+			}
+			if !tt.timeRange {
+				for index, expanded := range got {
+					assert.Equal(t, query.Start, expanded.Start, "start of query %d must not change", index)
+					assert.Equal(t, query.End, expanded.End, "end of query %d must not change", index)
 				}
-				assert.True(t, contained, "%v was not present in to got metrics", wanted)
+				return
 			}
+			assert.Equal(t, start, got[0].Start, "start of absolute range wrong")
+			assert.Equal(t, end, got[0].End, "end of absolute range wrong")
+			assert.Equal(t, time.Hour, got[1].End.Sub(got[1].Start), "length of relative range wrong")
+			assert.WithinDuration(t, time.Now(), got[1].End, time.Minute, "relative range should end now")
+			assert.Equal(t, query.Start, got[2].Start, "start of query without range in link must not change")
+			assert.Equal(t, query.End, got[2].End, "end of query without range in link must not change")
 		})
 	}
 }
 
-func ptr(value string) *string {
+func ptr[T any](value T) *T {
 	result := value
 	return &result
 }
@@ -0,0 +1,111 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+type Format string
+
+const (
+	FormatTimeSeries Format = ""
+	// FormatSummary returns a single table with one row per metric instead of a time series per metric.
+	FormatSummary Format = "summary"
+)
+
+// The percentile of the summary table if the query does not define one.
+const defaultSummaryPercentile = 95.0
+
+// SeriesSummary condenses a series into the values shown by a status table.
+type SeriesSummary struct {
+	Last         float64
+	Min          float64
+	Max          float64
+	Avg          float64
+	Percentile   float64
+	LastSample   *time.Time
+	Availability float64
+}
+
+// Summarizes the series over the range from start to end. The availability is the share of the range in percent that
+// is covered by samples. Without samples, all values are NaN.
+func summarize(series stablenet.MetricDataSeries, percentileValue float64, start, end time.Time) SeriesSummary {
+	if len(series) == 0 {
+		nan := math.NaN()
+		return SeriesSummary{Last: nan, Min: nan, Max: nan, Avg: nan, Percentile: nan}
+	}
+	averages := make([]float64, 0, len(series))
+	result := SeriesSummary{Min: math.Inf(1), Max: math.Inf(-1)}
+	var covered time.Duration
+	for _, entry := range series {
+		averages = append(averages, entry.Avg)
+		result.Min = math.Min(result.Min, entry.Min)
+		result.Max = math.Max(result.Max, entry.Max)
+		covered += entry.Interval
+	}
+	last := series[len(series)-1]
+	result.Last = last.Avg
+	result.LastSample = &last.Time
+	result.Avg = sum(averages) / float64(len(averages))
+	result.Percentile = percentile(averages, percentileValue)
+	if end.After(start) {
+		result.Availability = math.Min(100, float64(covered)/float64(end.Sub(start))*100)
+	}
+	return result
+}
+
+// Creates a table with one row per device, measurement and metric of the inputs.
+func summaryFrame(inputs []aggregationInput, query MetricQuery, labeler measurementLabeler) (*data.Frame, error) {
+	percentileValue := defaultSummaryPercentile
+	if query.SummaryPercentile != nil {
+		percentileValue = *query.SummaryPercentile
+	}
+	if percentileValue < 0 || percentileValue > 100 {
+		return nil, fmt.Errorf("the percentile %v is not between 0 and 100", percentileValue)
+	}
+	frame := data.NewFrame("Summary",
+		data.NewField("Device", nil, []string{}),
+		data.NewField("Measurement", nil, []string{}),
+		data.NewField("Metric", nil, []string{}),
+		data.NewField("Last", nil, []float64{}),
+		data.NewField("Min", nil, []float64{}),
+		data.NewField("Max", nil, []float64{}),
+		data.NewField("Avg", nil, []float64{}),
+		data.NewField(fmt.Sprintf("P%v", percentileValue), nil, []float64{}),
+		data.NewField("Last Sample", nil, []*time.Time{}),
+		data.NewField("Availability", nil, []float64{}),
+	)
+	frame.Meta = &data.FrameMeta{Custom: FrameMetadata{Start: query.Start, End: query.End, Average: query.Interval}}
+	frame.Fields[9].Config = &data.FieldConfig{Unit: "percent"}
+	// the statistics of the rows can only have a unit if all metrics have the same
+	if unit := commonUnit(inputs); unit != "" {
+		for _, field := range frame.Fields[3:8] {
+			field.Config = &data.FieldConfig{Unit: unit}
+		}
+	}
+	for _, input := range inputs {
+		// combined series, e.g. the others of a ranking, do not belong to a measurement
+		var measurement, device string
+		if input.MeasurementObid != 0 {
+			var err error
+			measurement, device, err = labeler(input.MeasurementObid)
+			if err != nil {
+				return nil, fmt.Errorf("could not determine the name of measurement %d: %w", input.MeasurementObid, err)
+			}
+		}
+		summary := summarize(input.Series, percentileValue, query.Start, query.End)
+		frame.AppendRow(device, measurement, input.MetricName, summary.Last, summary.Min, summary.Max, summary.Avg, summary.Percentile, summary.LastSample, summary.Availability)
+	}
+	return frame, nil
+}
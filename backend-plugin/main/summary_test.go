@@ -0,0 +1,94 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarize(t *testing.T) {
+	start := time.UnixMilli(1599999960000)
+	series := seriesOf(start, time.Minute, 10, 40, 20, 30)
+	for index := range series {
+		series[index].Interval = time.Minute
+	}
+	series[1].Min = 5
+	series[2].Max = 50
+
+	summary := summarize(series, 50, start, start.Add(8*time.Minute))
+	assert.Equal(t, 30.0, summary.Last, "last value wrong")
+	assert.Equal(t, 5.0, summary.Min, "min wrong")
+	assert.Equal(t, 50.0, summary.Max, "max wrong")
+	assert.Equal(t, 25.0, summary.Avg, "avg wrong")
+	assert.Equal(t, 25.0, summary.Percentile, "percentile wrong")
+	require.NotNil(t, summary.LastSample, "last sample should be set")
+	assert.Equal(t, start.Add(3*time.Minute), *summary.LastSample, "last sample wrong")
+	assert.Equal(t, 50.0, summary.Availability, "availability wrong")
+
+	summary = summarize(series, 50, start, start.Add(2*time.Minute))
+	assert.Equal(t, 100.0, summary.Availability, "availability should not exceed 100 percent")
+
+	summary = summarize(nil, 50, start, start.Add(time.Hour))
+	assert.True(t, math.IsNaN(summary.Last), "last value of empty series should be NaN")
+	assert.True(t, math.IsNaN(summary.Avg), "avg of empty series should be NaN")
+	assert.Nil(t, summary.LastSample, "last sample of empty series should not be set")
+	assert.Equal(t, 0.0, summary.Availability, "availability of empty series wrong")
+}
+
+func TestSummaryFrame(t *testing.T) {
+	start := time.UnixMilli(1599999960000)
+	query := MetricQuery{Start: start, End: start.Add(2 * time.Minute), Interval: 60000}
+	inputs := []aggregationInput{
+		{MeasurementObid: 1, MetricKey: "SNMP_1", MetricName: "In", Series: seriesOf(start, time.Minute, 10, 20)},
+		{MeasurementObid: 2, MetricKey: "SNMP_1", MetricName: "In", Series: nil},
+		{MetricName: "others (sum)", Series: seriesOf(start, time.Minute, 1)},
+	}
+	labeler := func(measurementObid int) (string, string, error) {
+		if measurementObid == 1 {
+			return "eth0", "router", nil
+		}
+		return "eth1", "switch", nil
+	}
+
+	frame, err := summaryFrame(inputs, query, labeler)
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, "Summary", frame.Name, "name of frame wrong")
+	require.Equal(t, 3, frame.Rows(), "number of rows")
+	assert.Equal(t, "P95", frame.Fields[7].Name, "name of percentile column wrong")
+	assert.Equal(t, "percent", frame.Fields[9].Config.Unit, "unit of availability wrong")
+	assert.Equal(t, []interface{}{"router", "eth0", "In"}, frame.RowCopy(0)[:3], "labels of first row wrong")
+	assert.Equal(t, 20.0, frame.RowCopy(0)[3], "last value of first row wrong")
+	assert.Equal(t, []interface{}{"switch", "eth1", "In"}, frame.RowCopy(1)[:3], "labels of second row wrong")
+	assert.Equal(t, []interface{}{"", "", "others (sum)"}, frame.RowCopy(2)[:3], "combined series should not have a device or measurement")
+
+	query.SummaryPercentile = ptr(99.0)
+	frame, err = summaryFrame(inputs[:1], query, labeler)
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, "P99", frame.Fields[7].Name, "name of percentile column wrong")
+
+	query.SummaryPercentile = ptr(0.0)
+	frame, err = summaryFrame(inputs[:1], query, labeler)
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, "P0", frame.Fields[7].Name, "the percentile 0 should not be replaced by the default")
+	assert.Equal(t, 10.0, frame.RowCopy(0)[7], "the percentile 0 should be the smallest average")
+
+	query.SummaryPercentile = ptr(101.0)
+	_, err = summaryFrame(inputs, query, labeler)
+	assert.EqualError(t, err, "the percentile 101 is not between 0 and 100", "error message wrong")
+
+	query.SummaryPercentile = nil
+	failing := func(int) (string, string, error) { return "", "", errors.New("not found") }
+	_, err = summaryFrame(inputs, query, failing)
+	assert.EqualError(t, err, "could not determine the name of measurement 1: not found", "error message wrong")
+}
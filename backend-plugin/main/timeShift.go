@@ -0,0 +1,108 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
+
+// Comparison selects how the series of the current range and the series of the shifted range are combined.
+type Comparison string
+
+const (
+	// CompareNone only returns the shifted series.
+	CompareNone       Comparison = ""
+	CompareDifference Comparison = "difference"
+	CompareRatio      Comparison = "ratio"
+)
+
+func validateTimeShift(shift time.Duration, comparison Comparison) error {
+	if shift < 0 {
+		return fmt.Errorf("the time shift must not be negative, but is %s", shift)
+	}
+	switch comparison {
+	case CompareNone:
+		return nil
+	case CompareDifference, CompareRatio:
+		if shift == 0 {
+			return errors.New("a comparison requires a time shift")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown comparison \"%s\"", comparison)
+}
+
+// Formats the shift in the largest unit that represents it exactly, e.g. "−7d" for a week.
+func formatTimeShift(shift time.Duration) string {
+	return "−" + formatDuration(shift)
+}
+
+// Formats the duration in the largest unit that represents it exactly, e.g. "5m".
+func formatDuration(duration time.Duration) string {
+	units := []struct {
+		suffix string
+		size   time.Duration
+	}{{"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}}
+	for _, unit := range units {
+		if duration%unit.size == 0 {
+			return fmt.Sprintf("%d%s", duration/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%ds", duration/time.Second)
+}
+
+// Moves the series forward by the shift, such that it lines up with the range the shift was applied to.
+func shiftSeries(series stablenet.MetricDataSeries, shift time.Duration) stablenet.MetricDataSeries {
+	result := make(stablenet.MetricDataSeries, 0, len(series))
+	for _, entry := range series {
+		entry.Time = entry.Time.Add(shift)
+		result = append(result, entry)
+	}
+	return result
+}
+
+// Compares the current series with the shifted one for every timestamp both series have values for after aligning
+// them onto the step.
+func compareSeries(current, shifted stablenet.MetricDataSeries, comparison Comparison, step time.Duration) stablenet.MetricDataSeries {
+	alignedCurrent := alignSeries(current, step)
+	alignedShifted := alignSeries(shifted, step)
+	timestamps := make([]int64, 0, len(alignedCurrent))
+	for timestamp := range alignedCurrent {
+		if _, ok := alignedShifted[timestamp]; ok {
+			timestamps = append(timestamps, timestamp)
+		}
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	compare := func(current, shifted float64) float64 {
+		if comparison == CompareDifference {
+			return current - shifted
+		}
+		if shifted == 0 {
+			return math.NaN()
+		}
+		return current / shifted
+	}
+	result := make(stablenet.MetricDataSeries, 0, len(timestamps))
+	for _, timestamp := range timestamps {
+		a, b := alignedCurrent[timestamp], alignedShifted[timestamp]
+		result = append(result, stablenet.MetricData{
+			Interval: step,
+			Time:     time.UnixMilli(timestamp),
+			Min:      compare(a.Min, b.Min),
+			Max:      compare(a.Max, b.Max),
+			Avg:      compare(a.Avg, b.Avg),
+		})
+	}
+	return result
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTimeShift(t *testing.T) {
+	tests := []struct {
+		name       string
+		shift      time.Duration
+		comparison Comparison
+		wantErr    string
+	}{
+		{name: "no shift", shift: 0, comparison: CompareNone},
+		{name: "shift", shift: time.Hour, comparison: CompareNone},
+		{name: "difference", shift: time.Hour, comparison: CompareDifference},
+		{name: "ratio", shift: time.Hour, comparison: CompareRatio},
+		{name: "negative", shift: -time.Hour, comparison: CompareNone, wantErr: "the time shift must not be negative, but is -1h0m0s"},
+		{name: "comparison without shift", shift: 0, comparison: CompareRatio, wantErr: "a comparison requires a time shift"},
+		{name: "unknown comparison", shift: time.Hour, comparison: "quotient", wantErr: "unknown comparison \"quotient\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTimeShift(tt.shift, tt.comparison)
+			if tt.wantErr == "" {
+				assert.NoError(t, err, "no error expected")
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr, "error message wrong")
+		})
+	}
+}
+
+func TestFormatTimeShift(t *testing.T) {
+	tests := []struct {
+		shift time.Duration
+		want  string
+	}{
+		{shift: 7 * 24 * time.Hour, want: "−7d"},
+		{shift: 36 * time.Hour, want: "−36h"},
+		{shift: 90 * time.Minute, want: "−90m"},
+		{shift: 45 * time.Second, want: "−45s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatTimeShift(tt.shift), "formatted shift wrong")
+		})
+	}
+}
+
+func TestShiftSeries(t *testing.T) {
+	start := time.UnixMilli(1599999960000)
+	series := seriesOf(start, time.Minute, 1, 2)
+	got := shiftSeries(series, time.Hour)
+	require.Equal(t, 2, len(got), "number of entries")
+	assert.Equal(t, start.Add(time.Hour), got[0].Time, "time of first entry wrong")
+	assert.Equal(t, 2.0, got[1].Avg, "value of second entry wrong")
+	assert.Equal(t, start, series[0].Time, "the original series must not be changed")
+}
+
+func TestCompareSeries(t *testing.T) {
+	start := time.UnixMilli(1599999960000)
+	current := seriesOf(start, time.Minute, 10, 20, 30)
+	shifted := seriesOf(start.Add(time.Minute), time.Minute, 5, 0)
+
+	got := compareSeries(current, shifted, CompareDifference, time.Minute)
+	require.Equal(t, 2, len(got), "only timestamps of both series should be compared")
+	assert.Equal(t, start.Add(time.Minute), got[0].Time, "time of first entry wrong")
+	assert.Equal(t, 15.0, got[0].Avg, "first difference wrong")
+	assert.Equal(t, 30.0, got[1].Avg, "second difference wrong")
+
+	got = compareSeries(current, shifted, CompareRatio, time.Minute)
+	require.Equal(t, 2, len(got), "only timestamps of both series should be compared")
+	assert.Equal(t, 4.0, got[0].Avg, "first ratio wrong")
+	assert.True(t, math.IsNaN(got[1].Avg), "a ratio to zero should be NaN")
+
+	assert.Empty(t, compareSeries(current, stablenet.MetricDataSeries{}, CompareRatio, time.Minute), "nothing to compare to")
+}
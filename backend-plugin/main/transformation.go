@@ -0,0 +1,224 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
+
+type TransformationKind string
+
+const (
+	// TransformRate converts the values into their change per second. The first point is dropped.
+	TransformRate TransformationKind = "rate"
+	// TransformDelta converts the values into their change between two points. The first point is dropped.
+	TransformDelta         TransformationKind = "delta"
+	TransformMultiply      TransformationKind = "multiply"
+	TransformDivide        TransformationKind = "divide"
+	TransformBitsToBytes   TransformationKind = "bitsToBytes"
+	TransformBytesToBits   TransformationKind = "bytesToBits"
+	TransformCumulativeSum TransformationKind = "cumulativeSum"
+	// TransformPercentOf expresses the values in percent of the average of another metric of the same measurement, e.g.
+	// the used memory in percent of the total memory. Points without a non-zero reference value are dropped.
+	TransformPercentOf TransformationKind = "percentOf"
+)
+
+// AllMetrics is the metric key of transformations that apply to every metric without transformations of its own.
+const AllMetrics = "*"
+
+// Transformation converts the data of a metric in the backend, such that alerting and recorded queries see the same
+// values as the panels. Factor is used by multiply and divide, Reference by percentOf. Counter tells rate and delta that
+// the metric is a counter, whose decreasing values are resets, e.g. by a restart of the device. The points after a reset
+// are dropped instead of returning a negative change.
+type Transformation struct {
+	Kind      TransformationKind `json:"kind"`
+	Factor    float64            `json:"factor,omitempty"`
+	Reference string             `json:"reference,omitempty"`
+	Counter   bool               `json:"counter,omitempty"`
+}
+
+func (t Transformation) validate() error {
+	if t.Counter && t.Kind != TransformRate && t.Kind != TransformDelta {
+		return fmt.Errorf("only rate and delta treat a metric as counter, not %s", t.Kind)
+	}
+	switch t.Kind {
+	case TransformRate, TransformDelta, TransformBitsToBytes, TransformBytesToBits, TransformCumulativeSum:
+		return nil
+	case TransformMultiply:
+		if t.Factor == 0 {
+			return fmt.Errorf("cannot multiply by zero")
+		}
+		return nil
+	case TransformDivide:
+		if t.Factor == 0 {
+			return fmt.Errorf("cannot divide by zero")
+		}
+		return nil
+	case TransformPercentOf:
+		if len(t.Reference) == 0 {
+			return fmt.Errorf("percentOf requires a reference metric")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown transformation \"%s\"", t.Kind)
+}
+
+func (t Transformation) apply(series stablenet.MetricDataSeries, data map[string]stablenet.MetricDataSeries) (stablenet.MetricDataSeries, error) {
+	switch t.Kind {
+	case TransformRate:
+		return differentiate(series, t.Counter, func(value, previous float64, elapsed time.Duration) float64 {
+			return (value - previous) / elapsed.Seconds()
+		}), nil
+	case TransformDelta:
+		return differentiate(series, t.Counter, func(value, previous float64, _ time.Duration) float64 {
+			return value - previous
+		}), nil
+	case TransformMultiply:
+		return mapValues(series, func(value float64) float64 { return value * t.Factor }), nil
+	case TransformDivide:
+		return mapValues(series, func(value float64) float64 { return value / t.Factor }), nil
+	case TransformBitsToBytes:
+		return mapValues(series, func(value float64) float64 { return value / 8 }), nil
+	case TransformBytesToBits:
+		return mapValues(series, func(value float64) float64 { return value * 8 }), nil
+	case TransformCumulativeSum:
+		return cumulativeSum(series), nil
+	case TransformPercentOf:
+		reference, ok := data[t.Reference]
+		if !ok {
+			return nil, fmt.Errorf("the reference metric %s has no data", t.Reference)
+		}
+		return percentOf(series, reference), nil
+	}
+	return nil, t.validate()
+}
+
+// Returns the keys of the metrics that are referenced by the transformations and must be fetched in addition, sorted
+// such that the requests for the same query are always the same.
+func referencedMetrics(transformations map[string][]Transformation) []string {
+	result := make([]string, 0)
+	for _, list := range transformations {
+		for _, transformation := range list {
+			if transformation.Kind == TransformPercentOf {
+				result = appendMissing(result, []string{transformation.Reference})
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func validateTransformations(transformations map[string][]Transformation) error {
+	keys := make([]string, 0, len(transformations))
+	for key := range transformations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for index, transformation := range transformations[key] {
+			if err := transformation.validate(); err != nil {
+				return fmt.Errorf("transformation %d of metric %s is invalid: %w", index, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Applies the transformations of each metric in the order they are listed. The data is transformed based on the
+// untransformed data of the other metrics, thus a reference metric is never used with its transformations applied.
+func applyTransformations(data map[string]stablenet.MetricDataSeries, transformations map[string][]Transformation) (map[string]stablenet.MetricDataSeries, error) {
+	if len(transformations) == 0 {
+		return data, nil
+	}
+	if err := validateTransformations(transformations); err != nil {
+		return nil, err
+	}
+	result := make(map[string]stablenet.MetricDataSeries, len(data))
+	for key, series := range data {
+		list, ok := transformations[key]
+		if !ok {
+			list = transformations[AllMetrics]
+		}
+		for _, transformation := range list {
+			var err error
+			series, err = transformation.apply(series, data)
+			if err != nil {
+				return nil, fmt.Errorf("transforming metric %s failed: %w", key, err)
+			}
+		}
+		result[key] = series
+	}
+	return result, nil
+}
+
+func mapValues(series stablenet.MetricDataSeries, mapping func(float64) float64) stablenet.MetricDataSeries {
+	result := make(stablenet.MetricDataSeries, 0, len(series))
+	for _, entry := range series {
+		entry.Min = mapping(entry.Min)
+		entry.Max = mapping(entry.Max)
+		entry.Avg = mapping(entry.Avg)
+		result = append(result, entry)
+	}
+	return result
+}
+
+func differentiate(series stablenet.MetricDataSeries, counter bool, difference func(value, previous float64, elapsed time.Duration) float64) stablenet.MetricDataSeries {
+	result := make(stablenet.MetricDataSeries, 0, len(series))
+	for index := 1; index < len(series); index++ {
+		previous, entry := series[index-1], series[index]
+		elapsed := entry.Time.Sub(previous.Time)
+		if elapsed <= 0 {
+			continue
+		}
+		// a decreasing counter was reset, its change is unknown
+		if counter && (entry.Min < previous.Min || entry.Max < previous.Max || entry.Avg < previous.Avg) {
+			continue
+		}
+		entry.Min = difference(entry.Min, previous.Min, elapsed)
+		entry.Max = difference(entry.Max, previous.Max, elapsed)
+		entry.Avg = difference(entry.Avg, previous.Avg, elapsed)
+		result = append(result, entry)
+	}
+	return result
+}
+
+func cumulativeSum(series stablenet.MetricDataSeries) stablenet.MetricDataSeries {
+	result := make(stablenet.MetricDataSeries, 0, len(series))
+	var sum stablenet.MetricData
+	for _, entry := range series {
+		sum.Min += entry.Min
+		sum.Max += entry.Max
+		sum.Avg += entry.Avg
+		entry.Min, entry.Max, entry.Avg = sum.Min, sum.Max, sum.Avg
+		result = append(result, entry)
+	}
+	return result
+}
+
+func percentOf(series stablenet.MetricDataSeries, reference stablenet.MetricDataSeries) stablenet.MetricDataSeries {
+	references := make(map[int64]stablenet.MetricData, len(reference))
+	for _, entry := range reference {
+		references[entry.Time.UnixMilli()] = entry
+	}
+	result := make(stablenet.MetricDataSeries, 0, len(series))
+	for _, entry := range series {
+		ref, ok := references[entry.Time.UnixMilli()]
+		if !ok || ref.Avg == 0 {
+			continue
+		}
+		entry.Min = entry.Min / ref.Avg * 100
+		entry.Max = entry.Max / ref.Avg * 100
+		entry.Avg = entry.Avg / ref.Avg * 100
+		result = append(result, entry)
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seriesOf(start time.Time, step time.Duration, values ...float64) stablenet.MetricDataSeries {
+	result := make(stablenet.MetricDataSeries, 0, len(values))
+	for index, value := range values {
+		result = append(result, stablenet.MetricData{Time: start.Add(time.Duration(index) * step), Min: value, Max: value, Avg: value})
+	}
+	return result
+}
+
+func TestApplyTransformations(t *testing.T) {
+	start := time.UnixMilli(1600000000000)
+	step := 10 * time.Second
+	data := map[string]stablenet.MetricDataSeries{
+		"SNMP_1": seriesOf(start, step, 100, 200, 400),
+		"SNMP_2": seriesOf(start, step, 1000, 1000, 0),
+	}
+	tests := []struct {
+		name            string
+		transformations map[string][]Transformation
+		want            stablenet.MetricDataSeries
+	}{
+		{name: "none", transformations: nil, want: seriesOf(start, step, 100, 200, 400)},
+		{name: "rate", transformations: map[string][]Transformation{"SNMP_1": {{Kind: TransformRate}}}, want: seriesOf(start.Add(step), step, 10, 20)},
+		{name: "delta", transformations: map[string][]Transformation{"SNMP_1": {{Kind: TransformDelta}}}, want: seriesOf(start.Add(step), step, 100, 200)},
+		{name: "multiply", transformations: map[string][]Transformation{"SNMP_1": {{Kind: TransformMultiply, Factor: 0.5}}}, want: seriesOf(start, step, 50, 100, 200)},
+		{name: "divide", transformations: map[string][]Transformation{"SNMP_1": {{Kind: TransformDivide, Factor: 100}}}, want: seriesOf(start, step, 1, 2, 4)},
+		{name: "bits to bytes", transformations: map[string][]Transformation{"SNMP_1": {{Kind: TransformBitsToBytes}}}, want: seriesOf(start, step, 12.5, 25, 50)},
+		{name: "bytes to bits", transformations: map[string][]Transformation{"SNMP_1": {{Kind: TransformBytesToBits}}}, want: seriesOf(start, step, 800, 1600, 3200)},
+		{name: "cumulative sum", transformations: map[string][]Transformation{"SNMP_1": {{Kind: TransformCumulativeSum}}}, want: seriesOf(start, step, 100, 300, 700)},
+		{name: "percent of", transformations: map[string][]Transformation{"SNMP_1": {{Kind: TransformPercentOf, Reference: "SNMP_2"}}}, want: seriesOf(start, step, 10, 20)},
+		{name: "pipeline", transformations: map[string][]Transformation{"SNMP_1": {{Kind: TransformDelta}, {Kind: TransformBytesToBits}}}, want: seriesOf(start.Add(step), step, 800, 1600)},
+		{name: "all metrics", transformations: map[string][]Transformation{AllMetrics: {{Kind: TransformMultiply, Factor: 2}}}, want: seriesOf(start, step, 200, 400, 800)},
+		{name: "own transformations win", transformations: map[string][]Transformation{AllMetrics: {{Kind: TransformMultiply, Factor: 2}}, "SNMP_1": {{Kind: TransformDelta}}}, want: seriesOf(start.Add(step), step, 100, 200)},
+		{name: "other metric", transformations: map[string][]Transformation{"SNMP_2": {{Kind: TransformDelta}}}, want: seriesOf(start, step, 100, 200, 400)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyTransformations(data, tt.transformations)
+			require.NoError(t, err, "no error expected")
+			assert.Equal(t, tt.want, got["SNMP_1"], "transformed series wrong")
+			assert.Equal(t, seriesOf(start, step, 100, 200, 400), data["SNMP_1"], "original data must not be modified")
+		})
+	}
+}
+
+func TestApplyTransformations_CounterReset(t *testing.T) {
+	start := time.UnixMilli(1600000000000)
+	step := 10 * time.Second
+	data := map[string]stablenet.MetricDataSeries{"SNMP_1": seriesOf(start, step, 100, 200, 50, 150)}
+	want := stablenet.MetricDataSeries{seriesOf(start.Add(step), step, 10)[0], seriesOf(start.Add(3*step), step, 10)[0]}
+	got, err := applyTransformations(data, map[string][]Transformation{"SNMP_1": {{Kind: TransformRate, Counter: true}}})
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, want, got["SNMP_1"], "the point after the reset should be dropped")
+}
+
+func TestApplyTransformations_Gauge(t *testing.T) {
+	start := time.UnixMilli(1600000000000)
+	step := 10 * time.Second
+	data := map[string]stablenet.MetricDataSeries{"SNMP_1": seriesOf(start, step, 100, 200, 50, 150)}
+	got, err := applyTransformations(data, map[string][]Transformation{"SNMP_1": {{Kind: TransformDelta}}})
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, seriesOf(start.Add(step), step, 100, -150, 100), got["SNMP_1"], "a falling gauge should have a negative delta")
+}
+
+func TestApplyTransformations_Error(t *testing.T) {
+	data := map[string]stablenet.MetricDataSeries{"SNMP_1": seriesOf(time.Now(), time.Second, 1, 2)}
+	tests := []struct {
+		name           string
+		transformation Transformation
+		wantErr        string
+	}{
+		{name: "unknown", transformation: Transformation{Kind: "log"}, wantErr: "transformation 0 of metric SNMP_1 is invalid: unknown transformation \"log\""},
+		{name: "divide by zero", transformation: Transformation{Kind: TransformDivide}, wantErr: "transformation 0 of metric SNMP_1 is invalid: cannot divide by zero"},
+		{name: "multiply by zero", transformation: Transformation{Kind: TransformMultiply}, wantErr: "transformation 0 of metric SNMP_1 is invalid: cannot multiply by zero"},
+		{name: "percent without reference", transformation: Transformation{Kind: TransformPercentOf}, wantErr: "transformation 0 of metric SNMP_1 is invalid: percentOf requires a reference metric"},
+		{name: "counter of other kind", transformation: Transformation{Kind: TransformCumulativeSum, Counter: true}, wantErr: "transformation 0 of metric SNMP_1 is invalid: only rate and delta treat a metric as counter, not cumulativeSum"},
+		{name: "reference without data", transformation: Transformation{Kind: TransformPercentOf, Reference: "SNMP_9"}, wantErr: "transforming metric SNMP_1 failed: the reference metric SNMP_9 has no data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyTransformations(data, map[string][]Transformation{"SNMP_1": {tt.transformation}})
+			assert.Nil(t, got, "result should be nil in case of an error")
+			assert.EqualError(t, err, tt.wantErr, "error message wrong")
+		})
+	}
+}
+
+func TestReferencedMetrics(t *testing.T) {
+	transformations := map[string][]Transformation{
+		"SNMP_1":   {{Kind: TransformPercentOf, Reference: "SNMP_3"}, {Kind: TransformPercentOf, Reference: "SNMP_10"}},
+		"SNMP_2":   {{Kind: TransformRate}, {Kind: TransformPercentOf, Reference: "SNMP_3"}},
+		"SNMP_4":   {{Kind: TransformPercentOf, Reference: "SNMP_0"}},
+		AllMetrics: {{Kind: TransformMultiply, Factor: 3}},
+	}
+	assert.Equal(t, []string{"SNMP_0", "SNMP_10", "SNMP_3"}, referencedMetrics(transformations), "referenced metrics wrong")
+}
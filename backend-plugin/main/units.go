@@ -0,0 +1,125 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// The Grafana® units of the units StableNet® displays, keyed by the lower case unit. Counts ("#") have no unit.
+var grafanaUnits = map[string]string{
+	"#":         "",
+	"%":         "percent",
+	"ms":        "ms",
+	"s":         "s",
+	"sec":       "s",
+	"min":       "m",
+	"h":         "h",
+	"day":       "d",
+	"days":      "d",
+	"bit":       "decbits",
+	"bits":      "decbits",
+	"byte":      "decbytes",
+	"bytes":     "decbytes",
+	"bit/s":     "bps",
+	"bps":       "bps",
+	"kbit/s":    "Kbits",
+	"mbit/s":    "Mbits",
+	"gbit/s":    "Gbits",
+	"byte/s":    "Bps",
+	"packets/s": "pps",
+	"pps":       "pps",
+	"hz":        "hertz",
+	"°c":        "celsius",
+	"w":         "watt",
+	"v":         "volt",
+	"a":         "amp",
+	"dbm":       "dBm",
+	"rpm":       "rotrpm",
+}
+
+// Returns the Grafana® unit of a unit of StableNet®. Units Grafana® does not know are shown as suffix of the values.
+func grafanaUnit(unit string) string {
+	unit = strings.TrimSpace(unit)
+	if unit == "" {
+		return ""
+	}
+	if result, ok := grafanaUnits[strings.ToLower(unit)]; ok {
+		return result
+	}
+	return "suffix: " + unit
+}
+
+// Returns the unit all inputs have in common, which is empty if they differ.
+func commonUnit(inputs []aggregationInput) string {
+	if len(inputs) == 0 {
+		return ""
+	}
+	for _, input := range inputs[1:] {
+		if input.Unit != inputs[0].Unit {
+			return ""
+		}
+	}
+	return inputs[0].Unit
+}
+
+// Looks up the units of the metrics and the polling interval of the measurement of an expanded query. A query without
+// average keeps it, such that StableNet® chooses the average. The metrics are described on a best effort basis: if they
+// cannot be fetched, the query is run as it is and an actual problem with the measurement is reported by the data request.
+func describeMetrics(query *MetricQuery, metricSupplier func(int) ([]stablenet.Metric, error)) {
+	if query.MeasurementObid == 0 {
+		return
+	}
+	metrics, err := metricSupplier(query.MeasurementObid)
+	if err != nil {
+		return
+	}
+	query.Units = make(map[string]string, len(metrics))
+	for _, metric := range metrics {
+		if unit := grafanaUnit(metric.Unit); unit != "" {
+			query.Units[metric.Key] = unit
+		}
+	}
+	query.PollingInterval = stablenet.PollingInterval(metrics)
+}
+
+// Returns a warning if the query requests a shorter average than the polling interval of its measurement, since
+// StableNet® has at most one value per polling interval.
+func (m *MetricQuery) averageWarning() string {
+	average := time.Duration(m.Interval) * time.Millisecond
+	if average <= 0 || m.PollingInterval <= 0 || average >= m.PollingInterval {
+		return ""
+	}
+	return fmt.Sprintf("The average of %s is shorter than the polling interval of %s of measurement %d, StableNet® has at most one value per polling interval.", formatDuration(average), formatDuration(m.PollingInterval), m.MeasurementObid)
+}
+
+// Attaches a warning to the first frame of a response, Grafana® shows the notices of all frames of a panel.
+func addWarning(dataResponse backend.DataResponse, text string) {
+	if dataResponse.Error != nil || len(dataResponse.Frames) == 0 {
+		return
+	}
+	frame := dataResponse.Frames[0]
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.Notices = append(frame.Meta.Notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: text})
+}
+
+// Returns the Grafana® unit of a metric. Transformations and ratios change the unit, thus their series have none.
+func (m *MetricQuery) unitOf(key string) string {
+	if m.Comparison == CompareRatio || len(m.Transformations[key]) > 0 || len(m.Transformations[AllMetrics]) > 0 {
+		return ""
+	}
+	return m.Units[key]
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrafanaUnit(t *testing.T) {
+	tests := []struct {
+		unit string
+		want string
+	}{
+		{unit: "", want: ""},
+		{unit: "#", want: ""},
+		{unit: "%", want: "percent"},
+		{unit: "Bit/s", want: "bps"},
+		{unit: " ms ", want: "ms"},
+		{unit: "day", want: "d"},
+		{unit: "Erl", want: "suffix: Erl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			assert.Equal(t, tt.want, grafanaUnit(tt.unit), "unit wrong")
+		})
+	}
+}
+
+func TestDescribeMetrics(t *testing.T) {
+	metrics := []stablenet.Metric{
+		{Key: "SNMP_1", Name: "In", Unit: "bit/s", Interval: 300000},
+		{Key: "SNMP_2", Name: "Errors", Unit: "#", Interval: 300000},
+	}
+	supplier := func(measurementObid int) ([]stablenet.Metric, error) {
+		if measurementObid != 1001 {
+			return nil, errors.New("not found")
+		}
+		return metrics, nil
+	}
+	start := time.UnixMilli(1699999980000)
+
+	t.Run("described", func(t *testing.T) {
+		query := MetricQuery{MeasurementObid: 1001, Start: start, End: start.Add(time.Hour)}
+		describeMetrics(&query, supplier)
+		assert.Equal(t, map[string]string{"SNMP_1": "bps"}, query.Units, "units wrong")
+		assert.Equal(t, 5*time.Minute, query.PollingInterval, "polling interval wrong")
+		assert.Equal(t, int64(0), query.Interval, "the average should be left to StableNet®")
+		assert.Equal(t, "bps", query.unitOf("SNMP_1"), "unit of metric wrong")
+		assert.Empty(t, query.averageWarning(), "no warning expected")
+	})
+	t.Run("short average", func(t *testing.T) {
+		query := MetricQuery{MeasurementObid: 1001, Start: start, End: start.Add(time.Hour), Interval: 60000}
+		describeMetrics(&query, supplier)
+		assert.Equal(t, int64(60000), query.Interval, "the average of the query should be kept")
+		assert.Equal(t, "The average of 1m is shorter than the polling interval of 5m of measurement 1001, StableNet® has at most one value per polling interval.", query.averageWarning(), "warning wrong")
+	})
+	t.Run("unknown measurement", func(t *testing.T) {
+		query := MetricQuery{MeasurementObid: 4711, Start: start, End: start.Add(time.Hour)}
+		describeMetrics(&query, supplier)
+		assert.Empty(t, query.Units, "no units expected")
+		assert.Equal(t, int64(0), query.Interval, "the average should be left to StableNet®")
+	})
+	t.Run("changed units", func(t *testing.T) {
+		query := MetricQuery{Units: map[string]string{"SNMP_1": "bps"}, Comparison: CompareRatio}
+		assert.Empty(t, query.unitOf("SNMP_1"), "ratios have no unit")
+		query = MetricQuery{Units: map[string]string{"SNMP_1": "bps"}, Transformations: map[string][]Transformation{AllMetrics: {{Kind: TransformBitsToBytes}}}}
+		assert.Empty(t, query.unitOf("SNMP_1"), "transformed metrics have no unit")
+	})
+}
@@ -0,0 +1,261 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package mock
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
+
+// Call is a method call of the Client with its arguments, without the context.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Client is an in-memory stablenet.Client that answers from the entities of a SnServer, such that code depending on
+// stablenet.Client can be tested without an HTTP server. Like StableNet®, it rejects every call with an authentication
+// error if the credentials differ from the ones of the server. The data is generated like the one of the server.
+type Client struct {
+	Server   *SnServer
+	Username string
+	Password string
+
+	mutex    sync.Mutex
+	lastCall Call
+}
+
+var _ stablenet.Client = (*Client)(nil)
+
+// Creates a client for the server that authenticates with the given credentials.
+func NewClient(server *SnServer, username, password string) *Client {
+	return &Client{Server: server, Username: username, Password: password}
+}
+
+// Returns the last call of the client.
+func (c *Client) LastCall() Call {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lastCall
+}
+
+// Records the call and checks the credentials. The action describes the call in the error, like the one of the
+// StableNet® client does.
+func (c *Client) begin(ctx context.Context, action string, method string, args ...interface{}) error {
+	c.mutex.Lock()
+	c.lastCall = Call{Method: method, Args: args}
+	c.mutex.Unlock()
+	if err := ctx.Err(); err != nil {
+		return &stablenet.RequestError{Message: fmt.Sprintf("%s failed", action), Err: err}
+	}
+	if c.Username != c.Server.Username || c.Password != c.Server.Password {
+		return &stablenet.StatusError{Message: fmt.Sprintf("%s failed", action), StatusCode: http.StatusUnauthorized, Body: "Authentication Error"}
+	}
+	return nil
+}
+
+func notFound(action string) error {
+	return &stablenet.StatusError{Message: fmt.Sprintf("%s failed", action), StatusCode: http.StatusNotFound, Body: "404 page not found"}
+}
+
+// StableNet® filters names case-insensitively.
+func nameContains(name, filter string) bool {
+	return strings.Contains(strings.ToLower(name), strings.ToLower(filter))
+}
+
+func (c *Client) QueryStableNetInfo(ctx context.Context) (*stablenet.ServerInfo, error) {
+	if err := c.begin(ctx, "retrieving server info", "QueryStableNetInfo"); err != nil {
+		return nil, err
+	}
+	info := c.Server.Info
+	return &info, nil
+}
+
+func (c *Client) QueryDevices(ctx context.Context, nameFilter string) (*stablenet.DeviceQueryResult, error) {
+	if err := c.begin(ctx, fmt.Sprintf("retrieving devices matching query \"%s\"", nameFilter), "QueryDevices", nameFilter); err != nil {
+		return nil, err
+	}
+	devices := make([]stablenet.Device, 0, len(c.Server.Devices))
+	for _, device := range c.Server.Devices {
+		if nameContains(device.Name, nameFilter) {
+			devices = append(devices, device)
+		}
+	}
+	return &stablenet.DeviceQueryResult{Count: len(c.Server.Devices), FilterCount: len(devices), Data: devices}, nil
+}
+
+func (c *Client) SearchDevices(ctx context.Context, search stablenet.DeviceSearch) (*stablenet.DeviceQueryResult, error) {
+	filter := search.Filter()
+	action := fmt.Sprintf("retrieving devices matching filter \"%s\"", filter)
+	if err := c.begin(ctx, action, "SearchDevices", search); err != nil {
+		return nil, err
+	}
+	query, err := parseCollectionQuery(url.Values{"$filter": {filter}, "$orderBy": {"name"}})
+	if err != nil {
+		return nil, err
+	}
+	result, err := applyQuery(c.Server.Devices, query, deviceField)
+	if err != nil {
+		return nil, err
+	}
+	devices := stablenet.DeviceQueryResult(result)
+	return &devices, nil
+}
+
+func (c *Client) FetchDevice(ctx context.Context, id int) (*stablenet.Device, error) {
+	if err := c.begin(ctx, fmt.Sprintf("retrieving device %d", id), "FetchDevice", id); err != nil {
+		return nil, err
+	}
+	for _, device := range c.Server.Devices {
+		if device.Obid == id {
+			result := device
+			return &result, nil
+		}
+	}
+	return nil, notFound(fmt.Sprintf("retrieving device %d", id))
+}
+
+func (c *Client) measurementsOf(deviceObid int, nameFilter string) []stablenet.Measurement {
+	result := make([]stablenet.Measurement, 0)
+	for _, measurement := range c.Server.Measurements {
+		if measurement.DeviceObid == deviceObid && nameContains(measurement.Name, nameFilter) {
+			result = append(result, measurement)
+		}
+	}
+	return result
+}
+
+func (c *Client) FetchMeasurementsForDevice(ctx context.Context, deviceObid int, nameFilter string) (*stablenet.MeasurementQueryResult, error) {
+	action := fmt.Sprintf("retrieving measurements for device filter \"destDeviceId eq '%d'\"", deviceObid)
+	if err := c.begin(ctx, action, "FetchMeasurementsForDevice", deviceObid, nameFilter); err != nil {
+		return nil, err
+	}
+	measurements := c.measurementsOf(deviceObid, nameFilter)
+	return &stablenet.MeasurementQueryResult{Count: len(c.Server.Measurements), FilterCount: len(measurements), Data: measurements}, nil
+}
+
+func (c *Client) SearchMeasurements(ctx context.Context, search stablenet.MeasurementSearch) (*stablenet.MeasurementQueryResult, error) {
+	filter := search.Filter()
+	action := fmt.Sprintf("retrieving measurements matching filter \"%s\"", filter)
+	if err := c.begin(ctx, action, "SearchMeasurements", search); err != nil {
+		return nil, err
+	}
+	query, err := parseCollectionQuery(url.Values{"$filter": {filter}, "$orderBy": {"name"}})
+	if err != nil {
+		return nil, err
+	}
+	result, err := applyQuery(c.Server.Measurements, query, measurementField)
+	if err != nil {
+		return nil, err
+	}
+	measurements := stablenet.MeasurementQueryResult(result)
+	return &measurements, nil
+}
+
+func (c *Client) FetchAllMeasurementsForDevice(ctx context.Context, deviceObid int, nameFilter string, limit int) ([]stablenet.Measurement, error) {
+	action := fmt.Sprintf("retrieving measurements for device filter \"destDeviceId eq '%d'\"", deviceObid)
+	if err := c.begin(ctx, action, "FetchAllMeasurementsForDevice", deviceObid, nameFilter, limit); err != nil {
+		return nil, err
+	}
+	measurements := c.measurementsOf(deviceObid, nameFilter)
+	if len(measurements) > limit {
+		return nil, fmt.Errorf("device %d has more than %d measurements matching \"%s\"", deviceObid, limit, nameFilter)
+	}
+	return measurements, nil
+}
+
+func (c *Client) findMeasurement(id int) (stablenet.Measurement, bool) {
+	for _, measurement := range c.Server.Measurements {
+		if measurement.Obid == id {
+			return measurement, true
+		}
+	}
+	return stablenet.Measurement{}, false
+}
+
+func (c *Client) FetchMeasurement(ctx context.Context, id int) (*stablenet.Measurement, error) {
+	if err := c.begin(ctx, fmt.Sprintf("retrieving measurement %d", id), "FetchMeasurement", id); err != nil {
+		return nil, err
+	}
+	measurement, ok := c.findMeasurement(id)
+	if !ok {
+		return nil, notFound(fmt.Sprintf("retrieving measurement %d", id))
+	}
+	return &measurement, nil
+}
+
+func (c *Client) FetchMeasurementName(ctx context.Context, id int) (string, error) {
+	measurement, err := c.FetchMeasurement(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return measurement.Name, nil
+}
+
+func (c *Client) FetchMetricsForMeasurement(ctx context.Context, measurementObid int) ([]stablenet.Metric, error) {
+	action := fmt.Sprintf("retrieving metrics for measurement %d", measurementObid)
+	if err := c.begin(ctx, action, "FetchMetricsForMeasurement", measurementObid); err != nil {
+		return nil, err
+	}
+	if _, ok := c.findMeasurement(measurementObid); !ok {
+		return nil, notFound(action)
+	}
+	return c.Server.metricsOf(measurementObid), nil
+}
+
+// Returns the data of the requested metrics of a measurement. Like StableNet®, the server returns at most 100 entries
+// per metric and request, the client does not split the time range.
+func (c *Client) dataOf(measurementObid int, metrics []string, start, end time.Time, average int64) map[string]stablenet.MetricDataSeries {
+	data := generateData(c.Server.Metrics[measurementObid], metrics, start, end, time.Duration(average)*time.Millisecond, defaultTop)
+	result := make(map[string]stablenet.MetricDataSeries, len(data.Values))
+	for _, values := range data.Values {
+		series := make(stablenet.MetricDataSeries, 0, len(values.Data))
+		for _, entry := range values.Data {
+			series = append(series, stablenet.MetricData{
+				Time:     time.UnixMilli(entry.Timestamp),
+				Interval: time.Duration(entry.Interval) * time.Millisecond,
+				Min:      *entry.Min,
+				Avg:      *entry.Avg,
+				Max:      *entry.Max,
+			})
+		}
+		result[values.MetricKey] = series
+	}
+	return result
+}
+
+func (c *Client) FetchDataForMetrics(ctx context.Context, options stablenet.DataQueryOptions) (map[string]stablenet.MetricDataSeries, error) {
+	action := fmt.Sprintf("retrieving metric data for measurement %d", options.MeasurementObid)
+	if err := c.begin(ctx, action, "FetchDataForMetrics", options); err != nil {
+		return nil, err
+	}
+	if _, ok := c.findMeasurement(options.MeasurementObid); !ok {
+		return nil, notFound(action)
+	}
+	return c.dataOf(options.MeasurementObid, options.Metrics, options.Start, options.End, options.Average), nil
+}
+
+func (c *Client) FetchDataForMeasurements(ctx context.Context, options stablenet.MultiDataQueryOptions) (map[int]map[string]stablenet.MetricDataSeries, error) {
+	if err := c.begin(ctx, "retrieving metric data for measurements", "FetchDataForMeasurements", options); err != nil {
+		return nil, err
+	}
+	result := make(map[int]map[string]stablenet.MetricDataSeries, len(options.Metrics))
+	for obid, metrics := range options.Metrics {
+		if _, ok := c.findMeasurement(obid); ok {
+			result[obid] = c.dataOf(obid, metrics, options.Start, options.End, options.Average)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package mock
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Fault scripts a misbehaviour of the server for the requests whose path starts with Path and, if given, have the
+// Method. The first matching fault is applied:
+//   - Latency delays the response.
+//   - Status replaces the response by an error with this status code, e.g. 401 or 503.
+//   - Truncate cuts the body of the response after this many bytes.
+//
+// If Times is greater than 0, the fault is only applied to this many requests.
+type Fault struct {
+	Path     string   `json:"path"`
+	Method   string   `json:"method,omitempty"`
+	Latency  Duration `json:"latency,omitempty"`
+	Status   int      `json:"status,omitempty"`
+	Truncate int      `json:"truncate,omitempty"`
+	Times    int      `json:"times,omitempty"`
+}
+
+func (f *Fault) matches(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, f.Path) && (f.Method == "" || strings.EqualFold(f.Method, req.Method))
+}
+
+// Replaces the faults of the server. The counts of applied faults are reset.
+func (s *SnServer) SetFaults(faults []Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = append([]Fault{}, faults...)
+	s.faultHits = make([]int, len(faults))
+}
+
+// Returns the faults of the server.
+func (s *SnServer) Faults() []Fault {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Fault{}, s.faults...)
+}
+
+// Returns the first fault that matches the request and counts it as applied, nil if there is none.
+func (s *SnServer) nextFault(req *http.Request) *Fault {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range s.faults {
+		fault := &s.faults[i]
+		if !fault.matches(req) || (fault.Times > 0 && s.faultHits[i] >= fault.Times) {
+			continue
+		}
+		s.faultHits[i]++
+		result := *fault
+		return &result
+	}
+	return nil
+}
+
+// truncatingWriter discards everything after the first remaining bytes of the body.
+type truncatingWriter struct {
+	http.ResponseWriter
+	remaining int
+}
+
+func (w *truncatingWriter) Write(data []byte) (int, error) {
+	if w.remaining <= 0 {
+		return len(data), nil
+	}
+	written := data
+	if len(written) > w.remaining {
+		written = written[:w.remaining]
+	}
+	w.remaining -= len(written)
+	if _, err := w.ResponseWriter.Write(written); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Applies the scripted faults to the requests before they are handled by next.
+func (s *SnServer) faultMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fault := s.nextFault(req)
+		if fault == nil {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(time.Duration(fault.Latency)):
+			case <-req.Context().Done():
+				return
+			}
+		}
+		if fault.Truncate > 0 {
+			rw = &truncatingWriter{ResponseWriter: rw, remaining: fault.Truncate}
+		}
+		switch {
+		case fault.Status == http.StatusUnauthorized:
+			http.Error(rw, "Authentication Error", http.StatusUnauthorized)
+		case fault.Status != 0:
+			http.Error(rw, http.StatusText(fault.Status), fault.Status)
+		default:
+			next.ServeHTTP(rw, req)
+		}
+	})
+}
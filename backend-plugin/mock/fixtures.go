@@ -0,0 +1,161 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"gopkg.in/yaml.v3"
+)
+
+// Fixtures describe the content of a mock server. They are loaded from YAML or JSON files with the field names of the
+// JSON API of StableNet®, e.g.
+//
+//	username: infosim
+//	password: stablenet
+//	version: "11.2.0"
+//	modules: [rest-reporting]
+//	devices:
+//	  - {obid: 9000, name: Bach, ip: 10.1.2.3, vendor: 9}
+//	measurements:
+//	  - obid: 1001
+//	    name: Host
+//	    device: 9000
+//	    type: snmpTemplate
+//	    metrics:
+//	      - {key: SNMP_1, name: Uptime, generator: {type: sine, min: 0, max: 100, period: 1h}}
+//	faults:
+//	  - {path: /api/1/measurement-data, latency: 2s}
+type Fixtures struct {
+	Username     string               `json:"username"`
+	Password     string               `json:"password"`
+	Version      string               `json:"version"`
+	Modules      []string             `json:"modules"`
+	Devices      []stablenet.Device   `json:"devices"`
+	Measurements []MeasurementFixture `json:"measurements"`
+	Faults       []Fault              `json:"faults"`
+}
+
+// MeasurementFixture is a measurement with its metrics. The device is the obid of the device it belongs to, 0 if none.
+type MeasurementFixture struct {
+	Obid    int             `json:"obid"`
+	Name    string          `json:"name"`
+	Device  int             `json:"device"`
+	Type    string          `json:"type,omitempty"`
+	Metrics []MetricFixture `json:"metrics"`
+}
+
+// MetricFixture is a metric with the generator of its data.
+type MetricFixture struct {
+	stablenet.Metric
+	Generator Generator `json:"generator"`
+}
+
+// Duration is a time.Duration that is written as text like "1h30m" in fixtures.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Loads the fixtures from a YAML or JSON file. Since JSON is a subset of YAML, both are parsed alike.
+func LoadFixtures(file string) (*Fixtures, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFixtures(content)
+}
+
+// Parses and validates fixtures given as YAML or JSON. The YAML is converted to JSON first, such that the entities
+// have the same field names as in the responses of StableNet®. Unknown fields are rejected to catch typos.
+func ParseFixtures(content []byte) (*Fixtures, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("could not parse fixtures: %w", err)
+	}
+	converted, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse fixtures: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(converted))
+	decoder.DisallowUnknownFields()
+	var result Fixtures
+	if err := decoder.Decode(&result); err != nil {
+		return nil, fmt.Errorf("could not parse fixtures: %w", err)
+	}
+	if err := result.validate(); err != nil {
+		return nil, fmt.Errorf("invalid fixtures: %w", err)
+	}
+	return &result, nil
+}
+
+func (f *Fixtures) validate() error {
+	devices := make(map[int]bool, len(f.Devices))
+	for _, device := range f.Devices {
+		if devices[device.Obid] {
+			return fmt.Errorf("the device %d is defined twice", device.Obid)
+		}
+		devices[device.Obid] = true
+	}
+	measurements := make(map[int]bool, len(f.Measurements))
+	for _, measurement := range f.Measurements {
+		if measurements[measurement.Obid] {
+			return fmt.Errorf("the measurement %d is defined twice", measurement.Obid)
+		}
+		measurements[measurement.Obid] = true
+		if measurement.Device != 0 && !devices[measurement.Device] {
+			return fmt.Errorf("the device %d of measurement %d does not exist", measurement.Device, measurement.Obid)
+		}
+		for _, metric := range measurement.Metrics {
+			if err := metric.Generator.validate(); err != nil {
+				return fmt.Errorf("metric %s of measurement %d: %w", metric.Key, measurement.Obid, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Creates a server that serves the fixtures.
+func NewServer(fixtures *Fixtures) *SnServer {
+	var modules []stablenet.Module
+	for _, module := range fixtures.Modules {
+		modules = append(modules, stablenet.Module{Name: module})
+	}
+	server := &SnServer{
+		Username: fixtures.Username,
+		Password: fixtures.Password,
+		Info: stablenet.ServerInfo{
+			ServerVersion: stablenet.ServerVersion{Version: fixtures.Version},
+			License:       stablenet.License{Modules: stablenet.Modules{Modules: modules}},
+		},
+		Devices:      fixtures.Devices,
+		Measurements: make([]stablenet.Measurement, 0, len(fixtures.Measurements)),
+		Metrics:      make(map[int][]MetricFixture, len(fixtures.Measurements)),
+	}
+	for _, measurement := range fixtures.Measurements {
+		server.Measurements = append(server.Measurements, stablenet.Measurement{Obid: measurement.Obid, Name: measurement.Name, DeviceObid: measurement.Device, Type: measurement.Type})
+		server.Metrics[measurement.Obid] = measurement.Metrics
+	}
+	server.SetFaults(fixtures.Faults)
+	return server
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package mock
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
+
+type GeneratorType string
+
+const (
+	// The average is always the value.
+	GeneratorConstant GeneratorType = "constant"
+	// The average oscillates between min and max once per period.
+	GeneratorSine GeneratorType = "sine"
+	// The average rises from min to max during a period and drops back to min afterwards.
+	GeneratorSawtooth GeneratorType = "sawtooth"
+	// The average is a random value between min and max, which is the same for every request of the same timestamp.
+	GeneratorRandom GeneratorType = "random"
+)
+
+// The period of generators that have none.
+const defaultPeriod = time.Hour
+
+// The averages StableNet® chooses from if a request has no average, the first one that yields at most $top entries is used.
+var defaultAverages = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 24 * time.Hour}
+
+// Generator generates the data of a metric. The data only depends on the timestamp, so that every request of the same
+// time range returns the same data. The min and max of an entry differ from its average by the spread.
+type Generator struct {
+	Type   GeneratorType `json:"type,omitempty"`
+	Value  float64       `json:"value,omitempty"`
+	Min    float64       `json:"min,omitempty"`
+	Max    float64       `json:"max,omitempty"`
+	Spread float64       `json:"spread,omitempty"`
+	Period Duration      `json:"period,omitempty"`
+}
+
+func (g Generator) validate() error {
+	switch g.Type {
+	case "", GeneratorConstant:
+		return nil
+	case GeneratorSine, GeneratorSawtooth, GeneratorRandom:
+		if g.Max < g.Min {
+			return fmt.Errorf("the max %v of the generator is less than its min %v", g.Max, g.Min)
+		}
+		if g.Period < 0 {
+			return fmt.Errorf("the period of the generator is negative")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown generator type \"%s\"", g.Type)
+	}
+}
+
+// Returns the average of the entry at the timestamp. The key makes random generators of different metrics differ.
+func (g Generator) average(key string, timestamp time.Time) float64 {
+	period := time.Duration(g.Period)
+	if period <= 0 {
+		period = defaultPeriod
+	}
+	phase := float64(timestamp.UnixMilli()%period.Milliseconds()) / float64(period.Milliseconds())
+	switch g.Type {
+	case GeneratorSine:
+		return g.Min + (g.Max-g.Min)*(1+math.Sin(2*math.Pi*phase))/2
+	case GeneratorSawtooth:
+		return g.Min + (g.Max-g.Min)*phase
+	case GeneratorRandom:
+		hash := fnv.New64a()
+		_, _ = fmt.Fprintf(hash, "%s@%d", key, timestamp.UnixMilli())
+		return g.Min + (g.Max-g.Min)*float64(hash.Sum64()%1_000_000)/1_000_000
+	default:
+		return g.Value
+	}
+}
+
+// Generates the entries of the metric with the key between start (inclusive) and end (exclusive). The timestamps are
+// multiples of the average and there are at most limit entries.
+func (g Generator) entries(key string, start, end time.Time, average time.Duration, limit int) []stablenet.MeasurementDataEntryDTO {
+	result := make([]stablenet.MeasurementDataEntryDTO, 0)
+	if average <= 0 {
+		return result
+	}
+	timestamp := start.Truncate(average)
+	if timestamp.Before(start) {
+		timestamp = timestamp.Add(average)
+	}
+	for ; timestamp.Before(end) && len(result) < limit; timestamp = timestamp.Add(average) {
+		avg := g.average(key, timestamp)
+		result = append(result, stablenet.MeasurementDataEntryDTO{
+			Timestamp: timestamp.UnixMilli(),
+			Interval:  average.Milliseconds(),
+			Min:       floatPointer(avg - g.Spread),
+			Avg:       floatPointer(avg),
+			Max:       floatPointer(avg + g.Spread),
+		})
+	}
+	return result
+}
+
+// Returns the requested average or, if there is none, the smallest of the default averages that yields at most limit
+// entries for the time range.
+func chooseAverage(average time.Duration, start, end time.Time, limit int) time.Duration {
+	if average > 0 {
+		return average
+	}
+	for _, candidate := range defaultAverages {
+		if limit <= 0 || end.Sub(start)/candidate <= time.Duration(limit) {
+			return candidate
+		}
+	}
+	return defaultAverages[len(defaultAverages)-1]
+}
+
+func floatPointer(v float64) *float64 {
+	return &v
+}
+
+// Generates the data of the requested metrics of a measurement. Unknown metrics are left out like StableNet® does.
+func generateData(metrics []MetricFixture, keys []string, start, end time.Time, average time.Duration, limit int) stablenet.MeasurementMultiMetricResultDataDTO {
+	average = chooseAverage(average, start, end, limit)
+	result := stablenet.MeasurementMultiMetricResultDataDTO{Values: make([]stablenet.MeasurementMetricResultDataDTO, 0, len(keys))}
+	for _, key := range keys {
+		for _, metric := range metrics {
+			if metric.Key == key {
+				result.Values = append(result.Values, stablenet.MeasurementMetricResultDataDTO{
+					MetricKey: key,
+					Data:      metric.Generator.entries(key, start, end, average, limit),
+				})
+				break
+			}
+		}
+	}
+	return result
+}
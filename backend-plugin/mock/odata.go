@@ -0,0 +1,213 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package mock
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
+
+// The number of entities StableNet® returns if a request has no $top.
+const defaultTop = 100
+
+// condition is a single condition of a $filter like "name ct 'host'". StableNet® supports more operators, the mock
+// supports the ones the plugin uses: eq compares exactly and ct checks case-insensitively whether the field contains
+// the value.
+type condition struct {
+	field    string
+	operator string
+	value    string
+}
+
+// collectionQuery contains the OData parameters of a request for a collection.
+type collectionQuery struct {
+	filter     []condition
+	orderBy    string
+	descending bool
+	top        int
+	skip       int
+}
+
+// A condition at the start of the rest of a filter. The value is quoted, a quote within the value is written as two
+// quotes.
+var conditionRegex = regexp.MustCompile(`^(\w+) (eq|ct) '((?:[^']|'')*)'`)
+
+// Parses $filter, $orderBy, $top and $skip. The conditions of a filter must be joined by "and".
+func parseCollectionQuery(values url.Values) (collectionQuery, error) {
+	result := collectionQuery{top: defaultTop}
+	if filter := values.Get("$filter"); filter != "" {
+		conditions, err := parseFilter(filter)
+		if err != nil {
+			return result, err
+		}
+		result.filter = conditions
+	}
+	if orderBy := strings.Fields(values.Get("$orderBy")); len(orderBy) > 0 {
+		if len(orderBy) > 2 || (len(orderBy) == 2 && orderBy[1] != "asc" && orderBy[1] != "desc") {
+			return result, fmt.Errorf("unsupported $orderBy \"%s\"", values.Get("$orderBy"))
+		}
+		result.orderBy = orderBy[0]
+		result.descending = len(orderBy) == 2 && orderBy[1] == "desc"
+	}
+	var err error
+	if result.top, err = nonNegativeParameter(values, "$top", defaultTop); err != nil {
+		return result, err
+	}
+	if result.skip, err = nonNegativeParameter(values, "$skip", 0); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Parses the conditions of a filter one after the other, such that an "and" within a quoted value does not split it.
+func parseFilter(filter string) ([]condition, error) {
+	result := make([]condition, 0)
+	rest := strings.TrimSpace(filter)
+	for {
+		match := conditionRegex.FindStringSubmatch(rest)
+		if match == nil {
+			return nil, fmt.Errorf("unsupported $filter condition \"%s\"", rest)
+		}
+		result = append(result, condition{field: match[1], operator: match[2], value: strings.ReplaceAll(match[3], "''", "'")})
+		rest = strings.TrimSpace(rest[len(match[0]):])
+		if rest == "" {
+			return result, nil
+		}
+		next, ok := strings.CutPrefix(rest, "and ")
+		if !ok {
+			return nil, fmt.Errorf("the conditions of $filter must be joined by \"and\", found \"%s\"", rest)
+		}
+		rest = strings.TrimSpace(next)
+	}
+}
+
+func nonNegativeParameter(values url.Values, name string, fallback int) (int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid %s \"%s\"", name, value)
+	}
+	return number, nil
+}
+
+// Returns the values of a field of an entity and whether the entity has this field.
+type fieldGetter[T any] func(entity T, field string) ([]string, bool)
+
+func deviceField(device stablenet.Device, field string) ([]string, bool) {
+	switch field {
+	case "obid":
+		return []string{strconv.Itoa(device.Obid)}, true
+	case "name":
+		return []string{device.Name}, true
+	case "ip":
+		return []string{device.IPAddress}, true
+	case "model":
+		return []string{device.Model}, true
+	case "vendor":
+		return []string{strconv.Itoa(device.Vendor)}, true
+	}
+	return nil, false
+}
+
+func measurementField(measurement stablenet.Measurement, field string) ([]string, bool) {
+	switch field {
+	case "obid":
+		return []string{strconv.Itoa(measurement.Obid)}, true
+	case "name":
+		return []string{measurement.Name}, true
+	case "destDeviceId":
+		return []string{strconv.Itoa(measurement.DeviceObid)}, true
+	case "type":
+		return []string{measurement.Type}, true
+	}
+	return nil, false
+}
+
+// Returns whether one of the values matches the condition.
+func (c condition) matches(values []string) bool {
+	for _, value := range values {
+		if c.operator == "ct" && strings.Contains(strings.ToLower(value), strings.ToLower(c.value)) {
+			return true
+		}
+		if c.operator == "eq" && value == c.value {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the first value of a field for ordering, which is empty if the field has no value.
+func sortValue[T any](entity T, field fieldGetter[T], name string) string {
+	values, _ := field(entity, name)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Compares numbers numerically and everything else case-insensitively.
+func lessValue(a, b string) bool {
+	numberA, errA := strconv.Atoi(a)
+	numberB, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return numberA < numberB
+	}
+	return strings.ToLower(a) < strings.ToLower(b)
+}
+
+// Filters, orders and pages the entities like the JSON API of StableNet® does.
+func applyQuery[T any](entities []T, query collectionQuery, field fieldGetter[T]) (stablenet.CollectionDTO[T], error) {
+	var empty T
+	for _, c := range query.filter {
+		if _, ok := field(empty, c.field); !ok {
+			return stablenet.CollectionDTO[T]{}, fmt.Errorf("unknown $filter field \"%s\"", c.field)
+		}
+	}
+	if _, ok := field(empty, query.orderBy); query.orderBy != "" && !ok {
+		return stablenet.CollectionDTO[T]{}, fmt.Errorf("unknown $orderBy field \"%s\"", query.orderBy)
+	}
+
+	filtered := make([]T, 0, len(entities))
+	for _, entity := range entities {
+		matches := true
+		for _, c := range query.filter {
+			values, _ := field(entity, c.field)
+			matches = matches && c.matches(values)
+		}
+		if matches {
+			filtered = append(filtered, entity)
+		}
+	}
+	if query.orderBy != "" {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			a, b := sortValue(filtered[i], field, query.orderBy), sortValue(filtered[j], field, query.orderBy)
+			if query.descending {
+				return lessValue(b, a)
+			}
+			return lessValue(a, b)
+		})
+	}
+
+	start := min(query.skip, len(filtered))
+	end := min(start+query.top, len(filtered))
+	return stablenet.CollectionDTO[T]{
+		Count:       len(entities),
+		FilterCount: len(filtered),
+		Data:        filtered[start:end],
+		HasMore:     end < len(filtered),
+	}, nil
+}
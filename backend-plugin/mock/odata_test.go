@@ -0,0 +1,45 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package mock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		want    []condition
+		wantErr string
+	}{
+		{name: "single condition", filter: "name ct 'host'", want: []condition{{field: "name", operator: "ct", value: "host"}}},
+		{name: "joined conditions", filter: "destDeviceId eq '9000' and name ct 'proc'", want: []condition{{field: "destDeviceId", operator: "eq", value: "9000"}, {field: "name", operator: "ct", value: "proc"}}},
+		{name: "and within value", filter: "name ct 'Rock and Roll' and model ct 'x'", want: []condition{{field: "name", operator: "ct", value: "Rock and Roll"}, {field: "model", operator: "ct", value: "x"}}},
+		{name: "escaped quote", filter: "name ct 'O''Reilly and Sons'", want: []condition{{field: "name", operator: "ct", value: "O'Reilly and Sons"}}},
+		{name: "value of quotes", filter: "name ct ''''", want: []condition{{field: "name", operator: "ct", value: "'"}}},
+		{name: "unsupported operator", filter: "obid gt '1'", wantErr: "unsupported $filter condition \"obid gt '1'\""},
+		{name: "unterminated quote", filter: "name ct 'Rock and Roll", wantErr: "unsupported $filter condition \"name ct 'Rock and Roll\""},
+		{name: "dangling and", filter: "name ct 'a' and", wantErr: "the conditions of $filter must be joined by \"and\", found \"and\""},
+		{name: "or", filter: "name ct 'a' or name ct 'b'", wantErr: "the conditions of $filter must be joined by \"and\", found \"or name ct 'b'\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFilter(tt.filter)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr, "error wrong")
+				return
+			}
+			require.NoError(t, err, "no error expected")
+			assert.Equal(t, tt.want, got, "conditions wrong")
+		})
+	}
+}
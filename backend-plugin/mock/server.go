@@ -8,138 +8,248 @@
 package mock
 
 import (
-	"backend-plugin/stablenet"
 	"encoding/json"
 	"encoding/xml"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
 	"time"
-)
-
-type MeasurementData struct {
-	Min *float64 `json:"min"`
-	Avg *float64 `json:"avg"`
-	Max *float64 `json:"max"`
-}
 
-type TimestampResponse struct {
-	Interval  int               `json:"interval"`
-	TimeStamp int64             `json:"timestamp"`
-	Row       []MeasurementData `json:"row"`
-}
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+)
 
+// SnServer is a fake StableNet® that serves the entities of its fields and generates the data of the metrics. The
+// metrics map the obids of the measurements to their metrics. The exported fields must not be changed while the server
+// handles requests, the scripted faults can be changed at any time with SetFaults.
 type SnServer struct {
 	Username     string
 	Password     string
 	Devices      []stablenet.Device
 	Measurements []stablenet.Measurement
-	Metrics      []stablenet.Metric
-	Data         stablenet.MeasurementMultiMetricResultDataDTO
+	Metrics      map[int][]MetricFixture
 	Info         stablenet.ServerInfo
-	LastQueries  url.Values
+
+	mutex     sync.Mutex
+	lastQuery url.Values
+	faults    []Fault
+	faultHits []int
 }
 
 var DefaultDevices = []stablenet.Device{
-	{Obid: 9000, Name: "Bach"},
-	{Obid: 9001, Name: "Fluss"},
-	{Obid: 9002, Name: "Meer"},
+	{Obid: 9000, Name: "Bach", IPAddress: "10.1.2.3", Model: "Catalyst 9200", Vendor: 9},
+	{Obid: 9001, Name: "Fluss", IPAddress: "10.1.2.4", Model: "MX204", Vendor: 2636},
+	{Obid: 9002, Name: "Meer", IPAddress: "192.168.7.1", Vendor: 311},
 }
 
 var DefaultMeasurements = []stablenet.Measurement{
-	{Obid: 1001, Name: "Host"},
-	{Obid: 1002, Name: "Processor"},
-	{Obid: 1003, Name: "Interface 1"},
+	{Obid: 1001, Name: "Host", DeviceObid: 9000, Type: "snmpTemplate"},
+	{Obid: 1002, Name: "Processor", DeviceObid: 9000, Type: "snmpTemplate"},
+	{Obid: 1003, Name: "Interface 1", DeviceObid: 9001, Type: "snmpInterface"},
 }
 
+// The metrics of the measurement 1001 of the default fixtures, the other measurements have none.
 var DefaultMetrics = []stablenet.Metric{
-	{Name: "Uptime", Key: "SNMP_1"},
-	{Name: "CPU 1", Key: "EXTERN_2"},
+	{Name: "Uptime", Key: "SNMP_1", Description: "System uptime of the device", Unit: "s", Interval: 60000},
+	{Name: "CPU 1", Key: "EXTERN_2", Description: "Load of the first processor", Unit: "%", Interval: 60000},
 }
 
-func floatPointer(v float64) *float64 {
-	return &v
+// Returns the fixtures used by CreateMockServer. The uptime is constantly 7.5 with a min of 5 and a max of 10.
+func DefaultFixtures(username, password string) *Fixtures {
+	fixtures := &Fixtures{Username: username, Password: password, Devices: DefaultDevices}
+	for _, measurement := range DefaultMeasurements {
+		fixtures.Measurements = append(fixtures.Measurements, MeasurementFixture{Obid: measurement.Obid, Name: measurement.Name, Device: measurement.DeviceObid, Type: measurement.Type})
+	}
+	fixtures.Measurements[0].Metrics = []MetricFixture{
+		{Metric: DefaultMetrics[0], Generator: Generator{Type: GeneratorConstant, Value: 7.5, Spread: 2.5}},
+		{Metric: DefaultMetrics[1], Generator: Generator{Type: GeneratorSine, Min: 0, Max: 100, Period: Duration(time.Hour)}},
+	}
+	return fixtures
 }
 
-var defaultEntry = stablenet.MeasurementDataEntryDTO{
-	Timestamp: time.Now().Local().UnixMilli(),
-	Interval:  1000,
-	Min:       floatPointer(5.0),
-	Avg:       floatPointer(7.5),
-	Max:       floatPointer(10.0),
+func CreateMockServer(username, password string) *SnServer {
+	return NewServer(DefaultFixtures(username, password))
 }
 
-var DefaultData = stablenet.MeasurementMultiMetricResultDataDTO{
-	Values: []stablenet.MeasurementMetricResultDataDTO{
-		{
-			MetricKey: "SNMP_1",
-			Data: []stablenet.MeasurementDataEntryDTO{
-				defaultEntry,
-			},
-		},
-	},
+// Returns the query parameters of the last request to the API.
+func (s *SnServer) LastQuery() url.Values {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastQuery
 }
 
-func CreateMockServer(username, password string) *SnServer {
-	return &SnServer{
-		Username:     username,
-		Password:     password,
-		Devices:      DefaultDevices,
-		Measurements: DefaultMeasurements,
-		Metrics:      DefaultMetrics,
-		Data:         DefaultData,
+func (s *SnServer) findMeasurement(obid int) (stablenet.Measurement, bool) {
+	for _, measurement := range s.Measurements {
+		if measurement.Obid == obid {
+			return measurement, true
+		}
 	}
+	return stablenet.Measurement{}, false
 }
 
-func (s *SnServer) getDevices(rw http.ResponseWriter, req *http.Request) {
-	s.LastQueries = req.URL.Query()
-	result := stablenet.DeviceQueryResult{Data: s.Devices, HasMore: false}
-	payload, _ := json.Marshal(result)
+// Returns the metrics of a measurement, which are empty if it has none.
+func (s *SnServer) metricsOf(obid int) []stablenet.Metric {
+	result := make([]stablenet.Metric, 0, len(s.Metrics[obid]))
+	for _, metric := range s.Metrics[obid] {
+		result = append(result, metric.Metric)
+	}
+	return result
+}
+
+func writeJSON(rw http.ResponseWriter, value interface{}) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
 	_, _ = rw.Write(payload)
 }
 
+// Returns the obid of the path or writes 404 if it is unknown.
+func (s *SnServer) measurementFromPath(rw http.ResponseWriter, req *http.Request) (int, bool) {
+	obid, err := strconv.Atoi(req.PathValue("obid"))
+	if err != nil {
+		http.NotFound(rw, req)
+		return 0, false
+	}
+	if _, ok := s.findMeasurement(obid); !ok {
+		http.NotFound(rw, req)
+		return 0, false
+	}
+	return obid, true
+}
+
+func (s *SnServer) getDevices(rw http.ResponseWriter, req *http.Request) {
+	query, err := parseCollectionQuery(req.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := applyQuery(s.Devices, query, deviceField)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(rw, stablenet.DeviceQueryResult(result))
+}
+
 func (s *SnServer) getMeasurements(rw http.ResponseWriter, req *http.Request) {
-	s.LastQueries = req.URL.Query()
-	result := stablenet.MeasurementQueryResult{Data: s.Measurements, HasMore: false}
-	payload, _ := json.Marshal(result)
-	_, _ = rw.Write(payload)
+	query, err := parseCollectionQuery(req.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := applyQuery(s.Measurements, query, measurementField)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(rw, stablenet.MeasurementQueryResult(result))
 }
 
 func (s *SnServer) getMetrics(rw http.ResponseWriter, req *http.Request) {
-	s.LastQueries = req.URL.Query()
-	payload, _ := json.Marshal(s.Metrics)
-	_, _ = rw.Write(payload)
+	obid, ok := s.measurementFromPath(rw, req)
+	if !ok {
+		return
+	}
+	writeJSON(rw, s.metricsOf(obid))
 }
 
-func (s *SnServer) getData(rw http.ResponseWriter, req *http.Request) {
-	s.LastQueries = req.URL.Query()
-	payload, _ := json.Marshal(s.Data)
-	_, _ = rw.Write(payload)
+func (s *SnServer) postData(rw http.ResponseWriter, req *http.Request) {
+	obid, ok := s.measurementFromPath(rw, req)
+	if !ok {
+		return
+	}
+	top, err := nonNegativeParameter(req.URL.Query(), "$top", defaultTop)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var query stablenet.DataQuery
+	if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+		http.Error(rw, "invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	start, end, average := time.UnixMilli(query.Start), time.UnixMilli(query.End), time.Duration(query.Average)*time.Millisecond
+	writeJSON(rw, generateData(s.Metrics[obid], query.Metrics, start, end, average, top))
+}
+
+func (s *SnServer) postMultiData(rw http.ResponseWriter, req *http.Request) {
+	top, err := nonNegativeParameter(req.URL.Query(), "$top", defaultTop)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var query stablenet.MultiMeasurementDataQuery
+	if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+		http.Error(rw, "invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	start, end, average := time.UnixMilli(query.Start), time.UnixMilli(query.End), time.Duration(query.Average)*time.Millisecond
+	result := make([]stablenet.MeasurementResultDataDTO, 0, len(query.Measurements))
+	for _, measurement := range query.Measurements {
+		if _, ok := s.findMeasurement(measurement.MeasurementObid); !ok {
+			continue
+		}
+		data := generateData(s.Metrics[measurement.MeasurementObid], measurement.Metrics, start, end, average, top)
+		result = append(result, stablenet.MeasurementResultDataDTO{MeasurementObid: measurement.MeasurementObid, Values: data.Values})
+	}
+	writeJSON(rw, result)
 }
 
-func (s *SnServer) getInfo(rw http.ResponseWriter, req *http.Request) {
-	s.LastQueries = req.URL.Query()
+func (s *SnServer) getInfo(rw http.ResponseWriter, _ *http.Request) {
 	payload, _ := xml.Marshal(s.Info)
 	_, _ = rw.Write(payload)
 }
 
+func (s *SnServer) getFaults(rw http.ResponseWriter, _ *http.Request) {
+	writeJSON(rw, s.Faults())
+}
+
+func (s *SnServer) putFaults(rw http.ResponseWriter, req *http.Request) {
+	var faults []Fault
+	if err := json.NewDecoder(req.Body).Decode(&faults); err != nil {
+		http.Error(rw, "invalid faults: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.SetFaults(faults)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (s *SnServer) deleteFaults(rw http.ResponseWriter, _ *http.Request) {
+	s.SetFaults(nil)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Creates the handler of the server. Besides the API of StableNet®, it offers the endpoint /mock/faults to read (GET),
+// replace (PUT) and remove (DELETE) the scripted faults while the server is running. This endpoint needs no
+// authentication and is not affected by the faults.
 func CreateHandler(server *SnServer) http.Handler {
-	authMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
-		return func(rw http.ResponseWriter, req *http.Request) {
+	api := func(next http.HandlerFunc) http.Handler {
+		return server.faultMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			server.mutex.Lock()
+			server.lastQuery = req.URL.Query()
+			server.mutex.Unlock()
 			user, pass, ok := req.BasicAuth()
 			if ok && user == server.Username && pass == server.Password {
 				next.ServeHTTP(rw, req)
 				return
 			}
 			http.Error(rw, "Authentication Error", http.StatusUnauthorized)
-		}
+		}))
 	}
 
 	r := http.NewServeMux()
-	r.HandleFunc("/api/1/devices", authMiddleware(server.getDevices))
-	r.HandleFunc("/api/1/measurements", authMiddleware(server.getMeasurements))
-	r.HandleFunc("/api/1/measurement-data/1001/metrics", authMiddleware(server.getMetrics))
-	r.HandleFunc("/api/1/measurement-data/1001", authMiddleware(server.getData))
-	r.HandleFunc("/rest/info", authMiddleware(server.getInfo))
+	r.Handle("GET /api/1/devices", api(server.getDevices))
+	r.Handle("GET /api/1/measurements", api(server.getMeasurements))
+	r.Handle("GET /api/1/measurement-data/{obid}/metrics", api(server.getMetrics))
+	r.Handle("POST /api/1/measurement-data/{obid}", api(server.postData))
+	r.Handle("POST /api/1/measurement-data", api(server.postMultiData))
+	r.Handle("GET /rest/info", api(server.getInfo))
+	r.HandleFunc("GET /mock/faults", server.getFaults)
+	r.HandleFunc("PUT /mock/faults", server.putFaults)
+	r.HandleFunc("DELETE /mock/faults", server.deleteFaults)
 
 	return r
 }
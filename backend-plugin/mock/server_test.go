@@ -0,0 +1,244 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Infosim/stablenet_grafana_datasource/backend-plugin/stablenet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, server *SnServer) (*httptest.Server, *stablenet.StableNetClient) {
+	httpServer := httptest.NewServer(CreateHandler(server))
+	t.Cleanup(httpServer.Close)
+	return httpServer, stablenet.NewClient(httpServer.URL, stablenet.WithCredentials(server.Username, server.Password))
+}
+
+func TestLoadFixtures_Example(t *testing.T) {
+	fixtures, err := LoadFixtures("fixtures/example.yaml")
+	require.NoError(t, err, "the example fixtures should be valid")
+	assert.Equal(t, "infosim", fixtures.Username, "username wrong")
+	assert.Equal(t, []string{"rest-reporting"}, fixtures.Modules, "modules wrong")
+	require.NotEmpty(t, fixtures.Measurements, "measurements expected")
+	assert.Equal(t, MetricFixture{
+		Metric:    stablenet.Metric{Key: "SNMP_2", Name: "CPU Load", Unit: "%", Interval: 300000},
+		Generator: Generator{Type: GeneratorSine, Min: 10, Max: 80, Spread: 5, Period: Duration(time.Hour)},
+	}, fixtures.Measurements[0].Metrics[1], "metric with generator wrong")
+	assert.Equal(t, []Fault{{Path: "/api/1/measurement-data", Method: "POST", Latency: Duration(time.Second)}}, fixtures.Faults, "faults wrong")
+}
+
+func TestParseFixtures(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "json", content: `{"devices": [{"obid": 1, "name": "a"}], "measurements": [{"obid": 2, "name": "b", "device": 1, "metrics": [{"key": "K", "name": "N", "generator": {"type": "random", "max": 1}}]}]}`},
+		{name: "duplicate device", content: "devices: [{obid: 1}, {obid: 1}]", wantErr: "invalid fixtures: the device 1 is defined twice"},
+		{name: "unknown device", content: "measurements: [{obid: 2, device: 1}]", wantErr: "invalid fixtures: the device 1 of measurement 2 does not exist"},
+		{name: "unknown generator", content: "measurements: [{obid: 2, metrics: [{key: K, generator: {type: square}}]}]", wantErr: "invalid fixtures: metric K of measurement 2: unknown generator type \"square\""},
+		{name: "invalid range", content: "measurements: [{obid: 2, metrics: [{key: K, generator: {type: sine, min: 2, max: 1}}]}]", wantErr: "invalid fixtures: metric K of measurement 2: the max 1 of the generator is less than its min 2"},
+		{name: "invalid duration", content: "faults: [{path: /, latency: soon}]", wantErr: "could not parse fixtures"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFixtures([]byte(tt.content))
+			if tt.wantErr == "" {
+				assert.NoError(t, err, "no error expected")
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr, "error wrong")
+			}
+		})
+	}
+}
+
+func TestSnServer_Collections(t *testing.T) {
+	server := CreateMockServer("infosim", "stablenet")
+	httpServer, _ := startServer(t, server)
+
+	tests := []struct {
+		name       string
+		path       string
+		query      url.Values
+		wantStatus int
+		wantObids  []int
+		wantCount  int
+		wantMore   bool
+	}{
+		{name: "all devices", path: "/api/1/devices", wantObids: []int{9000, 9001, 9002}, wantCount: 3},
+		{name: "name filter ignores case", path: "/api/1/devices", query: url.Values{"$filter": {"name ct 'FLU'"}}, wantObids: []int{9001}, wantCount: 1},
+		{name: "order descending", path: "/api/1/devices", query: url.Values{"$orderBy": {"name desc"}}, wantObids: []int{9002, 9001, 9000}, wantCount: 3},
+		{name: "paging", path: "/api/1/devices", query: url.Values{"$orderBy": {"name"}, "$top": {"1"}, "$skip": {"1"}}, wantObids: []int{9001}, wantCount: 3, wantMore: true},
+		{name: "combined filter", path: "/api/1/measurements", query: url.Values{"$filter": {"destDeviceId eq '9000' and name ct 'proc'"}}, wantObids: []int{1002}, wantCount: 1},
+		{name: "device attributes", path: "/api/1/devices", query: url.Values{"$filter": {"ip ct '10.1.' and model ct 'catalyst' and vendor eq '9'"}}, wantObids: []int{9000}, wantCount: 1},
+		{name: "escaped quote", path: "/api/1/devices", query: url.Values{"$filter": {"name ct 'O''Reilly'"}}, wantObids: []int{}, wantCount: 0},
+		{name: "and within value", path: "/api/1/devices", query: url.Values{"$filter": {"name ct 'Bach and Fluss'"}}, wantObids: []int{}, wantCount: 0},
+		{name: "measurement type", path: "/api/1/measurements", query: url.Values{"$filter": {"type ct 'interface'"}}, wantObids: []int{1003}, wantCount: 1},
+		{name: "unknown field", path: "/api/1/measurements", query: url.Values{"$filter": {"color eq 'red'"}}, wantStatus: http.StatusBadRequest},
+		{name: "unsupported operator", path: "/api/1/devices", query: url.Values{"$filter": {"obid gt '1'"}}, wantStatus: http.StatusBadRequest},
+		{name: "invalid top", path: "/api/1/devices", query: url.Values{"$top": {"-1"}}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, httpServer.URL+tt.path+"?"+tt.query.Encode(), nil)
+			req.SetBasicAuth("infosim", "stablenet")
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "request failed")
+			defer resp.Body.Close()
+			if tt.wantStatus != 0 {
+				assert.Equal(t, tt.wantStatus, resp.StatusCode, "status wrong")
+				return
+			}
+			require.Equal(t, http.StatusOK, resp.StatusCode, "status wrong")
+			var got stablenet.CollectionDTO[struct{ Obid int }]
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&got), "response should be JSON")
+			obids := make([]int, 0, len(got.Data))
+			for _, entity := range got.Data {
+				obids = append(obids, entity.Obid)
+			}
+			assert.Equal(t, tt.wantObids, obids, "entities wrong")
+			assert.Equal(t, tt.wantCount, got.FilterCount, "filter count wrong")
+			assert.Equal(t, tt.wantMore, got.HasMore, "has more wrong")
+			assert.Equal(t, tt.query.Encode(), server.LastQuery().Encode(), "last query wrong")
+		})
+	}
+}
+
+func TestSnServer_WithClient(t *testing.T) {
+	server := CreateMockServer("infosim", "stablenet")
+	_, client := startServer(t, server)
+	ctx := context.Background()
+
+	measurements, err := client.FetchAllMeasurementsForDevice(ctx, 9000, "", 1000)
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, DefaultMeasurements[:2], measurements, "measurements wrong")
+
+	search := stablenet.DeviceSearch{IPAddress: "10.1.2", Vendor: 2636}
+	devices, err := client.SearchDevices(ctx, search)
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, DefaultDevices[1:2], devices.Data, "the attributes of the devices should be served")
+	inMemory, err := NewClient(server, "infosim", "stablenet").SearchDevices(ctx, search)
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, devices, inMemory, "the in-memory client should find the same devices")
+
+	metrics, err := client.FetchMetricsForMeasurement(ctx, 1002)
+	require.NoError(t, err, "no error expected")
+	assert.Empty(t, metrics, "the measurement 1002 has no metrics")
+
+	_, err = client.FetchMetricsForMeasurement(ctx, 4711)
+	assert.True(t, errors.Is(err, stablenet.ErrNotFound), "unknown measurements should not be found: %v", err)
+
+	start := time.UnixMilli(1699999980000)
+	data, err := client.FetchDataForMetrics(ctx, stablenet.DataQueryOptions{MeasurementObid: 1001, Metrics: []string{"SNMP_1", "SNMP_4711"}, Start: start, End: start.Add(5 * time.Hour), Average: 60000})
+	require.NoError(t, err, "no error expected")
+	require.Equal(t, []string{"SNMP_1"}, keys(data), "only known metrics should have data")
+	require.Equal(t, 300, len(data["SNMP_1"]), "one entry per minute expected, fetched in several chunks")
+	assert.Equal(t, stablenet.MetricData{Time: start, Interval: time.Minute, Min: 5, Avg: 7.5, Max: 10}, data["SNMP_1"][0], "first entry wrong")
+
+	multi, err := client.FetchDataForMeasurements(ctx, stablenet.MultiDataQueryOptions{Metrics: map[int][]string{1001: {"EXTERN_2"}, 4711: {"SNMP_1"}}, Start: start, End: start.Add(time.Hour)})
+	require.NoError(t, err, "no error expected")
+	require.Equal(t, 1, len(multi), "unknown measurements should be left out")
+	assert.Equal(t, 60, len(multi[1001]["EXTERN_2"]), "the server should choose an average of a minute")
+}
+
+func keys(data map[string]stablenet.MetricDataSeries) []string {
+	result := make([]string, 0, len(data))
+	for key := range data {
+		result = append(result, key)
+	}
+	return result
+}
+
+func TestSnServer_Faults(t *testing.T) {
+	server := CreateMockServer("infosim", "stablenet")
+	httpServer, client := startServer(t, server)
+	ctx := context.Background()
+
+	server.SetFaults([]Fault{
+		{Path: "/api/1/devices", Status: http.StatusServiceUnavailable, Times: 1},
+		{Path: "/api/1/measurements", Status: http.StatusUnauthorized},
+		{Path: "/api/1/measurement-data/1001/metrics", Truncate: 10},
+	})
+
+	_, err := client.QueryDevices(ctx, "")
+	assert.ErrorContains(t, err, "status code: 503", "the first request should fail")
+	_, err = client.QueryDevices(ctx, "")
+	assert.NoError(t, err, "the fault should only be applied once")
+	_, err = client.FetchMeasurement(ctx, 1001)
+	assert.True(t, errors.Is(err, stablenet.ErrUnauthorized), "the credentials should be rejected: %v", err)
+	_, err = client.FetchMetricsForMeasurement(ctx, 1001)
+	var parseError *stablenet.ParseError
+	assert.ErrorAs(t, err, &parseError, "the truncated body should not be parsable")
+
+	req, _ := http.NewRequest(http.MethodPut, httpServer.URL+"/mock/faults", strings.NewReader(`[{"path": "/rest/info", "latency": "50ms"}]`))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "request failed")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode, "status of replacing the faults wrong")
+	assert.Equal(t, []Fault{{Path: "/rest/info", Latency: Duration(50 * time.Millisecond)}}, server.Faults(), "faults should be replaced")
+
+	started := time.Now()
+	_, err = client.QueryStableNetInfo(ctx)
+	assert.NoError(t, err, "a delayed request should succeed")
+	assert.GreaterOrEqual(t, time.Since(started), 50*time.Millisecond, "the request should be delayed")
+
+	resp, err = http.Get(httpServer.URL + "/mock/faults")
+	require.NoError(t, err, "request failed")
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.JSONEq(t, `[{"path": "/rest/info", "latency": "50ms"}]`, string(body), "listed faults wrong")
+}
+
+func TestGenerator_Entries(t *testing.T) {
+	start := time.UnixMilli(1699999980000)
+	tests := []struct {
+		name      string
+		generator Generator
+		start     time.Time
+		average   time.Duration
+		limit     int
+		wantAvgs  []float64
+		wantFirst time.Time
+	}{
+		{name: "constant", generator: Generator{Value: 3}, start: start, average: time.Minute, limit: 100, wantAvgs: []float64{3, 3, 3}, wantFirst: start},
+		{name: "aligned to the average", generator: Generator{Value: 3}, start: start.Add(time.Second), average: time.Minute, limit: 100, wantAvgs: []float64{3, 3}, wantFirst: start.Add(time.Minute)},
+		{name: "limited", generator: Generator{Value: 3}, start: start, average: time.Minute, limit: 2, wantAvgs: []float64{3, 3}, wantFirst: start},
+		{name: "sawtooth", generator: Generator{Type: GeneratorSawtooth, Min: 0, Max: 3, Period: Duration(3 * time.Minute)}, start: start, average: time.Minute, limit: 100, wantAvgs: []float64{1, 2, 0}, wantFirst: start},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.generator.entries("SNMP_1", tt.start, start.Add(3*time.Minute), tt.average, tt.limit)
+			avgs := make([]float64, 0, len(got))
+			for _, entry := range got {
+				avgs = append(avgs, *entry.Avg)
+			}
+			assert.InDeltaSlice(t, tt.wantAvgs, avgs, 1e-9, "averages wrong")
+			require.NotEmpty(t, got, "entries expected")
+			assert.Equal(t, tt.wantFirst.UnixMilli(), got[0].Timestamp, "first timestamp wrong")
+		})
+	}
+
+	random := Generator{Type: GeneratorRandom, Min: 10, Max: 20}
+	first := random.entries("SNMP_1", start, start.Add(time.Hour), time.Minute, 100)
+	assert.Equal(t, first, random.entries("SNMP_1", start, start.Add(time.Hour), time.Minute, 100), "random data should be the same for the same range")
+	assert.NotEqual(t, first, random.entries("SNMP_2", start, start.Add(time.Hour), time.Minute, 100), "random data should differ per metric")
+	for _, entry := range first {
+		assert.True(t, *entry.Avg >= 10 && *entry.Avg < 20, "random value %v out of range", *entry.Avg)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Client is the API of a StableNet® server. All methods send their requests with the given context, which is used for
+// cancellation and as parent of the tracing spans. The errors can be classified with errors.Is and the sentinel errors
+// of this package, UserMessage returns a text for the end user.
+type Client interface {
+	// Queries the version and the licensed modules of the server.
+	QueryStableNetInfo(ctx context.Context) (*ServerInfo, error)
+	// Queries the devices whose name contains the filter. An empty filter matches all devices.
+	QueryDevices(ctx context.Context, nameFilter string) (*DeviceQueryResult, error)
+	// Queries the first page of the devices that match the search.
+	SearchDevices(ctx context.Context, search DeviceSearch) (*DeviceQueryResult, error)
+	// Fetches a single device. An error wrapping ErrNotFound is returned if it does not exist.
+	FetchDevice(ctx context.Context, id int) (*Device, error)
+	// Fetches the first page of the measurements of a device whose name contains the filter.
+	FetchMeasurementsForDevice(ctx context.Context, deviceObid int, nameFilter string) (*MeasurementQueryResult, error)
+	// Fetches the first page of the measurements that match the search.
+	SearchMeasurements(ctx context.Context, search MeasurementSearch) (*MeasurementQueryResult, error)
+	// Fetches all measurements of a device whose name contains the filter, but fails if there are more than limit.
+	FetchAllMeasurementsForDevice(ctx context.Context, deviceObid int, nameFilter string, limit int) ([]Measurement, error)
+	// Fetches a single measurement. An error wrapping ErrNotFound is returned if it does not exist.
+	FetchMeasurement(ctx context.Context, id int) (*Measurement, error)
+	// Fetches the name of a single measurement.
+	FetchMeasurementName(ctx context.Context, id int) (string, error)
+	// Fetches the metrics of a measurement.
+	FetchMetricsForMeasurement(ctx context.Context, measurementObid int) ([]Metric, error)
+	// Fetches the data of some metrics of one measurement, the result maps the metric keys to their series.
+	FetchDataForMetrics(ctx context.Context, options DataQueryOptions) (map[string]MetricDataSeries, error)
+	// Fetches the data of several measurements at once, the result maps the measurement obids to the data of their
+	// metrics. Only available if ServerInfo.SupportsMultiMeasurementData returns true.
+	FetchDataForMeasurements(ctx context.Context, options MultiDataQueryOptions) (map[int]map[string]MetricDataSeries, error)
+}
+
+var _ Client = (*StableNetClient)(nil)
+
+// ConnectOptions are the settings of a StableNet® datasource.
+type ConnectOptions struct {
+	Address  string
+	Username string
+	Password string
+	// If true, every request to StableNet® is logged with its (redacted and truncated) response.
+	VerboseLogging bool
+}
+
+// StableNetClient is the Client that talks to the REST API of a StableNet® server. It is safe for concurrent use.
+type StableNetClient struct {
+	Address string
+	client  *resty.Client
+	logger  Logger
+	verbose bool
+	tracer  trace.Tracer
+	metrics *requestMetrics
+}
+
+// Logger logs the requests of a client. The arguments are pairs of keys and values, such that the logger of the
+// Grafana® plugin SDK can be used.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(string, ...interface{}) {}
+func (noopLogger) Info(string, ...interface{})  {}
+func (noopLogger) Warn(string, ...interface{})  {}
+func (noopLogger) Error(string, ...interface{}) {}
+
+// Option configures a StableNetClient created by NewClient.
+type Option func(*clientConfig)
+
+type clientConfig struct {
+	username   string
+	password   string
+	logger     Logger
+	verbose    bool
+	timeout    time.Duration
+	httpClient *http.Client
+	recorder   *Recorder
+	tracer     trace.Tracer
+	registerer prometheus.Registerer
+}
+
+// Authenticates the requests with the given user.
+func WithCredentials(username, password string) Option {
+	return func(config *clientConfig) {
+		config.username = username
+		config.password = password
+	}
+}
+
+// Logs with the given logger, e.g. a logger that carries the datasource id. By default, nothing is logged.
+func WithLogger(logger Logger) Option {
+	return func(config *clientConfig) {
+		config.logger = logger
+	}
+}
+
+// Logs every request with its (redacted and truncated) response.
+func WithVerboseLogging(verbose bool) Option {
+	return func(config *clientConfig) {
+		config.verbose = verbose
+	}
+}
+
+// Aborts every request that takes longer than the timeout. By default, only the context limits the requests.
+func WithTimeout(timeout time.Duration) Option {
+	return func(config *clientConfig) {
+		config.timeout = timeout
+	}
+}
+
+// Sends the requests with the given HTTP client instead of one that accepts any certificate of the server, since
+// StableNet® servers usually have self-signed certificates.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(config *clientConfig) {
+		config.httpClient = httpClient
+	}
+}
+
+// Records a span for every request with the given tracer. By default, no spans are recorded.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(config *clientConfig) {
+		config.tracer = tracer
+	}
+}
+
+// Registers the metrics of the requests at the given registerer. Clients with the same registerer share their
+// metrics, such that a client can be created per request. By default, the metrics are not registered anywhere.
+func WithRegisterer(registerer prometheus.Registerer) Option {
+	return func(config *clientConfig) {
+		config.registerer = registerer
+	}
+}
+
+// Creates a client for the StableNet® server at the address, e.g. "https://127.0.0.1:5443".
+func NewClient(address string, options ...Option) *StableNetClient {
+	config := clientConfig{logger: noopLogger{}, tracer: noop.NewTracerProvider().Tracer("")}
+	for _, option := range options {
+		option(&config)
+	}
+
+	var client *resty.Client
+	if config.httpClient != nil {
+		client = resty.NewWithClient(config.httpClient)
+	} else {
+		client = resty.New().SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
+	}
+	if config.username != "" || config.password != "" {
+		client.SetBasicAuth(config.username, config.password)
+	}
+	if config.timeout > 0 {
+		client.SetTimeout(config.timeout)
+	}
+	if config.recorder != nil {
+		config.recorder.Next = client.GetClient().Transport
+		client.SetTransport(config.recorder)
+	}
+
+	return &StableNetClient{
+		Address: address,
+		client:  client,
+		logger:  config.logger,
+		verbose: config.verbose,
+		tracer:  config.tracer,
+		metrics: newRequestMetrics(config.registerer),
+	}
+}
+
+// Creates a client from the settings of a datasource.
+func NewStableNetClient(options *ConnectOptions, extra ...Option) *StableNetClient {
+	all := append([]Option{WithCredentials(options.Username, options.Password), WithVerboseLogging(options.VerboseLogging)}, extra...)
+	return NewClient(options.Address, all...)
+}
@@ -0,0 +1,39 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClient_Options(t *testing.T) {
+	httpClient := &http.Client{}
+	httpmock.ActivateNonDefault(httpClient)
+	defer httpmock.DeactivateAndReset()
+
+	var user, password string
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:5443/rest/info", func(req *http.Request) (*http.Response, error) {
+		user, password, _ = req.BasicAuth()
+		return httpmock.NewStringResponse(http.StatusOK, "<info><serverversion version=\"9.0.0\" /></info>"), nil
+	})
+
+	client := NewClient("https://127.0.0.1:5443", WithCredentials("infosim", "stablenet"), WithHTTPClient(httpClient), WithTimeout(time.Minute))
+	info, err := client.QueryStableNetInfo(context.Background())
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, "9.0.0", info.ServerVersion.Version, "version wrong")
+	assert.Equal(t, "infosim", user, "the credentials should be sent")
+	assert.Equal(t, "stablenet", password, "the credentials should be sent")
+	assert.Equal(t, time.Minute, client.client.GetClient().Timeout, "timeout wrong")
+}
@@ -0,0 +1,21 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+
+// Package stablenet is a client for the REST API of StableNet®. It is used by the Grafana® datasource, but does not
+// depend on it and can be used by other tools as well:
+//
+//	client := stablenet.NewClient("https://127.0.0.1:5443", stablenet.WithCredentials("infosim", "stablenet"))
+//	devices, err := client.QueryDevices(ctx, "router")
+//	if errors.Is(err, stablenet.ErrUnauthorized) {
+//		...
+//	}
+//
+// Code that only reads from StableNet® should depend on the Client interface, such that it can be tested with an
+// in-memory implementation. The requests are logged, traced and counted only if the client is created with the options
+// WithLogger, WithTracer and WithRegisterer.
+package stablenet
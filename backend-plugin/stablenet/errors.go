@@ -0,0 +1,174 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Sentinel errors of the client. Use errors.Is to check whether an error returned by the client is of one of these kinds.
+var (
+	ErrUnauthorized       = errors.New("the credentials were rejected by StableNet®")
+	ErrNotFound           = errors.New("the requested entity does not exist in StableNet®")
+	ErrLicense            = errors.New("the StableNet® server does not have the required license \"rest-reporting\"")
+	ErrUnsupportedVersion = errors.New("the StableNet® version does not support Grafana®")
+	ErrTimeout            = errors.New("the request to StableNet® timed out")
+	ErrInvalidResponse    = errors.New("the answer of StableNet® could not be parsed")
+)
+
+// StatusError is returned if StableNet® answers with an unexpected status code. The body is already redacted and truncated.
+type StatusError struct {
+	Message    string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: status code: %d, response: %s", e.Message, e.StatusCode, e.Body)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// RequestError is returned if the request could not be sent to StableNet® or no answer was received.
+type RequestError struct {
+	Message string
+	Err     error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RequestError) Is(target error) bool {
+	if target != ErrTimeout {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(e.Err, context.DeadlineExceeded) || (errors.As(e.Err, &netErr) && netErr.Timeout())
+}
+
+// ParseError is returned if the answer of StableNet® could not be unmarshalled.
+type ParseError struct {
+	Message string
+	// The redacted and truncated answer, may be empty.
+	Body string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ParseError) Is(target error) bool {
+	return target == ErrInvalidResponse
+}
+
+// VersionError is returned if the StableNet® server is too old for the plugin.
+type VersionError struct {
+	Version string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("the StableNet® version %s does not support Grafana®", e.Version)
+}
+
+func (e *VersionError) Is(target error) bool {
+	return target == ErrUnsupportedVersion
+}
+
+// LinkError is returned for a statistic link that cannot be parsed or does not carry a measurement.
+type LinkError struct {
+	Link string
+	// The reason why the link could not be parsed, nil if it does not carry a measurement.
+	Err error
+}
+
+func (e *LinkError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("the link \"%s\" does not carry at least a measurement id", e.Link)
+	}
+	return fmt.Sprintf("the link \"%s\" is invalid: %v", e.Link, e.Err)
+}
+
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+
+func (e notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// Returns the message that is presented to the end user for an error of the client, e.g. in the health check or in panels.
+// All user facing texts of the client errors are located here.
+func UserMessage(err error) string {
+	var versionErr *VersionError
+	var requestErr *RequestError
+	var statusErr *StatusError
+	var parseErr *ParseError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &versionErr):
+		return fmt.Sprintf("The StableNet® version %s does not support Grafana®.", versionErr.Version)
+	case errors.Is(err, ErrLicense):
+		return "The StableNet® server does not have the required license \"rest-reporting\"."
+	case errors.Is(err, ErrUnauthorized):
+		return "The StableNet® server could be reached, but the credentials were invalid."
+	case errors.Is(err, ErrTimeout):
+		return "The StableNet® server did not answer in time."
+	case errors.As(err, &requestErr):
+		return fmt.Sprintf("Connecting to StableNet® failed: %v", RedactCredentials(requestErr.Err.Error()))
+	case errors.Is(err, ErrNotFound):
+		return fmt.Sprintf("StableNet® could not find the requested entity: %v", err)
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("StableNet® answered with the unexpected status code %d: %s", statusErr.StatusCode, statusErr.Body)
+	case errors.As(err, &parseErr) && len(parseErr.Body) != 0:
+		return fmt.Sprintf("The StableNet® answer \"%s\" could not be parsed: %v", parseErr.Body, parseErr.Err)
+	case errors.As(err, &parseErr):
+		return fmt.Sprintf("The StableNet® answer could not be parsed: %v", parseErr.Err)
+	}
+	return err.Error()
+}
+
+func buildStatusError(msg string, statusCode int, body []byte) error {
+	return &StatusError{Message: msg, StatusCode: statusCode, Body: SanitizeBody(body)}
+}
+
+func buildRequestError(msg string, err error) error {
+	return &RequestError{Message: msg, Err: err}
+}
+
+func buildParseError(err error) error {
+	return &ParseError{Message: "could not unmarshal json", Err: err}
+}
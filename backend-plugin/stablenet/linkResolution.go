@@ -0,0 +1,122 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// LinkResolution describes which measurements, devices and metrics a statistic link refers to. It allows to preview a
+// link and to convert it into measurement queries.
+type LinkResolution struct {
+	Measurements []ResolvedMeasurement   `json:"measurements"`
+	Unresolved   []UnresolvedMeasurement `json:"unresolved"`
+}
+
+// ResolvedMeasurement is a measurement of a link with its device and the metrics requested by the link. The device
+// is nil if the measurement has none or it is not visible.
+type ResolvedMeasurement struct {
+	Obid    int      `json:"obid"`
+	Name    string   `json:"name"`
+	Device  *Device  `json:"device,omitempty"`
+	Metrics []Metric `json:"metrics"`
+}
+
+// UnresolvedMeasurement is a measurement of a link that does not exist (anymore) or has none of the requested metrics.
+type UnresolvedMeasurement struct {
+	Obid  int    `json:"obid"`
+	Error string `json:"error"`
+}
+
+// ExpandedMeasurement is a measurement of a statistic link with the metrics the link requests of it. If the metrics of
+// the measurement could not be fetched, Err is set instead.
+type ExpandedMeasurement struct {
+	LinkMeasurement
+	Metrics []Metric
+	Err     error
+}
+
+// Expands a statistic link into its measurements and the metrics the link requests of them. This decides for the
+// datasource, the link resource and the CLI alike which measurements and metrics a link refers to. A link that cannot
+// be parsed or carries no measurement results in a *LinkError.
+func ExpandStatisticLink(link string, metricSupplier func(int) ([]Metric, error)) ([]ExpandedMeasurement, error) {
+	parsed, err := ParseStatisticLink(link)
+	if err != nil {
+		return nil, &LinkError{Link: link, Err: err}
+	}
+	requested := parsed.MergedMeasurements()
+	if len(requested) == 0 {
+		return nil, &LinkError{Link: link}
+	}
+	result := make([]ExpandedMeasurement, 0, len(requested))
+	for _, measurement := range requested {
+		metrics, err := metricSupplier(measurement.Obid)
+		if err != nil {
+			result = append(result, ExpandedMeasurement{LinkMeasurement: measurement, Err: err})
+			continue
+		}
+		result = append(result, ExpandedMeasurement{LinkMeasurement: measurement, Metrics: SelectLinkMetrics(measurement.ValueKeys, metrics)})
+	}
+	return result, nil
+}
+
+// Resolves the measurements of a statistic link. Measurements that do not exist are reported as unresolved, any other
+// error of the StableNet® server aborts the resolution.
+func ResolveStatisticLink(ctx context.Context, client Client, link string) (*LinkResolution, error) {
+	expanded, err := ExpandStatisticLink(link, func(measurementObid int) ([]Metric, error) {
+		return client.FetchMetricsForMeasurement(ctx, measurementObid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LinkResolution{Measurements: []ResolvedMeasurement{}, Unresolved: []UnresolvedMeasurement{}}
+	for _, measurement := range expanded {
+		if errors.Is(measurement.Err, ErrNotFound) {
+			result.Unresolved = append(result.Unresolved, UnresolvedMeasurement{Obid: measurement.Obid, Error: measurement.Err.Error()})
+			continue
+		}
+		if measurement.Err != nil {
+			return nil, fmt.Errorf("could not fetch metrics for measurement %d: %w", measurement.Obid, measurement.Err)
+		}
+		if len(measurement.Metrics) == 0 {
+			result.Unresolved = append(result.Unresolved, UnresolvedMeasurement{Obid: measurement.Obid, Error: fmt.Sprintf("measurement %d has none of the metrics requested by the link", measurement.Obid)})
+			continue
+		}
+		resolved, err := resolveMeasurement(ctx, client, measurement.Obid, measurement.Metrics)
+		if errors.Is(err, ErrNotFound) {
+			result.Unresolved = append(result.Unresolved, UnresolvedMeasurement{Obid: measurement.Obid, Error: err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Measurements = append(result.Measurements, *resolved)
+	}
+	return result, nil
+}
+
+func resolveMeasurement(ctx context.Context, client Client, obid int, metrics []Metric) (*ResolvedMeasurement, error) {
+	measurement, err := client.FetchMeasurement(ctx, obid)
+	if err != nil {
+		return nil, err
+	}
+	result := &ResolvedMeasurement{Obid: measurement.Obid, Name: measurement.Name, Metrics: metrics}
+	if measurement.DeviceObid == 0 {
+		return result, nil
+	}
+	// A measurement without a visible device can still be queried, thus a missing device is not an error.
+	device, err := client.FetchDevice(ctx, measurement.DeviceObid)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	result.Device = device
+	return result, nil
+}
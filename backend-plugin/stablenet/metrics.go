@@ -0,0 +1,57 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// requestMetrics are the collectors of the requests sent to StableNet®, labeled by endpoint and status code.
+type requestMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// Creates the collectors and registers them at the registerer. If the collectors are already registered, e.g. by
+// another client, the registered ones are used. Without a registerer, the collectors are not registered anywhere.
+func newRequestMetrics(registerer prometheus.Registerer) *requestMetrics {
+	metrics := &requestMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "stablenet_datasource",
+			Name:      "stablenet_requests_total",
+			Help:      "Number of HTTP requests sent to StableNet® by endpoint and status code.",
+		}, []string{"endpoint", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "stablenet_datasource",
+			Name:      "stablenet_request_duration_seconds",
+			Help:      "Duration of HTTP requests sent to StableNet® by endpoint and status code.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"endpoint", "status"}),
+	}
+	if registerer == nil {
+		return metrics
+	}
+	metrics.requests = register(registerer, metrics.requests)
+	metrics.duration = register(registerer, metrics.duration)
+	return metrics
+}
+
+// Registers the collector and returns it, or returns the equal collector that is already registered. If the
+// registration fails otherwise, the collector is used without being registered.
+func register[T prometheus.Collector](registerer prometheus.Registerer, collector T) T {
+	err := registerer.Register(collector)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+	return collector
+}
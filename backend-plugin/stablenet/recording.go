@@ -0,0 +1,273 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Recording contains requests to StableNet® with their responses. Recordings of real servers are replayed in tests,
+// so that the tests notice when the plugin cannot cope with the actual responses of StableNet®.
+type Recording struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a request with its response. Neither the address of the server nor the credentials are recorded.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request of an Interaction. The path contains the query.
+type RecordedRequest struct {
+	Method string       `json:"method"`
+	Path   string       `json:"path"`
+	Body   RecordedBody `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode  int          `json:"statusCode"`
+	ContentType string       `json:"contentType,omitempty"`
+	Body        RecordedBody `json:"body,omitempty"`
+}
+
+// RecordedBody is a body that is stored as JSON if it is valid JSON and as string otherwise, so that the JSON bodies
+// of recordings can be read and edited.
+type RecordedBody []byte
+
+func (b RecordedBody) MarshalJSON() ([]byte, error) {
+	if len(b) == 0 {
+		return []byte(`""`), nil
+	}
+	if json.Valid(b) {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, b); err != nil {
+			return nil, err
+		}
+		return compact.Bytes(), nil
+	}
+	return json.Marshal(string(b))
+}
+
+func (b *RecordedBody) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*b = RecordedBody(text)
+		return nil
+	}
+	*b = append(RecordedBody{}, data...)
+	return nil
+}
+
+// Loads a recording written by Recorder.Save.
+func LoadRecording(file string) (*Recording, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var result Recording
+	if err := json.Unmarshal(content, &result); err != nil {
+		return nil, fmt.Errorf("could not parse recording %s: %w", file, err)
+	}
+	return &result, nil
+}
+
+// Recorder is an http.RoundTripper that records the requests sent via the next round tripper. The bodies are
+// sanitized by RedactCredentials and, if given, by Sanitize, e.g. to replace the names of customers.
+type Recorder struct {
+	Next     http.RoundTripper
+	Sanitize func(text string) string
+
+	mutex        sync.Mutex
+	interactions []Interaction
+}
+
+// Records every request of the client. Recorder.Next is set to the transport the client would use otherwise.
+func WithRecorder(recorder *Recorder) Option {
+	return func(config *clientConfig) {
+		config.recorder = recorder
+	}
+}
+
+func (r *Recorder) sanitize(text []byte) RecordedBody {
+	result := RedactCredentials(string(text))
+	if r.Sanitize != nil {
+		result = r.Sanitize(result)
+	}
+	return RecordedBody(result)
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		if requestBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+	next := r.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	interaction := Interaction{
+		Request:  RecordedRequest{Method: req.Method, Path: string(r.sanitize([]byte(req.URL.RequestURI()))), Body: r.sanitize(requestBody)},
+		Response: RecordedResponse{StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Body: r.sanitize(responseBody)},
+	}
+	r.mutex.Lock()
+	r.interactions = append(r.interactions, interaction)
+	r.mutex.Unlock()
+	return resp, nil
+}
+
+// Returns the recorded interactions, sorted by their requests such that recordings of concurrent requests are stable.
+func (r *Recorder) Recording() *Recording {
+	r.mutex.Lock()
+	interactions := append([]Interaction{}, r.interactions...)
+	r.mutex.Unlock()
+	sort.SliceStable(interactions, func(i, j int) bool {
+		a, b := interactions[i].Request, interactions[j].Request
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return string(a.Body) < string(b.Body)
+	})
+	return &Recording{Interactions: interactions}
+}
+
+// Writes the recording to the file, which can be loaded by LoadRecording.
+func (r *Recorder) Save(file string) error {
+	content, err := json.MarshalIndent(r.Recording(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(content, '\n'), 0644)
+}
+
+// Replayer is an http.RoundTripper that answers requests from a recording instead of sending them. A request is
+// answered by the first unused interaction with the same method, path and body; the order of the query parameters and
+// the formatting of JSON bodies are ignored. If all matching interactions were used, the last one is repeated.
+type Replayer struct {
+	recording *Recording
+
+	mutex sync.Mutex
+	used  []bool
+}
+
+func NewReplayer(recording *Recording) *Replayer {
+	return &Replayer{recording: recording, used: make([]bool, len(recording.Interactions))}
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	match := -1
+	for i, interaction := range r.recording.Interactions {
+		if interaction.Request.Method != req.Method || !samePath(interaction.Request.Path, req.URL.RequestURI()) || !sameBody(interaction.Request.Body, body) {
+			continue
+		}
+		match = i
+		if !r.used[i] {
+			break
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("no recorded interaction for %s %s %s", req.Method, req.URL.RequestURI(), string(body))
+	}
+	r.used[match] = true
+
+	recorded := r.recording.Interactions[match].Response
+	header := make(http.Header)
+	if recorded.ContentType != "" {
+		header.Set("Content-Type", recorded.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Returns the requests of the recording that were not replayed, e.g. to notice outdated recordings.
+func (r *Replayer) Unused() []RecordedRequest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	result := make([]RecordedRequest, 0)
+	for i, used := range r.used {
+		if !used {
+			result = append(result, r.recording.Interactions[i].Request)
+		}
+	}
+	return result
+}
+
+func samePath(recorded, actual string) bool {
+	recordedPath, recordedQuery, _ := strings.Cut(recorded, "?")
+	actualPath, actualQuery, _ := strings.Cut(actual, "?")
+	return recordedPath == actualPath && sameQuery(recordedQuery, actualQuery)
+}
+
+func sameQuery(recorded, actual string) bool {
+	a, errA := url.ParseQuery(recorded)
+	b, errB := url.ParseQuery(actual)
+	if errA != nil || errB != nil {
+		return recorded == actual
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func sameBody(recorded RecordedBody, actual []byte) bool {
+	if len(recorded) == 0 || len(actual) == 0 {
+		return len(recorded) == len(actual)
+	}
+	var a, b interface{}
+	if json.Unmarshal(recorded, &a) != nil || json.Unmarshal(actual, &b) != nil {
+		return bytes.Equal(recorded, actual)
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder_RecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/1/devices":
+			rw.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(rw, `{"data": [{"obid": 9000, "name": "ACME Router", "password": "secret"}], "hasMore": false, "extra": null}`)
+		case "/api/1/measurement-data/1001":
+			body, _ := io.ReadAll(req.Body)
+			_, _ = io.WriteString(rw, `{"values": [{"metricKey": "SNMP_1", "data": [{"timestamp": 60000, "interval": 60000, "avg": 1}]}], "query": `+string(body)+`}`)
+		case "/rest/info":
+			_, _ = io.WriteString(rw, `<info><serverversion version="11.2.0"/></info>`)
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer server.Close()
+
+	recorder := &Recorder{Sanitize: func(text string) string { return strings.ReplaceAll(text, "ACME", "Customer") }}
+	client := NewClient(server.URL, WithCredentials("infosim", "stablenet"), WithRecorder(recorder))
+	ctx := context.Background()
+	devices, err := client.QueryDevices(ctx, "")
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, "ACME Router", devices.Data[0].Name, "the client should get the original response")
+	options := DataQueryOptions{MeasurementObid: 1001, Metrics: []string{"SNMP_1"}, Start: time.UnixMilli(0), End: time.UnixMilli(120000)}
+	data, err := client.FetchDataForMetrics(ctx, options)
+	require.NoError(t, err, "no error expected")
+	_, err = client.QueryStableNetInfo(ctx)
+	require.NoError(t, err, "no error expected")
+	_, err = client.FetchMeasurement(ctx, 4711)
+	require.Error(t, err, "unknown paths should fail")
+
+	file := filepath.Join(t.TempDir(), "recording.json")
+	require.NoError(t, recorder.Save(file), "saving failed")
+	recording, err := LoadRecording(file)
+	require.NoError(t, err, "loading failed")
+	require.Equal(t, 4, len(recording.Interactions), "number of interactions")
+	for _, interaction := range recording.Interactions {
+		assert.NotContains(t, string(interaction.Response.Body), "secret", "credentials should be redacted")
+		assert.NotContains(t, string(interaction.Response.Body), "ACME", "the bodies should be sanitized")
+		assert.NotContains(t, interaction.Request.Path, "127.0.0.1", "the address should not be recorded")
+	}
+
+	replayer := NewReplayer(recording)
+	replayed := NewClient("https://stablenet.invalid:5443", WithHTTPClient(&http.Client{Transport: replayer}))
+	devices, err = replayed.QueryDevices(ctx, "")
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, "Customer Router", devices.Data[0].Name, "the sanitized response should be replayed")
+	replayedData, err := replayed.FetchDataForMetrics(ctx, options)
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, data, replayedData, "replayed data differs")
+	info, err := replayed.QueryStableNetInfo(ctx)
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, "11.2.0", info.ServerVersion.Version, "text bodies should be replayed")
+	_, err = replayed.FetchMeasurement(ctx, 4711)
+	assert.ErrorIs(t, err, ErrNotFound, "recorded errors should be replayed")
+	assert.Empty(t, replayer.Unused(), "all interactions should be replayed")
+
+	_, err = replayed.FetchMetricsForMeasurement(ctx, 1001)
+	assert.ErrorContains(t, err, "no recorded interaction for GET /api/1/measurement-data/1001/metrics", "requests that were not recorded should fail")
+}
+
+func TestReplayer_Matching(t *testing.T) {
+	recording := &Recording{Interactions: []Interaction{
+		{Request: RecordedRequest{Method: "GET", Path: "/api/1/devices?$top=100&$filter=name+ct+%27a%27"}, Response: RecordedResponse{StatusCode: 200, Body: RecordedBody("first")}},
+		{Request: RecordedRequest{Method: "GET", Path: "/api/1/devices?$top=100&$filter=name+ct+%27a%27"}, Response: RecordedResponse{StatusCode: 503, Body: RecordedBody("second")}},
+		{Request: RecordedRequest{Method: "POST", Path: "/api/1/measurement-data/1", Body: RecordedBody(`{"start": 1, "metrics": ["a"]}`)}, Response: RecordedResponse{StatusCode: 200, Body: RecordedBody("data")}},
+	}}
+	replayer := NewReplayer(recording)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantBody   string
+		wantStatus int
+		wantErr    bool
+	}{
+		{name: "query order ignored", method: "GET", path: "/api/1/devices?$filter=name%20ct%20%27a%27&$top=100", wantBody: "first", wantStatus: 200},
+		{name: "next unused interaction", method: "GET", path: "/api/1/devices?$top=100&$filter=name+ct+%27a%27", wantBody: "second", wantStatus: 503},
+		{name: "last interaction repeated", method: "GET", path: "/api/1/devices?$top=100&$filter=name+ct+%27a%27", wantBody: "second", wantStatus: 503},
+		{name: "json formatting ignored", method: "POST", path: "/api/1/measurement-data/1", body: `{"metrics":["a"],"start":1}`, wantBody: "data", wantStatus: 200},
+		{name: "different body", method: "POST", path: "/api/1/measurement-data/1", body: `{"metrics":["b"],"start":1}`, wantErr: true},
+		{name: "different method", method: "DELETE", path: "/api/1/measurement-data/1", wantErr: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "https://stablenet.invalid:5443"+tt.path, strings.NewReader(tt.body))
+		resp, err := replayer.RoundTrip(req)
+		if tt.wantErr {
+			assert.Error(t, err, "%s: error expected", tt.name)
+			continue
+		}
+		require.NoError(t, err, "%s: no error expected", tt.name)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, tt.wantBody, string(body), "%s: body wrong", tt.name)
+		assert.Equal(t, tt.wantStatus, resp.StatusCode, "%s: status wrong", tt.name)
+	}
+}
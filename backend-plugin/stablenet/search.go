@@ -0,0 +1,75 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeviceSearch selects devices by their attributes. A device matches if each non-empty field is contained in the
+// respective attribute, ignoring the case. The vendor is an IANA enterprise number that has to match exactly (see
+// ParseVendor). The empty search matches all devices.
+type DeviceSearch struct {
+	Name      string
+	IPAddress string
+	Vendor    int
+}
+
+// Returns the $filter of the JSON API of StableNet® for the search, which is empty if the search matches all devices.
+func (s DeviceSearch) Filter() string {
+	return joinFilters(
+		containsFilter("name", s.Name),
+		containsFilter("ip", s.IPAddress),
+		equalsFilter("vendor", s.Vendor),
+	)
+}
+
+// MeasurementSearch selects measurements like DeviceSearch selects devices. If the DeviceObid is 0, the measurements
+// of all devices are searched.
+type MeasurementSearch struct {
+	DeviceObid int
+	Name       string
+	Type       string
+}
+
+// Returns the $filter of the JSON API of StableNet® for the search.
+func (s MeasurementSearch) Filter() string {
+	return joinFilters(equalsFilter("destDeviceId", s.DeviceObid), containsFilter("name", s.Name), containsFilter("type", s.Type))
+}
+
+// Returns a condition like "name ct 'router'", which is empty if the value is empty.
+func containsFilter(field, value string) string {
+	if value == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s ct %s", field, quoteFilterValue(value))
+}
+
+// Returns a condition like "destDeviceId eq '1024'", which is empty if the number is 0.
+func equalsFilter(field string, number int) string {
+	if number == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s eq '%d'", field, number)
+}
+
+// Quotes a value of a $filter. Like in OData, quotes in the value are escaped by doubling them.
+func quoteFilterValue(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func joinFilters(filters ...string) string {
+	nonEmpty := make([]string, 0, len(filters))
+	for _, filter := range filters {
+		if filter != "" {
+			nonEmpty = append(nonEmpty, filter)
+		}
+	}
+	return strings.Join(nonEmpty, " and ")
+}
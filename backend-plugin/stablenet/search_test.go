@@ -0,0 +1,108 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceSearch_Filter(t *testing.T) {
+	tests := []struct {
+		name   string
+		search DeviceSearch
+		want   string
+	}{
+		{name: "empty", search: DeviceSearch{}, want: ""},
+		{name: "name", search: DeviceSearch{Name: "lab"}, want: "name ct 'lab'"},
+		{name: "all fields", search: DeviceSearch{Name: "lab", IPAddress: "10.1.", Vendor: 9}, want: "name ct 'lab' and ip ct '10.1.' and vendor eq '9'"},
+		{name: "quote", search: DeviceSearch{Name: "St. John's"}, want: "name ct 'St. John''s'"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.search.Filter(), "%s: filter wrong", tt.name)
+	}
+}
+
+func TestMeasurementSearch_Filter(t *testing.T) {
+	tests := []struct {
+		name   string
+		search MeasurementSearch
+		want   string
+	}{
+		{name: "empty", search: MeasurementSearch{}, want: ""},
+		{name: "device", search: MeasurementSearch{DeviceObid: 1024}, want: "destDeviceId eq '1024'"},
+		{name: "all fields", search: MeasurementSearch{DeviceObid: 1024, Name: "eth", Type: "interface"}, want: "destDeviceId eq '1024' and name ct 'eth' and type ct 'interface'"},
+		{name: "type only", search: MeasurementSearch{Type: "ping"}, want: "type ct 'ping'"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.search.Filter(), "%s: filter wrong", tt.name)
+	}
+}
+
+func TestClientImpl_SearchDevices(t *testing.T) {
+	devices, err := os.ReadFile("./test-data/devices.json")
+	require.NoError(t, err)
+
+	client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443"})
+	httpmock.ActivateNonDefault(client.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+	url := "https://127.0.0.1:5443/api/1/devices?$top=100&$orderBy=name&$filter=ip+ct+%2710.1.%27+and+vendor+eq+%27311%27"
+	httpmock.RegisterResponder("GET", url, httpmock.NewBytesResponder(200, devices))
+
+	actual, err := client.SearchDevices(context.Background(), DeviceSearch{IPAddress: "10.1.", Vendor: 311})
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, 1, httpmock.GetTotalCallCount(), "number of requests")
+	assert.Equal(t, Device{Name: "Atomcore.root.infosim.net", Obid: 1024, IPAddress: "10.1.2.66", Model: "PC", Vendor: 311}, actual.Data[0], "attributes of the device wrong")
+}
+
+func TestClientImpl_SearchMeasurements(t *testing.T) {
+	measurements, err := os.ReadFile("./test-data/measurements.json")
+	require.NoError(t, err)
+
+	client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443"})
+	httpmock.ActivateNonDefault(client.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+	url := "https://127.0.0.1:5443/api/1/measurements?$top=100&$orderBy=name&$filter=destDeviceId+eq+%271024%27+and+type+ct+%27ping%27"
+	httpmock.RegisterResponder("GET", url, httpmock.NewBytesResponder(200, measurements))
+	httpmock.RegisterNoResponder(httpmock.NewStringResponder(500, "unexpected request"))
+
+	actual, err := client.SearchMeasurements(context.Background(), MeasurementSearch{DeviceObid: 1024, Type: "ping"})
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, Measurement{Name: "Atomcore.root.infosim.net", Obid: 1029, DeviceObid: 1024, Type: "ping"}, actual.Data[0], "attributes of the measurement wrong")
+
+	_, err = client.SearchMeasurements(context.Background(), MeasurementSearch{Type: "interface"})
+	assert.ErrorContains(t, err, "retrieving measurements matching filter \"type ct 'interface'\" failed", "error message wrong")
+}
+
+func TestParseVendor(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    int
+		wantErr string
+	}{
+		{text: "311", want: 311},
+		{text: " 9 ", want: 9},
+		{text: "0", wantErr: "\"0\" is not an IANA enterprise number, e.g. 9 for Cisco"},
+		{text: "-9", wantErr: "\"-9\" is not an IANA enterprise number, e.g. 9 for Cisco"},
+		{text: "juniper", wantErr: "\"juniper\" is not an IANA enterprise number, e.g. 9 for Cisco"},
+	}
+	for _, tt := range tests {
+		got, err := ParseVendor(tt.text)
+		if tt.wantErr != "" {
+			assert.EqualError(t, err, tt.wantErr, "%s: error wrong", tt.text)
+			continue
+		}
+		require.NoError(t, err, "%s: no error expected", tt.text)
+		assert.Equal(t, tt.want, got, "%s: vendor wrong", tt.text)
+	}
+}
@@ -8,79 +8,114 @@
 package stablenet
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"net/http"
 	url2 "net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type ConnectOptions struct {
-	Address  string
-	Username string
-	Password string
+func (stableNetClient *StableNetClient) get(ctx context.Context, endpoint string, path string, attributes ...attribute.KeyValue) (*resty.Response, error) {
+	return stableNetClient.execute(ctx, resty.MethodGet, endpoint, path, nil, attributes...)
 }
 
-func NewStableNetClient(options *ConnectOptions) *StableNetClient {
-	client := resty.New().
-		SetBasicAuth(options.Username, options.Password).
-		SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-
-	return &StableNetClient{Address: options.Address, client: client}
+func (stableNetClient *StableNetClient) post(ctx context.Context, endpoint string, path string, body interface{}, attributes ...attribute.KeyValue) (*resty.Response, error) {
+	return stableNetClient.execute(ctx, resty.MethodPost, endpoint, path, body, attributes...)
 }
 
-type StableNetClient struct {
-	Address string
-	client  *resty.Client
-}
+// Sends the request and records a tracing span as well as the request metrics. The endpoint is a short, constant name
+// of the called API (e.g. "devices"), which is used as metric label and must thus not contain any ids. The context is
+// used for cancellation and as parent of the tracing span.
+func (stableNetClient *StableNetClient) execute(ctx context.Context, method string, endpoint string, path string, body interface{}, attributes ...attribute.KeyValue) (*resty.Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := stableNetClient.tracer.Start(ctx, fmt.Sprintf("StableNet %s %s", method, endpoint), trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	span.SetAttributes(attributes...)
+	span.SetAttributes(attribute.String("http.method", method), attribute.String("stablenet.endpoint", endpoint))
+
+	request := stableNetClient.client.R().SetContext(ctx)
+	if body != nil {
+		request = request.SetHeader("Content-Type", "application/json").SetBody(body)
+	}
 
-func (stableNetClient *StableNetClient) get(path string) (*resty.Response, error) {
-	return stableNetClient.client.R().Get(stableNetClient.Address + path)
+	start := time.Now()
+	resp, err := request.Execute(method, stableNetClient.Address+path)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode())
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode()))
+	} else {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	duration := time.Since(start)
+	stableNetClient.metrics.requests.WithLabelValues(endpoint, status).Inc()
+	stableNetClient.metrics.duration.WithLabelValues(endpoint, status).Observe(duration.Seconds())
+	if stableNetClient.verbose {
+		stableNetClient.logRequest(method, endpoint, path, status, duration, resp, err)
+	}
+	return resp, err
 }
 
-var unauthorizedStatusMessage = "The StableNet® server could be reached, but the credentials were invalid."
-
-// Queries StableNet® for its version. Attention: Unlike Go-conventions state, this function returns a string point instead of an error in case the version cannot be fetched.
-// The reason is that the returned string is meant to be presented to the end user, while an error type string should generally not be presented to the end user.
-func (stableNetClient *StableNetClient) QueryStableNetInfo() (*ServerInfo, *string) {
-	// use old XML API here because all server versions should have this endpoint, opposed to the JSON API version info endpoint.
-	response, err := stableNetClient.get("/rest/info")
-
+func (stableNetClient *StableNetClient) logRequest(method, endpoint, path, status string, duration time.Duration, resp *resty.Response, err error) {
+	logger := stableNetClient.logger
+	args := []interface{}{
+		"method", method,
+		"endpoint", endpoint,
+		"url", RedactCredentials(stableNetClient.Address + path),
+		"status", status,
+		"duration", duration,
+	}
 	if err != nil {
-		errorStr := fmt.Sprintf("Connecting to StableNet® failed: %v", err.Error())
-		return nil, &errorStr
+		logger.Info("StableNet request failed", append(args, "error", RedactCredentials(err.Error()))...)
+		return
 	}
+	logger.Info("StableNet request", append(args, "response", SanitizeBody(resp.Body()))...)
+}
 
-	if response.StatusCode() == http.StatusUnauthorized {
-		return nil, &unauthorizedStatusMessage
+// Queries StableNet® for its version and licensed modules. Use UserMessage to obtain a text for the end user from the error.
+func (stableNetClient *StableNetClient) QueryStableNetInfo(ctx context.Context) (*ServerInfo, error) {
+	// use old XML API here because all server versions should have this endpoint, opposed to the JSON API version info endpoint.
+	response, err := stableNetClient.get(ctx, "info", "/rest/info")
+	if err != nil {
+		return nil, buildRequestError("retrieving server info failed", err)
 	}
-
 	if response.StatusCode() != http.StatusOK {
-		errorStr := fmt.Sprintf("Log in to StableNet® successful, but the StableNet® version could not be queried. Status Code: %d", response.StatusCode())
-		return nil, &errorStr
+		return nil, buildStatusError("retrieving server info failed", response.StatusCode(), response.Body())
 	}
 
 	var result ServerInfo
 	err = xml.Unmarshal(response.Body(), &result)
 	if err != nil {
-		errorStr := fmt.Sprintf("Log in to StableNet® successful, but the StableNet® answer \"%s\" could not be parsed: %v", response.String(), err)
-		return nil, &errorStr
+		return nil, &ParseError{Message: "could not unmarshal xml", Body: SanitizeBody(response.Body()), Err: err}
 	}
 
 	return &result, nil
 }
 
-func buildStatusError(msg string, resp *resty.Response) error {
-	return fmt.Errorf("%s: status code: %d, response: %s", msg, resp.StatusCode(), string(resp.Body()))
-}
+// The number of entities requested per page of the JSON API.
+const pageSize = 100
 
 func buildJsonApiUrl(endpoint string, orderBy string, filters ...string) string {
-	url := fmt.Sprintf("/api/1/%s?$top=100", endpoint)
+	return buildPagedJsonApiUrl(endpoint, orderBy, pageSize, 0, filters...)
+}
+
+// Returns the url of the page of top entities starting with the entity at the index skip.
+func buildPagedJsonApiUrl(endpoint string, orderBy string, top int, skip int, filters ...string) string {
+	url := fmt.Sprintf("/api/1/%s?$top=%d", endpoint, top)
 
 	if len(orderBy) != 0 {
 		url = url + fmt.Sprintf("&$orderBy=%s", orderBy)
@@ -93,157 +128,441 @@ func buildJsonApiUrl(endpoint string, orderBy string, filters ...string) string
 		}
 	}
 
-	if len(nonEmptyFilters) == 0 {
-		return url
+	if len(nonEmptyFilters) != 0 {
+		url = url + "&$filter=" + url2.QueryEscape(strings.Join(nonEmptyFilters, " and "))
+	}
+	if skip > 0 {
+		url = url + fmt.Sprintf("&$skip=%d", skip)
 	}
+	return url
+}
 
-	return url + "&$filter=" + url2.QueryEscape(strings.Join(nonEmptyFilters, " and "))
+// Returns the number of entities to request for the next page if limit entities may be fetched at most. One more than
+// the limit is requested, such that exceeding the limit is noticed without fetching a full page.
+func nextPageSize(limit int, fetched int) int {
+	return max(1, min(pageSize, limit+1-fetched))
 }
 
-// Queries devices from the StableNet server that contain the string "nameFilter" in their nae
-func (stableNetClient *StableNetClient) QueryDevices(nameFilter string) (*DeviceQueryResult, error) {
-	path := buildJsonApiUrl("devices", "name")
+// Queries the devices whose name contains the filter. An empty filter matches all devices.
+func (stableNetClient *StableNetClient) QueryDevices(ctx context.Context, nameFilter string) (*DeviceQueryResult, error) {
+	return stableNetClient.queryDevices(ctx, DeviceSearch{Name: nameFilter}.Filter(), fmt.Sprintf("query \"%s\"", nameFilter))
+}
 
-	if len(nameFilter) != 0 {
-		path = path + "&$filter=" + url2.QueryEscape(fmt.Sprintf("name ct '%s'", nameFilter))
-	}
+// Queries the first page of the devices that match the search.
+func (stableNetClient *StableNetClient) SearchDevices(ctx context.Context, search DeviceSearch) (*DeviceQueryResult, error) {
+	filter := search.Filter()
+	return stableNetClient.queryDevices(ctx, filter, fmt.Sprintf("filter \"%s\"", filter))
+}
+
+// Queries the devices matching the filter. The description of the filter is used in the errors.
+func (stableNetClient *StableNetClient) queryDevices(ctx context.Context, filter string, description string) (*DeviceQueryResult, error) {
+	path := buildJsonApiUrl("devices", "name", filter)
 
-	resp, err := stableNetClient.get(path)
+	resp, err := stableNetClient.get(ctx, "devices", path)
 	if err != nil {
-		return nil, fmt.Errorf("retrieving devices matching query \"%s\" failed: %v", nameFilter, err)
+		return nil, buildRequestError(fmt.Sprintf("retrieving devices matching %s failed", description), err)
 	}
 	if resp.StatusCode() != 200 {
-		return nil, buildStatusError(fmt.Sprintf("retrieving devices matching query \"%s\" failed", nameFilter), resp)
+		return nil, buildStatusError(fmt.Sprintf("retrieving devices matching %s failed", description), resp.StatusCode(), resp.Body())
 	}
 
 	var result DeviceQueryResult
 	err = json.Unmarshal(resp.Body(), &result)
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal json: %v", err)
+		return nil, buildParseError(err)
 	}
 	return &result, nil
 }
 
-func (stableNetClient *StableNetClient) FetchMeasurementsForDevice(deviceObid int, fieldFilter string) (*MeasurementQueryResult, error) {
-	var nameFilter string
-	if len(fieldFilter) != 0 {
-		nameFilter = fmt.Sprintf("name ct '%s'", fieldFilter)
+// Fetches the first page of the measurements of a device whose name contains the filter.
+func (stableNetClient *StableNetClient) FetchMeasurementsForDevice(ctx context.Context, deviceObid int, fieldFilter string) (*MeasurementQueryResult, error) {
+	return stableNetClient.fetchMeasurementPage(ctx, MeasurementSearch{DeviceObid: deviceObid, Name: fieldFilter}, 0, pageSize)
+}
+
+// Fetches the first page of the measurements that match the search.
+func (stableNetClient *StableNetClient) SearchMeasurements(ctx context.Context, search MeasurementSearch) (*MeasurementQueryResult, error) {
+	return stableNetClient.fetchMeasurementPage(ctx, search, 0, pageSize)
+}
+
+// Fetches all measurements of a device that match the filter, page by page. Since every page is a request of its own,
+// an error is returned instead of fetching more than limit measurements.
+func (stableNetClient *StableNetClient) FetchAllMeasurementsForDevice(ctx context.Context, deviceObid int, fieldFilter string, limit int) ([]Measurement, error) {
+	result := make([]Measurement, 0)
+	for {
+		page, err := stableNetClient.fetchMeasurementPage(ctx, MeasurementSearch{DeviceObid: deviceObid, Name: fieldFilter}, len(result), nextPageSize(limit, len(result)))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page.Data...)
+		if len(result) > limit {
+			return nil, fmt.Errorf("device %d has more than %d measurements matching \"%s\"", deviceObid, limit, fieldFilter)
+		}
+		if !page.HasMore || len(page.Data) == 0 {
+			return result, nil
+		}
 	}
+}
 
-	deviceFilter := fmt.Sprintf("destDeviceId eq '%d'", deviceObid)
+// Fetches a page of at most top measurements that match the search. The errors describe the device filter, since most
+// searches are for the measurements of a device.
+func (stableNetClient *StableNetClient) fetchMeasurementPage(ctx context.Context, search MeasurementSearch, skip int, top int) (*MeasurementQueryResult, error) {
+	deviceFilter := fmt.Sprintf("destDeviceId eq '%d'", search.DeviceObid)
+	description := fmt.Sprintf("for device filter \"%s\"", deviceFilter)
+	if search.DeviceObid == 0 || search.Type != "" {
+		description = fmt.Sprintf("matching filter \"%s\"", search.Filter())
+	}
 
-	path := buildJsonApiUrl("measurements", "name", deviceFilter, nameFilter)
+	path := buildPagedJsonApiUrl("measurements", "name", top, skip, search.Filter())
 
-	resp, err := stableNetClient.get(path)
+	resp, err := stableNetClient.get(ctx, "measurements", path, attribute.Int("stablenet.device.obid", search.DeviceObid))
 	if err != nil {
-		return nil, fmt.Errorf("retrieving measurements for device filter \"%s\" failed: %v", deviceFilter, err)
+		return nil, buildRequestError(fmt.Sprintf("retrieving measurements %s failed", description), err)
 	}
 	if resp.StatusCode() != 200 {
-		return nil, buildStatusError(fmt.Sprintf("retrieving measurements for device filter \"%s\" failed", deviceFilter), resp)
+		return nil, buildStatusError(fmt.Sprintf("retrieving measurements %s failed", description), resp.StatusCode(), resp.Body())
 	}
 
 	var result MeasurementQueryResult
 	err = json.Unmarshal(resp.Body(), &result)
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal json: %v", err)
+		return nil, buildParseError(err)
 	}
 	return &result, nil
 }
 
-func (stableNetCliet *StableNetClient) FetchMeasurementName(id int) (*string, error) {
+// Fetches the name of a single measurement.
+func (stableNetClient *StableNetClient) FetchMeasurementName(ctx context.Context, id int) (string, error) {
+	measurement, err := stableNetClient.FetchMeasurement(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return measurement.Name, nil
+}
+
+// Fetches a single measurement including the obid of the device it belongs to.
+func (stableNetClient *StableNetClient) FetchMeasurement(ctx context.Context, id int) (*Measurement, error) {
 	url := buildJsonApiUrl("measurements", "name", fmt.Sprintf("obid eq '%d'", id))
 
-	resp, err := stableNetCliet.get(url)
+	resp, err := stableNetClient.get(ctx, "measurements", url, attribute.Int("stablenet.measurement.obid", id))
 	if err != nil {
-		return nil, fmt.Errorf("retrieving name for measurement %d failed: %v", id, err)
+		return nil, buildRequestError(fmt.Sprintf("retrieving measurement %d failed", id), err)
 	}
 	if resp.StatusCode() != 200 {
-		return nil, buildStatusError(fmt.Sprintf("retrieving name for measurement %d failed", id), resp)
+		return nil, buildStatusError(fmt.Sprintf("retrieving measurement %d failed", id), resp.StatusCode(), resp.Body())
 	}
 
 	var responseData MeasurementQueryResult
 	err = json.Unmarshal(resp.Body(), &responseData)
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal json: %v", err)
+		return nil, buildParseError(err)
+	}
+
+	if len(responseData.Data) == 0 {
+		return nil, notFoundError(fmt.Sprintf("measurement with id %d does not exist", id))
+	}
+
+	return &responseData.Data[0], nil
+}
+
+// Fetches a single device by its obid.
+func (stableNetClient *StableNetClient) FetchDevice(ctx context.Context, id int) (*Device, error) {
+	url := buildJsonApiUrl("devices", "name", fmt.Sprintf("obid eq '%d'", id))
+
+	resp, err := stableNetClient.get(ctx, "devices", url, attribute.Int("stablenet.device.obid", id))
+	if err != nil {
+		return nil, buildRequestError(fmt.Sprintf("retrieving device %d failed", id), err)
+	}
+	if resp.StatusCode() != 200 {
+		return nil, buildStatusError(fmt.Sprintf("retrieving device %d failed", id), resp.StatusCode(), resp.Body())
+	}
+
+	var responseData DeviceQueryResult
+	err = json.Unmarshal(resp.Body(), &responseData)
+	if err != nil {
+		return nil, buildParseError(err)
 	}
 
 	if len(responseData.Data) == 0 {
-		return nil, fmt.Errorf("measurement with id %d does not exist", id)
+		return nil, notFoundError(fmt.Sprintf("device with id %d does not exist", id))
 	}
 
-	return &responseData.Data[0].Name, nil
+	return &responseData.Data[0], nil
 }
 
-func (stableNetClient *StableNetClient) FetchMetricsForMeasurement(measurementObid int) ([]Metric, error) {
+// Fetches the metrics of a measurement.
+func (stableNetClient *StableNetClient) FetchMetricsForMeasurement(ctx context.Context, measurementObid int) ([]Metric, error) {
 	url := fmt.Sprintf("/api/1/measurement-data/%d/metrics?$top=100", measurementObid)
 
-	resp, err := stableNetClient.get(url)
+	resp, err := stableNetClient.get(ctx, "metrics", url, attribute.Int("stablenet.measurement.obid", measurementObid))
 	if err != nil {
-		return nil, fmt.Errorf("retrieving metrics for measurement %d failed: %v", measurementObid, err)
+		return nil, buildRequestError(fmt.Sprintf("retrieving metrics for measurement %d failed", measurementObid), err)
 	}
 	if resp.StatusCode() != 200 {
-		return nil, buildStatusError(fmt.Sprintf("retrieving metrics for measurement %d failed", measurementObid), resp)
+		return nil, buildStatusError(fmt.Sprintf("retrieving metrics for measurement %d failed", measurementObid), resp.StatusCode(), resp.Body())
 	}
 
 	responseData := make([]Metric, 0)
 	err = json.Unmarshal(resp.Body(), &responseData)
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal json: %v", err)
+		return nil, buildParseError(err)
 	}
 	return responseData, nil
 }
 
-func (stableNetClient *StableNetClient) FetchDataForMetrics(options DataQueryOptions) (map[string]MetricDataSeries, error) {
+// The StableNet® data endpoint returns at most this many entries per metric, thus larger ranges must be requested in chunks.
+const maxDataPointsPerRequest = 100
+
+// The number of chunk requests that are sent to StableNet® at the same time for one measurement.
+const maxConcurrentDataRequests = 4
+
+type timeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Splits the time range into consecutive chunks such that each chunk is expected to contain at most maxDataPointsPerRequest
+// data points for the given average (in milliseconds). If the average is unknown, the whole range is returned as one chunk.
+func splitTimeRange(start, end time.Time, average int64) []timeRange {
+	if average <= 0 || !end.After(start) {
+		return []timeRange{{Start: start, End: end}}
+	}
+	chunkDuration := time.Duration(average*maxDataPointsPerRequest) * time.Millisecond
+	chunks := make([]timeRange, 0, end.Sub(start)/chunkDuration+1)
+	for chunkStart := start; chunkStart.Before(end); chunkStart = chunkStart.Add(chunkDuration) {
+		chunkEnd := chunkStart.Add(chunkDuration)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+		chunks = append(chunks, timeRange{Start: chunkStart, End: chunkEnd})
+	}
+	return chunks
+}
+
+// Fetches the data of the requested metrics. Large time ranges are split into several requests which are sent with limited
+// concurrency, the results are merged and de-duplicated by timestamp afterwards.
+func (stableNetClient *StableNetClient) FetchDataForMetrics(ctx context.Context, options DataQueryOptions) (map[string]MetricDataSeries, error) {
+	chunks := splitTimeRange(options.Start, options.End, options.Average)
+	results, err := fetchChunks(chunks, func(chunk timeRange) (map[string]MetricDataSeries, error) {
+		return stableNetClient.fetchDataChunk(ctx, options, chunk)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 1 {
+		return results[0], nil
+	}
+	return mergeDataSeries(results), nil
+}
+
+// Calls fetch for every chunk with at most maxConcurrentDataRequests calls running at the same time. The results are
+// returned in the order of the chunks. If any call fails, the first error (in chunk order) is returned.
+func fetchChunks[T any](chunks []timeRange, fetch func(timeRange) (T, error)) ([]T, error) {
+	results := make([]T, len(chunks))
+	if len(chunks) == 1 {
+		result, err := fetch(chunks[0])
+		if err != nil {
+			return nil, err
+		}
+		results[0] = result
+		return results, nil
+	}
+
+	errs := make([]error, len(chunks))
+	semaphore := make(chan struct{}, maxConcurrentDataRequests)
+	var wg sync.WaitGroup
+	for index, chunk := range chunks {
+		wg.Add(1)
+		go func(index int, chunk timeRange) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			results[index], errs[index] = fetch(chunk)
+		}(index, chunk)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (stableNetClient *StableNetClient) fetchDataChunk(ctx context.Context, options DataQueryOptions, chunk timeRange) (map[string]MetricDataSeries, error) {
 	query := DataQuery{
-		Start:   options.Start.UnixNano() / int64(time.Millisecond),
-		End:     options.End.UnixNano() / int64(time.Millisecond),
+		Start:   chunk.Start.UnixNano() / int64(time.Millisecond),
+		End:     chunk.End.UnixNano() / int64(time.Millisecond),
 		Metrics: options.Metrics,
 		Average: options.Average,
 		Raw:     false,
 	}
 
-	url := stableNetClient.Address + fmt.Sprintf("/api/1/measurement-data/%d?$top=100", options.MeasurementObid)
+	url := fmt.Sprintf("/api/1/measurement-data/%d?$top=%d", options.MeasurementObid, maxDataPointsPerRequest)
 
-	resp, err := stableNetClient.client.R().SetHeader("Content-Type", "application/json").SetBody(query).Post(url)
+	resp, err := stableNetClient.post(ctx, "measurement-data", url, query,
+		attribute.Int("stablenet.measurement.obid", options.MeasurementObid),
+		attribute.Int("stablenet.metric.count", len(options.Metrics)),
+	)
 	if err != nil {
-		return nil, fmt.Errorf("retrieving metric data for measurement %d failed: %v", options.MeasurementObid, err)
+		return nil, buildRequestError(fmt.Sprintf("retrieving metric data for measurement %d failed", options.MeasurementObid), err)
 	}
 	if resp.StatusCode() != 200 {
-		return nil, buildStatusError(fmt.Sprintf("retrieving metric data for measurement %d failed", options.MeasurementObid), resp)
+		return nil, buildStatusError(fmt.Sprintf("retrieving metric data for measurement %d failed", options.MeasurementObid), resp.StatusCode(), resp.Body())
 	}
 
 	return parseStatisticByteSlice(resp.Body())
 }
 
-func convertMeasurementData(data MeasurementDataEntryDTO) MetricData {
-	return MetricData{
+// Fetches the data of several measurements with one request per chunk by using the multi-measurement endpoint of
+// StableNet®. This endpoint is only available if ServerInfo.SupportsMultiMeasurementData returns true. The result maps
+// the measurement obids to the data of their metrics.
+func (stableNetClient *StableNetClient) FetchDataForMeasurements(ctx context.Context, options MultiDataQueryOptions) (map[int]map[string]MetricDataSeries, error) {
+	chunks := splitTimeRange(options.Start, options.End, options.Average)
+	results, err := fetchChunks(chunks, func(chunk timeRange) (map[int]map[string]MetricDataSeries, error) {
+		return stableNetClient.fetchMultiMeasurementDataChunk(ctx, options, chunk)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 1 {
+		return results[0], nil
+	}
+
+	perMeasurement := make(map[int][]map[string]MetricDataSeries)
+	for _, result := range results {
+		for obid, series := range result {
+			perMeasurement[obid] = append(perMeasurement[obid], series)
+		}
+	}
+	merged := make(map[int]map[string]MetricDataSeries, len(perMeasurement))
+	for obid, series := range perMeasurement {
+		merged[obid] = mergeDataSeries(series)
+	}
+	return merged, nil
+}
+
+func (stableNetClient *StableNetClient) fetchMultiMeasurementDataChunk(ctx context.Context, options MultiDataQueryOptions, chunk timeRange) (map[int]map[string]MetricDataSeries, error) {
+	query := MultiMeasurementDataQuery{
+		Start:        chunk.Start.UnixNano() / int64(time.Millisecond),
+		End:          chunk.End.UnixNano() / int64(time.Millisecond),
+		Measurements: make([]MeasurementMetricsDTO, 0, len(options.Metrics)),
+		Average:      options.Average,
+		Raw:          false,
+	}
+	obids := make([]int, 0, len(options.Metrics))
+	for obid := range options.Metrics {
+		obids = append(obids, obid)
+	}
+	sort.Ints(obids)
+	for _, obid := range obids {
+		query.Measurements = append(query.Measurements, MeasurementMetricsDTO{MeasurementObid: obid, Metrics: options.Metrics[obid]})
+	}
+
+	url := fmt.Sprintf("/api/1/measurement-data?$top=%d", maxDataPointsPerRequest)
+
+	resp, err := stableNetClient.post(ctx, "multi-measurement-data", url, query,
+		attribute.IntSlice("stablenet.measurement.obids", obids),
+		attribute.Int("stablenet.measurement.count", len(obids)),
+	)
+	if err != nil {
+		return nil, buildRequestError(fmt.Sprintf("retrieving metric data for measurements %v failed", obids), err)
+	}
+	if resp.StatusCode() != 200 {
+		return nil, buildStatusError(fmt.Sprintf("retrieving metric data for measurements %v failed", obids), resp.StatusCode(), resp.Body())
+	}
+
+	var data []MeasurementResultDataDTO
+	err = json.Unmarshal(resp.Body(), &data)
+	if err != nil {
+		return nil, buildParseError(err)
+	}
+	result := make(map[int]map[string]MetricDataSeries, len(data))
+	for _, measurement := range data {
+		result[measurement.MeasurementObid] = convertMultiMetricResult(MeasurementMultiMetricResultDataDTO{Values: measurement.Values})
+	}
+	return result, nil
+}
+
+// Merges the series of several chunks into one series per metric. The entries are sorted by time and entries with a
+// timestamp that is already present (e.g. at the chunk boundaries) are dropped.
+func mergeDataSeries(chunks []map[string]MetricDataSeries) map[string]MetricDataSeries {
+	resultMap := make(map[string]MetricDataSeries)
+	for _, chunk := range chunks {
+		for key, series := range chunk {
+			resultMap[key] = append(resultMap[key], series...)
+		}
+	}
+	for key, series := range resultMap {
+		sort.SliceStable(series, func(i, j int) bool {
+			return series[i].Time.Before(series[j].Time)
+		})
+		deduplicated := make(MetricDataSeries, 0, len(series))
+		for _, entry := range series {
+			if len(deduplicated) > 0 && deduplicated[len(deduplicated)-1].Time.Equal(entry.Time) {
+				continue
+			}
+			deduplicated = append(deduplicated, entry)
+		}
+		resultMap[key] = deduplicated
+	}
+	return resultMap
+}
+
+// Converts an entry of StableNet®, which returns null for values it has no data for. The entry is only valid if it has
+// an average, missing minima and maxima default to the average.
+func convertMeasurementData(data MeasurementDataEntryDTO) (MetricData, bool) {
+	if data.Avg == nil {
+		return MetricData{}, false
+	}
+	result := MetricData{
 		Time:     time.Unix(0, data.Timestamp*int64(time.Millisecond)),
 		Interval: time.Duration(data.Interval) * time.Millisecond,
-		Min:      *data.Min,
+		Min:      *data.Avg,
 		Avg:      *data.Avg,
-		Max:      *data.Max,
+		Max:      *data.Avg,
+	}
+	if data.Min != nil {
+		result.Min = *data.Min
+	}
+	if data.Max != nil {
+		result.Max = *data.Max
 	}
+	return result, true
 }
 
 func parseStatisticByteSlice(bytes []byte) (map[string]MetricDataSeries, error) {
 	var data MeasurementMultiMetricResultDataDTO
 	err := json.Unmarshal(bytes, &data)
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal json: %v", err)
+		return nil, buildParseError(err)
 	}
+	return convertMultiMetricResult(data), nil
+}
 
+// Converts the result of StableNet® into series that are sorted by time, since StableNet® does not guarantee the order.
+// Entries without an average are gaps in the data and are dropped.
+func convertMultiMetricResult(data MeasurementMultiMetricResultDataDTO) map[string]MetricDataSeries {
 	resultMap := make(map[string]MetricDataSeries)
 	for _, record := range data.Values {
 		key := record.MetricKey
 
 		for _, measurementData := range record.Data {
-			metricData := convertMeasurementData(measurementData)
+			metricData, ok := convertMeasurementData(measurementData)
+			if !ok {
+				continue
+			}
 			if _, ok := resultMap[key]; !ok {
 				resultMap[key] = make([]MetricData, 0)
 			}
 			resultMap[key] = append(resultMap[key], metricData)
 		}
 	}
+	for _, series := range resultMap {
+		sort.SliceStable(series, func(i, j int) bool {
+			return series[i].Time.Before(series[j].Time)
+		})
+	}
 
-	return resultMap, nil
+	return resultMap
 }
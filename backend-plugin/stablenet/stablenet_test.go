@@ -8,16 +8,20 @@
 package stablenet
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/jarcoal/httpmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -38,13 +42,15 @@ func TestClientImpl_QueryStableNetInfo(t *testing.T) {
 		returnedStatus int
 		httpError      error
 		wantInfo       *ServerInfo
-		wantErrStr     *string
+		wantErr        error
+		wantMessage    string
 	}{
-		{name: "success", returnedBody: versionXml, returnedStatus: http.StatusOK, wantInfo: wantInfo, wantErrStr: nil},
-		{name: "connection error", httpError: errors.New("server running low on schnitzels"), wantErrStr: strPtr("Connecting to StableNet® failed: Get \"https://127.0.0.1:443/rest/info\": server running low on schnitzels")},
-		{name: "authentication error", returnedBody: "Forbidden", returnedStatus: http.StatusUnauthorized, wantErrStr: strPtr("The StableNet® server could be reached, but the credentials were invalid.")},
-		{name: "status error", returnedBody: "Internal Server Error", returnedStatus: http.StatusInternalServerError, wantErrStr: strPtr("Log in to StableNet® successful, but the StableNet® version could not be queried. Status Code: 500")},
-		{name: "unmarshal error", returnedBody: "this is no xml", returnedStatus: http.StatusOK, wantErrStr: strPtr("Log in to StableNet® successful, but the StableNet® answer \"this is no xml\" could not be parsed: EOF")},
+		{name: "success", returnedBody: versionXml, returnedStatus: http.StatusOK, wantInfo: wantInfo},
+		{name: "connection error", httpError: errors.New("server running low on schnitzels"), wantMessage: "Connecting to StableNet® failed: Get \"https://127.0.0.1:443/rest/info\": server running low on schnitzels"},
+		{name: "timeout", httpError: context.DeadlineExceeded, wantErr: ErrTimeout, wantMessage: "The StableNet® server did not answer in time."},
+		{name: "authentication error", returnedBody: "Forbidden", returnedStatus: http.StatusUnauthorized, wantErr: ErrUnauthorized, wantMessage: "The StableNet® server could be reached, but the credentials were invalid."},
+		{name: "status error", returnedBody: "Internal Server Error", returnedStatus: http.StatusInternalServerError, wantMessage: "StableNet® answered with the unexpected status code 500: Internal Server Error"},
+		{name: "unmarshal error", returnedBody: "this is no xml", returnedStatus: http.StatusOK, wantErr: ErrInvalidResponse, wantMessage: "The StableNet® answer \"this is no xml\" could not be parsed: EOF"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -59,23 +65,22 @@ func TestClientImpl_QueryStableNetInfo(t *testing.T) {
 
 			client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:443"})
 			httpmock.ActivateNonDefault(client.client.GetClient())
-			actual, errStr := client.QueryStableNetInfo()
+			actual, err := client.QueryStableNetInfo(context.Background())
 			assert.Equal(t, tt.wantInfo, actual, "queried server version wrong")
-			if tt.wantErrStr != nil {
-				assert.Equal(t, *tt.wantErrStr, *errStr, "returned error string wrong")
+			if len(tt.wantMessage) != 0 {
+				require.Error(t, err, "error expected")
+				assert.Equal(t, tt.wantMessage, UserMessage(err), "user message wrong")
 			} else {
-				assert.Nil(t, errStr, "returned error string should be nil")
+				assert.NoError(t, err, "no error expected")
+			}
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr, "kind of error wrong")
 			}
 			httpmock.Reset()
 		})
 	}
 }
 
-func strPtr(value string) *string {
-	result := value
-	return &result
-}
-
 func TestClientImpl_QueryDevices(t *testing.T) {
 	devices, err := os.ReadFile("./test-data/devices.json")
 	require.NoError(t, err)
@@ -98,7 +103,7 @@ func TestClientImpl_QueryDevices(t *testing.T) {
 			httpmock.ActivateNonDefault(client.client.GetClient())
 			defer httpmock.Deactivate()
 
-			actual, err := client.QueryDevices(tt.filter)
+			actual, err := client.QueryDevices(context.Background(), tt.filter)
 			require.NoError(t, err)
 
 			assert.Equal(t, 1, httpmock.GetTotalCallCount())
@@ -115,7 +120,7 @@ func TestClientImpl_QueryDevices(t *testing.T) {
 func TestClientImpl_QueryDevice_Error(t *testing.T) {
 	url := "https://127.0.0.1:5443/api/1/devices?$top=100&$orderBy=name&$filter=name+ct+%27lab%27"
 	shouldReturnError := func(client *StableNetClient) (interface{}, error) {
-		return client.QueryDevices("lab")
+		return client.QueryDevices(context.Background(), "lab")
 	}
 	t.Run("json error", invalidJsonTest(shouldReturnError, "GET", url))
 	t.Run("status error", wrongStatusResponseTest(shouldReturnError, "GET", url, "devices matching query \"lab\""))
@@ -146,7 +151,7 @@ func TestClientImpl_FetchMeasurementsForDevice(t *testing.T) {
 			httpmock.RegisterResponder("GET", tt.mockUrl, httpmock.NewBytesResponder(200, rawData))
 			client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443", Username: "infosim", Password: "stablenet"})
 			httpmock.ActivateNonDefault(client.client.GetClient())
-			actual, err := client.FetchMeasurementsForDevice(tt.deviceObid, tt.filter)
+			actual, err := client.FetchMeasurementsForDevice(context.Background(), tt.deviceObid, tt.filter)
 			require.NoError(t, err)
 			require.Equal(t, 10, len(actual.Data), "number of queried measurements wrong")
 			test := assert.New(t)
@@ -157,11 +162,65 @@ func TestClientImpl_FetchMeasurementsForDevice(t *testing.T) {
 	}
 }
 
+func TestClientImpl_FetchAllMeasurementsForDevice(t *testing.T) {
+	page := func(top int, skip int) string {
+		url := fmt.Sprintf("https://127.0.0.1:5443/api/1/measurements?$top=%d&$orderBy=name&$filter=destDeviceId+eq+%%271024%%27", top)
+		if skip > 0 {
+			url += fmt.Sprintf("&$skip=%d", skip)
+		}
+		return url
+	}
+	// the pages request one more measurement than the limit allows in total
+	register := func(limit int) *StableNetClient {
+		httpmock.RegisterResponder("GET", page(limit+1, 0), httpmock.NewStringResponder(200, `{"hasMore": true, "data": [{"name": "A", "obid": 1}, {"name": "B", "obid": 2}]}`))
+		httpmock.RegisterResponder("GET", page(limit-1, 2), httpmock.NewStringResponder(200, `{"hasMore": true, "data": [{"name": "C", "obid": 3}, {"name": "D", "obid": 4}]}`))
+		httpmock.RegisterResponder("GET", page(limit-3, 4), httpmock.NewStringResponder(200, `{"hasMore": false, "data": [{"name": "E", "obid": 5}]}`))
+		client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443", Username: "infosim", Password: "stablenet"})
+		httpmock.ActivateNonDefault(client.client.GetClient())
+		return client
+	}
+	t.Run("all pages", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.Deactivate()
+		got, err := register(5).FetchAllMeasurementsForDevice(context.Background(), 1024, "", 5)
+		require.NoError(t, err, "no error expected")
+		assert.Equal(t, []Measurement{{Name: "A", Obid: 1}, {Name: "B", Obid: 2}, {Name: "C", Obid: 3}, {Name: "D", Obid: 4}, {Name: "E", Obid: 5}}, got, "measurements wrong")
+	})
+	t.Run("limit exceeded", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.Deactivate()
+		client := register(3)
+		httpmock.ZeroCallCounters()
+		got, err := client.FetchAllMeasurementsForDevice(context.Background(), 1024, "", 3)
+		assert.Nil(t, got, "result should be nil in case of an error")
+		assert.EqualError(t, err, "device 1024 has more than 3 measurements matching \"\"", "error message wrong")
+		assert.Equal(t, 1, httpmock.GetCallCountInfo()["GET "+page(2, 2)], "the second page should only request the rest of the limit")
+		assert.Equal(t, 2, httpmock.GetTotalCallCount(), "no page should be fetched after the limit is exceeded")
+	})
+	t.Run("large limit", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.Deactivate()
+		client := register(1000)
+		httpmock.RegisterResponder("GET", page(100, 0), httpmock.NewStringResponder(200, `{"hasMore": false, "data": [{"name": "A", "obid": 1}]}`))
+		got, err := client.FetchAllMeasurementsForDevice(context.Background(), 1024, "", 1000)
+		require.NoError(t, err, "no error expected")
+		assert.Equal(t, []Measurement{{Name: "A", Obid: 1}}, got, "measurements wrong")
+	})
+	t.Run("page error", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.Deactivate()
+		client := register(5)
+		httpmock.RegisterResponder("GET", page(4, 2), httpmock.NewStringResponder(500, "internal error"))
+		_, err := client.FetchAllMeasurementsForDevice(context.Background(), 1024, "", 5)
+		assert.EqualError(t, err, "retrieving measurements for device filter \"destDeviceId eq '1024'\" failed: status code: 500, response: internal error", "error message wrong")
+	})
+}
+
 func TestClientImpl_FetchMeasurementsForDevice_Error(t *testing.T) {
 	url := "https://127.0.0.1:5443/api/1/measurements?$top=100&$orderBy=name&$filter=destDeviceId+eq+%271024%27"
 
 	shouldReturnError := func(client *StableNetClient) (interface{}, error) {
-		return client.FetchMeasurementsForDevice(1024, "")
+		return client.FetchMeasurementsForDevice(context.Background(), 1024, "")
 	}
 
 	t.Run("json error", invalidJsonTest(shouldReturnError, "GET", url))
@@ -182,7 +241,7 @@ func TestClientImpl_FetchMetricsForMeasurement(t *testing.T) {
 	httpmock.ActivateNonDefault(client.client.GetClient())
 	defer httpmock.Deactivate()
 
-	metrics, err := client.FetchMetricsForMeasurement(1643)
+	metrics, err := client.FetchMetricsForMeasurement(context.Background(), 1643)
 	require.NoError(t, err)
 	require.Equal(t, 3, len(metrics), "number of queried metrics wrong")
 
@@ -193,6 +252,9 @@ func TestClientImpl_FetchMetricsForMeasurement(t *testing.T) {
 	test.Equal("System Processes", metrics[1].Name, "name of second metric wrong")
 	test.Equal("SNMP_1002", metrics[2].Key, "Key of third metric wrong")
 	test.Equal("System Uptime", metrics[2].Name, "name of third metric wrong")
+	test.Equal("day", metrics[2].Unit, "unit of third metric wrong")
+	test.Equal("System uptime of the device", metrics[2].Description, "description of third metric wrong")
+	test.Equal(5*time.Minute, PollingInterval(metrics), "polling interval wrong")
 }
 
 func TestClientImpl_FetchMeasurementName(t *testing.T) {
@@ -203,16 +265,65 @@ func TestClientImpl_FetchMeasurementName(t *testing.T) {
 	httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, "{\"count\": 2264, \"hasMore\": false, \"data\": [{\"name\": \"ThinkStation Address\", \"obid\": 1643}]}"))
 	client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443", Username: "infosim", Password: "stablenet"})
 	httpmock.ActivateNonDefault(client.client.GetClient())
-	name, err := client.FetchMeasurementName(1643)
+	name, err := client.FetchMeasurementName(context.Background(), 1643)
 	require.NoError(t, err, "no error expected")
-	require.Equal(t, "ThinkStation Address", *name, "name not correct")
+	require.Equal(t, "ThinkStation Address", name, "name not correct")
+}
+
+func TestClientImpl_FetchMeasurement(t *testing.T) {
+	url := "https://127.0.0.1:5443/api/1/measurements?$top=100&$orderBy=name&$filter=obid+eq+%271643%27"
+	httpmock.Activate()
+	defer httpmock.Deactivate()
+
+	httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, "{\"count\": 1, \"hasMore\": false, \"data\": [{\"name\": \"ThinkStation Address\", \"obid\": 1643, \"destDeviceId\": 1024}]}"))
+	client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443", Username: "infosim", Password: "stablenet"})
+	httpmock.ActivateNonDefault(client.client.GetClient())
+	measurement, err := client.FetchMeasurement(context.Background(), 1643)
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, Measurement{Name: "ThinkStation Address", Obid: 1643, DeviceObid: 1024}, *measurement, "measurement not correct")
+}
+
+func TestClientImpl_FetchDevice(t *testing.T) {
+	url := "https://127.0.0.1:5443/api/1/devices?$top=100&$orderBy=name&$filter=obid+eq+%271024%27"
+	httpmock.Activate()
+	defer httpmock.Deactivate()
+
+	httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, "{\"count\": 1, \"hasMore\": false, \"data\": [{\"name\": \"ThinkStation\", \"obid\": 1024}]}"))
+	client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443", Username: "infosim", Password: "stablenet"})
+	httpmock.ActivateNonDefault(client.client.GetClient())
+	device, err := client.FetchDevice(context.Background(), 1024)
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, Device{Name: "ThinkStation", Obid: 1024}, *device, "device not correct")
+}
+
+func TestClientImpl_FetchDevice_Error(t *testing.T) {
+	url := "https://127.0.0.1:5443/api/1/devices?$top=100&$orderBy=name&$filter=obid+eq+%271024%27"
+
+	shouldReturnError := func(client *StableNetClient) (i interface{}, e error) {
+		return client.FetchDevice(context.Background(), 1024)
+	}
+
+	t.Run("json error", invalidJsonTest(shouldReturnError, "GET", url))
+	t.Run("status error", wrongStatusResponseTest(shouldReturnError, "GET", url, "device 1024"))
+	t.Run("rest error", errorResponseTest(shouldReturnError, "GET", url, "device 1024"))
+	t.Run("no device", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.Deactivate()
+
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, "{\"count\": 0, \"hasMore\": false, \"data\": []}"))
+		client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443", Username: "infosim", Password: "stablenet"})
+		httpmock.ActivateNonDefault(client.client.GetClient())
+		_, err := client.FetchDevice(context.Background(), 1024)
+		require.EqualError(t, err, "device with id 1024 does not exist", "error message wrong")
+		assert.ErrorIs(t, err, ErrNotFound, "error should be a not found error")
+	})
 }
 
 func TestClientImpl_FetchMetricsForMeasurement_Error(t *testing.T) {
 	url := "https://127.0.0.1:5443/api/1/measurement-data/1643/metrics?$top=100"
 
 	shouldReturnError := func(client *StableNetClient) (i interface{}, e error) {
-		return client.FetchMetricsForMeasurement(1643)
+		return client.FetchMetricsForMeasurement(context.Background(), 1643)
 	}
 
 	t.Run("json error", invalidJsonTest(shouldReturnError, "GET", url))
@@ -292,7 +403,7 @@ func TestClientImpl_FetchDataForMetrics(t *testing.T) {
 		Average:         250,
 	}
 
-	actual, err := client.FetchDataForMetrics(options)
+	actual, err := client.FetchDataForMetrics(context.Background(), options)
 	require.NoError(t, err)
 
 	assert.Equal(t, len(metrics), len(actual), "number of downloaded metrics")
@@ -312,7 +423,7 @@ func TestClientImpl_FetchDataForMetrics(t *testing.T) {
 		{time.Unix(0, 1_574_840_583_813*int64(time.Millisecond)), 0.224},
 		{time.Unix(0, 1_574_840_883_813*int64(time.Millisecond)), 0.228},
 	}
-	assert.Equal(t, systemUptimeAvg, systemUptime.AsTable(false, false, true), "system uptime data")
+	assert.Equal(t, systemUptimeAvg, systemUptime.AsTable([]Statistic{StatisticAvg}, time.Hour), "system uptime data")
 }
 
 func TestClientImpl_FetchDataForMetrics_Error(t *testing.T) {
@@ -326,7 +437,7 @@ func TestClientImpl_FetchDataForMetrics_Error(t *testing.T) {
 	}
 
 	shouldReturnError := func(client *StableNetClient) (i interface{}, e error) {
-		return client.FetchDataForMetrics(options)
+		return client.FetchDataForMetrics(context.Background(), options)
 	}
 
 	t.Run("json error", invalidJsonTest(shouldReturnError, "POST", url))
@@ -379,6 +490,10 @@ func wrongStatusResponseTest(shouldReturnError func(*StableNetClient) (interface
 		_, err := shouldReturnError(client)
 		wantErr := fmt.Sprintf("retrieving %s failed: status code: 404, response: entity not found", msg)
 		require.EqualError(t, err, wantErr, "error message wrong")
+		assert.ErrorIs(t, err, ErrNotFound, "status 404 should be a not found error")
+		var statusErr *StatusError
+		require.ErrorAs(t, err, &statusErr, "error should be a status error")
+		assert.Equal(t, 404, statusErr.StatusCode, "status code of error")
 	}
 }
 
@@ -386,12 +501,16 @@ func TestClientImpl_FetchMeasurementName_Error(t *testing.T) {
 	url := "https://127.0.0.1:5443/api/1/measurements?$top=100&$orderBy=name&$filter=obid+eq+%271643%27"
 
 	shouldReturnError := func(client *StableNetClient) (i interface{}, e error) {
-		return client.FetchMeasurementName(1643)
+		name, err := client.FetchMeasurementName(context.Background(), 1643)
+		if err != nil {
+			return nil, err
+		}
+		return name, nil
 	}
 
 	t.Run("json error", invalidJsonTest(shouldReturnError, "GET", url))
-	t.Run("status error", wrongStatusResponseTest(shouldReturnError, "GET", url, "name for measurement 1643"))
-	t.Run("rest error", errorResponseTest(shouldReturnError, "GET", url, "name for measurement 1643"))
+	t.Run("status error", wrongStatusResponseTest(shouldReturnError, "GET", url, "measurement 1643"))
+	t.Run("rest error", errorResponseTest(shouldReturnError, "GET", url, "measurement 1643"))
 	t.Run("no measurement", func(t *testing.T) {
 		httpmock.Activate()
 		defer httpmock.Deactivate()
@@ -399,8 +518,9 @@ func TestClientImpl_FetchMeasurementName_Error(t *testing.T) {
 		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, "{\"count\": 2264, \"hasMore\": false, \"data\": []}"))
 		client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443", Username: "infosim", Password: "stablenet"})
 		httpmock.ActivateNonDefault(client.client.GetClient())
-		_, err := client.FetchMeasurementName(1643)
+		_, err := client.FetchMeasurementName(context.Background(), 1643)
 		require.EqualError(t, err, "measurement with id 1643 does not exist", "error message wrong")
+		assert.ErrorIs(t, err, ErrNotFound, "error should be a not found error")
 	})
 }
 
@@ -409,6 +529,8 @@ func TestClientImpl_buildJsonApiUrl(t *testing.T) {
 		name     string
 		endpoint string
 		orderBy  string
+		top      int
+		skip     int
 		filters  []string
 		want     string
 	}{
@@ -420,6 +542,10 @@ func TestClientImpl_buildJsonApiUrl(t *testing.T) {
 			name: "two filters", endpoint: "measurement/1234/metrics", filters: []string{"destDeviceId eq '1024'", "name ct 'ether'"},
 			want: "/api/1/measurement/1234/metrics?$top=100&$filter=destDeviceId+eq+%271024%27+and+name+ct+%27ether%27",
 		},
+		{
+			name: "page", endpoint: "measurements", orderBy: "name", top: 7, skip: 200, filters: []string{"destDeviceId eq '1024'"},
+			want: "/api/1/measurements?$top=7&$orderBy=name&$filter=destDeviceId+eq+%271024%27&$skip=200",
+		},
 		{
 			name: "two filter with order by", endpoint: "measurement/1234/metrics", orderBy: "description", filters: []string{"destDeviceId eq '1024'", "name ct 'ether'"},
 			want: "/api/1/measurement/1234/metrics?$top=100&$orderBy=description&$filter=destDeviceId+eq+%271024%27+and+name+ct+%27ether%27",
@@ -429,7 +555,243 @@ func TestClientImpl_buildJsonApiUrl(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := buildJsonApiUrl(tt.endpoint, tt.orderBy, tt.filters...)
+			if tt.top != 0 {
+				got = buildPagedJsonApiUrl(tt.endpoint, tt.orderBy, tt.top, tt.skip, tt.filters...)
+			}
 			require.Equal(t, tt.want, got, "constructed url not correct")
 		})
 	}
 }
+
+func TestSplitTimeRange(t *testing.T) {
+	start := time.Unix(1_600_000_000, 0)
+	tests := []struct {
+		name    string
+		end     time.Time
+		average int64
+		want    []timeRange
+	}{
+		{name: "no average", end: start.Add(time.Hour), average: 0, want: []timeRange{{Start: start, End: start.Add(time.Hour)}}},
+		{name: "fits into one chunk", end: start.Add(time.Hour), average: 60_000, want: []timeRange{{Start: start, End: start.Add(time.Hour)}}},
+		{name: "exactly two chunks", end: start.Add(200 * time.Minute), average: 60_000, want: []timeRange{
+			{Start: start, End: start.Add(100 * time.Minute)},
+			{Start: start.Add(100 * time.Minute), End: start.Add(200 * time.Minute)},
+		}},
+		{name: "last chunk is shorter", end: start.Add(250 * time.Minute), average: 60_000, want: []timeRange{
+			{Start: start, End: start.Add(100 * time.Minute)},
+			{Start: start.Add(100 * time.Minute), End: start.Add(200 * time.Minute)},
+			{Start: start.Add(200 * time.Minute), End: start.Add(250 * time.Minute)},
+		}},
+		{name: "end before start", end: start.Add(-time.Hour), average: 60_000, want: []timeRange{{Start: start, End: start.Add(-time.Hour)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitTimeRange(start, tt.end, tt.average)
+			assert.Equal(t, tt.want, got, "chunks are wrong")
+		})
+	}
+}
+
+func TestClientImpl_FetchDataForMetrics_Chunked(t *testing.T) {
+	client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443", Username: "infosim", Password: "stablenet"})
+	url := "https://127.0.0.1:5443/api/1/measurement-data/5555?$top=100"
+
+	// every chunk answers with one entry at its start and one at its end, so the chunk boundaries are returned twice
+	var calls int32
+	var mutex sync.Mutex
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", url, func(req *http.Request) (*http.Response, error) {
+		mutex.Lock()
+		calls++
+		mutex.Unlock()
+		var query DataQuery
+		if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+			return nil, err
+		}
+		result := MeasurementMultiMetricResultDataDTO{Values: []MeasurementMetricResultDataDTO{{
+			MetricKey: "SNMP_1",
+			Data: []MeasurementDataEntryDTO{
+				{Timestamp: query.End, Interval: query.Average, Min: f(2), Avg: f(2), Max: f(2)},
+				{Timestamp: query.Start, Interval: query.Average, Min: f(1), Avg: f(1), Max: f(1)},
+			},
+		}}}
+		return httpmock.NewJsonResponse(200, result)
+	})
+	httpmock.ActivateNonDefault(client.client.GetClient())
+	defer httpmock.Deactivate()
+
+	start := time.UnixMilli(1_600_000_000_000)
+	options := DataQueryOptions{
+		MeasurementObid: 5555,
+		Metrics:         []string{"SNMP_1"},
+		Start:           start,
+		End:             start.Add(1000 * time.Minute),
+		Average:         60_000,
+	}
+
+	actual, err := client.FetchDataForMetrics(context.Background(), options)
+	require.NoError(t, err)
+	assert.Equal(t, int32(10), calls, "number of chunk requests")
+	require.Equal(t, 1, len(actual), "number of metrics")
+	series := actual["SNMP_1"]
+	require.Equal(t, 11, len(series), "chunk boundaries should be de-duplicated")
+	for index, entry := range series {
+		assert.Equal(t, start.Add(time.Duration(index)*100*time.Minute), entry.Time, "time of entry %d", index)
+	}
+}
+
+func TestClientImpl_FetchDataForMetrics_ChunkError(t *testing.T) {
+	client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443", Username: "infosim", Password: "stablenet"})
+	url := "https://127.0.0.1:5443/api/1/measurement-data/5555?$top=100"
+
+	start := time.UnixMilli(1_600_000_000_000)
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", url, func(req *http.Request) (*http.Response, error) {
+		var query DataQuery
+		if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+			return nil, err
+		}
+		if query.Start != start.UnixMilli() {
+			return httpmock.NewStringResponse(500, "chunk failed"), nil
+		}
+		return httpmock.NewJsonResponse(200, exampleTestData)
+	})
+	httpmock.ActivateNonDefault(client.client.GetClient())
+	defer httpmock.Deactivate()
+
+	options := DataQueryOptions{
+		MeasurementObid: 5555,
+		Metrics:         []string{"SNMP_1"},
+		Start:           start,
+		End:             start.Add(300 * time.Minute),
+		Average:         60_000,
+	}
+
+	actual, err := client.FetchDataForMetrics(context.Background(), options)
+	assert.Nil(t, actual, "result should be nil in case of an error")
+	require.EqualError(t, err, "retrieving metric data for measurement 5555 failed: status code: 500, response: chunk failed")
+}
+
+func TestMergeDataSeries(t *testing.T) {
+	now := time.Now()
+	chunks := []map[string]MetricDataSeries{
+		{"SNMP_1": {{Time: now.Add(time.Minute), Avg: 2}, {Time: now, Avg: 1}}, "SNMP_2": {{Time: now, Avg: 10}}},
+		{"SNMP_1": {{Time: now.Add(time.Minute), Avg: 3}, {Time: now.Add(2 * time.Minute), Avg: 4}}},
+	}
+	got := mergeDataSeries(chunks)
+	want := map[string]MetricDataSeries{
+		"SNMP_1": {{Time: now, Avg: 1}, {Time: now.Add(time.Minute), Avg: 2}, {Time: now.Add(2 * time.Minute), Avg: 4}},
+		"SNMP_2": {{Time: now, Avg: 10}},
+	}
+	assert.Equal(t, want, got, "merged series wrong")
+}
+
+func TestParseStatisticByteSlice_NullsAndOrder(t *testing.T) {
+	payload := `{"values": [{"metricKey": "SNMP_1", "unit": "s", "data": [
+		{"timestamp": 120000, "interval": 60000, "min": null, "avg": 2, "max": 3},
+		{"timestamp": 60000, "interval": 60000, "min": 0, "avg": 1, "max": null},
+		{"timestamp": 180000, "interval": 60000, "missingInterval": 60000, "min": null, "avg": null, "max": null}
+	]}]}`
+	got, err := parseStatisticByteSlice([]byte(payload))
+	require.NoError(t, err, "no error expected")
+	want := MetricDataSeries{
+		{Time: time.UnixMilli(60000), Interval: time.Minute, Min: 0, Avg: 1, Max: 1},
+		{Time: time.UnixMilli(120000), Interval: time.Minute, Min: 2, Avg: 2, Max: 3},
+	}
+	assert.Equal(t, want, got["SNMP_1"], "entries should be sorted, gaps dropped and missing values default to the average")
+}
+
+func TestClientImpl_FetchDataForMeasurements(t *testing.T) {
+	client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443", Username: "infosim", Password: "stablenet"})
+	url := "https://127.0.0.1:5443/api/1/measurement-data?$top=100"
+
+	var received []MultiMeasurementDataQuery
+	var mutex sync.Mutex
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", url, func(req *http.Request) (*http.Response, error) {
+		var query MultiMeasurementDataQuery
+		if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+			return nil, err
+		}
+		mutex.Lock()
+		received = append(received, query)
+		mutex.Unlock()
+		result := make([]MeasurementResultDataDTO, 0, len(query.Measurements))
+		for _, measurement := range query.Measurements {
+			values := make([]MeasurementMetricResultDataDTO, 0, len(measurement.Metrics))
+			for _, key := range measurement.Metrics {
+				values = append(values, MeasurementMetricResultDataDTO{MetricKey: key, Data: []MeasurementDataEntryDTO{
+					{Timestamp: query.Start, Interval: query.Average, Min: f(float64(measurement.MeasurementObid)), Avg: f(1), Max: f(1)},
+				}})
+			}
+			result = append(result, MeasurementResultDataDTO{MeasurementObid: measurement.MeasurementObid, Values: values})
+		}
+		return httpmock.NewJsonResponse(200, result)
+	})
+	httpmock.ActivateNonDefault(client.client.GetClient())
+	defer httpmock.Deactivate()
+
+	start := time.UnixMilli(1_600_000_000_000)
+	options := MultiDataQueryOptions{
+		Metrics: map[int][]string{2: {"SNMP_2"}, 1: {"SNMP_1", "SNMP_2"}},
+		Start:   start,
+		End:     start.Add(200 * time.Minute),
+		Average: 60_000,
+	}
+
+	actual, err := client.FetchDataForMeasurements(context.Background(), options)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(received), "number of chunk requests")
+	assert.Equal(t, []MeasurementMetricsDTO{{MeasurementObid: 1, Metrics: []string{"SNMP_1", "SNMP_2"}}, {MeasurementObid: 2, Metrics: []string{"SNMP_2"}}}, received[0].Measurements, "requested measurements")
+	require.Equal(t, 2, len(actual), "number of measurements")
+	assert.Equal(t, 2, len(actual[1]), "number of metrics of measurement 1")
+	require.Equal(t, 2, len(actual[2]["SNMP_2"]), "entries of both chunks should be merged")
+	assert.Equal(t, start, actual[2]["SNMP_2"][0].Time, "time of first entry")
+	assert.Equal(t, start.Add(100*time.Minute), actual[2]["SNMP_2"][1].Time, "time of second entry")
+	assert.Equal(t, 2.0, actual[2]["SNMP_2"][0].Min, "data should belong to the right measurement")
+}
+
+func TestClientImpl_FetchDataForMeasurements_Error(t *testing.T) {
+	url := "https://127.0.0.1:5443/api/1/measurement-data?$top=100"
+
+	options := MultiDataQueryOptions{
+		Metrics: map[int][]string{5555: {"1"}, 5556: {"2"}},
+		Start:   time.Now(),
+		End:     time.Now().Add(5 * time.Minute),
+	}
+
+	shouldReturnError := func(client *StableNetClient) (i interface{}, e error) {
+		return client.FetchDataForMeasurements(context.Background(), options)
+	}
+
+	t.Run("json error", invalidJsonTest(shouldReturnError, "POST", url))
+	t.Run("status error", wrongStatusResponseTest(shouldReturnError, "POST", url, "metric data for measurements [5555 5556]"))
+	t.Run("rest error", errorResponseTest(shouldReturnError, "POST", url, "metric data for measurements [5555 5556]"))
+}
+
+func TestClientImpl_RequestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	client := NewStableNetClient(&ConnectOptions{Address: "https://127.0.0.1:5443", Username: "infosim", Password: "stablenet"}, WithRegisterer(registry))
+	url := "https://127.0.0.1:5443/api/1/measurement-data/1643/metrics?$top=100"
+
+	httpmock.Activate()
+	httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(404, "entity not found"))
+	httpmock.ActivateNonDefault(client.client.GetClient())
+	defer httpmock.Deactivate()
+
+	_, _ = client.FetchMetricsForMeasurement(context.Background(), 1643)
+	_, _ = client.FetchMetricsForMeasurement(context.Background(), 1643)
+	assert.Equal(t, 2.0, testutil.ToFloat64(client.metrics.requests.WithLabelValues("metrics", "404")), "requests should be counted by endpoint and status")
+
+	httpmock.RegisterResponder("GET", url, httpmock.NewErrorResponder(errors.New("connection refused")))
+	_, _ = client.FetchMetricsForMeasurement(context.Background(), 1643)
+	assert.Equal(t, 1.0, testutil.ToFloat64(client.metrics.requests.WithLabelValues("metrics", "error")), "failed requests should be counted as error")
+
+	count, err := testutil.GatherAndCount(registry, "stablenet_datasource_stablenet_requests_total")
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, 2, count, "the counter should be registered at the registry")
+
+	other := NewClient("https://127.0.0.1:5443", WithRegisterer(registry))
+	assert.Same(t, client.metrics.requests, other.metrics.requests, "clients with the same registry should share the counter")
+	assert.Same(t, client.metrics.duration, other.metrics.duration, "clients with the same registry should share the histogram")
+}
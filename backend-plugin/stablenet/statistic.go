@@ -0,0 +1,134 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// Statistic is a column of a data series. Min, max and avg are provided by StableNet®, the other statistics are
+// derived from the averages of the series.
+type Statistic string
+
+const (
+	StatisticMin Statistic = "min"
+	StatisticMax Statistic = "max"
+	StatisticAvg Statistic = "avg"
+	// StatisticLast is the latest average of the whole series, e.g. to show the current value next to the history.
+	StatisticLast Statistic = "last"
+	// StatisticStdDev is the standard deviation of the averages within the sliding window.
+	StatisticStdDev Statistic = "stddev"
+	// StatisticP95 is the 95th percentile of the averages within the sliding window.
+	StatisticP95 Statistic = "p95"
+	// StatisticP99 is the 99th percentile of the averages within the sliding window.
+	StatisticP99 Statistic = "p99"
+)
+
+var statisticColumnNames = map[Statistic]string{
+	StatisticMin:    "Min",
+	StatisticMax:    "Max",
+	StatisticAvg:    "Avg",
+	StatisticLast:   "Last",
+	StatisticStdDev: "StdDev",
+	StatisticP95:    "P95",
+	StatisticP99:    "P99",
+}
+
+// Returns an error if the statistic is unknown.
+func (s Statistic) Validate() error {
+	if _, ok := statisticColumnNames[s]; !ok {
+		return fmt.Errorf("unknown statistic \"%s\"", s)
+	}
+	return nil
+}
+
+// Returns the name of the column of the statistic, e.g. "P95" for StatisticP95.
+func (s Statistic) ColumnName() string {
+	return statisticColumnNames[s]
+}
+
+// Returns the column of the statistic for all entries of the series. The window of an entry contains all entries whose
+// time is within the window duration before the time of the entry, including the entry itself.
+func (s MetricDataSeries) statisticColumn(statistic Statistic, window time.Duration) []float64 {
+	column := make([]float64, len(s))
+	for index, entry := range s {
+		switch statistic {
+		case StatisticMin:
+			column[index] = entry.Min
+		case StatisticMax:
+			column[index] = entry.Max
+		case StatisticAvg:
+			column[index] = entry.Avg
+		case StatisticLast:
+			column[index] = s[len(s)-1].Avg
+		default:
+			column[index] = math.NaN()
+		}
+	}
+	if statistic != StatisticStdDev && statistic != StatisticP95 && statistic != StatisticP99 {
+		return column
+	}
+
+	// the averages of the window are kept sorted while the window slides over the series, such that every entry only
+	// inserts its own average and removes the ones that left the window
+	sorted := make([]float64, 0)
+	first := 0
+	for index, entry := range s {
+		sorted = insertSorted(sorted, entry.Avg)
+		for ; first < index && entry.Time.Sub(s[first].Time) >= window; first++ {
+			sorted = removeSorted(sorted, s[first].Avg)
+		}
+		switch statistic {
+		case StatisticStdDev:
+			column[index] = standardDeviation(sorted)
+		case StatisticP95:
+			column[index] = percentileOfSorted(sorted, 95)
+		case StatisticP99:
+			column[index] = percentileOfSorted(sorted, 99)
+		}
+	}
+	return column
+}
+
+// Inserts the value into the sorted values, where NaN is sorted before all numbers as by sort.Float64s.
+func insertSorted(sorted []float64, value float64) []float64 {
+	index, _ := slices.BinarySearch(sorted, value)
+	return slices.Insert(sorted, index, value)
+}
+
+// Removes the value, which must be one of the sorted values.
+func removeSorted(sorted []float64, value float64) []float64 {
+	index, _ := slices.BinarySearch(sorted, value)
+	return slices.Delete(sorted, index, index+1)
+}
+
+// Computes the percentile of sorted values by linear interpolation between the closest ranks. The values must not be
+// empty.
+func percentileOfSorted(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// Computes the population standard deviation of the values.
+func standardDeviation(values []float64) float64 {
+	mean := 0.0
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+	return math.Sqrt(variance / float64(len(values)))
+}
@@ -0,0 +1,339 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatisticLink is a parsed link to a StableNet® statistic (e.g. the PlotServlet or the analyzer of the web portal).
+// A link can show several measurements, their parameters carry the index of the measurement as prefix, e.g. "1id=3889"
+// and "1value0=1". Parameters without index refer to the first measurement (id, value) or to all measurements (all others).
+type StatisticLink struct {
+	Measurements []LinkMeasurement
+	TimeZone     string
+}
+
+type LinkMeasurement struct {
+	// The index of the measurement in the link.
+	Index int
+	Obid  int
+	// The value keys (data ids) of the requested metrics in the order of their value index. If empty, all metrics of the
+	// measurement are requested.
+	ValueKeys []string
+	// The average of the chart in milliseconds, 0 if the link does not carry one.
+	Average   int64
+	ChartType int
+	// The absolute time range of the chart, zero if the link does not carry one.
+	Start time.Time
+	End   time.Time
+	// The relative time range of the chart ("last"), shifted into the past by Offset. Zero if the link does not carry one.
+	Last   time.Duration
+	Offset time.Duration
+}
+
+// Returns the time range of the measurement's chart. Absolute ranges take precedence over relative ones, which are
+// computed relative to now. If the link does not carry a time range, ok is false.
+func (m *LinkMeasurement) TimeRange(now time.Time) (start time.Time, end time.Time, ok bool) {
+	if !m.Start.IsZero() && !m.End.IsZero() {
+		return m.Start, m.End, true
+	}
+	if m.Last > 0 {
+		end = now.Add(-m.Offset)
+		return end.Add(-m.Last), end, true
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// Parses a statistic link. The link may be percent-encoded as a whole, may carry its parameters in the fragment (as the
+// links of the web portal do), or may consist of the parameters only. The measurements are sorted by their index.
+func ParseStatisticLink(link string) (*StatisticLink, error) {
+	values, err := statisticLinkParameters(link)
+	if err != nil {
+		return nil, err
+	}
+
+	measurements := make(map[int]*LinkMeasurement)
+	measurement := func(index int) *LinkMeasurement {
+		if _, ok := measurements[index]; !ok {
+			measurements[index] = &LinkMeasurement{Index: index}
+		}
+		return measurements[index]
+	}
+	type valueKey struct {
+		index int
+		key   string
+	}
+	valueKeys := make(map[int][]valueKey)
+	defaults := LinkMeasurement{}
+	indexed := make(map[int]url.Values)
+	hasId := make(map[int]bool)
+	result := &StatisticLink{TimeZone: values.Get("tz")}
+
+	// the parameters are handled in the order of their measurement, such that the same link always results in the same
+	// measurements and errors
+	for _, key := range sortedParameterKeys(values) {
+		list := values[key]
+		index, hasIndex, name := splitParameterIndex(key)
+		switch {
+		case name == "id":
+			if hasId[index] || len(list) > 1 {
+				return nil, fmt.Errorf("duplicate measurement id in parameter %s", key)
+			}
+			obid, err := strconv.Atoi(list[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid measurement id \"%s\" in parameter %s", list[0], key)
+			}
+			measurement(index).Obid = obid
+			hasId[index] = true
+		case strings.HasPrefix(name, "value") && isDigits(strings.TrimPrefix(name, "value")):
+			valueIndex, _ := strconv.Atoi(strings.TrimPrefix(name, "value"))
+			for _, value := range list {
+				if !isDigits(value) || len(value) == 0 {
+					return nil, fmt.Errorf("invalid value key \"%s\" in parameter %s", value, key)
+				}
+				valueKeys[index] = append(valueKeys[index], valueKey{index: valueIndex, key: value})
+			}
+		case hasIndex:
+			if _, ok := indexed[index]; !ok {
+				indexed[index] = url.Values{}
+			}
+			indexed[index][name] = list
+		default:
+			if err := applyChartParameter(&defaults, name, list[0]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	indices := make([]int, 0, len(measurements))
+	for index := range measurements {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	for _, index := range indices {
+		m := measurements[index]
+		m.Average, m.ChartType, m.Start, m.End, m.Last, m.Offset = defaults.Average, defaults.ChartType, defaults.Start, defaults.End, defaults.Last, defaults.Offset
+		for _, name := range sortedParameterKeys(indexed[index]) {
+			if err := applyChartParameter(m, name, indexed[index][name][0]); err != nil {
+				return nil, err
+			}
+		}
+		keys := valueKeys[index]
+		sort.SliceStable(keys, func(i, j int) bool { return keys[i].index < keys[j].index })
+		m.ValueKeys = make([]string, 0, len(keys))
+		for _, key := range keys {
+			if !containsString(m.ValueKeys, key.key) {
+				m.ValueKeys = append(m.ValueKeys, key.key)
+			}
+		}
+		if m.Obid != 0 {
+			result.Measurements = append(result.Measurements, *m)
+		}
+	}
+	return result, nil
+}
+
+// Returns the keys of the parameters sorted by the index of their measurement and then by name. Parameters without index
+// come first, "id" precedes "0id".
+func sortedParameterKeys(values url.Values) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		indexI, hasIndexI, nameI := splitParameterIndex(keys[i])
+		indexJ, hasIndexJ, nameJ := splitParameterIndex(keys[j])
+		if hasIndexI != hasIndexJ {
+			return !hasIndexI
+		}
+		if indexI != indexJ {
+			return indexI < indexJ
+		}
+		if nameI != nameJ {
+			return nameI < nameJ
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// Extracts the query parameters of the link, including the ones in the fragment.
+func statisticLinkParameters(link string) (url.Values, error) {
+	link = strings.TrimSpace(strings.ReplaceAll(link, "&amp;", "&"))
+	// the whole link is percent-encoded, e.g. when it was copied from another url
+	if !strings.Contains(link, "?") && strings.Contains(strings.ToLower(link), "%3f") {
+		if unescaped, err := url.QueryUnescape(link); err == nil {
+			link = unescaped
+		}
+	}
+	// the link consists of parameters only
+	if !strings.Contains(link, "?") && strings.Contains(link, "=") {
+		link = "?" + strings.TrimLeft(link, "&")
+	}
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("the link could not be parsed: %w", err)
+	}
+	values, err := url.ParseQuery(parsed.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("the parameters of the link could not be parsed: %w", err)
+	}
+	if _, fragmentQuery, found := strings.Cut(parsed.Fragment, "?"); found {
+		fragmentValues, err := url.ParseQuery(fragmentQuery)
+		if err != nil {
+			return nil, fmt.Errorf("the parameters in the fragment of the link could not be parsed: %w", err)
+		}
+		for key, list := range fragmentValues {
+			values[key] = append(values[key], list...)
+		}
+	}
+	return values, nil
+}
+
+// Applies a parameter describing the chart of a measurement. Unknown parameters are ignored.
+// The parameters "last" and "offset" carry two numbers, days and minutes, which are added up, e.g. "last=0,1440".
+func applyChartParameter(m *LinkMeasurement, name string, value string) error {
+	var err error
+	switch name {
+	case "interval":
+		m.Average, err = strconv.ParseInt(value, 10, 64)
+	case "chart":
+		m.ChartType, err = strconv.Atoi(value)
+	case "start":
+		m.Start, err = parseMillis(value)
+	case "end":
+		m.End, err = parseMillis(value)
+	case "last":
+		m.Last, err = parseDaysMinutes(value)
+	case "offset":
+		m.Offset, err = parseDaysMinutes(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value \"%s\" of parameter %s: %w", value, name, err)
+	}
+	return nil
+}
+
+func parseMillis(value string) (time.Time, error) {
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(millis), nil
+}
+
+func parseDaysMinutes(value string) (time.Duration, error) {
+	daysStr, minutesStr, found := strings.Cut(value, ",")
+	if !found {
+		minutesStr, daysStr = daysStr, "0"
+	}
+	days, err := strconv.Atoi(strings.TrimSpace(daysStr))
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.Atoi(strings.TrimSpace(minutesStr))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(days)*24*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// Splits a parameter like "12value3" into the measurement index 12 and the name "value3".
+func splitParameterIndex(key string) (index int, hasIndex bool, name string) {
+	digits := strings.IndexFunc(key, func(r rune) bool { return !isDigit(r) })
+	if digits <= 0 {
+		return 0, false, key
+	}
+	index, _ = strconv.Atoi(key[:digits])
+	return index, true, key[digits:]
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isDigits(value string) bool {
+	for _, r := range value {
+		if !isDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, value string) bool {
+	for _, element := range list {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the measurements of the link, each at most once. A link may show the same measurement several times, such
+// measurements are merged into the first one, which requests all metrics if any of the merged ones does.
+func (l *StatisticLink) MergedMeasurements() []LinkMeasurement {
+	result := make([]LinkMeasurement, 0, len(l.Measurements))
+	positions := make(map[int]int)
+	for _, measurement := range l.Measurements {
+		position, ok := positions[measurement.Obid]
+		if !ok {
+			positions[measurement.Obid] = len(result)
+			result = append(result, measurement)
+			continue
+		}
+		merged := &result[position]
+		if len(merged.ValueKeys) == 0 || len(measurement.ValueKeys) == 0 {
+			merged.ValueKeys = nil
+			continue
+		}
+		valueKeys := append([]string(nil), merged.ValueKeys...)
+		for _, valueKey := range measurement.ValueKeys {
+			if !containsString(valueKeys, valueKey) {
+				valueKeys = append(valueKeys, valueKey)
+			}
+		}
+		merged.ValueKeys = valueKeys
+	}
+	return result
+}
+
+// Returns the metric key without its source prefix, e.g. "1000" for "SNMP_1000" or "SNMP1000".
+func KeySuffix(metricKey string) string {
+	start := 0
+	for start < len(metricKey) && !isDigit(rune(metricKey[start])) && metricKey[start] != '_' {
+		start++
+	}
+	if start < len(metricKey) && metricKey[start] == '_' {
+		start++
+	}
+	return metricKey[start:]
+}
+
+// Selects the metrics requested by the value keys of a link measurement, each metric at most once. A value key refers
+// to the metric key without its source prefix, e.g. the value key "1" matches "SNMP_1" but neither "SNMP_11" nor
+// "SNMP_21". If no value keys are given, all metrics are selected.
+func SelectLinkMetrics(valueKeys []string, metrics []Metric) []Metric {
+	result := make([]Metric, 0, len(metrics))
+	selected := make(map[string]bool, len(metrics))
+	for _, metric := range metrics {
+		if selected[metric.Key] || (len(valueKeys) > 0 && !containsString(valueKeys, KeySuffix(metric.Key))) {
+			continue
+		}
+		selected[metric.Key] = true
+		result = append(result, metric)
+	}
+	return result
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatisticLink(t *testing.T) {
+	day := 24 * time.Hour
+	cases := []struct {
+		name string
+		link string
+		want StatisticLink
+	}{
+		{
+			name: "one measurement without index",
+			link: "stablenet.de/?id=33",
+			want: StatisticLink{Measurements: []LinkMeasurement{{Index: 0, Obid: 33, ValueKeys: []string{}}}},
+		},
+		{
+			name: "parameters only",
+			link: "&id=10000",
+			want: StatisticLink{Measurements: []LinkMeasurement{{Index: 0, Obid: 10000, ValueKeys: []string{}}}},
+		},
+		{
+			name: "chart before id",
+			link: "stablenet.de/?chart=555&id=34",
+			want: StatisticLink{Measurements: []LinkMeasurement{{Index: 0, Obid: 34, ChartType: 555, ValueKeys: []string{}}}},
+		},
+		{
+			name: "plot servlet with one measurement and several metrics",
+			link: "https://localhost:5443/PlotServlet?id=1643&chart=5504&last=0,1440&offset=0,0&tz=Europe%2FBerlin&value0=1002&value1=1000&value2=1001",
+			want: StatisticLink{TimeZone: "Europe/Berlin", Measurements: []LinkMeasurement{
+				{Index: 0, Obid: 1643, ChartType: 5504, Last: day, ValueKeys: []string{"1002", "1000", "1001"}},
+			}},
+		},
+		{
+			name: "value indices define the order",
+			link: "https://localhost:5443/PlotServlet?id=1643&value2=1001&value0=1002&value1=1000",
+			want: StatisticLink{Measurements: []LinkMeasurement{{Index: 0, Obid: 1643, ValueKeys: []string{"1002", "1000", "1001"}}}},
+		},
+		{
+			name: "several measurements",
+			link: "https://localhost:5443/PlotServlet?multicharttype=0&dns=1&log=0&width=1252&height=1126&quality=-1.0&0last=0,1440&0offset=0,0&0interval=60000&0id=1643&0chart=5504&0value0=1000&0value1=1001&0value2=1002&1last=0,1440&1offset=0,0&1interval=60000&1id=3889&1chart=5504&1value0=1",
+			want: StatisticLink{Measurements: []LinkMeasurement{
+				{Index: 0, Obid: 1643, ChartType: 5504, Average: 60000, Last: day, ValueKeys: []string{"1000", "1001", "1002"}},
+				{Index: 1, Obid: 3889, ChartType: 5504, Average: 60000, Last: day, ValueKeys: []string{"1"}},
+			}},
+		},
+		{
+			name: "ping measurements without value keys",
+			link: "https://localhost:5443/PlotServlet?multicharttype=0&dns=1&log=0&width=1252&height=1126&quality=-1.0&0last=0,1440&0offset=0,0&0interval=60000&0id=3088&0chart=100&1last=2,60&1offset=1,0&1interval=300000&1id=7228&1chart=100&tz=Europe/Berlin",
+			want: StatisticLink{TimeZone: "Europe/Berlin", Measurements: []LinkMeasurement{
+				{Index: 0, Obid: 3088, ChartType: 100, Average: 60000, Last: day, ValueKeys: []string{}},
+				{Index: 1, Obid: 7228, ChartType: 100, Average: 300000, Last: 2*day + time.Hour, Offset: day, ValueKeys: []string{}},
+			}},
+		},
+		{
+			name: "indices in any order",
+			link: "stablenet.de/?chart=555&1id=34&1value1=1000&0value1=2000&0id=56&1value0=1001",
+			want: StatisticLink{Measurements: []LinkMeasurement{
+				{Index: 0, Obid: 56, ChartType: 555, ValueKeys: []string{"2000"}},
+				{Index: 1, Obid: 34, ChartType: 555, ValueKeys: []string{"1001", "1000"}},
+			}},
+		},
+		{
+			name: "id without index is the first measurement",
+			link: "stablenet.de/?chart=555&id=34&0value1=1000&0value1=2000&1id=56&1value0=1001",
+			want: StatisticLink{Measurements: []LinkMeasurement{
+				{Index: 0, Obid: 34, ChartType: 555, ValueKeys: []string{"1000", "2000"}},
+				{Index: 1, Obid: 56, ChartType: 555, ValueKeys: []string{"1001"}},
+			}},
+		},
+		{
+			name: "other parameters ending with id",
+			link: "https://localhost:5443/PlotServlet?deviceid=1024&id=1643&chartid=4&value0=1",
+			want: StatisticLink{Measurements: []LinkMeasurement{{Index: 0, Obid: 1643, ValueKeys: []string{"1"}}}},
+		},
+		{
+			name: "absolute time range",
+			link: "https://localhost:5443/PlotServlet?id=1643&start=1574839083813&end=1574840883813&interval=300000",
+			want: StatisticLink{Measurements: []LinkMeasurement{{Index: 0, Obid: 1643, Average: 300000, Start: time.UnixMilli(1574839083813), End: time.UnixMilli(1574840883813), ValueKeys: []string{}}}},
+		},
+		{
+			name: "percent-encoded link",
+			link: "https%3A%2F%2Flocalhost%3A5443%2FPlotServlet%3Fid%3D1643%26value0%3D1002%26tz%3DEurope%252FBerlin",
+			want: StatisticLink{TimeZone: "Europe/Berlin", Measurements: []LinkMeasurement{{Index: 0, Obid: 1643, ValueKeys: []string{"1002"}}}},
+		},
+		{
+			name: "html-escaped link",
+			link: "https://localhost:5443/PlotServlet?id=1643&amp;value0=1002",
+			want: StatisticLink{Measurements: []LinkMeasurement{{Index: 0, Obid: 1643, ValueKeys: []string{"1002"}}}},
+		},
+		{
+			name: "parameters in fragment",
+			link: "https://localhost:5443/web/#/statistic?0id=1643&0value0=1002&1id=3889",
+			want: StatisticLink{Measurements: []LinkMeasurement{
+				{Index: 0, Obid: 1643, ValueKeys: []string{"1002"}},
+				{Index: 1, Obid: 3889, ValueKeys: []string{}},
+			}},
+		},
+		{
+			name: "values without measurement are ignored",
+			link: "https://localhost:5443/PlotServlet?0id=1643&1value0=1",
+			want: StatisticLink{Measurements: []LinkMeasurement{{Index: 0, Obid: 1643, ValueKeys: []string{}}}},
+		},
+		{
+			name: "no measurement",
+			link: "not a link",
+			want: StatisticLink{},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStatisticLink(tt.link)
+			require.NoError(t, err, "no error expected")
+			assert.Equal(t, tt.want, *got, "parsed link wrong")
+		})
+	}
+}
+
+func TestParseStatisticLink_Error(t *testing.T) {
+	cases := []struct {
+		name    string
+		link    string
+		wantErr string
+	}{
+		{name: "invalid id", link: "https://localhost:5443/PlotServlet?id=abc", wantErr: "invalid measurement id \"abc\" in parameter id"},
+		{name: "invalid value key", link: "https://localhost:5443/PlotServlet?id=1&2value0=x", wantErr: "invalid value key \"x\" in parameter 2value0"},
+		{name: "invalid interval", link: "https://localhost:5443/PlotServlet?id=1&0interval=x", wantErr: "invalid value \"x\" of parameter interval: strconv.ParseInt: parsing \"x\": invalid syntax"},
+		{name: "invalid last", link: "https://localhost:5443/PlotServlet?id=1&last=a,5", wantErr: "invalid value \"a,5\" of parameter last: strconv.Atoi: parsing \"a\": invalid syntax"},
+		{name: "duplicate id", link: "https://localhost:5443/PlotServlet?id=1&0id=2", wantErr: "duplicate measurement id in parameter 0id"},
+		{name: "repeated id", link: "https://localhost:5443/PlotServlet?1id=1&1id=2", wantErr: "duplicate measurement id in parameter 1id"},
+		{name: "id in fragment", link: "https://localhost:5443/PlotServlet?1id=1#/statistic?1id=2", wantErr: "duplicate measurement id in parameter 1id"},
+		{name: "first invalid parameter", link: "https://localhost:5443/PlotServlet?2id=x&1id=y&0id=z", wantErr: "invalid measurement id \"z\" in parameter 0id"},
+		{name: "invalid escaping", link: "https://localhost:5443/PlotServlet?id=%zz", wantErr: "the parameters of the link could not be parsed: invalid URL escape \"%zz\""},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStatisticLink(tt.link)
+			assert.Nil(t, got, "result should be nil in case of an error")
+			assert.EqualError(t, err, tt.wantErr, "error message wrong")
+		})
+	}
+}
+
+func TestLinkMeasurement_TimeRange(t *testing.T) {
+	now := time.Now()
+	start := now.Add(-5 * time.Hour)
+	end := now.Add(-time.Hour)
+
+	tests := []struct {
+		name        string
+		measurement LinkMeasurement
+		wantStart   time.Time
+		wantEnd     time.Time
+		wantOk      bool
+	}{
+		{name: "no range", measurement: LinkMeasurement{}, wantOk: false},
+		{name: "absolute", measurement: LinkMeasurement{Start: start, End: end, Last: time.Hour}, wantStart: start, wantEnd: end, wantOk: true},
+		{name: "relative", measurement: LinkMeasurement{Last: 2 * time.Hour}, wantStart: now.Add(-2 * time.Hour), wantEnd: now, wantOk: true},
+		{name: "relative with offset", measurement: LinkMeasurement{Last: 2 * time.Hour, Offset: time.Hour}, wantStart: now.Add(-3 * time.Hour), wantEnd: now.Add(-time.Hour), wantOk: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStart, gotEnd, ok := tt.measurement.TimeRange(now)
+			assert.Equal(t, tt.wantOk, ok, "ok wrong")
+			assert.Equal(t, tt.wantStart, gotStart, "start wrong")
+			assert.Equal(t, tt.wantEnd, gotEnd, "end wrong")
+		})
+	}
+}
+
+func TestStatisticLink_MergedMeasurements(t *testing.T) {
+	link := StatisticLink{Measurements: []LinkMeasurement{
+		{Index: 0, Obid: 10, ValueKeys: []string{"1"}},
+		{Index: 1, Obid: 20, ValueKeys: []string{"1"}},
+		{Index: 2, Obid: 10, ValueKeys: []string{"2", "1"}},
+		{Index: 3, Obid: 20},
+		{Index: 4, Obid: 30, ValueKeys: []string{"3"}},
+	}}
+	got := link.MergedMeasurements()
+	want := []LinkMeasurement{
+		{Index: 0, Obid: 10, ValueKeys: []string{"1", "2"}},
+		{Index: 1, Obid: 20},
+		{Index: 4, Obid: 30, ValueKeys: []string{"3"}},
+	}
+	assert.Equal(t, want, got, "merged measurements wrong")
+	assert.Equal(t, []string{"1"}, link.Measurements[0].ValueKeys, "the original measurements must not be modified")
+}
+
+func TestKeySuffix(t *testing.T) {
+	tests := map[string]string{"SNMP_1000": "1000", "SNMP1000": "1000", "EXTERN_2": "2", "1000": "1000", "SNMP_": "", "custom_key_1": "key_1"}
+	for key, want := range tests {
+		assert.Equal(t, want, KeySuffix(key), "suffix of %s wrong", key)
+	}
+}
+
+func TestSelectLinkMetrics(t *testing.T) {
+	cases := []struct {
+		name      string
+		metrics   []Metric
+		valueKeys []string
+		wanted    []int
+	}{
+		{name: "Wanted Metrics 1", metrics: []Metric{{Name: "Uptime", Key: "SNMP1001"}, {Name: "Processes", Key: "SNMP1000"}, {Name: "Users", Key: "SNMP1002"}}, valueKeys: []string{"1001", "1002"}, wanted: []int{0, 2}},
+		{name: "Wanted Metrics 2", metrics: []Metric{{Name: "Uptime", Key: "SNMP1010"}, {Name: "Processes", Key: "SNMP1020"}}, valueKeys: []string{"1000"}, wanted: []int{}},
+		{name: "Wanted Metrics is empty", metrics: []Metric{{Name: "Uptime", Key: "SNMP1010"}, {Name: "Processes", Key: "SNMP1020"}}, valueKeys: []string{}, wanted: []int{0, 1}},
+		{name: "No partial matches", metrics: []Metric{{Name: "In", Key: "SNMP_1"}, {Name: "Out", Key: "SNMP_11"}, {Name: "Errors", Key: "SNMP_21"}}, valueKeys: []string{"1"}, wanted: []int{0}},
+		{name: "No duplicates", metrics: []Metric{{Name: "In", Key: "SNMP_1"}, {Name: "Out", Key: "SNMP_2"}, {Name: "In", Key: "SNMP_1"}}, valueKeys: []string{"1", "1", "2"}, wanted: []int{0, 1}},
+		{name: "Different sources", metrics: []Metric{{Name: "In", Key: "SNMP_5"}, {Name: "Script", Key: "EXTERN_5"}, {Name: "Other", Key: "EXTERN_15"}}, valueKeys: []string{"5"}, wanted: []int{0, 1}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			want := make([]Metric, 0, len(tt.wanted))
+			for _, index := range tt.wanted {
+				want = append(want, tt.metrics[index])
+			}
+			assert.Equal(t, want, SelectLinkMetrics(tt.valueKeys, tt.metrics), "selected metrics wrong")
+		})
+	}
+}
+
+func TestExpandStatisticLink(t *testing.T) {
+	missing := errors.New("measurement 6000 not found")
+	metricSupplier := func(measurementObid int) ([]Metric, error) {
+		switch measurementObid {
+		case 4000:
+			return []Metric{{Key: "SNMP_1", Name: "In"}, {Key: "SNMP_2", Name: "Out"}}, nil
+		case 5000:
+			return []Metric{{Key: "SNMP_3", Name: "Up"}}, nil
+		}
+		return nil, missing
+	}
+
+	t.Run("success", func(t *testing.T) {
+		got, err := ExpandStatisticLink("?0id=4000&0value0=2&1id=5000&1value0=1&2id=6000", metricSupplier)
+		require.NoError(t, err, "no error expected")
+		require.Equal(t, 3, len(got), "number of measurements")
+		assert.Equal(t, 4000, got[0].Obid, "obid of first measurement")
+		assert.Equal(t, []Metric{{Key: "SNMP_2", Name: "Out"}}, got[0].Metrics, "the requested metric should be selected")
+		assert.Empty(t, got[1].Metrics, "the measurement has none of the requested metrics")
+		assert.NoError(t, got[1].Err, "a measurement without requested metrics is no error")
+		assert.ErrorIs(t, got[2].Err, missing, "the error of the supplier should be kept")
+	})
+	errorTests := []struct {
+		name    string
+		link    string
+		wantErr string
+	}{
+		{name: "no measurement", link: "https://localhost:5443/PlotServlet", wantErr: "the link \"https://localhost:5443/PlotServlet\" does not carry at least a measurement id"},
+		{name: "invalid measurement id", link: "?id=abc", wantErr: "the link \"?id=abc\" is invalid: invalid measurement id \"abc\" in parameter id"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ExpandStatisticLink(tt.link, metricSupplier)
+			var linkErr *LinkError
+			assert.ErrorAs(t, err, &linkErr, "a link error expected")
+			assert.EqualError(t, err, tt.wantErr, "error message wrong")
+		})
+	}
+}
@@ -8,9 +8,13 @@
 package stablenet
 
 import (
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// CollectionDTO is a page of entities returned by the JSON API of StableNet®. HasMore is true if there are further pages.
 type CollectionDTO[T any] struct {
 	Count       int  `json:"count"`
 	FilterCount int  `json:"filterCount"`
@@ -18,25 +22,56 @@ type CollectionDTO[T any] struct {
 	HasMore     bool `json:"hasMore"`
 }
 
+// Device is a device of the StableNet® inventory. The attributes besides the name and obid are empty if StableNet® has
+// no value for them. The vendor is the IANA enterprise number of the vendor.
 type Device struct {
-	Name string `json:"name"`
-	Obid int    `json:"obid"`
+	Name      string `json:"name"`
+	Obid      int    `json:"obid"`
+	IPAddress string `json:"ip,omitempty"`
+	Model     string `json:"model,omitempty"`
+	Vendor    int    `json:"vendor,omitempty"`
 }
 
+// DeviceQueryResult is a page of devices.
 type DeviceQueryResult CollectionDTO[Device]
 
+// Measurement is a measurement of StableNet®. The DeviceObid is 0 if the measurement has no destination device. The
+// type is the kind of measurement, e.g. "snmpInterface", "snmpTemplate" or "ping".
 type Measurement struct {
-	Name string `json:"name"`
-	Obid int    `json:"obid"`
+	Name       string `json:"name"`
+	Obid       int    `json:"obid"`
+	DeviceObid int    `json:"destDeviceId,omitempty"`
+	Type       string `json:"type,omitempty"`
 }
 
+// MeasurementQueryResult is a page of measurements.
 type MeasurementQueryResult CollectionDTO[Measurement]
 
+// Metric is a value measured by a measurement. The key identifies the metric when its data is requested. The unit is
+// the one StableNet® displays, e.g. "%", "ms" or "bit/s", and the interval is the polling interval of the measurement in
+// milliseconds, which StableNet® calls the expected interval.
 type Metric struct {
-	Name string `json:"name"`
-	Key  string `json:"key"`
+	Name        string `json:"name"`
+	Key         string `json:"key"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Interval    int64  `json:"expectedInterval,omitempty"`
+}
+
+// PollingInterval returns the shortest interval of the metrics, which is the finest resolution StableNet® stores for
+// the measurement. It is zero if no metric has an interval.
+func PollingInterval(metrics []Metric) time.Duration {
+	var result time.Duration
+	for _, metric := range metrics {
+		interval := time.Duration(metric.Interval) * time.Millisecond
+		if interval > 0 && (result == 0 || interval < result) {
+			result = interval
+		}
+	}
+	return result
 }
 
+// MetricData is a single entry of a data series with the statistics of one interval.
 type MetricData struct {
 	Interval time.Duration
 	Time     time.Time
@@ -45,38 +80,71 @@ type MetricData struct {
 	Avg      float64
 }
 
+// MetricDataSeries is the data of a metric, sorted by time.
 type MetricDataSeries []MetricData
 
-// Returns the data series as two-dimensional array of interfaces. The columns are as follows:
-// time, min, max avg. The columns min max avg are only present, if the respective parameter is true.
-func (s MetricDataSeries) AsTable(min, max, avg bool) [][]interface{} {
+// Returns the data series as two-dimensional array of interfaces. The first column is the time, followed by a column for
+// each of the statistics in the given order. Windowed statistics are computed over the window preceding each row.
+func (s MetricDataSeries) AsTable(statistics []Statistic, window time.Duration) [][]interface{} {
+	columns := make([][]float64, 0, len(statistics))
+	for _, statistic := range statistics {
+		columns = append(columns, s.statisticColumn(statistic, window))
+	}
 	table := make([][]interface{}, 0, len(s))
-	for _, data := range s {
-		row := make([]interface{}, 0, 4)
+	for index, data := range s {
+		row := make([]interface{}, 0, len(statistics)+1)
 		row = append(row, data.Time)
-		if min {
-			row = append(row, data.Min)
-		}
-		if max {
-			row = append(row, data.Max)
-		}
-		if avg {
-			row = append(row, data.Avg)
+		for _, column := range columns {
+			row = append(row, column[index])
 		}
 		table = append(table, row)
 	}
 	return table
 }
 
+// ServerInfo is the version and the license of a StableNet® server.
 type ServerInfo struct {
 	ServerVersion ServerVersion `xml:"serverversion"`
 	License       License       `xml:"license"`
 }
 
+// Grafana® is supported by StableNet® 9 and later.
+var supportedVersionRegex = regexp.MustCompile(`^(?:9|[1-9]\d)\.`)
+
+// Returns an error wrapping ErrUnsupportedVersion or ErrLicense if the server cannot be used by the plugin.
+func (i *ServerInfo) CheckCompatibility() error {
+	if !supportedVersionRegex.MatchString(i.ServerVersion.Version) {
+		return &VersionError{Version: i.ServerVersion.Version}
+	}
+	if !i.License.Modules.IsRestReportingLicensed() {
+		return ErrLicense
+	}
+	return nil
+}
+
+// The first major version of StableNet® that is expected to offer the multi-measurement data endpoint.
+const multiMeasurementDataMinVersion = 11
+
+// Returns whether the multi-measurement data endpoint is expected to be available. Callers should fall back to single
+// requests if the endpoint is not found nevertheless.
+func (i *ServerInfo) SupportsMultiMeasurementData() bool {
+	return i.ServerVersion.Major() >= multiMeasurementDataMinVersion
+}
+
 type ServerVersion struct {
 	Version string `xml:"version,attr"`
 }
 
+// Returns the major version of the server, e.g. 9 for "9.0.2". If the version cannot be parsed, 0 is returned.
+func (v ServerVersion) Major() int {
+	major, _, _ := strings.Cut(v.Version, ".")
+	result, err := strconv.Atoi(major)
+	if err != nil {
+		return 0
+	}
+	return result
+}
+
 type License struct {
 	Modules Modules `xml:"modules"`
 }
@@ -106,6 +174,8 @@ type DataQuery struct {
 	Average int64    `json:"average"`
 }
 
+// DataQueryOptions requests the data of some metrics of a measurement. The average is given in milliseconds, if it is
+// 0, StableNet® chooses the average.
 type DataQueryOptions struct {
 	MeasurementObid int
 	Metrics         []string
@@ -114,6 +184,28 @@ type DataQueryOptions struct {
 	Average         int64
 }
 
+// MultiDataQueryOptions requests the data of several measurements. The metrics map the measurement obids to the keys
+// of the requested metrics.
+type MultiDataQueryOptions struct {
+	Metrics map[int][]string
+	Start   time.Time
+	End     time.Time
+	Average int64
+}
+
+type MeasurementMetricsDTO struct {
+	MeasurementObid int      `json:"measurementId"`
+	Metrics         []string `json:"metrics"`
+}
+
+type MultiMeasurementDataQuery struct {
+	Start        int64                   `json:"start"`
+	End          int64                   `json:"end"`
+	Measurements []MeasurementMetricsDTO `json:"measurements"`
+	Raw          bool                    `json:"raw"`
+	Average      int64                   `json:"average"`
+}
+
 type MeasurementDataEntryDTO struct {
 	Timestamp       int64    `json:"timestamp"`
 	Interval        int64    `json:"interval"`
@@ -131,3 +223,8 @@ type MeasurementMetricResultDataDTO struct {
 type MeasurementMultiMetricResultDataDTO struct {
 	Values []MeasurementMetricResultDataDTO `json:"values"`
 }
+
+type MeasurementResultDataDTO struct {
+	MeasurementObid int                              `json:"measurementId"`
+	Values          []MeasurementMetricResultDataDTO `json:"values"`
+}
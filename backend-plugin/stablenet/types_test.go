@@ -8,10 +8,13 @@
 package stablenet
 
 import (
+	"math"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestExampleModules_IsRestReportingLicensed(t *testing.T) {
@@ -34,26 +37,113 @@ func TestMetricDataSeries_AsTable(t *testing.T) {
 	}
 
 	tests := []struct {
-		name string
-		min  bool
-		max  bool
-		avg  bool
-		want [][]interface{}
+		name       string
+		statistics []Statistic
+		want       [][]interface{}
 	}{
-		{name: "all false", want: [][]interface{}{{now}, {five}, {ten}}},
-		{name: "min", min: true, want: [][]interface{}{{now, 1.0}, {five, 2.0}, {ten, 0.0}}},
-		{name: "min,max", min: true, max: true, want: [][]interface{}{{now, 1.0, 101.0}, {five, 2.0, 102.0}, {ten, 0.0, 100.0}}},
-		{name: "min,max,avg", min: true, max: true, avg: true, want: [][]interface{}{{now, 1.0, 101.0, 11.0}, {five, 2.0, 102.0, 12.0}, {ten, 0.0, 100.0, 10.0}}},
-		{name: "min,avg", min: true, avg: true, want: [][]interface{}{{now, 1.0, 11.0}, {five, 2.0, 12.0}, {ten, 0.0, 10.0}}},
-		{name: "max,avg", max: true, avg: true, want: [][]interface{}{{now, 101.0, 11.0}, {five, 102.0, 12.0}, {ten, 100.0, 10.0}}},
-		{name: "avg", avg: true, want: [][]interface{}{{now, 11.0}, {five, 12.0}, {ten, 10.0}}},
-		{name: "max", max: true, want: [][]interface{}{{now, 101.0}, {five, 102.0}, {ten, 100.0}}},
+		{name: "none", want: [][]interface{}{{now}, {five}, {ten}}},
+		{name: "min", statistics: []Statistic{StatisticMin}, want: [][]interface{}{{now, 1.0}, {five, 2.0}, {ten, 0.0}}},
+		{name: "min,max", statistics: []Statistic{StatisticMin, StatisticMax}, want: [][]interface{}{{now, 1.0, 101.0}, {five, 2.0, 102.0}, {ten, 0.0, 100.0}}},
+		{name: "min,max,avg", statistics: []Statistic{StatisticMin, StatisticMax, StatisticAvg}, want: [][]interface{}{{now, 1.0, 101.0, 11.0}, {five, 2.0, 102.0, 12.0}, {ten, 0.0, 100.0, 10.0}}},
+		{name: "avg,min", statistics: []Statistic{StatisticAvg, StatisticMin}, want: [][]interface{}{{now, 11.0, 1.0}, {five, 12.0, 2.0}, {ten, 10.0, 0.0}}},
+		{name: "max", statistics: []Statistic{StatisticMax}, want: [][]interface{}{{now, 101.0}, {five, 102.0}, {ten, 100.0}}},
+		{name: "last", statistics: []Statistic{StatisticLast}, want: [][]interface{}{{now, 10.0}, {five, 10.0}, {ten, 10.0}}},
+		{name: "stddev", statistics: []Statistic{StatisticStdDev}, want: [][]interface{}{{now, 0.0}, {five, 0.5}, {ten, 1.0}}},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := series.AsTable(tt.min, tt.max, tt.avg)
+			got := series.AsTable(tt.statistics, 6*time.Minute)
 			assert.Equal(t, tt.want, got, "computed table is wrong")
 		})
 	}
 }
+
+func TestMetricDataSeries_AsTable_Windowed(t *testing.T) {
+	start := time.Now()
+	series := make(MetricDataSeries, 0, 10)
+	for index := 0; index < 10; index++ {
+		series = append(series, MetricData{Time: start.Add(time.Duration(index) * time.Minute), Avg: float64(index + 1)})
+	}
+
+	got := series.AsTable([]Statistic{StatisticP95, StatisticP99}, 5*time.Minute)
+	require.Equal(t, 10, len(got), "number of rows")
+	assert.Equal(t, []interface{}{start, 1.0, 1.0}, got[0], "the window of the first row only contains the first entry")
+	assert.InDelta(t, 9.8, got[9][1], 1e-9, "p95 of the last window wrong")
+	assert.InDelta(t, 9.96, got[9][2], 1e-9, "p99 of the last window wrong")
+
+	got = series.AsTable([]Statistic{StatisticP95}, 10*time.Minute)
+	assert.InDelta(t, 9.55, got[9][1], 1e-9, "p95 over the whole series wrong")
+}
+
+func TestMetricDataSeries_AsTable_SlidingWindow(t *testing.T) {
+	start := time.Now()
+	averages := []float64{5, 1, 5, math.NaN(), 3, 5, 2}
+	series := make(MetricDataSeries, 0, len(averages))
+	for index, average := range averages {
+		series = append(series, MetricData{Time: start.Add(time.Duration(index) * time.Minute), Avg: average})
+	}
+	// the window covers the entry and the two entries before it, the results are compared to a sort of each window
+	got := series.AsTable([]Statistic{StatisticP95, StatisticStdDev}, 3*time.Minute)
+	require.Equal(t, len(series), len(got), "number of rows")
+	for index := range series {
+		window := append([]float64(nil), averages[max(0, index-2):index+1]...)
+		sort.Float64s(window)
+		rank := 0.95 * float64(len(window)-1)
+		lower, upper := int(math.Floor(rank)), int(math.Ceil(rank))
+		wantP95 := window[lower] + (window[upper]-window[lower])*(rank-float64(lower))
+		assert.InDelta(t, wantP95, got[index][1], 1e-9, "p95 of row %d wrong", index)
+		if wantStdDev := standardDeviation(window); math.IsNaN(wantStdDev) {
+			assert.True(t, math.IsNaN(got[index][2].(float64)), "stddev of row %d should be NaN", index)
+		} else {
+			assert.InDelta(t, wantStdDev, got[index][2], 1e-9, "stddev of row %d wrong", index)
+		}
+	}
+}
+
+func TestStatistic_Validate(t *testing.T) {
+	for _, statistic := range []Statistic{StatisticMin, StatisticMax, StatisticAvg, StatisticLast, StatisticStdDev, StatisticP95, StatisticP99} {
+		assert.NoError(t, statistic.Validate(), "statistic %s should be valid", statistic)
+		assert.NotEmpty(t, statistic.ColumnName(), "statistic %s should have a column name", statistic)
+	}
+	assert.EqualError(t, Statistic("median").Validate(), "unknown statistic \"median\"", "error message wrong")
+}
+
+func TestServerInfo_SupportsMultiMeasurementData(t *testing.T) {
+	tests := []struct {
+		version   string
+		wantMajor int
+		want      bool
+	}{
+		{version: "9.0.2", wantMajor: 9, want: false},
+		{version: "10.1.0", wantMajor: 10, want: false},
+		{version: "11.0.0", wantMajor: 11, want: true},
+		{version: "12", wantMajor: 12, want: true},
+		{version: "StableNet 11.0.0", wantMajor: 0, want: false},
+		{version: "", wantMajor: 0, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			info := ServerInfo{ServerVersion: ServerVersion{Version: tt.version}}
+			assert.Equal(t, tt.wantMajor, info.ServerVersion.Major(), "major version wrong")
+			assert.Equal(t, tt.want, info.SupportsMultiMeasurementData(), "support of multi-measurement data wrong")
+		})
+	}
+}
+
+func TestPollingInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics []Metric
+		want    time.Duration
+	}{
+		{name: "no metrics", want: 0},
+		{name: "no intervals", metrics: []Metric{{Key: "SNMP_1"}}, want: 0},
+		{name: "shortest interval", metrics: []Metric{{Key: "SNMP_1", Interval: 300000}, {Key: "SNMP_2"}, {Key: "SNMP_3", Interval: 60000}}, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, PollingInterval(tt.metrics), "polling interval wrong")
+		})
+	}
+}
@@ -0,0 +1,25 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+package stablenet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parses the IANA enterprise number StableNet® identifies the vendor of a device by, e.g. 9 for Cisco. The plugin does
+// not know the names of the vendors, thus an error is returned if the text is not a number greater than 0.
+func ParseVendor(text string) (int, error) {
+	text = strings.TrimSpace(text)
+	number, err := strconv.Atoi(text)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("\"%s\" is not an IANA enterprise number, e.g. 9 for Cisco", text)
+	}
+	return number, nil
+}
@@ -5,9 +5,18 @@
  *                  97074 Wuerzburg, Germany
  *                  www.infosim.net
  */
-import { DataSourceInstanceSettings } from '@grafana/data';
+import { DataSourceInstanceSettings, ScopedVars } from '@grafana/data';
 import { DataSourceWithBackend } from '@grafana/runtime';
-import { LabelValue, MetricResult, QueryResult, StableNetConfigOptions, Target } from './types';
+import {
+  LabelValue,
+  LinkResolution,
+  MetricResult,
+  QueryResult,
+  SearchHit,
+  StableNetConfigOptions,
+  Target,
+} from './types';
+import { isQueryModel, toQueryModel } from './queryModel';
 
 interface CollectionDTO<T> {
   hasMore: boolean;
@@ -17,17 +26,49 @@ interface CollectionDTO<T> {
 interface Device {
   obid: number;
   name: string;
+  ip?: string;
+  model?: string;
+  vendor?: number;
 }
 
 interface Measurement {
   obid: number;
   name: string;
+  type?: string;
+}
+
+// The keys that can be used in the search text of devices and measurements, e.g. "router ip:10.1 vendor:9".
+const deviceSearchKeys = ['ip', 'vendor'];
+const measurementSearchKeys = ['type'];
+
+// Splits the search text into the values of the given keys and the filter of the name. Values containing spaces can
+// be quoted, e.g. type:"snmp interface". Unknown keys are part of the name filter.
+export function parseSearchText(text: string, keys: string[]): Record<string, string> {
+  const result: Record<string, string> = {};
+  const filter: string[] = [];
+  for (const [token, key, quoted, plain] of text.matchAll(/(?:(\w+):(?:"([^"]*)"|(\S*)))|\S+/g)) {
+    if (key !== undefined && keys.includes(key.toLowerCase())) {
+      result[key.toLowerCase()] = quoted ?? plain;
+    } else {
+      filter.push(token);
+    }
+  }
+  result.filter = filter.join(' ');
+  return result;
+}
+
+function describe(...attributes: Array<string | undefined>): string | undefined {
+  const present = attributes.filter((attribute) => attribute);
+  return present.length > 0 ? present.join(' · ') : undefined;
 }
 
 interface Metric {
   obid: number;
   key: string;
   name: string;
+  description?: string;
+  unit?: string;
+  pollingInterval?: number;
 }
 
 export class DataSource extends DataSourceWithBackend<Target, StableNetConfigOptions> {
@@ -35,10 +76,24 @@ export class DataSource extends DataSourceWithBackend<Target, StableNetConfigOpt
     super(instanceSettings);
   }
 
+  // Queries that already have a version, e.g. hand-written ones of provisioned dashboards, are sent as they are. The
+  // query editor converts them into a Target once they are edited, see fromQueryModel.
+  applyTemplateVariables(query: Target, scopedVars: ScopedVars): Record<string, any> {
+    if (isQueryModel(query)) {
+      return query;
+    }
+    return { refId: query.refId, hide: query.hide, ...toQueryModel(query) };
+  }
+
   async queryDevices(queryString: string): Promise<QueryResult> {
-    const { data, hasMore }: CollectionDTO<Device> = await super.getResource('devices', { filter: queryString });
+    const params = parseSearchText(queryString, deviceSearchKeys);
+    const { data, hasMore }: CollectionDTO<Device> = await super.getResource('devices', params);
 
-    const res: LabelValue[] = data.map(({ name, obid }) => ({ label: name, value: obid }));
+    const res: LabelValue[] = data.map(({ name, obid, ip, model }) => ({
+      label: name,
+      value: obid,
+      description: describe(ip, model),
+    }));
 
     res.push({ label: 'none', value: -1 });
     return { hasMore, data: res };
@@ -47,15 +102,30 @@ export class DataSource extends DataSourceWithBackend<Target, StableNetConfigOpt
   async findMeasurementsForDevice(deviceObid: number, filter: string): Promise<QueryResult> {
     const { data, hasMore }: CollectionDTO<Measurement> = await super.getResource('measurements', {
       deviceObid,
-      filter,
+      ...parseSearchText(filter, measurementSearchKeys),
     });
 
-    return { hasMore, data: data.map(({ obid, name }) => ({ value: obid, label: name })) };
+    return { hasMore, data: data.map(({ obid, name, type }) => ({ value: obid, label: name, description: type })) };
   }
 
   async findMetricsForMeasurement(obid: number): Promise<MetricResult[]> {
     const result: Metric[] = await super.getResource('metrics', { measurementObid: obid });
 
-    return result.map(({ obid, key, name }) => ({ measurementObid: obid, key, text: name }));
+    return result.map(({ obid, key, name, description, unit, pollingInterval }) => ({
+      measurementObid: obid,
+      key,
+      text: name,
+      unit,
+      description: description,
+      pollingInterval,
+    }));
+  }
+
+  async search(text: string): Promise<SearchHit[]> {
+    return super.getResource('search', { text });
+  }
+
+  async resolveStatisticLink(link: string): Promise<LinkResolution> {
+    return super.getResource('statistic-link', { link });
   }
 }
@@ -0,0 +1,89 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+import React from 'react';
+import { Checkbox, Input, InlineFormLabel, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { Aggregation, AggregationFunction, GroupBy } from '../types';
+
+interface Props {
+  aggregation?: Aggregation;
+  onChange: (aggregation?: Aggregation) => void;
+}
+
+const tooltip =
+  'Combines all series of the query, e.g. all measurements of a statistic link, into one series per group. The series are aligned onto the average period before they are combined.';
+
+const functions: Array<SelectableValue<AggregationFunction>> = [
+  { label: 'sum', value: AggregationFunction.SUM },
+  { label: 'avg', value: AggregationFunction.AVG },
+  { label: 'min', value: AggregationFunction.MIN },
+  { label: 'max', value: AggregationFunction.MAX },
+  { label: 'count', value: AggregationFunction.COUNT },
+  { label: 'percentile', value: AggregationFunction.PERCENTILE },
+];
+
+const groups: Array<SelectableValue<GroupBy>> = [
+  { label: 'no grouping', value: GroupBy.NONE },
+  { label: 'by device', value: GroupBy.DEVICE },
+  { label: 'by measurement', value: GroupBy.MEASUREMENT },
+  { label: 'by metric', value: GroupBy.METRIC },
+];
+
+export function AggregationEditor({ aggregation, onChange }: Props): JSX.Element {
+  return (
+    <div className="gf-form" style={{ display: 'flex', alignItems: 'center' }}>
+      <InlineFormLabel width={11} tooltip={tooltip}>
+        Aggregation:
+      </InlineFormLabel>
+
+      <div style={{ paddingLeft: '2px', paddingRight: '2px' }}>
+        <Checkbox
+          value={!!aggregation}
+          onChange={() => onChange(aggregation ? undefined : { function: AggregationFunction.SUM, keepInputs: false })}
+          tabIndex={0}
+          label={'Aggregate'}
+        />
+      </div>
+
+      {aggregation ? (
+        <>
+          <Select
+            width={14}
+            options={functions}
+            value={aggregation.function}
+            onChange={(v) => onChange({ ...aggregation, function: v.value! })}
+          />
+          {aggregation.function === AggregationFunction.PERCENTILE ? (
+            <Input
+              width={8}
+              type="number"
+              min={0}
+              max={100}
+              value={aggregation.percentile ?? 95}
+              onChange={(e) => onChange({ ...aggregation, percentile: Number(e.currentTarget.value) })}
+            />
+          ) : null}
+          <Select
+            width={18}
+            options={groups}
+            value={aggregation.groupBy || GroupBy.NONE}
+            onChange={(v) => onChange({ ...aggregation, groupBy: v.value })}
+          />
+          <div style={{ paddingLeft: '2px', paddingRight: '2px' }}>
+            <Checkbox
+              value={aggregation.keepInputs}
+              onChange={() => onChange({ ...aggregation, keepInputs: !aggregation.keepInputs })}
+              tabIndex={0}
+              label={'Keep inputs'}
+            />
+          </div>
+        </>
+      ) : null}
+    </div>
+  );
+}
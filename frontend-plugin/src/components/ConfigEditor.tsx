@@ -6,16 +6,16 @@
  *                  www.infosim.net
  */
 import React, { ChangeEvent } from 'react';
-import { InlineField, Input, SecretInput } from '@grafana/ui';
+import { InlineField, InlineSwitch, Input, SecretInput } from '@grafana/ui';
 import { DataSourcePluginOptionsEditorProps } from '@grafana/data';
-import { StableNetSecureJsonData } from '../types';
+import { StableNetConfigOptions, StableNetSecureJsonData } from '../types';
 
-type Props = DataSourcePluginOptionsEditorProps<{}, StableNetSecureJsonData>;
+type Props = DataSourcePluginOptionsEditorProps<StableNetConfigOptions, StableNetSecureJsonData>;
 
 const labelWidth = 15;
 
 export const ConfigEditor = ({ options, onOptionsChange }: Props): JSX.Element => {
-  const { url, user, secureJsonFields, secureJsonData } = options;
+  const { url, user, secureJsonFields, secureJsonData, jsonData } = options;
 
   const onUrlChange = (event: ChangeEvent<HTMLInputElement>) =>
     onOptionsChange({
@@ -42,6 +42,12 @@ export const ConfigEditor = ({ options, onOptionsChange }: Props): JSX.Element =
       secureJsonData: { password: undefined },
     });
 
+  const onVerboseLoggingChange = (event: React.FormEvent<HTMLInputElement>) =>
+    onOptionsChange({
+      ...options,
+      jsonData: { ...jsonData, verboseLogging: event.currentTarget.checked },
+    });
+
   return (
     <>
       <InlineField
@@ -78,6 +84,19 @@ export const ConfigEditor = ({ options, onOptionsChange }: Props): JSX.Element =
           required
         />
       </InlineField>
+
+      <InlineField
+        label="Verbose logging"
+        labelWidth={labelWidth}
+        tooltip="Logs every request to the StableNet® Server with its response. Credentials are redacted and responses are truncated."
+        interactive
+      >
+        <InlineSwitch
+          id="stablenet-verbose-logging"
+          value={jsonData.verboseLogging ?? false}
+          onChange={onVerboseLoggingChange}
+        />
+      </InlineField>
     </>
   );
 };
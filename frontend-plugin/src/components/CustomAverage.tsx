@@ -6,7 +6,7 @@
  *                  www.infosim.net
  */
 import React, { ChangeEvent } from 'react';
-import { Checkbox, Input, Select, LegacyForms } from '@grafana/ui';
+import { Checkbox, Input, InlineFormLabel, Select, LegacyForms } from '@grafana/ui';
 import { SelectableValue } from '@grafana/data';
 import { LabelValue, Unit } from 'types';
 
@@ -16,13 +16,14 @@ interface Props {
   use: boolean;
   period: string;
   unit: number;
+  pollingInterval?: number;
   onUseAverageChange: () => void;
   onUseCustomAverageChange: (event: ChangeEvent<HTMLInputElement>) => void;
   onAverageUnitChange: (value: SelectableValue<number>) => void;
 }
 
 const tooltip =
-  'Allows to define a custom average period. If disabled, Grafana will automatically compute a suiting average period.';
+  'Allows to define a custom average period, which starts at the polling interval of the measurement. If disabled, StableNet® chooses the average.';
 
 const units: LabelValue[] = [
   { label: 'sec', value: Unit.SECONDS },
@@ -35,10 +36,14 @@ export function CustomAverage({
   use,
   period,
   unit,
+  pollingInterval,
   onUseAverageChange,
   onUseCustomAverageChange,
   onAverageUnitChange,
 }: Props): JSX.Element {
+  const average = parseInt(period, 10) * unit;
+  const belowPollingInterval = use && !!pollingInterval && average > 0 && average < pollingInterval;
+
   return (
     <div className="gf-form-inline" style={{ display: 'flex', alignItems: 'center' }}>
       <Checkbox value={use} onChange={onUseAverageChange} tabIndex={0} />
@@ -72,6 +77,12 @@ export function CustomAverage({
           </div>
         }
       />
+
+      {belowPollingInterval ? (
+        <InlineFormLabel width={'auto'}>
+          {`Shorter than the polling interval of ${pollingInterval! / 1000}s, StableNet® has at most one value per polling interval.`}
+        </InlineFormLabel>
+      ) : null}
     </div>
   );
 }
@@ -19,6 +19,9 @@ interface Props {
   onChange: (value: SelectableValue<number>) => void;
 }
 
+const searchTooltip =
+  'Searches the names of the devices. Use ip: or vendor: to search other attributes, e.g. "router ip:10.1". The vendor is given by its IANA enterprise number, e.g. vendor:9 for Cisco.';
+
 const moreDevicesTooltip =
   'There are more devices available, but only the first 100 are displayed. Use a stricter search to reduce the number of shown devices.';
 
@@ -28,7 +31,7 @@ export function DeviceMenu({ selectedDevice, hasMoreDevices, get, onChange }: Pr
       <FormField
         label={'Device:'}
         labelWidth={11}
-        tooltip={hasMoreDevices ? moreDevicesTooltip : ''}
+        tooltip={hasMoreDevices ? `${moreDevicesTooltip} ${searchTooltip}` : searchTooltip}
         inputEl={
           <div tabIndex={0}>
             <AsyncSelect<number>
@@ -0,0 +1,49 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+import React from 'react';
+import { Input, InlineFormLabel, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { Format } from '../types';
+
+interface Props {
+  format: Format;
+  percentile?: number;
+  onChange: (format: Format, percentile?: number) => void;
+}
+
+const tooltip =
+  'Time series returns one series per metric. Summary returns a single table with one row per metric containing the last, min, max, average and percentile value, the time of the last sample and the availability.';
+
+const formats: Array<SelectableValue<Format>> = [
+  { label: 'Time series', value: Format.TIME_SERIES },
+  { label: 'Summary', value: Format.SUMMARY },
+];
+
+export function FormatChooser({ format, percentile, onChange }: Props): JSX.Element {
+  return (
+    <div className="gf-form" style={{ display: 'flex', alignItems: 'center' }}>
+      <InlineFormLabel width={11} tooltip={tooltip}>
+        Format:
+      </InlineFormLabel>
+      <Select width={18} options={formats} value={format} onChange={(v) => onChange(v.value!, percentile)} />
+      {format === Format.SUMMARY ? (
+        <>
+          <InlineFormLabel width={6}>Percentile:</InlineFormLabel>
+          <Input
+            width={8}
+            type="number"
+            min={0}
+            max={100}
+            value={percentile ?? 95}
+            onChange={(e) => onChange(format, e.currentTarget.value === '' ? undefined : Number(e.currentTarget.value))}
+          />
+        </>
+      ) : null}
+    </div>
+  );
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+import React from 'react';
+import { Button, InlineFormLabel } from '@grafana/ui';
+import { LinkResolution, ResolvedMeasurement } from '../types';
+
+interface Props {
+  resolution?: LinkResolution;
+  error?: string;
+  onPreview: () => void;
+  onConvert: (measurement: ResolvedMeasurement) => void;
+}
+
+const tooltip =
+  'Shows the measurements and metrics the link refers to. Each measurement can be converted into a measurement query, which replaces the link of this query.';
+
+export function LinkPreview({ resolution, error, onPreview, onConvert }: Props): JSX.Element {
+  return (
+    <div className="gf-form" style={{ alignItems: 'baseline' }}>
+      <InlineFormLabel width={11} tooltip={tooltip}>
+        Preview:
+      </InlineFormLabel>
+
+      <div style={{ display: 'flex', flexDirection: 'column' }}>
+        <div style={{ padding: '2px' }}>
+          <Button variant="secondary" size="sm" onClick={onPreview}>
+            Resolve link
+          </Button>
+        </div>
+
+        {error ? <div style={{ padding: '2px' }}>{error}</div> : null}
+
+        {resolution?.measurements.map((measurement) => (
+          <div key={measurement.obid} style={{ padding: '2px' }}>
+            <span>
+              {measurement.device ? `${measurement.device.name} / ` : ''}
+              {measurement.name}: {measurement.metrics.map(({ name }) => name).join(', ')}
+            </span>{' '}
+            <Button variant="secondary" size="sm" onClick={() => onConvert(measurement)}>
+              Convert to measurement query
+            </Button>
+          </div>
+        ))}
+
+        {resolution?.unresolved.map(({ obid, error }) => (
+          <div key={obid} style={{ padding: '2px' }}>
+            Measurement {obid}: {error}
+          </div>
+        ))}
+      </div>
+    </div>
+  );
+}
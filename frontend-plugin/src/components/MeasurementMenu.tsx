@@ -26,7 +26,7 @@ const moreMeasurementsTooltip =
   'There are more measurements available, but only the first 100 are displayed. Use a stricter search to reduce the number of shown measurements.';
 
 const filterTooltip =
-  'The dropdown menu on the left only shows at most 100 measurements. Use this text field to query measurements that are not shown on the left, or to search for specific measurements.';
+  'The dropdown menu on the left only shows at most 100 measurements. Use this text field to query measurements that are not shown on the left, or to search for specific measurements. Use type: to search by the type of the measurement, e.g. "eth type:interface".';
 
 export function MeasurementMenu({
   measurements,
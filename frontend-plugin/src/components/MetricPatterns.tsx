@@ -0,0 +1,85 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+import React from 'react';
+import { Button, Checkbox, Input, InlineFormLabel, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { MatchMode, MetricPattern } from '../types';
+
+interface Props {
+  use: boolean;
+  patterns: MetricPattern[];
+  onUseChange: () => void;
+  onChange: (patterns: MetricPattern[]) => void;
+}
+
+const tooltip =
+  'Selects metrics by patterns instead of a fixed list, such that metrics added later on show up without editing the panel. Exact and suffix match the metric key (the suffix ignores the source prefix, e.g. "SNMP_"), glob and regex match the name or the key.';
+
+const modes: Array<SelectableValue<MatchMode>> = [
+  { label: 'exact', value: MatchMode.EXACT },
+  { label: 'suffix', value: MatchMode.SUFFIX },
+  { label: 'glob', value: MatchMode.GLOB },
+  { label: 'regex', value: MatchMode.REGEX },
+];
+
+export function MetricPatterns({ use, patterns, onUseChange, onChange }: Props): JSX.Element {
+  const update = (index: number, pattern: MetricPattern) =>
+    onChange(patterns.map((current, i) => (i === index ? pattern : current)));
+
+  return (
+    <div className="gf-form" style={{ alignItems: 'baseline' }}>
+      <InlineFormLabel width={11} tooltip={tooltip}>
+        Metric Patterns:
+      </InlineFormLabel>
+
+      <div style={{ display: 'flex', flexDirection: 'column' }}>
+        <div style={{ padding: '2px' }}>
+          <Checkbox value={use} onChange={onUseChange} tabIndex={0} label={'Select metrics by pattern'} />
+        </div>
+
+        {use
+          ? patterns.map((pattern, index) => (
+              <div key={index} style={{ display: 'flex', padding: '2px' }}>
+                <Select
+                  width={12}
+                  options={modes}
+                  value={pattern.mode}
+                  onChange={(v) => update(index, { ...pattern, mode: v.value! })}
+                />
+                <Input
+                  width={30}
+                  value={pattern.pattern}
+                  spellCheck={false}
+                  onChange={(e) => update(index, { ...pattern, pattern: e.currentTarget.value })}
+                />
+                <Button
+                  variant="secondary"
+                  icon="trash-alt"
+                  aria-label="Remove pattern"
+                  onClick={() => onChange(patterns.filter((_, i) => i !== index))}
+                />
+              </div>
+            ))
+          : null}
+
+        {use ? (
+          <div style={{ padding: '2px' }}>
+            <Button
+              variant="secondary"
+              size="sm"
+              icon="plus"
+              onClick={() => onChange([...patterns, { mode: MatchMode.GLOB, pattern: '' }])}
+            >
+              Add pattern
+            </Button>
+          </div>
+        ) : null}
+      </div>
+    </div>
+  );
+}
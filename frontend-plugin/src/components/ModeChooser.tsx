@@ -20,9 +20,10 @@ interface Props {
 const modes: Array<SelectableValue<number>> = [
   { label: 'Measurement', value: Mode.MEASUREMENT },
   { label: 'Statistic Link', value: Mode.STATISTIC_LINK },
+  { label: 'Top N', value: Mode.TOP_N },
 ];
 
-const tooltip = 'Allows switching between Measurement mode and Statistic Link mode.';
+const tooltip = 'Allows switching between Measurement mode, Statistic Link mode and Top N mode.';
 
 export function ModeChooser({ selectedMode, onChange }: Props): JSX.Element {
   const inputElement = (
@@ -5,18 +5,44 @@
  *                  97074 Wuerzburg, Germany
  *                  www.infosim.net
  */
-import React, { ChangeEvent } from 'react';
+import React, { ChangeEvent, useState } from 'react';
 import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import { Checkbox, InlineFormLabel } from '@grafana/ui';
 import { DataSource } from '../DataSource';
-import { LabelValue, Metric, Mode, StableNetConfigOptions, Target, Unit } from '../types';
+import {
+  Aggregation,
+  Comparison,
+  Format,
+  LabelValue,
+  LinkResolution,
+  Metric,
+  MetricPattern,
+  Mode,
+  Ranking,
+  ResolvedMeasurement,
+  SearchHit,
+  StableNetConfigOptions,
+  Statistic,
+  Target,
+  Transformation,
+  Unit,
+} from '../types';
 import { MetricPrefix } from './MetricPrefix';
 import { DeviceMenu } from './DeviceMenu';
 import { StatLink } from './StatLink';
 import { ModeChooser } from './ModeChooser';
 import { CustomAverage } from './CustomAverage';
-import { MinMaxAvg } from './MinMaxAvg';
+import { DEFAULT_STATISTIC_WINDOW_MINUTES, Statistics, statisticsOf } from './Statistics';
 import { MeasurementMenu } from './MeasurementMenu';
+import { LinkPreview } from './LinkPreview';
+import { MetricPatterns } from './MetricPatterns';
+import { Transformations } from './Transformations';
+import { AggregationEditor } from './AggregationEditor';
+import { defaultRanking, RankingEditor } from './RankingEditor';
+import { FormatChooser } from './FormatChooser';
+import { TimeShift } from './TimeShift';
+import { SearchMenu } from './SearchMenu';
+import { fromQueryModel, isQueryModel } from '../queryModel';
 
 const singleMetric: React.CSSProperties = {
   textOverflow: 'ellipsis',
@@ -27,7 +53,12 @@ const singleMetric: React.CSSProperties = {
 
 type Props = QueryEditorProps<DataSource, Target, StableNetConfigOptions, Target>;
 
-export const QueryEditor = ({ datasource, query, onChange, onRunQuery }: Props) => {
+export const QueryEditor = ({ datasource, query: storedQuery, onChange, onRunQuery }: Props) => {
+  // a versioned query is edited as a Target, such that the edits are sent instead of the unchanged versioned fields
+  const query = isQueryModel(storedQuery) ? fromQueryModel(storedQuery, storedQuery.refId) : storedQuery;
+  const [linkResolution, setLinkResolution] = useState<LinkResolution | undefined>(undefined);
+  const [linkError, setLinkError] = useState<string | undefined>(undefined);
+
   const onModeChange = (v: SelectableValue<number>) =>
     onChange({
       ...query,
@@ -35,6 +66,7 @@ export const QueryEditor = ({ datasource, query, onChange, onRunQuery }: Props)
       includeMaxStats: false,
       includeAvgStats: true,
       includeMinStats: false,
+      statistics: [Statistic.AVG],
       averageUnit: Unit.MINUTES,
     });
 
@@ -43,6 +75,48 @@ export const QueryEditor = ({ datasource, query, onChange, onRunQuery }: Props)
     onRunQuery();
   };
 
+  const onLinkPreview = async () => {
+    try {
+      setLinkResolution(await datasource.resolveStatisticLink(query.statisticLink || ''));
+      setLinkError(undefined);
+    } catch (e: any) {
+      setLinkResolution(undefined);
+      setLinkError(e?.data?.message || e?.data || 'The link could not be resolved.');
+    }
+  };
+
+  const onConvertLink = async ({ obid, name, device, metrics }: ResolvedMeasurement) => {
+    const allMetrics = await datasource.findMetricsForMeasurement(obid);
+
+    onChange({
+      ...query,
+      mode: Mode.MEASUREMENT,
+      selectedDevice: device ? { label: device.name, value: device.obid } : query.selectedDevice,
+      selectedMeasurement: { label: name, value: obid },
+      measurements: [{ label: name, value: obid }],
+      measurementFilter: '',
+      metricPrefix: name,
+      metrics: allMetrics,
+      chosenMetrics: metrics.map(({ key }) => key),
+    });
+    setLinkResolution(undefined);
+
+    onRunQuery();
+  };
+
+  const onLinkOptionChange = (value: 'timeRange' | 'average') => {
+    switch (value) {
+      case 'timeRange':
+        onChange({ ...query, useLinkTimeRange: !query.useLinkTimeRange });
+        break;
+      case 'average':
+        onChange({ ...query, useLinkAverage: !query.useLinkAverage });
+        break;
+    }
+
+    onRunQuery();
+  };
+
   const getDevices = async (v: string): Promise<LabelValue[]> => {
     const response = await datasource.queryDevices(v);
 
@@ -91,6 +165,35 @@ export const QueryEditor = ({ datasource, query, onChange, onRunQuery }: Props)
     onRunQuery();
   };
 
+  // a device hit selects the device, measurement and metric hits also select the measurement and the metric
+  const onSearchSelect = async ({ device, measurement, metric }: SearchHit) => {
+    if (!device) {
+      return;
+    }
+
+    const { hasMore, data } = await datasource.findMeasurementsForDevice(device.obid, '');
+    const metrics = measurement ? await datasource.findMetricsForMeasurement(measurement.obid) : [];
+
+    onChange({
+      ...query,
+      moreMeasurements: hasMore || !!query.moreMeasurements,
+      measurements: data,
+      measurementFilter: '',
+      selectedDevice: { label: device.name, value: device.obid },
+      selectedMeasurement: measurement ? { label: measurement.name, value: measurement.obid } : { label: '', value: -1 },
+      metricPrefix: measurement ? measurement.name : '',
+      metrics,
+      chosenMetrics: metric ? [metric.key] : [],
+      mode: Mode.MEASUREMENT,
+      includeAvgStats: query.includeAvgStats === undefined ? true : query.includeAvgStats,
+      includeMaxStats: query.includeMaxStats === undefined ? false : query.includeMaxStats,
+      includeMinStats: query.includeMinStats === undefined ? false : query.includeMinStats,
+      averageUnit: query.averageUnit ? query.averageUnit : Unit.MINUTES,
+    });
+
+    onRunQuery();
+  };
+
   const onMeasurementFilterChange = async (v: ChangeEvent<HTMLInputElement>) => {
     const x = v.target.value;
 
@@ -133,24 +236,75 @@ export const QueryEditor = ({ datasource, query, onChange, onRunQuery }: Props)
     onRunQuery();
   };
 
-  const onIncludeChange = (value: 'min' | 'avg' | 'max') => {
-    switch (value) {
-      case 'min':
-        onChange({ ...query, includeMinStats: !query.includeMinStats });
-        break;
-      case 'avg':
-        onChange({ ...query, includeAvgStats: !query.includeAvgStats });
-        break;
-      case 'max':
-        onChange({ ...query, includeMaxStats: !query.includeMaxStats });
-        break;
+  const onStatisticChange = (statistic: Statistic) => {
+    const current = statisticsOf(query);
+    const statistics = current.includes(statistic) ? current.filter((s) => s !== statistic) : [...current, statistic];
+
+    onChange({ ...query, statistics });
+    onRunQuery();
+  };
+
+  const onStatisticWindowChange = (minutes: number) => {
+    onChange({ ...query, statisticWindow: minutes * 60000 });
+    onRunQuery();
+  };
+
+
+  const onUseMetricPatternsChange = () => {
+    onChange({ ...query, useMetricPatterns: !query.useMetricPatterns });
+    onRunQuery();
+  };
+
+  const onMetricPatternsChange = (metricPatterns: MetricPattern[]) => {
+    onChange({ ...query, metricPatterns });
+    onRunQuery();
+  };
+
+  const onTransformationsChange = (transformations: Record<string, Transformation[]>) => {
+    onChange({ ...query, transformations });
+    onRunQuery();
+  };
+
+  const onAggregationChange = (aggregation?: Aggregation) => {
+    onChange({ ...query, aggregation });
+    onRunQuery();
+  };
+
+  const onTimeShiftChange = (timeShift: number, comparison: Comparison) => {
+    onChange({ ...query, timeShift, comparison: timeShift ? comparison : Comparison.NONE });
+    onRunQuery();
+  };
+
+  const onFormatChange = (format: Format, summaryPercentile?: number) => {
+    onChange({ ...query, format, summaryPercentile });
+    onRunQuery();
+  };
+
+  const onRankingChange = (ranking: Ranking) => {
+    onChange({ ...query, ranking });
+    onRunQuery();
+  };
+
+  const onRankingDeviceChange = ({ value, label }: SelectableValue<number>) => {
+    if (value === undefined || label === undefined) {
+      return;
     }
 
+    onChange({ ...query, selectedDevice: { label, value }, ranking: { ...(query.ranking || defaultRanking), deviceObid: value } });
     onRunQuery();
   };
 
+  // the metrics of a measurement share its polling interval
+  const pollingInterval = (query.metrics || []).find((metric) => metric.pollingInterval)?.pollingInterval;
+
+  // a custom average starts at the polling interval, the finest resolution StableNet® has
   const onUseAvgChange = () => {
-    onChange({ ...query, useCustomAverage: !query.useCustomAverage });
+    if (!query.useCustomAverage && !query.averagePeriod && pollingInterval) {
+      const averageUnit = pollingInterval % Unit.MINUTES === 0 ? Unit.MINUTES : Unit.SECONDS;
+      onChange({ ...query, useCustomAverage: true, averagePeriod: String(pollingInterval / averageUnit), averageUnit });
+    } else {
+      onChange({ ...query, useCustomAverage: !query.useCustomAverage });
+    }
     onRunQuery();
   };
 
@@ -164,15 +318,41 @@ export const QueryEditor = ({ datasource, query, onChange, onRunQuery }: Props)
     onRunQuery();
   };
 
+  const isLinkMode = query.mode === Mode.STATISTIC_LINK;
+  const isTopNMode = query.mode === Mode.TOP_N;
+  const hasMeasurement = !query.mode && !!(query.selectedMeasurement && query.selectedMeasurement.label);
+
   return (
     <div>
       <ModeChooser selectedMode={query.mode || Mode.MEASUREMENT} onChange={onModeChange} />
 
-      {!!query.mode ? (
-        <StatLink link={query.statisticLink || ''} onChange={onStatisticLinkChange} />
+      {isLinkMode ? (
+        <StatLink
+          link={query.statisticLink || ''}
+          onChange={onStatisticLinkChange}
+          useLinkTimeRange={!!query.useLinkTimeRange}
+          useLinkAverage={!!query.useLinkAverage}
+          onLinkOptionChange={onLinkOptionChange}
+        />
+      ) : null}
+
+      {isLinkMode ? (
+        <LinkPreview resolution={linkResolution} error={linkError} onPreview={onLinkPreview} onConvert={onConvertLink} />
+      ) : isTopNMode ? (
+        <RankingEditor
+          ranking={query.ranking || defaultRanking}
+          selectedDevice={query.selectedDevice}
+          hasMoreDevices={query.moreDevices}
+          getDevices={getDevices}
+          onDeviceChange={onRankingDeviceChange}
+          onChange={onRankingChange}
+        />
       ) : (
         <div>
           {/** Measurement mode */}
+          <div className="gf-form-inline">
+            <SearchMenu search={(text) => datasource.search(text)} onSelect={onSearchSelect} />
+          </div>
           <div className="gf-form-inline">
             <DeviceMenu
               selectedDevice={query.selectedDevice}
@@ -216,7 +396,8 @@ export const QueryEditor = ({ datasource, query, onChange, onRunQuery }: Props)
                           style={singleMetric}
                           value={query.chosenMetrics.includes(metric.key)}
                           onChange={() => onMetricChange(metric)}
-                          label={metric.text}
+                          label={metric.unit ? `${metric.text} (${metric.unit})` : metric.text}
+                          description={metric.description}
                         />
                       </div>
                     ))}
@@ -228,21 +409,59 @@ export const QueryEditor = ({ datasource, query, onChange, onRunQuery }: Props)
         </div>
       )}
 
-      {!!(query.selectedMeasurement && query.selectedMeasurement.label) || query.mode === Mode.STATISTIC_LINK ? (
+      {hasMeasurement || isLinkMode ? (
+        <MetricPatterns
+          use={!!query.useMetricPatterns}
+          patterns={query.metricPatterns || []}
+          onUseChange={onUseMetricPatternsChange}
+          onChange={onMetricPatternsChange}
+        />
+      ) : null}
+
+      {hasMeasurement || isLinkMode || isTopNMode ? (
+        <Transformations
+          metrics={hasMeasurement ? query.metrics || [] : []}
+          transformations={query.transformations || {}}
+          onChange={onTransformationsChange}
+        />
+      ) : null}
+
+      {hasMeasurement || isLinkMode ? (
+        <AggregationEditor aggregation={query.aggregation} onChange={onAggregationChange} />
+      ) : null}
+
+      {hasMeasurement || isLinkMode || isTopNMode ? (
+        <TimeShift
+          timeShift={query.timeShift || 0}
+          comparison={query.comparison || Comparison.NONE}
+          onChange={onTimeShiftChange}
+        />
+      ) : null}
+
+      {hasMeasurement || isLinkMode || isTopNMode ? (
+        <FormatChooser
+          format={query.format || Format.TIME_SERIES}
+          percentile={query.summaryPercentile}
+          onChange={onFormatChange}
+        />
+      ) : null}
+
+      {hasMeasurement || isLinkMode || isTopNMode ? (
         <div style={{ display: 'flex' }}>
           <CustomAverage
             use={query.useCustomAverage}
             period={query.averagePeriod || ''}
             unit={query.averageUnit || Unit.MINUTES}
+            pollingInterval={hasMeasurement ? pollingInterval : undefined}
             onUseAverageChange={onUseAvgChange}
             onUseCustomAverageChange={onCustAvgChange}
             onAverageUnitChange={onAvgUnitChange}
           />
-          <MinMaxAvg
-            includeMinStats={query.includeMinStats}
-            includeAvgStats={query.includeAvgStats}
-            includeMaxStats={query.includeMaxStats}
-            onChange={onIncludeChange}
+          <Statistics
+            statistics={statisticsOf(query)}
+            windowMinutes={query.statisticWindow ? query.statisticWindow / 60000 : DEFAULT_STATISTIC_WINDOW_MINUTES}
+            onChange={onStatisticChange}
+            onWindowChange={onStatisticWindowChange}
           />
         </div>
       ) : null}
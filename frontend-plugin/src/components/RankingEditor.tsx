@@ -0,0 +1,143 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+import React from 'react';
+import { Input, InlineFormLabel, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { AggregationFunction, LabelValue, MatchMode, RankFunction, Ranking } from '../types';
+import { DeviceMenu } from './DeviceMenu';
+
+interface Props {
+  ranking: Ranking;
+  selectedDevice: LabelValue;
+  hasMoreDevices: boolean;
+  getDevices: (value: string) => Promise<LabelValue[]>;
+  onDeviceChange: (value: SelectableValue<number>) => void;
+  onChange: (ranking: Ranking) => void;
+}
+
+export const defaultRanking: Ranking = {
+  deviceObid: -1,
+  measurementFilter: '',
+  metric: { mode: MatchMode.SUFFIX, pattern: '' },
+  rankBy: RankFunction.AVG,
+  bottom: false,
+  limit: 10,
+};
+
+const tooltip =
+  'Ranks the metric across all measurements of the device that match the filter and shows only the top or bottom N series. At most 500 measurements are ranked.';
+
+const rankFunctions: Array<SelectableValue<RankFunction>> = [
+  { label: 'avg', value: RankFunction.AVG },
+  { label: 'max', value: RankFunction.MAX },
+  { label: 'last', value: RankFunction.LAST },
+  { label: 'percentile', value: RankFunction.PERCENTILE },
+];
+
+const orders: Array<SelectableValue<boolean>> = [
+  { label: 'top', value: false },
+  { label: 'bottom', value: true },
+];
+
+const matchModes: Array<SelectableValue<MatchMode>> = [
+  { label: 'exact', value: MatchMode.EXACT },
+  { label: 'suffix', value: MatchMode.SUFFIX },
+  { label: 'glob', value: MatchMode.GLOB },
+  { label: 'regex', value: MatchMode.REGEX },
+];
+
+const others: Array<SelectableValue<AggregationFunction | ''>> = [
+  { label: 'no others', value: '' },
+  { label: 'sum of others', value: AggregationFunction.SUM },
+  { label: 'avg of others', value: AggregationFunction.AVG },
+  { label: 'max of others', value: AggregationFunction.MAX },
+];
+
+export function RankingEditor({
+  ranking,
+  selectedDevice,
+  hasMoreDevices,
+  getDevices,
+  onDeviceChange,
+  onChange,
+}: Props): JSX.Element {
+  return (
+    <div>
+      <div className="gf-form-inline">
+        <DeviceMenu selectedDevice={selectedDevice} hasMoreDevices={hasMoreDevices} get={getDevices} onChange={onDeviceChange} />
+      </div>
+
+      <div className="gf-form">
+        <InlineFormLabel width={11}>Measurements:</InlineFormLabel>
+        <Input
+          width={30}
+          value={ranking.measurementFilter}
+          placeholder={'name contains'}
+          onChange={(e) => onChange({ ...ranking, measurementFilter: e.currentTarget.value })}
+        />
+      </div>
+
+      <div className="gf-form">
+        <InlineFormLabel width={11}>Metric:</InlineFormLabel>
+        <Select
+          width={12}
+          options={matchModes}
+          value={ranking.metric.mode}
+          onChange={(v) => onChange({ ...ranking, metric: { ...ranking.metric, mode: v.value! } })}
+        />
+        <Input
+          width={30}
+          value={ranking.metric.pattern}
+          spellCheck={false}
+          onChange={(e) => onChange({ ...ranking, metric: { ...ranking.metric, pattern: e.currentTarget.value } })}
+        />
+      </div>
+
+      <div className="gf-form">
+        <InlineFormLabel width={11} tooltip={tooltip}>
+          Ranking:
+        </InlineFormLabel>
+        <Select
+          width={12}
+          options={orders}
+          value={ranking.bottom}
+          onChange={(v) => onChange({ ...ranking, bottom: !!v.value })}
+        />
+        <Input
+          width={8}
+          type="number"
+          min={1}
+          value={ranking.limit}
+          onChange={(e) => onChange({ ...ranking, limit: Number(e.currentTarget.value) })}
+        />
+        <Select
+          width={14}
+          options={rankFunctions}
+          value={ranking.rankBy}
+          onChange={(v) => onChange({ ...ranking, rankBy: v.value! })}
+        />
+        {ranking.rankBy === RankFunction.PERCENTILE ? (
+          <Input
+            width={8}
+            type="number"
+            min={0}
+            max={100}
+            value={ranking.percentile ?? 95}
+            onChange={(e) => onChange({ ...ranking, percentile: Number(e.currentTarget.value) })}
+          />
+        ) : null}
+        <Select
+          width={18}
+          options={others}
+          value={ranking.others || ''}
+          onChange={(v) => onChange({ ...ranking, others: v.value || undefined })}
+        />
+      </div>
+    </div>
+  );
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+import React from 'react';
+import { AsyncSelect, InlineFormLabel } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { SearchHit } from '../types';
+
+interface Props {
+  search: (text: string) => Promise<SearchHit[]>;
+  onSelect: (hit: SearchHit) => void;
+}
+
+const searchTooltip =
+  'Searches devices, measurements and metrics at once, e.g. "core1 eth0 in". Selecting a hit selects its device, measurement and metric.';
+
+export function SearchMenu({ search, onSelect }: Props): JSX.Element {
+  const loadOptions = async (text: string): Promise<Array<SelectableValue<SearchHit>>> => {
+    if (!text.trim()) {
+      return [];
+    }
+    const hits = await search(text);
+    return hits.map((hit) => ({ label: hit.path, value: hit, description: hit.kind }));
+  };
+
+  return (
+    <div className="gf-form">
+      <InlineFormLabel width={11} tooltip={searchTooltip}>
+        Search:
+      </InlineFormLabel>
+      <div tabIndex={0}>
+        <AsyncSelect<SearchHit>
+          value={null}
+          loadOptions={loadOptions}
+          onChange={(v) => v.value && onSelect(v.value)}
+          noOptionsMessage={`Nothing matches this search.`}
+          loadingMessage={`Searching...`}
+          className={'width-30'}
+          placeholder={'device, measurement or metric'}
+          menuPlacement={'bottom'}
+          isSearchable={true}
+        />
+      </div>
+    </div>
+  );
+}
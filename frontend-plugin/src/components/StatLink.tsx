@@ -6,26 +6,58 @@
  *                  www.infosim.net
  */
 import React, { ChangeEvent } from 'react';
-import { Input, InlineFormLabel } from '@grafana/ui';
+import { Checkbox, Input, InlineFormLabel } from '@grafana/ui';
 
 interface Props {
   link: string;
   onChange: (event: ChangeEvent<HTMLInputElement>) => void;
+  useLinkTimeRange: boolean;
+  useLinkAverage: boolean;
+  onLinkOptionChange: (value: 'timeRange' | 'average') => void;
 }
 
 const tooltip =
   'Copy a link from the StableNet®-Analyzer. Due to technical limitations, measurements other than template measurements (e.g. ping and interface measurements) are only partly supported.';
 
-export function StatLink({ link, onChange }: Props): JSX.Element {
+const optionsTooltip =
+  'By default, the time range of the dashboard and the average of the panel are used. Check these options to use the time range or the average stored in the link instead.';
+
+export function StatLink({
+  link,
+  onChange,
+  useLinkTimeRange,
+  useLinkAverage,
+  onLinkOptionChange,
+}: Props): JSX.Element {
   return (
-    <div className="gf-form-inline">
-      <div className={'gf-form'} style={{ width: '100%' }}>
-        <InlineFormLabel width={11} tooltip={tooltip}>
-          Link:
+    <div>
+      <div className="gf-form-inline">
+        <div className={'gf-form'} style={{ width: '100%' }}>
+          <InlineFormLabel width={11} tooltip={tooltip}>
+            Link:
+          </InlineFormLabel>
+
+          <div style={{ width: '100%' }}>
+            <Input type={'text'} value={link} onChange={onChange} spellCheck={false} tabIndex={0} />
+          </div>
+        </div>
+      </div>
+      <div className="gf-form" style={{ display: 'flex', alignItems: 'center' }}>
+        <InlineFormLabel width={11} tooltip={optionsTooltip}>
+          From Link:
         </InlineFormLabel>
 
-        <div style={{ width: '100%' }}>
-          <Input type={'text'} value={link} onChange={onChange} spellCheck={false} tabIndex={0} />
+        <div style={{ paddingLeft: '2px', paddingRight: '2px' }}>
+          <Checkbox
+            value={useLinkTimeRange}
+            onChange={() => onLinkOptionChange('timeRange')}
+            tabIndex={0}
+            label={'Time Range'}
+          />
+        </div>
+
+        <div style={{ paddingLeft: '2px', paddingRight: '2px' }}>
+          <Checkbox value={useLinkAverage} onChange={() => onLinkOptionChange('average')} tabIndex={0} label={'Average'} />
         </div>
       </div>
     </div>
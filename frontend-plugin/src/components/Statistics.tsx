@@ -0,0 +1,80 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+import React from 'react';
+import { Checkbox, Input, InlineFormLabel } from '@grafana/ui';
+import { Statistic, Target } from '../types';
+
+interface Props {
+  statistics: Statistic[];
+  windowMinutes: number;
+  onChange: (statistic: Statistic) => void;
+  onWindowChange: (minutes: number) => void;
+}
+
+const options: Array<{ label: string; value: Statistic }> = [
+  { label: 'Min', value: Statistic.MIN },
+  { label: 'Avg', value: Statistic.AVG },
+  { label: 'Max', value: Statistic.MAX },
+  { label: 'Last', value: Statistic.LAST },
+  { label: 'StdDev', value: Statistic.STDDEV },
+  { label: 'P95', value: Statistic.P95 },
+  { label: 'P99', value: Statistic.P99 },
+];
+
+const windowed = [Statistic.STDDEV, Statistic.P95, Statistic.P99];
+
+const windowTooltip = 'StdDev, P95 and P99 are computed over the averages within this window before each point.';
+
+export const DEFAULT_STATISTIC_WINDOW_MINUTES = 60;
+
+// Queries saved before the list of statistics was introduced only have the include flags.
+export function statisticsOf(query: Target): Statistic[] {
+  if (query.statistics) {
+    return query.statistics;
+  }
+  const result: Statistic[] = [];
+  if (query.includeMinStats) {
+    result.push(Statistic.MIN);
+  }
+  if (query.includeMaxStats) {
+    result.push(Statistic.MAX);
+  }
+  if (query.includeAvgStats === undefined || query.includeAvgStats) {
+    result.push(Statistic.AVG);
+  }
+  return result;
+}
+
+export function Statistics({ statistics, windowMinutes, onChange, onWindowChange }: Props): JSX.Element {
+  return (
+    <div className="gf-form" style={{ display: 'flex', alignItems: 'center' }}>
+      <InlineFormLabel width={11}>Include Statistics:</InlineFormLabel>
+
+      {options.map(({ label, value }) => (
+        <div key={value} style={{ paddingLeft: '2px', paddingRight: '2px' }}>
+          <Checkbox value={statistics.includes(value)} onChange={() => onChange(value)} tabIndex={0} label={label} />
+        </div>
+      ))}
+
+      {statistics.some((s) => windowed.includes(s)) ? (
+        <>
+          <InlineFormLabel width={8} tooltip={windowTooltip}>
+            Window (min):
+          </InlineFormLabel>
+          <Input
+            width={8}
+            type="number"
+            min={1}
+            value={windowMinutes}
+            onChange={(e) => onWindowChange(Number(e.currentTarget.value))}
+          />
+        </>
+      ) : null}
+    </div>
+  );
+}
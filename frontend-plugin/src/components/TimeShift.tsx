@@ -0,0 +1,68 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+import React, { useState } from 'react';
+import { Input, InlineFormLabel, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { Comparison, Unit } from '../types';
+
+interface Props {
+  timeShift: number;
+  comparison: Comparison;
+  onChange: (timeShift: number, comparison: Comparison) => void;
+}
+
+const tooltip =
+  'Fetches the data of the range shifted into the past and moves it forward onto the current range, e.g. to overlay last week. A comparison returns the difference or ratio between the current and the shifted data instead.';
+
+const units: Array<SelectableValue<number>> = [
+  { label: 'minutes', value: Unit.MINUTES },
+  { label: 'hours', value: Unit.HOURS },
+  { label: 'days', value: Unit.DAYS },
+];
+
+const comparisons: Array<SelectableValue<Comparison>> = [
+  { label: 'shifted data', value: Comparison.NONE },
+  { label: 'difference', value: Comparison.DIFFERENCE },
+  { label: 'ratio', value: Comparison.RATIO },
+];
+
+// Shows the shift in the largest unit that represents it exactly.
+function unitOf(timeShift: number): number {
+  return [Unit.DAYS, Unit.HOURS, Unit.MINUTES].find((unit) => timeShift % unit === 0) || Unit.MINUTES;
+}
+
+export function TimeShift({ timeShift, comparison, onChange }: Props): JSX.Element {
+  const [unit, setUnit] = useState<number>(timeShift ? unitOf(timeShift) : Unit.DAYS);
+
+  return (
+    <div className="gf-form" style={{ display: 'flex', alignItems: 'center' }}>
+      <InlineFormLabel width={11} tooltip={tooltip}>
+        Time shift:
+      </InlineFormLabel>
+      <Input
+        width={8}
+        type="number"
+        min={0}
+        value={timeShift / unit}
+        onChange={(e) => onChange(Number(e.currentTarget.value) * unit, comparison)}
+      />
+      <Select
+        width={14}
+        options={units}
+        value={unit}
+        onChange={(v) => {
+          setUnit(v.value!);
+          onChange((timeShift / unit) * v.value!, comparison);
+        }}
+      />
+      {timeShift ? (
+        <Select width={18} options={comparisons} value={comparison} onChange={(v) => onChange(timeShift, v.value!)} />
+      ) : null}
+    </div>
+  );
+}
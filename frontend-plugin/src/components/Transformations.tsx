@@ -0,0 +1,145 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+import React from 'react';
+import { Button, Checkbox, Input, InlineFormLabel, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { ALL_METRICS, Metric, Transformation, TransformationKind } from '../types';
+
+interface Props {
+  metrics: Metric[];
+  transformations: Record<string, Transformation[]>;
+  onChange: (transformations: Record<string, Transformation[]>) => void;
+}
+
+interface Row {
+  metric: string;
+  transformation: Transformation;
+}
+
+const tooltip =
+  'Transforms the data in the backend, such that alerts see the same values as the panel. The transformations of a metric are applied in the listed order.';
+
+const kinds: Array<SelectableValue<TransformationKind>> = [
+  { label: 'rate per second', value: TransformationKind.RATE },
+  { label: 'delta', value: TransformationKind.DELTA },
+  { label: 'multiply', value: TransformationKind.MULTIPLY },
+  { label: 'divide', value: TransformationKind.DIVIDE },
+  { label: 'bits to bytes', value: TransformationKind.BITS_TO_BYTES },
+  { label: 'bytes to bits', value: TransformationKind.BYTES_TO_BITS },
+  { label: 'cumulative sum', value: TransformationKind.CUMULATIVE_SUM },
+  { label: 'percent of', value: TransformationKind.PERCENT_OF },
+];
+
+const toRows = (transformations: Record<string, Transformation[]>): Row[] =>
+  Object.entries(transformations).flatMap(([metric, list]) => list.map((transformation) => ({ metric, transformation })));
+
+const fromRows = (rows: Row[]): Record<string, Transformation[]> =>
+  rows.reduce<Record<string, Transformation[]>>((result, { metric, transformation }) => {
+    result[metric] = [...(result[metric] || []), transformation];
+    return result;
+  }, {});
+
+const hasFactor = (kind: TransformationKind): boolean =>
+  kind === TransformationKind.MULTIPLY || kind === TransformationKind.DIVIDE;
+
+const isDifference = (kind: TransformationKind): boolean =>
+  kind === TransformationKind.RATE || kind === TransformationKind.DELTA;
+
+export function Transformations({ metrics, transformations, onChange }: Props): JSX.Element {
+  const rows = toRows(transformations);
+  const metricOptions: Array<SelectableValue<string>> = [
+    { label: 'All metrics', value: ALL_METRICS },
+    ...metrics.map(({ key, text }) => ({ label: text, value: key })),
+  ];
+  const update = (index: number, row: Row) => onChange(fromRows(rows.map((current, i) => (i === index ? row : current))));
+
+  return (
+    <div className="gf-form" style={{ alignItems: 'baseline' }}>
+      <InlineFormLabel width={11} tooltip={tooltip}>
+        Transformations:
+      </InlineFormLabel>
+
+      <div style={{ display: 'flex', flexDirection: 'column' }}>
+        {rows.map((row, index) => (
+          <div key={index} style={{ display: 'flex', padding: '2px' }}>
+            <Select
+              width={25}
+              options={metricOptions}
+              value={row.metric}
+              allowCustomValue
+              onChange={(v) => update(index, { ...row, metric: v.value! })}
+            />
+            <Select
+              width={20}
+              options={kinds}
+              value={row.transformation.kind}
+              onChange={(v) =>
+                update(index, {
+                  ...row,
+                  // a factor of 0 is rejected by the backend, thus a new factor starts as 1
+                  transformation: hasFactor(v.value!) ? { kind: v.value!, factor: 1 } : { kind: v.value! },
+                })
+              }
+            />
+            {hasFactor(row.transformation.kind) ? (
+              <Input
+                width={12}
+                type="number"
+                value={row.transformation.factor ?? ''}
+                onChange={(e) =>
+                  update(index, { ...row, transformation: { ...row.transformation, factor: Number(e.currentTarget.value) } })
+                }
+              />
+            ) : null}
+            {isDifference(row.transformation.kind) ? (
+              <div style={{ paddingLeft: '4px', paddingRight: '4px' }}>
+                <Checkbox
+                  value={!!row.transformation.counter}
+                  onChange={() =>
+                    update(index, { ...row, transformation: { ...row.transformation, counter: !row.transformation.counter } })
+                  }
+                  tabIndex={0}
+                  label={'Counter'}
+                  description={'Drops the points after a reset of the counter instead of a negative change'}
+                />
+              </div>
+            ) : null}
+            {row.transformation.kind === TransformationKind.PERCENT_OF ? (
+              <Select
+                width={25}
+                options={metricOptions.slice(1)}
+                value={row.transformation.reference}
+                allowCustomValue
+                onChange={(v) => update(index, { ...row, transformation: { ...row.transformation, reference: v.value } })}
+              />
+            ) : null}
+            <Button
+              variant="secondary"
+              icon="trash-alt"
+              aria-label="Remove transformation"
+              onClick={() => onChange(fromRows(rows.filter((_, i) => i !== index)))}
+            />
+          </div>
+        ))}
+
+        <div style={{ padding: '2px' }}>
+          <Button
+            variant="secondary"
+            size="sm"
+            icon="plus"
+            onClick={() =>
+              onChange(fromRows([...rows, { metric: ALL_METRICS, transformation: { kind: TransformationKind.RATE } }]))
+            }
+          >
+            Add transformation
+          </Button>
+        </div>
+      </div>
+    </div>
+  );
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright: Infosim GmbH & Co. KG Copyright (c) 2000-2021
+ * Company: Infosim GmbH & Co. KG,
+ *                  Landsteinerstraße 4,
+ *                  97074 Wuerzburg, Germany
+ *                  www.infosim.net
+ */
+import { statisticsOf } from './components/Statistics';
+import { Mode, QueryModel, Statistic, Target, Unit } from './types';
+
+export const QUERY_VERSION = 1;
+
+/**
+ * Converts the state of the query editor into the QueryModel. This mirrors Target#migrate of the backend: a statistic
+ * link or ranking that is not defined yet falls back to the measurement of the target.
+ */
+export function toQueryModel(target: Target): QueryModel {
+  const period = parseInt(target.averagePeriod, 10);
+  const result: QueryModel = {
+    queryVersion: QUERY_VERSION,
+    mode: Mode.MEASUREMENT,
+    average: target.useCustomAverage && !isNaN(period) ? period * target.averageUnit : undefined,
+    statistics: statisticsOf(target),
+    statisticWindow: target.statisticWindow,
+    timeShift: target.timeShift,
+    comparison: target.timeShift ? target.comparison : undefined,
+    transformations: target.transformations,
+    aggregation: target.aggregation,
+    format: target.format,
+    summaryPercentile: target.summaryPercentile,
+  };
+
+  if (target.mode === Mode.TOP_N && target.ranking) {
+    return { ...result, mode: Mode.TOP_N, ranking: target.ranking };
+  }
+  if (target.mode === Mode.STATISTIC_LINK && target.statisticLink) {
+    return {
+      ...result,
+      mode: Mode.STATISTIC_LINK,
+      statisticLink: target.statisticLink,
+      useLinkTimeRange: target.useLinkTimeRange,
+      useLinkAverage: target.useLinkAverage,
+      metricPatterns: target.useMetricPatterns ? target.metricPatterns : undefined,
+    };
+  }
+  const measurementObid = target.selectedMeasurement?.value;
+  if (target.useMetricPatterns) {
+    return { ...result, measurementObid, metricPrefix: target.metricPrefix, metricPatterns: target.metricPatterns };
+  }
+  const metrics = (target.metrics || [])
+    .filter(({ key }) => (target.chosenMetrics || []).includes(key))
+    .map(({ key, text }) => ({ key, name: [target.metricPrefix, text].filter((part) => part).join(' ') }));
+  return { ...result, measurementObid: metrics.length ? measurementObid : undefined, metrics };
+}
+
+export function isQueryModel(query: Target | QueryModel): query is QueryModel {
+  return 'queryVersion' in query;
+}
+
+/**
+ * Converts a versioned query, e.g. a hand-written one of a provisioned dashboard, into the state of the query editor,
+ * such that edits are made to a Target that toQueryModel converts again. The names of the device and the measurement are
+ * not part of the QueryModel, the measurement is labelled by its obid until another one is chosen.
+ */
+export function fromQueryModel(model: QueryModel, refId: string): Target {
+  const averageUnit = [Unit.DAYS, Unit.HOURS, Unit.MINUTES].find((unit) => model.average && model.average % unit === 0);
+  const measurementObid = model.measurementObid || 0;
+  const metrics = (model.metrics || []).map(({ key, name }) => ({ key, text: name || key, measurementObid }));
+  return {
+    refId,
+    mode: model.mode,
+    selectedDevice: { label: '', value: -1 },
+    selectedMeasurement: measurementObid
+      ? { label: `Measurement ${measurementObid}`, value: measurementObid }
+      : { label: '', value: -1 },
+    measurementFilter: '',
+    chosenMetrics: metrics.map(({ key }) => key),
+    // the names of listed metrics already carry the prefix
+    metricPrefix: model.metricPatterns?.length ? model.metricPrefix || '' : '',
+    includeMinStats: false,
+    includeAvgStats: false,
+    includeMaxStats: false,
+    statistics: model.statistics || [Statistic.AVG],
+    statisticWindow: model.statisticWindow,
+    timeShift: model.timeShift,
+    comparison: model.comparison,
+    statisticLink: model.statisticLink || '',
+    useLinkTimeRange: model.useLinkTimeRange,
+    useLinkAverage: model.useLinkAverage,
+    useMetricPatterns: !!model.metricPatterns?.length,
+    metricPatterns: model.metricPatterns,
+    transformations: model.transformations,
+    aggregation: model.aggregation,
+    ranking: model.ranking,
+    format: model.format,
+    summaryPercentile: model.summaryPercentile,
+    useCustomAverage: !!model.average,
+    averageUnit: averageUnit || Unit.SECONDS,
+    averagePeriod: model.average ? String(model.average / (averageUnit || Unit.SECONDS)) : '',
+    measurements: [],
+    metrics,
+    moreDevices: false,
+    moreMeasurements: false,
+  };
+}
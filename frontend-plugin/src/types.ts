@@ -12,13 +12,15 @@ export interface Metric {
   text: string;
   key: string;
   measurementObid: number;
+  unit?: string;
+  description?: string;
+  pollingInterval?: number;
 }
 
 /**
- * This interface's structure is optimized for the config panel (it represents its state). However, we send this whole
- * object to the server because Grafana doesn't allow having different types for the data query and the config panel.
- * Ideally, the data should be organized and stripped before sending the request, but that isn't possible. As a workaround,
- * we do the stripping and reorganizing in the server instead.
+ * This interface's structure is optimized for the config panel (it represents its state) and is what dashboards store.
+ * Before a request, it is converted into the versioned QueryModel, which only contains what the server needs. Targets
+ * stored without a queryVersion, e.g. for alerting, are migrated by the server in the same way.
  */
 export interface Target extends DataQuery {
   mode: number;
@@ -31,6 +33,19 @@ export interface Target extends DataQuery {
   includeAvgStats: boolean;
   includeMaxStats: boolean;
   statisticLink: string;
+  statistics?: Statistic[];
+  statisticWindow?: number;
+  timeShift?: number;
+  comparison?: Comparison;
+  useLinkTimeRange?: boolean;
+  useLinkAverage?: boolean;
+  useMetricPatterns?: boolean;
+  metricPatterns?: MetricPattern[];
+  transformations?: Record<string, Transformation[]>;
+  aggregation?: Aggregation;
+  ranking?: Ranking;
+  format?: Format;
+  summaryPercentile?: number;
   averagePeriod: string;
   averageUnit: number;
   useCustomAverage: boolean;
@@ -40,6 +55,125 @@ export interface Target extends DataQuery {
   moreMeasurements: boolean;
 }
 
+export enum Statistic {
+  MIN = 'min',
+  MAX = 'max',
+  AVG = 'avg',
+  LAST = 'last',
+  STDDEV = 'stddev',
+  P95 = 'p95',
+  P99 = 'p99',
+}
+
+export enum Comparison {
+  NONE = '',
+  DIFFERENCE = 'difference',
+  RATIO = 'ratio',
+}
+
+export enum Format {
+  TIME_SERIES = '',
+  SUMMARY = 'summary',
+}
+
+/** The versioned query the server receives, see QueryModel in the backend. Durations are given in milliseconds. */
+export interface QueryModel {
+  queryVersion: number;
+  mode: Mode;
+  measurementObid?: number;
+  metrics?: Array<{ key: string; name?: string }>;
+  metricPatterns?: MetricPattern[];
+  metricPrefix?: string;
+  statisticLink?: string;
+  useLinkTimeRange?: boolean;
+  useLinkAverage?: boolean;
+  ranking?: Ranking;
+  average?: number;
+  statistics?: Statistic[];
+  statisticWindow?: number;
+  timeShift?: number;
+  comparison?: Comparison;
+  transformations?: Record<string, Transformation[]>;
+  aggregation?: Aggregation;
+  format?: Format;
+  summaryPercentile?: number;
+}
+
+export enum MatchMode {
+  EXACT = 'exact',
+  SUFFIX = 'suffix',
+  GLOB = 'glob',
+  REGEX = 'regex',
+}
+
+export interface MetricPattern {
+  mode: MatchMode;
+  pattern: string;
+}
+
+export enum TransformationKind {
+  RATE = 'rate',
+  DELTA = 'delta',
+  MULTIPLY = 'multiply',
+  DIVIDE = 'divide',
+  BITS_TO_BYTES = 'bitsToBytes',
+  BYTES_TO_BITS = 'bytesToBits',
+  CUMULATIVE_SUM = 'cumulativeSum',
+  PERCENT_OF = 'percentOf',
+}
+
+/** Transformations are keyed by metric key, the key ALL_METRICS applies to all metrics without transformations of their own. */
+export const ALL_METRICS = '*';
+
+export interface Transformation {
+  kind: TransformationKind;
+  factor?: number;
+  reference?: string;
+  /** Rate and delta drop the points after a decrease of a counter instead of returning a negative change. */
+  counter?: boolean;
+}
+
+export enum AggregationFunction {
+  SUM = 'sum',
+  AVG = 'avg',
+  MIN = 'min',
+  MAX = 'max',
+  COUNT = 'count',
+  PERCENTILE = 'percentile',
+}
+
+export enum GroupBy {
+  NONE = '',
+  DEVICE = 'device',
+  MEASUREMENT = 'measurement',
+  METRIC = 'metric',
+}
+
+export interface Aggregation {
+  function: AggregationFunction;
+  percentile?: number;
+  groupBy?: GroupBy;
+  keepInputs: boolean;
+}
+
+export enum RankFunction {
+  AVG = 'avg',
+  MAX = 'max',
+  LAST = 'last',
+  PERCENTILE = 'percentile',
+}
+
+export interface Ranking {
+  deviceObid: number;
+  measurementFilter: string;
+  metric: MetricPattern;
+  rankBy: RankFunction;
+  percentile?: number;
+  bottom: boolean;
+  limit: number;
+  others?: AggregationFunction;
+}
+
 export interface LabelValue extends SelectableValue<number> {
   label: string;
   value: number;
@@ -56,15 +190,47 @@ export interface QueryResult {
   hasMore: boolean;
 }
 
+/** The polling interval of the measurement of a metric is given in milliseconds. */
 export interface MetricResult {
   key: string;
   text: string;
   measurementObid: number;
+  unit?: string;
+  description?: string;
+  pollingInterval?: number;
+}
+
+/** A hit of the search, the path of a metric is "device › measurement › metric". */
+export interface SearchHit {
+  kind: 'device' | 'measurement' | 'metric';
+  path: string;
+  score: number;
+  device?: { obid: number; name: string };
+  measurement?: { obid: number; name: string };
+  metric?: { key: string; name: string };
+}
+
+export interface ResolvedMeasurement {
+  obid: number;
+  name: string;
+  device?: { obid: number; name: string };
+  metrics: Array<{ key: string; name: string }>;
+}
+
+export interface UnresolvedMeasurement {
+  obid: number;
+  error: string;
+}
+
+export interface LinkResolution {
+  measurements: ResolvedMeasurement[];
+  unresolved: UnresolvedMeasurement[];
 }
 
 export enum Mode {
   MEASUREMENT = 0,
   STATISTIC_LINK = 10,
+  TOP_N = 20,
 }
 
 export enum Unit {
@@ -78,6 +244,7 @@ export enum Unit {
 export interface StableNetConfigOptions extends DataSourceJsonData {
   ip?: string;
   port?: number;
+  verboseLogging?: boolean;
 }
 
 /** Value that is used in the backend, but never sent over HTTP to the frontend */